@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -9,17 +10,23 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/BorisRostovskiy/ESL/internal/auth"
 	"github.com/BorisRostovskiy/ESL/internal/clients"
 	"github.com/BorisRostovskiy/ESL/internal/handlers"
 	grpcServer "github.com/BorisRostovskiy/ESL/internal/handlers/grpc"
 	pb "github.com/BorisRostovskiy/ESL/internal/handlers/grpc/gen/user-manager"
 	httpHandler "github.com/BorisRostovskiy/ESL/internal/handlers/http"
+	memStorage "github.com/BorisRostovskiy/ESL/internal/repository/memory"
 	pgStorage "github.com/BorisRostovskiy/ESL/internal/repository/pg"
+	"github.com/BorisRostovskiy/ESL/internal/repository/pg/migrations"
 	"github.com/BorisRostovskiy/ESL/internal/service"
+	"github.com/BorisRostovskiy/ESL/internal/tokens"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/reflection"
 
@@ -39,6 +46,7 @@ import (
 const (
 	grpcHealthService = "health-service-grpc"
 	postgresStorage   = "postgres"
+	memoryStorage     = "memory"
 )
 
 var version = "dev"
@@ -64,29 +72,45 @@ func setupLogger(lvl string) *logrus.Logger {
 	return logger
 }
 
-func setupGRPC(l *logrus.Logger, users handlers.UsersService) *grpc.Server {
+func setupGRPC(l *logrus.Logger, users handlers.UsersService, authn *auth.Authenticator, pages *handlers.PageTokens,
+	cfg config) *grpc.Server {
 	loggingOptions := []logging.Option{
 		logging.WithLogOnEvents(logging.StartCall, logging.FinishCall),
 		logging.WithDurationField(logging.DurationToDurationField),
 	}
-	grpcS := grpc.NewServer(
-		grpc.ChainUnaryInterceptor(
-			logging.UnaryServerInterceptor(interceptorLogger(l), loggingOptions...),
-		),
-		grpc.ChainStreamInterceptor(
-			logging.StreamServerInterceptor(interceptorLogger(l), loggingOptions...),
-		),
-	)
+	requestUnary, requestStream := grpcServer.RequestInterceptors()
+	unary := []grpc.UnaryServerInterceptor{
+		requestUnary,
+		logging.UnaryServerInterceptor(interceptorLogger(l), loggingOptions...),
+	}
+	stream := []grpc.StreamServerInterceptor{
+		requestStream,
+		logging.StreamServerInterceptor(interceptorLogger(l), loggingOptions...),
+	}
+	if authn != nil {
+		authUnary, authStream := grpcServer.AuthInterceptors(authn, l)
+		unary = append(unary, authUnary)
+		stream = append(stream, authStream)
+	}
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(stream...),
+	}
+	if cfg.Handler.TLS.Enabled() {
+		opts = append(opts, grpc.Creds(auth.ServerCredentials()))
+	}
+	grpcS := grpc.NewServer(opts...)
 
 	reflection.Register(grpcS)
-	pb.RegisterUserManagerServer(grpcS, grpcServer.New(users, l))
+	pb.RegisterUserManagerServer(grpcS, grpcServer.New(users, l, pages))
 	healthServer := grpcHealth.NewServer()
 	healthServer.SetServingStatus(grpcHealthService, grpcHealthv1.HealthCheckResponse_SERVING)
 	grpcHealthv1.RegisterHealthServer(grpcS, healthServer)
 	return grpcS
 }
 
-func mustSetupHTTP(logger *logrus.Logger, users handlers.UsersService, cfg config) *http.Server {
+func mustSetupHTTP(logger *logrus.Logger, users handlers.UsersService, keys *tokens.Issuer, authn *auth.Authenticator,
+	pages *handlers.PageTokens, cfg config) *http.Server {
 	h, err := httpHealth.New(
 		httpHealth.WithSystemInfo(),
 		httpHealth.WithComponent(httpHealth.Component{
@@ -108,11 +132,16 @@ func mustSetupHTTP(logger *logrus.Logger, users handlers.UsersService, cfg confi
 	}
 
 	if cfg.GRPC {
+		creds := insecure.NewCredentials()
+		if cfg.Handler.TLS.Enabled() {
+			// the service checks its own listener, server certificate is not issued for the bind address
+			creds = credentials.NewTLS(&tls.Config{InsecureSkipVerify: true}) //nolint:gosec
+		}
 		check := grpcHealthCheck.New(grpcHealthCheck.Config{
 			Target:  cfg.Handler.Addr,
 			Service: grpcHealthService,
 			DialOptions: []grpc.DialOption{
-				grpc.WithTransportCredentials(insecure.NewCredentials()),
+				grpc.WithTransportCredentials(creds),
 			},
 		})
 		if err = h.Register(httpHealth.Config{
@@ -129,24 +158,211 @@ func mustSetupHTTP(logger *logrus.Logger, users handlers.UsersService, cfg confi
 
 	// Creating a normal HTTP handlers
 	return &http.Server{
-		Handler: httpHandler.New(logger, users, h),
+		Handler:     httpHandler.New(logger, users, h, keys, authn, pages),
+		ConnContext: auth.ConnContext,
 	}
 }
 
-func mustSetupStorage(cfg config, log *logrus.Logger) service.UserRepo {
+// storage all the repositories service relies on
+type storage interface {
+	service.UserRepo
+	service.TokenRepo
+	service.Transactor
+	service.OutboxRepo
+	service.PurgeRepo
+	service.AuditRepo
+	service.PrivacyRepo
+}
+
+func mustSetupStorage(cfg config, filters service.Filters, sorts service.SortFields, log *logrus.Logger) storage {
 	switch cfg.Storage.Type {
 	case postgresStorage:
-		store, err := pgStorage.New(&cfg.Storage.Config, log)
+		store, err := pgStorage.New(&cfg.Storage.Config, filters, sorts, log)
 		if err != nil {
 			logrus.Fatalf("failed to create repository: %v", err)
 		}
 		return store
+	case memoryStorage:
+		return memStorage.New(log)
 	default:
 		logrus.Fatalf("unknown repository: %s", cfg.Storage.Type)
 	}
 	return nil
 }
 
+// mustSetupFilters fields users could be filtered by, nothing is filterable if none are configured
+func mustSetupFilters(cfg config, log *logrus.Logger) service.Filters {
+	filters, err := service.NewFilters(cfg.Filters)
+	if err != nil {
+		logrus.Fatalf("failed to setup filters: %v", err)
+	}
+	if len(filters) == 0 {
+		log.Warn("no filters are configured, users could not be filtered")
+	}
+	return filters
+}
+
+// mustSetupSortFields fields users could be sorted by, all the supported ones if none are configured
+func mustSetupSortFields(cfg config) service.SortFields {
+	sorts, err := service.NewSortFields(cfg.SortFields)
+	if err != nil {
+		logrus.Fatalf("failed to setup sort fields: %v", err)
+	}
+	return sorts
+}
+
+// mustSetupPageTokens loads next_page tokens keys, tokens are valid within the process only if keys are not configured
+func mustSetupPageTokens(cfg config, log *logrus.Logger) *handlers.PageTokens {
+	if cfg.Handler.PageTokens.KeyFile == "" {
+		log.Warn("page tokens key is not configured, next_page tokens are not valid across instances and restarts")
+	}
+	pages, err := handlers.NewPageTokens(cfg.Handler.PageTokens)
+	if err != nil {
+		logrus.Fatalf("failed to setup page tokens: %v", err)
+	}
+	return pages
+}
+
+// mustSetupTokens returns nil if tokens issuing is disabled
+func mustSetupTokens(cfg config) *tokens.Issuer {
+	if !cfg.Tokens.Enabled {
+		return nil
+	}
+	issuer, err := tokens.New(cfg.Tokens)
+	if err != nil {
+		logrus.Fatalf("failed to setup tokens: %v", err)
+	}
+	return issuer
+}
+
+// mustSetupWebhooks returns nil if webhooks are disabled, the relay delivers events to every subscriber otherwise.
+// Returned close func waits for queued notifications to be delivered
+func mustSetupWebhooks(cfg config, log *logrus.Logger) (*clients.WebhookNotifier, func(ctx context.Context) error) {
+	if !cfg.Notifications.Webhooks.Enabled {
+		return nil, func(context.Context) error { return nil }
+	}
+	n, err := clients.NewWebhookNotifier(cfg.Notifications.Webhooks, log)
+	if err != nil {
+		logrus.Fatalf("failed to setup webhooks: %v", err)
+	}
+	return n, n.Close
+}
+
+// mustSetupAuth returns nil if authentication is disabled
+func mustSetupAuth(cfg config, keys *tokens.Issuer) *auth.Authenticator {
+	if !cfg.Auth.Enabled {
+		return nil
+	}
+	if cfg.Auth.MTLS && cfg.Handler.TLS.ClientCAFile == "" {
+		logrus.Fatal("failed to setup authentication: mtls requires handler tls client_ca_file")
+	}
+	authn, err := auth.FromConfig(cfg.Auth, keys)
+	if err != nil {
+		logrus.Fatalf("failed to setup authentication: %v", err)
+	}
+	return authn
+}
+
+// mustListen creates handlers listener, TLS is terminated before connections are
+// multiplexed, so that HTTP and gRPC share the same certificate
+func mustListen(cfg config) net.Listener {
+	l, err := net.Listen("tcp", cfg.Handler.Addr)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if !cfg.Handler.TLS.Enabled() {
+		return l
+	}
+	tlsCfg, err := cfg.Handler.TLS.ServerConfig()
+	if err != nil {
+		logrus.Fatalf("failed to setup tls: %v", err)
+	}
+	return tls.NewListener(l, tlsCfg)
+}
+
+// runMigrate handles `migrate up|down [steps]|status` command
+func runMigrate(cfg config, log *logrus.Logger, args []string) error {
+	if cfg.Storage.Type != postgresStorage {
+		return fmt.Errorf("migrations are available for %s storage only", postgresStorage)
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("migrate command expects one of: up, down, status")
+	}
+
+	conn, err := pgStorage.Connect(&cfg.Storage.Config)
+	if err != nil {
+		return fmt.Errorf("failed to connect to repository: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	m, err := migrations.New(conn, log)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "up":
+		n, err := m.Up(ctx)
+		if err != nil {
+			return err
+		}
+		log.Infof("%d migration(s) applied", n)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("malformed number of steps '%s'", args[1])
+			}
+		}
+		n, err := m.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		log.Infof("%d migration(s) reverted", n)
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, st := range statuses {
+			applied := "pending"
+			if st.AppliedAt != nil {
+				applied = st.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Printf("%04d_%s\t%s\n", st.Version, st.Name, applied)
+		}
+	default:
+		return fmt.Errorf("unknown migrate command '%s', expected one of: up, down, status", args[0])
+	}
+	return nil
+}
+
+// runRotateKeys handles `rotate-keys [batch size]` command, users along with their audit entries and outbox events
+// encrypted with previous keys or stored in plaintext are encrypted with the current key
+func runRotateKeys(cfg config, log *logrus.Logger, args []string) error {
+	if cfg.Storage.Type != postgresStorage {
+		return fmt.Errorf("key rotation is available for %s storage only", postgresStorage)
+	}
+	batchSize := pgStorage.DefaultRotateBatchSize
+	if len(args) > 0 {
+		var err error
+		if batchSize, err = strconv.Atoi(args[0]); err != nil || batchSize < 1 {
+			return fmt.Errorf("malformed batch size '%s'", args[0])
+		}
+	}
+
+	rotator, err := pgStorage.NewKeyRotator(&cfg.Storage.Config, log)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rotator.Close() }()
+
+	n, err := rotator.Rotate(context.Background(), batchSize)
+	log.Infof("%d user(s) and record(s) re-encrypted", n)
+	return err
+}
+
 func mustSetupConfig(configFile string) config {
 	var cfg config
 	if file, err := os.ReadFile(configFile); err != nil {
@@ -200,14 +416,26 @@ type config struct {
 	HTTP    bool `yaml:"HTTP"`
 	GRPC    bool `yaml:"GRPC"`
 	Handler struct {
-		Addr string `yaml:"addr"`
+		Addr       string                   `yaml:"addr"`
+		TLS        auth.TLSConfig           `yaml:"tls"`
+		PageTokens handlers.PageTokenConfig `yaml:"page_tokens"`
 	} `yaml:"handler"`
 
 	Storage struct {
-		Type   string           `yaml:"type"`
-		Config pgStorage.Config `yaml:"config"`
+		Type   string              `yaml:"type"`
+		Config pgStorage.Config    `yaml:"config"`
+		Purge  service.PurgeConfig `yaml:"purge"`
 	} `yaml:"storage"`
-	Filters []string `yaml:"filters"`
+	Filters    []service.FilterConfig `yaml:"filters"`
+	SortFields []string               `yaml:"sort_fields"`
+	Tokens     tokens.Config          `yaml:"tokens"`
+	Auth       auth.Config            `yaml:"auth"`
+
+	Notifications struct {
+		Webhooks clients.WebhookConfig `yaml:"webhooks"`
+		Outbox   service.RelayConfig   `yaml:"outbox"`
+		Watch    service.WatchConfig   `yaml:"watch"`
+	} `yaml:"notifications"`
 }
 
 func main() {
@@ -224,7 +452,9 @@ func main() {
 	if err != nil {
 		if errors.Is(err, flag.ErrHelp) {
 			fmt.Printf("UserManager\n\n")
-			fmt.Printf("USAGE\n\n  %s [OPTIONS]\n\n", os.Args[0])
+			fmt.Printf("USAGE\n\n  %s [OPTIONS]\n", os.Args[0])
+			fmt.Printf("  %s [OPTIONS] migrate up|down [steps]|status\n", os.Args[0])
+			fmt.Printf("  %s [OPTIONS] rotate-keys [batch size]\n\n", os.Args[0])
 			fmt.Print("OPTIONS\n\n")
 			flags.SetOutput(os.Stdout)
 			flags.PrintDefaults()
@@ -237,26 +467,74 @@ func main() {
 
 	logger := setupLogger(logLevel)
 	cfg := mustSetupConfig(configFile)
-	storage := mustSetupStorage(cfg, logger)
-	users := service.New(storage, logger, clients.NewChannelNotificationSvc(logger))
 
-	// creating a listener for handlers
-	l, err := net.Listen("tcp", cfg.Handler.Addr)
-	if err != nil {
-		logrus.Fatal(err)
+	if args := flags.Args(); len(args) > 0 {
+		switch args[0] {
+		case "migrate":
+			err = runMigrate(cfg, logger, args[1:])
+		case "rotate-keys":
+			err = runRotateKeys(cfg, logger, args[1:])
+		default:
+			fmt.Printf("unknown command '%s'\n", args[0])
+			os.Exit(1)
+		}
+		if err != nil {
+			logger.Fatalf("%s: %v", args[0], err)
+		}
+		return
 	}
 
-	m := cmux.New(l)
+	filters := mustSetupFilters(cfg, logger)
+	sorts := mustSetupSortFields(cfg)
+	store := mustSetupStorage(cfg, filters, sorts, logger)
+	keys := mustSetupTokens(cfg)
+	authn := mustSetupAuth(cfg, keys)
+
+	var opts []service.Option
+	if keys != nil {
+		opts = append(opts, service.WithTokens(keys, store))
+	}
+	if authn != nil {
+		opts = append(opts, service.WithAuthorization())
+	}
+	webhooks, closeWebhooks := mustSetupWebhooks(cfg, logger)
+	var relayOpts []service.RelayOption
+	if webhooks != nil {
+		relayOpts = append(relayOpts, service.WithSubscribers(webhooks))
+	}
+	// watchers get the very events subscribers are notified of
+	hub := service.NewWatchHub(cfg.Notifications.Watch, clients.NewChannelNotificationSvc(logger))
+	opts = append(opts, service.WithOutbox(store, store), service.WithAudit(store), service.WithPrivacy(store), service.WithFilters(filters), service.WithSortFields(sorts), service.WithWatch(hub))
+	users := service.New(store, logger, hub, opts...)
+
+	// user change events are stored in the outbox along with the changes and relayed from there
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	relayDone := make(chan struct{})
+	go func() {
+		defer close(relayDone)
+		service.NewOutboxRelay(cfg.Notifications.Outbox, store, store, hub, logger, relayOpts...).Run(relayCtx)
+	}()
+	// deleted users are kept for the retention period and purged afterward
+	purgeDone := make(chan struct{})
+	go func() {
+		defer close(purgeDone)
+		service.NewPurger(cfg.Storage.Purge, store, logger).Run(relayCtx)
+	}()
+
+	pages := mustSetupPageTokens(cfg, logger)
+
+	// creating a listener for handlers
+	m := cmux.New(mustListen(cfg))
 
 	var grpcSrv *grpc.Server
 	if cfg.GRPC {
-		grpcSrv = setupGRPC(logger, users)
+		grpcSrv = setupGRPC(logger, users, authn, pages, cfg)
 		serve(grpcSrv, m.Match(cmux.HTTP2()))
 	}
 
 	var httpSrv *http.Server
 	if cfg.HTTP {
-		httpSrv = mustSetupHTTP(logger, users, cfg)
+		httpSrv = mustSetupHTTP(logger, users, keys, authn, pages, cfg)
 		serve(httpSrv, m.Match(cmux.HTTP1Fast()))
 	}
 
@@ -277,6 +555,8 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
 	defer cancel()
 
+	// watches never end on their own
+	hub.Close()
 	if cfg.GRPC {
 		grpcSrv.GracefulStop()
 	}
@@ -286,6 +566,16 @@ func main() {
 	}
 
 	m.Close()
+
+	stopRelay()
+	<-relayDone
+	<-purgeDone
+
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer drainCancel()
+	if err = closeWebhooks(drainCtx); err != nil {
+		logger.Errorf("pending notifications are dropped: %v", err)
+	}
 	logrus.Println("===DONE===")
 
 }
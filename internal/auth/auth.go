@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/BorisRostovskiy/ESL/internal/service"
+	"github.com/BorisRostovskiy/ESL/internal/tokens"
+)
+
+const (
+	MethodAPIKey = "api_key"
+	MethodHMAC   = "hmac"
+	MethodJWT    = "jwt"
+	MethodMTLS   = "mtls"
+)
+
+var (
+	// ErrNoCredentials causes when request does not carry credentials the method understands
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrInvalidCredentials causes when credentials are present but could not be verified
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+type (
+	// Config authentication configuration, every configured method is accepted
+	Config struct {
+		Enabled bool `yaml:"enabled"`
+		// APIKeys key name -> hex encoded SHA-256 of the key sent in X-API-Key header
+		APIKeys map[string]string `yaml:"api_keys"`
+		HMAC    HMACConfig        `yaml:"hmac"`
+		// MTLS accepts verified client certificates, requires handler TLS with client CA
+		MTLS bool `yaml:"mtls"`
+		// AccessTokens accepts access tokens issued on login, requires tokens issuing to be enabled
+		AccessTokens bool `yaml:"access_tokens"`
+		// Roles caller subject -> role, for callers which are not users, e.g. API key names
+		// or certificate common names. Users get their role from access tokens
+		Roles map[string]string `yaml:"roles"`
+	}
+
+	// HMACConfig HS256 signed bearer tokens, used for service to service calls
+	HMACConfig struct {
+		SecretFile string `yaml:"secret_file"`
+		Issuer     string `yaml:"issuer"`
+	}
+
+	// Method single way of the caller authentication
+	Method interface {
+		// Authenticate returns ErrNoCredentials if credentials of the method are not present
+		Authenticate(c Credentials) (*service.Caller, error)
+	}
+
+	// Authenticator tries methods in order, the first one which recognises credentials decides
+	Authenticator struct {
+		methods []Method
+		roles   map[string]string
+	}
+)
+
+// New creates authenticator out of given methods, roles are assigned to callers
+// identified without one, see Config.Roles
+func New(roles map[string]string, methods ...Method) *Authenticator {
+	return &Authenticator{methods: methods, roles: roles}
+}
+
+// FromConfig creates authenticator with methods enabled in configuration,
+// issuer is mandatory if access tokens are accepted
+func FromConfig(cfg Config, issuer *tokens.Issuer) (*Authenticator, error) {
+	var methods []Method
+	if cfg.MTLS {
+		methods = append(methods, ClientCertificates())
+	}
+	if len(cfg.APIKeys) > 0 {
+		m, err := APIKeys(cfg.APIKeys)
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, m)
+	}
+	if cfg.HMAC.SecretFile != "" {
+		secret, err := os.ReadFile(cfg.HMAC.SecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read hmac secret: %w", err)
+		}
+		m, err := HMAC([]byte(strings.TrimSpace(string(secret))), cfg.HMAC.Issuer)
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, m)
+	}
+	if cfg.AccessTokens {
+		if issuer == nil {
+			return nil, fmt.Errorf("access tokens could not be accepted while tokens issuing is disabled")
+		}
+		methods = append(methods, AccessTokens(issuer))
+	}
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("authentication is enabled but no method is configured")
+	}
+	for subject, role := range cfg.Roles {
+		if !service.ValidRole(role) {
+			return nil, fmt.Errorf("unknown role '%s' of '%s'", role, subject)
+		}
+	}
+	return New(cfg.Roles, methods...), nil
+}
+
+// Authenticate identifies the caller, returns ErrNoCredentials if none of the methods
+// recognised credentials and ErrInvalidCredentials if recognised credentials are wrong
+func (a *Authenticator) Authenticate(c Credentials) (*service.Caller, error) {
+	for _, m := range a.methods {
+		caller, err := m.Authenticate(c)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if caller.Role == "" {
+			caller.Role = a.roles[caller.Subject]
+		}
+		return caller, nil
+	}
+	return nil, ErrNoCredentials
+}
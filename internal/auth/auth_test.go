@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/BorisRostovskiy/ESL/internal/service"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/soheilhy/cmux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var hmacSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func keyHash(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+func hmacToken(t *testing.T, secret []byte, claims jwt.RegisteredClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+	require.NoError(t, err)
+	return token
+}
+
+func TestAuthenticator_Authenticate(t *testing.T) {
+	t.Parallel()
+	keys, err := APIKeys(map[string]string{"ops": keyHash("secret-key")})
+	require.NoError(t, err)
+	hmacMethod, err := HMAC(hmacSecret, "billing")
+	require.NoError(t, err)
+	authn := New(map[string]string{"ops": service.RoleAdmin}, ClientCertificates(), keys, hmacMethod)
+
+	expiresAt := jwt.NewNumericDate(time.Now().Add(time.Minute))
+	tests := map[string]struct {
+		creds Credentials
+		want  *service.Caller
+		err   error
+	}{
+		"API key Ok": {
+			creds: Credentials{APIKey: "secret-key"},
+			want:  &service.Caller{Subject: "ops", Method: MethodAPIKey, Role: service.RoleAdmin},
+		},
+		"API key unknown error": {
+			creds: Credentials{APIKey: "unknown"},
+			err:   ErrInvalidCredentials,
+		},
+		"HMAC Ok": {
+			creds: Credentials{Bearer: hmacToken(t, hmacSecret,
+				jwt.RegisteredClaims{Subject: "billing-svc", Issuer: "billing", ExpiresAt: expiresAt})},
+			want: &service.Caller{Subject: "billing-svc", Method: MethodHMAC},
+		},
+		"HMAC wrong secret error": {
+			creds: Credentials{Bearer: hmacToken(t, []byte("fedcba9876543210fedcba9876543210"),
+				jwt.RegisteredClaims{Subject: "billing-svc", Issuer: "billing", ExpiresAt: expiresAt})},
+			err: ErrInvalidCredentials,
+		},
+		"HMAC expired error": {
+			creds: Credentials{Bearer: hmacToken(t, hmacSecret,
+				jwt.RegisteredClaims{Subject: "billing-svc", Issuer: "billing",
+					ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute))})},
+			err: ErrInvalidCredentials,
+		},
+		"HMAC no expiration error": {
+			creds: Credentials{Bearer: hmacToken(t, hmacSecret,
+				jwt.RegisteredClaims{Subject: "billing-svc", Issuer: "billing"})},
+			err: ErrInvalidCredentials,
+		},
+		"Unknown bearer error": {
+			creds: Credentials{Bearer: "garbage"},
+			err:   ErrNoCredentials,
+		},
+		"No credentials error": {
+			creds: Credentials{},
+			err:   ErrNoCredentials,
+		},
+		"Client certificate Ok": {
+			creds: Credentials{TLS: &tls.ConnectionState{
+				VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "reporting"}}}},
+			}},
+			want: &service.Caller{Subject: "reporting", Method: MethodMTLS},
+		},
+		"Client certificate not verified": {
+			creds: Credentials{TLS: &tls.ConnectionState{}},
+			err:   ErrNoCredentials,
+		},
+	}
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			got, err := authn.Authenticate(tt.creds)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestAPIKeys_MalformedHash(t *testing.T) {
+	t.Parallel()
+	_, err := APIKeys(map[string]string{"ops": "plain-key"})
+	assert.Error(t, err)
+}
+
+func TestHMAC_ShortSecret(t *testing.T) {
+	t.Parallel()
+	_, err := HMAC([]byte("short"), "")
+	assert.Error(t, err)
+}
+
+func TestBearerToken(t *testing.T) {
+	t.Parallel()
+	assert.Equal(t, "abc", BearerToken("Bearer abc"))
+	assert.Equal(t, "abc", BearerToken("bearer abc"))
+	assert.Equal(t, "", BearerToken("Basic abc"))
+	assert.Equal(t, "", BearerToken("abc"))
+}
+
+func selfSigned(t *testing.T, cn string, isCA bool) (tls.Certificate, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, cert
+}
+
+// TestConnContext client certificate must be visible to HTTP handlers behind cmux
+func TestConnContext(t *testing.T) {
+	t.Parallel()
+	serverCert, serverX509 := selfSigned(t, "server", true)
+	clientCert, clientX509 := selfSigned(t, "reporting", true)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientX509)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	m := cmux.New(tls.NewListener(l, &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+	}))
+	srv := &http.Server{
+		ConnContext: ConnContext,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			caller, err := ClientCertificates().Authenticate(Credentials{TLS: TLSStateFrom(r.Context())})
+			if err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = io.WriteString(w, caller.Subject)
+		}),
+	}
+	// matchers must be registered before cmux starts serving
+	httpL := m.Match(cmux.HTTP1Fast())
+	go func() { _ = srv.Serve(httpL) }()
+	go func() { _ = m.Serve() }()
+	defer m.Close()
+
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(serverX509)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:      rootCAs,
+		Certificates: []tls.Certificate{clientCert},
+	}}}
+	res, err := client.Get("https://" + l.Addr().String())
+	require.NoError(t, err)
+	defer func() { _ = res.Body.Close() }()
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "reporting", string(body))
+}
@@ -0,0 +1,25 @@
+package auth
+
+import (
+	"crypto/tls"
+	"strings"
+)
+
+const HeaderAPIKey = "X-API-Key"
+
+// Credentials raw credentials extracted from the request by the transport
+type Credentials struct {
+	APIKey string
+	Bearer string
+	// TLS state of the connection, nil for plain text connections
+	TLS *tls.ConnectionState
+}
+
+// BearerToken extracts token from the Authorization header value, empty if scheme is not Bearer
+func BearerToken(authorization string) string {
+	scheme, token, ok := strings.Cut(authorization, " ")
+	if !ok || !strings.EqualFold(scheme, "bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
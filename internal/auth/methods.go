@@ -0,0 +1,136 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/BorisRostovskiy/ESL/internal/service"
+	"github.com/BorisRostovskiy/ESL/internal/tokens"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minHMACSecret HS256 secret shorter than the hash output weakens the signature
+const minHMACSecret = 32
+
+type apiKey struct {
+	name string
+	hash []byte
+}
+
+type apiKeys []apiKey
+
+// APIKeys authenticates static API keys, keys map key name to hex encoded SHA-256 of the key,
+// so that plain keys are never stored in configuration
+func APIKeys(keys map[string]string) (Method, error) {
+	m := make(apiKeys, 0, len(keys))
+	for name, h := range keys {
+		hash, err := hex.DecodeString(h)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("api key '%s' must be hex encoded SHA-256 hash", name)
+		}
+		m = append(m, apiKey{name: name, hash: hash})
+	}
+	return m, nil
+}
+
+func (m apiKeys) Authenticate(c Credentials) (*service.Caller, error) {
+	if c.APIKey == "" {
+		return nil, ErrNoCredentials
+	}
+	hash := sha256.Sum256([]byte(c.APIKey))
+	for _, k := range m {
+		if subtle.ConstantTimeCompare(hash[:], k.hash) == 1 {
+			return &service.Caller{Subject: k.name, Method: MethodAPIKey}, nil
+		}
+	}
+	return nil, ErrInvalidCredentials
+}
+
+type hmacTokens struct {
+	secret []byte
+	parser *jwt.Parser
+}
+
+// HMAC authenticates HS256 signed bearer tokens, `sub` claim identifies the caller
+// and `exp` claim is mandatory
+func HMAC(secret []byte, issuer string) (Method, error) {
+	if len(secret) < minHMACSecret {
+		return nil, fmt.Errorf("hmac secret must be at least %d bytes long", minHMACSecret)
+	}
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithExpirationRequired(),
+	}
+	if issuer != "" {
+		opts = append(opts, jwt.WithIssuer(issuer))
+	}
+	return &hmacTokens{secret: secret, parser: jwt.NewParser(opts...)}, nil
+}
+
+func (m *hmacTokens) Authenticate(c Credentials) (*service.Caller, error) {
+	if c.Bearer == "" || bearerAlg(c.Bearer) != jwt.SigningMethodHS256.Alg() {
+		return nil, ErrNoCredentials
+	}
+	claims := &jwt.RegisteredClaims{}
+	if _, err := m.parser.ParseWithClaims(c.Bearer, claims, func(*jwt.Token) (interface{}, error) {
+		return m.secret, nil
+	}); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: subject is missing", ErrInvalidCredentials)
+	}
+	return &service.Caller{Subject: claims.Subject, Method: MethodHMAC}, nil
+}
+
+type accessTokens struct {
+	issuer *tokens.Issuer
+}
+
+// AccessTokens authenticates access tokens issued by the service on login
+func AccessTokens(issuer *tokens.Issuer) Method {
+	return accessTokens{issuer: issuer}
+}
+
+func (m accessTokens) Authenticate(c Credentials) (*service.Caller, error) {
+	if c.Bearer == "" || bearerAlg(c.Bearer) == jwt.SigningMethodHS256.Alg() {
+		return nil, ErrNoCredentials
+	}
+	claims, err := m.issuer.Verify(c.Bearer)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+	return &service.Caller{Subject: claims.Subject, Method: MethodJWT, Role: claims.Role}, nil
+}
+
+type clientCertificates struct{}
+
+// ClientCertificates authenticates client certificates verified during TLS handshake,
+// certificate common name identifies the caller
+func ClientCertificates() Method {
+	return clientCertificates{}
+}
+
+func (clientCertificates) Authenticate(c Credentials) (*service.Caller, error) {
+	if c.TLS == nil || len(c.TLS.VerifiedChains) == 0 || len(c.TLS.VerifiedChains[0]) == 0 {
+		return nil, ErrNoCredentials
+	}
+	cn := c.TLS.VerifiedChains[0][0].Subject.CommonName
+	if cn == "" {
+		return nil, fmt.Errorf("%w: client certificate has no common name", ErrInvalidCredentials)
+	}
+	return &service.Caller{Subject: cn, Method: MethodMTLS}, nil
+}
+
+// bearerAlg signing algorithm from unverified token header, lets bearer methods
+// skip tokens which are not theirs
+func bearerAlg(token string) string {
+	t, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		return ""
+	}
+	alg, _ := t.Header["alg"].(string)
+	return alg
+}
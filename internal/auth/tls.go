@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/soheilhy/cmux"
+	"google.golang.org/grpc/credentials"
+)
+
+type (
+	// TLSConfig handler TLS, client certificates are verified against client CA if it is set
+	TLSConfig struct {
+		CertFile     string `yaml:"cert_file"`
+		KeyFile      string `yaml:"key_file"`
+		ClientCAFile string `yaml:"client_ca_file"`
+	}
+
+	tlsStateKey struct{}
+)
+
+// Enabled true if server certificate is configured
+func (c TLSConfig) Enabled() bool {
+	return c.CertFile != ""
+}
+
+// ServerConfig builds server TLS configuration. Client certificates are optional,
+// so that callers could still use other authentication methods
+func (c TLSConfig) ServerConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not load server certificate: %w", err)
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if c.ClientCAFile != "" {
+		pem, err := os.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read client CA: %w", err)
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("client CA file '%s' has no certificates", c.ClientCAFile)
+		}
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg, nil
+}
+
+// ConnState TLS state of the connection accepted from TLS listener, connections are
+// wrapped by cmux so the state is not visible to HTTP and gRPC servers on their own
+func ConnState(c net.Conn) *tls.ConnectionState {
+	for {
+		switch conn := c.(type) {
+		case *tls.Conn:
+			st := conn.ConnectionState()
+			return &st
+		case *cmux.MuxConn:
+			c = conn.Conn
+		default:
+			return nil
+		}
+	}
+}
+
+// ConnContext exposes connection TLS state to HTTP handlers, see http.Server.ConnContext
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	if st := ConnState(c); st != nil {
+		return context.WithValue(ctx, tlsStateKey{}, st)
+	}
+	return ctx
+}
+
+// TLSStateFrom connection TLS state stored by ConnContext
+func TLSStateFrom(ctx context.Context) *tls.ConnectionState {
+	st, _ := ctx.Value(tlsStateKey{}).(*tls.ConnectionState)
+	return st
+}
+
+// muxCredentials exposes TLS state of connections terminated before cmux to gRPC peer info,
+// the handshake is already done by the listener, so connections are passed through as is
+type muxCredentials struct{}
+
+// ServerCredentials gRPC server credentials for TLS listener shared via cmux
+func ServerCredentials() credentials.TransportCredentials {
+	return muxCredentials{}
+}
+
+func (muxCredentials) ClientHandshake(context.Context, string, net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, errors.New("client handshake is not supported")
+}
+
+func (muxCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	st := ConnState(conn)
+	if st == nil {
+		return nil, nil, errors.New("connection is not TLS")
+	}
+	return conn, credentials.TLSInfo{
+		State:          *st,
+		CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity},
+	}, nil
+}
+
+func (muxCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "tls", SecurityVersion: "1.2"}
+}
+
+func (c muxCredentials) Clone() credentials.TransportCredentials {
+	return c
+}
+
+func (muxCredentials) OverrideServerName(string) error {
+	return nil
+}
@@ -3,13 +3,22 @@ package clients
 import (
 	"context"
 
+	"github.com/BorisRostovskiy/ESL/internal/events"
 	"github.com/sirupsen/logrus"
 )
 
 type (
-	channelName        string
+	// ChannelName notification channel
+	ChannelName        string
 	ChannelNotificator interface {
-		Notify(ctx context.Context, channelName channelName, msg string) error
+		Notify(ctx context.Context, channelName ChannelName, event *events.Event) error
+	}
+
+	// SubscriberNotificator delivers event to a single subscriber of the channel and reports the outcome,
+	// so that callers could track delivery of every subscriber on its own
+	SubscriberNotificator interface {
+		Subscribers(channelName ChannelName) []string
+		Deliver(ctx context.Context, subscriber string, event *events.Event) error
 	}
 
 	ChannelNotificationSvc struct {
@@ -19,16 +28,16 @@ type (
 )
 
 var (
-	ChannelCreate channelName = "create"
-	ChannelUpdate channelName = "update"
-	ChannelDelete channelName = "delete"
+	ChannelCreate ChannelName = "create"
+	ChannelUpdate ChannelName = "update"
+	ChannelDelete ChannelName = "delete"
 )
 
 func NewChannelNotificationSvc(l *logrus.Logger) *ChannelNotificationSvc {
 	return &ChannelNotificationSvc{logger: l}
 }
 
-func (n *ChannelNotificationSvc) Notify(_ context.Context, channelName channelName, msg string) error {
-	n.logger.Debugf("send message: %s, to channel: %s", msg, channelName)
+func (n *ChannelNotificationSvc) Notify(_ context.Context, channelName ChannelName, event *events.Event) error {
+	n.logger.Debugf("send event: %s %s of user %s, to channel: %s", event.ID, event.Type, event.UserID, channelName)
 	return nil
 }
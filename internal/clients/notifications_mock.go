@@ -8,6 +8,7 @@ import (
 	context "context"
 	reflect "reflect"
 
+	events "github.com/BorisRostovskiy/ESL/internal/events"
 	gomock "go.uber.org/mock/gomock"
 )
 
@@ -35,15 +36,66 @@ func (m *MockChannelNotificator) EXPECT() *MockChannelNotificatorMockRecorder {
 }
 
 // Notify mocks base method.
-func (m *MockChannelNotificator) Notify(ctx context.Context, channelName channelName, msg string) error {
+func (m *MockChannelNotificator) Notify(ctx context.Context, channelName ChannelName, event *events.Event) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Notify", ctx, channelName, msg)
+	ret := m.ctrl.Call(m, "Notify", ctx, channelName, event)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // Notify indicates an expected call of Notify.
-func (mr *MockChannelNotificatorMockRecorder) Notify(ctx, channelName, msg interface{}) *gomock.Call {
+func (mr *MockChannelNotificatorMockRecorder) Notify(ctx, channelName, event interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Notify", reflect.TypeOf((*MockChannelNotificator)(nil).Notify), ctx, channelName, msg)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Notify", reflect.TypeOf((*MockChannelNotificator)(nil).Notify), ctx, channelName, event)
+}
+
+// MockSubscriberNotificator is a mock of SubscriberNotificator interface.
+type MockSubscriberNotificator struct {
+	ctrl     *gomock.Controller
+	recorder *MockSubscriberNotificatorMockRecorder
+}
+
+// MockSubscriberNotificatorMockRecorder is the mock recorder for MockSubscriberNotificator.
+type MockSubscriberNotificatorMockRecorder struct {
+	mock *MockSubscriberNotificator
+}
+
+// NewMockSubscriberNotificator creates a new mock instance.
+func NewMockSubscriberNotificator(ctrl *gomock.Controller) *MockSubscriberNotificator {
+	mock := &MockSubscriberNotificator{ctrl: ctrl}
+	mock.recorder = &MockSubscriberNotificatorMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockSubscriberNotificator) EXPECT() *MockSubscriberNotificatorMockRecorder {
+	return m.recorder
+}
+
+// Deliver mocks base method.
+func (m *MockSubscriberNotificator) Deliver(ctx context.Context, subscriber string, event *events.Event) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Deliver", ctx, subscriber, event)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Deliver indicates an expected call of Deliver.
+func (mr *MockSubscriberNotificatorMockRecorder) Deliver(ctx, subscriber, event interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Deliver", reflect.TypeOf((*MockSubscriberNotificator)(nil).Deliver), ctx, subscriber, event)
+}
+
+// Subscribers mocks base method.
+func (m *MockSubscriberNotificator) Subscribers(channelName ChannelName) []string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Subscribers", channelName)
+	ret0, _ := ret[0].([]string)
+	return ret0
+}
+
+// Subscribers indicates an expected call of Subscribers.
+func (mr *MockSubscriberNotificatorMockRecorder) Subscribers(channelName interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Subscribers", reflect.TypeOf((*MockSubscriberNotificator)(nil).Subscribers), channelName)
 }
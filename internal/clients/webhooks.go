@@ -0,0 +1,344 @@
+package clients
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BorisRostovskiy/ESL/internal/events"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	HeaderSignature = "X-Signature"
+	HeaderTimestamp = "X-Timestamp"
+	HeaderEventID   = "X-Event-ID"
+
+	defaultQueueSize      = 1024
+	defaultWorkers        = 4
+	defaultMaxAttempts    = 5
+	defaultTimeout        = 5 * time.Second
+	defaultInitialBackoff = 500 * time.Millisecond
+	defaultMaxBackoff     = 30 * time.Second
+)
+
+var (
+	// ErrQueueFull causes when notification could not be queued, subscribers are too slow
+	ErrQueueFull = errors.New("notifications queue is full")
+	// ErrClosed causes when notification is sent after notifier has been closed
+	ErrClosed = errors.New("notifier is closed")
+	// ErrRejected causes when subscriber refuses event with a response not worth retrying,
+	// anything but 2xx, 429 and 5xx
+	ErrRejected = errors.New("subscriber rejected event")
+)
+
+type (
+	// WebhookConfig webhook subscribers along with delivery settings
+	WebhookConfig struct {
+		Enabled bool `yaml:"enabled"`
+		// Subscribers channel (create, update, delete) -> subscriber URLs
+		Subscribers map[string][]string `yaml:"subscribers"`
+		// SecretFile shared secret the payload signature is calculated with
+		SecretFile string `yaml:"secret_file"`
+		// Format payload serialization, json (default) or protobuf UserEvent message
+		Format         string        `yaml:"format"`
+		Timeout        time.Duration `yaml:"timeout"`
+		QueueSize      int           `yaml:"queue_size"`
+		Workers        int           `yaml:"workers"`
+		MaxAttempts    int           `yaml:"max_attempts"`
+		InitialBackoff time.Duration `yaml:"initial_backoff"`
+		MaxBackoff     time.Duration `yaml:"max_backoff"`
+	}
+
+	delivery struct {
+		ctx   context.Context
+		url   string
+		event *events.Event
+		body  []byte
+		// result receives outcome of the delivery made by Deliver, nil for the queued by Notify
+		result chan error
+	}
+
+	// WebhookNotifier delivers notifications to subscribers asynchronously through a bounded queue,
+	// failed deliveries are retried with exponential backoff
+	WebhookNotifier struct {
+		cfg         WebhookConfig
+		secret      []byte
+		contentType string
+		marshal     func(e *events.Event) ([]byte, error)
+		subscribers map[ChannelName][]string
+		client      *http.Client
+		logger      *logrus.Logger
+
+		mu       sync.RWMutex
+		closed   bool
+		queue    chan delivery
+		stop     chan struct{}
+		stopOnce sync.Once
+		wg       sync.WaitGroup
+	}
+)
+
+// NewWebhookNotifier creates notifier and starts delivery workers, Close must be called to stop them
+func NewWebhookNotifier(cfg WebhookConfig, l *logrus.Logger) (*WebhookNotifier, error) {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaultQueueSize
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = defaultWorkers
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultMaxAttempts
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = defaultInitialBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = defaultMaxBackoff
+	}
+
+	subscribers := make(map[ChannelName][]string, len(cfg.Subscribers))
+	for ch, urls := range cfg.Subscribers {
+		switch ChannelName(ch) {
+		case ChannelCreate, ChannelUpdate, ChannelDelete:
+			subscribers[ChannelName(ch)] = urls
+		default:
+			return nil, fmt.Errorf("unknown notification channel '%s'", ch)
+		}
+	}
+
+	contentType, marshal := events.ContentTypeJSON, marshalJSON
+	switch cfg.Format {
+	case "", "json":
+	case "protobuf":
+		contentType, marshal = events.ContentTypeProtobuf, events.MarshalProto
+	default:
+		return nil, fmt.Errorf("unknown webhook format '%s'", cfg.Format)
+	}
+
+	var secret []byte
+	if cfg.SecretFile != "" {
+		data, err := os.ReadFile(cfg.SecretFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read webhook secret: %w", err)
+		}
+		secret = []byte(strings.TrimSpace(string(data)))
+	}
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("webhook secret is mandatory")
+	}
+
+	n := &WebhookNotifier{
+		cfg:         cfg,
+		secret:      secret,
+		contentType: contentType,
+		marshal:     marshal,
+		subscribers: subscribers,
+		client:      &http.Client{Timeout: cfg.Timeout},
+		logger:      l,
+		queue:       make(chan delivery, cfg.QueueSize),
+		stop:        make(chan struct{}),
+	}
+	for i := 0; i < cfg.Workers; i++ {
+		n.wg.Add(1)
+		go n.work()
+	}
+	return n, nil
+}
+
+// Notify queues event for every subscriber of the channel, never blocks
+func (n *WebhookNotifier) Notify(_ context.Context, channelName ChannelName, event *events.Event) error {
+	urls := n.subscribers[channelName]
+	if len(urls) == 0 {
+		return nil
+	}
+
+	body, err := n.marshal(event)
+	if err != nil {
+		return fmt.Errorf("could not marshal event: %w", err)
+	}
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.closed {
+		return ErrClosed
+	}
+	for _, url := range urls {
+		select {
+		case n.queue <- delivery{ctx: context.Background(), url: url, event: event, body: body}:
+		default:
+			return ErrQueueFull
+		}
+	}
+	return nil
+}
+
+// Subscribers returns URLs subscribed to the channel
+func (n *WebhookNotifier) Subscribers(channelName ChannelName) []string {
+	return n.subscribers[channelName]
+}
+
+// Deliver posts event to a single subscriber through the queue and waits for the result, so that callers
+// could track every subscriber on its own. Unlike Notify it waits for a free queue slot, retries are
+// made till ctx is done. Returns ErrRejected if subscriber refused the event for good
+func (n *WebhookNotifier) Deliver(ctx context.Context, subscriber string, event *events.Event) error {
+	body, err := n.marshal(event)
+	if err != nil {
+		return fmt.Errorf("could not marshal event: %w", err)
+	}
+
+	result := make(chan error, 1)
+	if err = n.enqueue(ctx, delivery{ctx: ctx, url: subscriber, event: event, body: body, result: result}); err != nil {
+		return err
+	}
+	select {
+	case err = <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (n *WebhookNotifier) enqueue(ctx context.Context, d delivery) error {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.closed {
+		return ErrClosed
+	}
+	select {
+	case n.queue <- d:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting notifications and waits for queued ones to be delivered,
+// deliveries still in progress once ctx is done are abandoned
+func (n *WebhookNotifier) Close(ctx context.Context) error {
+	n.mu.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.queue)
+	}
+	n.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		n.stopOnce.Do(func() { close(n.stop) })
+		return ctx.Err()
+	}
+}
+
+func (n *WebhookNotifier) work() {
+	defer n.wg.Done()
+	for d := range n.queue {
+		err := n.deliver(d)
+		if d.result != nil {
+			d.result <- err
+			continue
+		}
+		if err != nil {
+			n.logger.WithField("component", "webhooks").
+				Errorf("event %s to %s is dropped: %v", d.event.ID, d.url, err)
+		}
+	}
+}
+
+// deliver posts event retrying on network errors, 429 and 5xx responses
+func (n *WebhookNotifier) deliver(d delivery) error {
+	var err error
+	for attempt := 1; attempt <= n.cfg.MaxAttempts; attempt++ {
+		var retry bool
+		if retry, err = n.post(d); err == nil || !retry {
+			return err
+		}
+		if attempt == n.cfg.MaxAttempts {
+			break
+		}
+		n.logger.WithField("component", "webhooks").
+			Debugf("event %s to %s attempt %d failed: %v", d.event.ID, d.url, attempt, err)
+
+		select {
+		case <-time.After(n.backoff(attempt)):
+		case <-d.ctx.Done():
+			return fmt.Errorf("%w: %w", d.ctx.Err(), err)
+		case <-n.stop:
+			return fmt.Errorf("notifier is stopped: %w", err)
+		}
+	}
+	return fmt.Errorf("%d attempts failed: %w", n.cfg.MaxAttempts, err)
+}
+
+func (n *WebhookNotifier) post(d delivery) (bool, error) {
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, d.url, bytes.NewReader(d.body))
+	if err != nil {
+		return false, err
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", n.contentType)
+	req.Header.Set(HeaderEventID, d.event.ID)
+	req.Header.Set(HeaderTimestamp, ts)
+	req.Header.Set(HeaderSignature, "sha256="+Sign(n.secret, ts, d.body))
+
+	res, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	_ = res.Body.Close()
+
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return false, nil
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		return true, fmt.Errorf("subscriber responded with %d", res.StatusCode)
+	default:
+		return false, fmt.Errorf("%w with %d", ErrRejected, res.StatusCode)
+	}
+}
+
+// backoff exponential delay before the next attempt, randomized within its upper half,
+// so that subscribers recovering from an outage are not hit by all the retries at once
+func (n *WebhookNotifier) backoff(attempt int) time.Duration {
+	d := n.cfg.InitialBackoff << (attempt - 1)
+	if d <= 0 || d > n.cfg.MaxBackoff {
+		d = n.cfg.MaxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func marshalJSON(e *events.Event) ([]byte, error) {
+	return json.Marshal(e)
+}
+
+// Sign HMAC-SHA256 of the timestamp and body joined with a dot, hex encoded.
+// Subscribers should compare it with X-Signature header and reject stale timestamps
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
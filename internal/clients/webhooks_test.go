@@ -0,0 +1,222 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/BorisRostovskiy/ESL/internal/events"
+	pb "github.com/BorisRostovskiy/ESL/internal/handlers/grpc/gen/user-manager"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+const webhookSecret = "webhook-secret"
+
+func newTestNotifier(t *testing.T, cfg WebhookConfig) *WebhookNotifier {
+	t.Helper()
+	cfg.SecretFile = filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(cfg.SecretFile, []byte(webhookSecret+"\n"), 0o600))
+	if cfg.InitialBackoff == 0 {
+		cfg.InitialBackoff = time.Millisecond
+	}
+	n, err := NewWebhookNotifier(cfg, logrus.New())
+	require.NoError(t, err)
+	return n
+}
+
+func TestWebhookNotifier_Notify(t *testing.T) {
+	t.Parallel()
+	var calls atomic.Int32
+	received := make(chan events.Event, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first attempt fails, delivery must be retried
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "sha256="+Sign([]byte(webhookSecret), r.Header.Get(HeaderTimestamp), body),
+			r.Header.Get(HeaderSignature))
+
+		assert.Equal(t, events.ContentTypeJSON, r.Header.Get("Content-Type"))
+		var e events.Event
+		assert.NoError(t, json.Unmarshal(body, &e))
+		assert.Equal(t, e.ID, r.Header.Get(HeaderEventID))
+		received <- e
+	}))
+	defer srv.Close()
+
+	n := newTestNotifier(t, WebhookConfig{
+		Subscribers: map[string][]string{string(ChannelCreate): {srv.URL}},
+	})
+	created := events.NewUserCreated(&events.Actor{Subject: "ops", Method: "api_key"}, events.User{ID: "1", Email: "a@b.c"})
+	require.NoError(t, n.Notify(context.Background(), ChannelCreate, created))
+	// no subscribers of the channel
+	require.NoError(t, n.Notify(context.Background(), ChannelDelete, events.NewUserDeleted(nil, "1")))
+
+	select {
+	case e := <-received:
+		assert.Equal(t, created.ID, e.ID)
+		assert.Equal(t, events.TypeUserCreated, e.Type)
+		assert.Equal(t, "ops", e.Actor.Subject)
+		require.NotNil(t, e.Created)
+		assert.Equal(t, "a@b.c", e.Created.User.Email)
+	case <-time.After(time.Second):
+		t.Fatal("event has not been delivered")
+	}
+	require.NoError(t, n.Close(context.Background()))
+	assert.Equal(t, int32(2), calls.Load())
+	assert.ErrorIs(t, n.Notify(context.Background(), ChannelCreate, created), ErrClosed)
+}
+
+func TestWebhookNotifier_Protobuf(t *testing.T) {
+	t.Parallel()
+	received := make(chan *pb.UserEvent, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, events.ContentTypeProtobuf, r.Header.Get("Content-Type"))
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		var e pb.UserEvent
+		assert.NoError(t, proto.Unmarshal(body, &e))
+		received <- &e
+	}))
+	defer srv.Close()
+
+	n := newTestNotifier(t, WebhookConfig{
+		Subscribers: map[string][]string{string(ChannelUpdate): {srv.URL}},
+		Format:      "protobuf",
+	})
+	updated := events.NewUserUpdated(nil, "1", []events.Change{
+		{Field: "country", Old: "NL", New: "DE"},
+		{Field: "password", Redacted: true},
+	})
+	require.NoError(t, n.Notify(context.Background(), ChannelUpdate, updated))
+
+	select {
+	case e := <-received:
+		assert.Equal(t, updated.ID, e.GetId())
+		assert.Equal(t, string(events.TypeUserUpdated), e.GetType())
+		assert.Equal(t, uint32(events.SchemaVersion), e.GetSchemaVersion())
+		require.Len(t, e.GetUpdated().GetChanges(), 2)
+		assert.Equal(t, "DE", e.GetUpdated().GetChanges()[0].GetNew())
+		assert.True(t, e.GetUpdated().GetChanges()[1].GetRedacted())
+	case <-time.After(time.Second):
+		t.Fatal("event has not been delivered")
+	}
+	require.NoError(t, n.Close(context.Background()))
+}
+
+func TestWebhookNotifier_NoRetryOnRejection(t *testing.T) {
+	t.Parallel()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	n := newTestNotifier(t, WebhookConfig{
+		Subscribers: map[string][]string{string(ChannelUpdate): {srv.URL}},
+	})
+	require.NoError(t, n.Notify(context.Background(), ChannelUpdate, events.NewUserUpdated(nil, "1", nil)))
+	require.NoError(t, n.Close(context.Background()))
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestWebhookNotifier_Deliver(t *testing.T) {
+	t.Parallel()
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/flaky":
+			// the first attempt fails, delivery must be retried
+			if calls.Add(1) == 1 {
+				w.WriteHeader(http.StatusTooManyRequests)
+			}
+		case "/gone":
+			w.WriteHeader(http.StatusGone)
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+
+	n := newTestNotifier(t, WebhookConfig{
+		Subscribers: map[string][]string{string(ChannelCreate): {srv.URL + "/flaky", srv.URL + "/gone"}},
+		MaxAttempts: 2,
+	})
+	assert.Equal(t, []string{srv.URL + "/flaky", srv.URL + "/gone"}, n.Subscribers(ChannelCreate))
+	assert.Empty(t, n.Subscribers(ChannelDelete))
+
+	ctx := context.Background()
+	created := events.NewUserCreated(nil, events.User{ID: "1"})
+	require.NoError(t, n.Deliver(ctx, srv.URL+"/flaky", created))
+	assert.Equal(t, int32(2), calls.Load())
+
+	err := n.Deliver(ctx, srv.URL+"/gone", created)
+	assert.ErrorIs(t, err, ErrRejected)
+	assert.EqualError(t, err, "subscriber rejected event with 410")
+
+	err = n.Deliver(ctx, srv.URL+"/down", created)
+	assert.NotErrorIs(t, err, ErrRejected, "5xx is worth retrying")
+	assert.EqualError(t, err, "2 attempts failed: subscriber responded with 502")
+
+	require.NoError(t, n.Close(ctx))
+	assert.ErrorIs(t, n.Deliver(ctx, srv.URL+"/flaky", created), ErrClosed)
+
+	// retries are made within the deadline of the caller
+	slow := newTestNotifier(t, WebhookConfig{InitialBackoff: time.Hour})
+	timeout, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, slow.Deliver(timeout, srv.URL+"/down", created), context.DeadlineExceeded)
+	require.NoError(t, slow.Close(ctx))
+}
+
+func TestWebhookNotifier_QueueFull(t *testing.T) {
+	t.Parallel()
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	n := newTestNotifier(t, WebhookConfig{
+		Subscribers: map[string][]string{string(ChannelCreate): {srv.URL}},
+		QueueSize:   1,
+		Workers:     1,
+	})
+
+	// the worker takes the first event and hangs on the subscriber, the second one fills the queue
+	var err error
+	for i := 0; i < 3 && err == nil; i++ {
+		err = n.Notify(context.Background(), ChannelCreate, events.NewUserCreated(nil, events.User{ID: "1"}))
+	}
+	assert.ErrorIs(t, err, ErrQueueFull)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, n.Close(ctx), context.DeadlineExceeded)
+}
+
+func TestNewWebhookNotifier_UnknownChannel(t *testing.T) {
+	t.Parallel()
+	_, err := NewWebhookNotifier(WebhookConfig{
+		Subscribers: map[string][]string{"upsert": {"http://localhost"}},
+	}, logrus.New())
+	assert.EqualError(t, err, "unknown notification channel 'upsert'")
+
+	_, err = NewWebhookNotifier(WebhookConfig{Format: "xml"}, logrus.New())
+	assert.EqualError(t, err, "unknown webhook format 'xml'")
+}
@@ -0,0 +1,191 @@
+// Package events defines user change events delivered to notification subscribers
+package events
+
+import (
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SchemaVersion version of the event payloads, bumped on incompatible changes only
+const SchemaVersion = 1
+
+// Type event type
+type Type string
+
+const (
+	TypeUserCreated  Type = "user.created"
+	TypeUserUpdated  Type = "user.updated"
+	TypeUserDeleted  Type = "user.deleted"
+	TypeUserRestored Type = "user.restored"
+	TypeUserErased   Type = "user.erased"
+)
+
+// PersonalFields user fields holding personal data, erased on the data subject request
+var PersonalFields = []string{"first_name", "last_name", "nickname", "email"}
+
+type (
+	// Event user change event, exactly one of Created, Updated, Deleted, Restored and Erased is set according to Type
+	Event struct {
+		ID            string    `json:"id"`
+		Type          Type      `json:"type"`
+		SchemaVersion int       `json:"schema_version"`
+		Timestamp     time.Time `json:"timestamp"`
+		// Actor who made the change, nil if authentication is disabled
+		Actor  *Actor `json:"actor,omitempty"`
+		UserID string `json:"user_id"`
+		// Country of the user after the change, the one user had for deletions
+		Country string `json:"country,omitempty"`
+
+		Created  *UserCreated  `json:"created,omitempty"`
+		Updated  *UserUpdated  `json:"updated,omitempty"`
+		Deleted  *UserDeleted  `json:"deleted,omitempty"`
+		Restored *UserRestored `json:"restored,omitempty"`
+		Erased   *UserErased   `json:"erased,omitempty"`
+	}
+
+	// Actor authenticated caller made the change
+	Actor struct {
+		Subject string `json:"subject"`
+		Method  string `json:"method"`
+		Role    string `json:"role,omitempty"`
+	}
+
+	// User user snapshot, password is never included
+	User struct {
+		ID        string    `json:"id"`
+		FirstName string    `json:"first_name"`
+		LastName  string    `json:"last_name"`
+		NickName  string    `json:"nickname"`
+		Email     string    `json:"email"`
+		Country   string    `json:"country"`
+		Role      string    `json:"role"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+
+	UserCreated struct {
+		User User `json:"user"`
+	}
+
+	UserUpdated struct {
+		Changes []Change `json:"changes"`
+	}
+
+	// Change changed field with its values before and after the update
+	Change struct {
+		Field string `json:"field"`
+		Old   string `json:"old,omitempty"`
+		New   string `json:"new,omitempty"`
+		// Redacted values of secret fields, e.g. password, are never sent
+		Redacted bool `json:"redacted,omitempty"`
+	}
+
+	UserDeleted struct{}
+
+	// UserRestored restored deleted user, as it is after the restore
+	UserRestored struct {
+		User User `json:"user"`
+	}
+
+	// UserErased personal data of the user is erased, subscribers should erase their copies as well
+	UserErased struct {
+		Fields []string `json:"fields"`
+	}
+)
+
+// NewUserCreated creates event of the user creation
+func NewUserCreated(actor *Actor, u User) *Event {
+	e := newEvent(TypeUserCreated, actor, u.ID)
+	e.Country = u.Country
+	e.Created = &UserCreated{User: u}
+	return e
+}
+
+// NewUserUpdated creates event of the user update
+func NewUserUpdated(actor *Actor, userID string, changes []Change) *Event {
+	e := newEvent(TypeUserUpdated, actor, userID)
+	e.Updated = &UserUpdated{Changes: changes}
+	return e
+}
+
+// NewUserDeleted creates event of the user deletion
+func NewUserDeleted(actor *Actor, userID string) *Event {
+	e := newEvent(TypeUserDeleted, actor, userID)
+	e.Deleted = &UserDeleted{}
+	return e
+}
+
+// NewUserRestored creates event of the deleted user restore
+func NewUserRestored(actor *Actor, u User) *Event {
+	e := newEvent(TypeUserRestored, actor, u.ID)
+	e.Country = u.Country
+	e.Restored = &UserRestored{User: u}
+	return e
+}
+
+// NewUserErased creates event of the user personal data erasure
+func NewUserErased(actor *Actor, userID string, fields []string) *Event {
+	e := newEvent(TypeUserErased, actor, userID)
+	e.Erased = &UserErased{Fields: fields}
+	return e
+}
+
+// Redact removes values of the given fields from the user snapshots and changes of the event,
+// reports whether anything is removed
+func (e *Event) Redact(fields []string) bool {
+	redacted := false
+	if e.Created != nil && e.Created.User.redact(fields) {
+		redacted = true
+	}
+	if e.Restored != nil && e.Restored.User.redact(fields) {
+		redacted = true
+	}
+	if e.Updated != nil && RedactChanges(e.Updated.Changes, fields) {
+		redacted = true
+	}
+	return redacted
+}
+
+// RedactChanges redacts values of the given fields in place, reports whether any value is redacted
+func RedactChanges(changes []Change, fields []string) bool {
+	redacted := false
+	for i, c := range changes {
+		if !slices.Contains(fields, c.Field) || c.Old == "" && c.New == "" {
+			continue
+		}
+		changes[i] = Change{Field: c.Field, Redacted: true}
+		redacted = true
+	}
+	return redacted
+}
+
+func (u *User) redact(fields []string) bool {
+	values := map[string]*string{
+		"first_name": &u.FirstName,
+		"last_name":  &u.LastName,
+		"nickname":   &u.NickName,
+		"email":      &u.Email,
+		"country":    &u.Country,
+		"role":       &u.Role,
+	}
+	redacted := false
+	for _, f := range fields {
+		if v, ok := values[f]; ok && *v != "" {
+			*v = ""
+			redacted = true
+		}
+	}
+	return redacted
+}
+
+func newEvent(t Type, actor *Actor, userID string) *Event {
+	return &Event{
+		ID:            uuid.New().String(),
+		Type:          t,
+		SchemaVersion: SchemaVersion,
+		Timestamp:     time.Now().UTC(),
+		Actor:         actor,
+		UserID:        userID,
+	}
+}
@@ -0,0 +1,65 @@
+package events
+
+import (
+	"time"
+
+	pb "github.com/BorisRostovskiy/ESL/internal/handlers/grpc/gen/user-manager"
+	"google.golang.org/protobuf/proto"
+)
+
+// Content types events are serialized to
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+// ToProto converts event to its protobuf message defined in events.proto
+func ToProto(e *Event) *pb.UserEvent {
+	m := &pb.UserEvent{
+		Id:            e.ID,
+		Type:          string(e.Type),
+		SchemaVersion: uint32(e.SchemaVersion),
+		Timestamp:     e.Timestamp.Format(time.RFC3339Nano),
+		UserId:        e.UserID,
+		Country:       e.Country,
+	}
+	if e.Actor != nil {
+		m.Actor = &pb.Actor{Subject: e.Actor.Subject, Method: e.Actor.Method, Role: e.Actor.Role}
+	}
+
+	switch {
+	case e.Created != nil:
+		m.Payload = &pb.UserEvent_Created{Created: &pb.UserCreated{User: userToProto(e.Created.User)}}
+	case e.Updated != nil:
+		changes := make([]*pb.FieldChange, len(e.Updated.Changes))
+		for i, c := range e.Updated.Changes {
+			changes[i] = &pb.FieldChange{Field: c.Field, Old: c.Old, New: c.New, Redacted: c.Redacted}
+		}
+		m.Payload = &pb.UserEvent_Updated{Updated: &pb.UserUpdated{Changes: changes}}
+	case e.Deleted != nil:
+		m.Payload = &pb.UserEvent_Deleted{Deleted: &pb.UserDeleted{}}
+	case e.Restored != nil:
+		m.Payload = &pb.UserEvent_Restored{Restored: &pb.UserRestored{User: userToProto(e.Restored.User)}}
+	case e.Erased != nil:
+		m.Payload = &pb.UserEvent_Erased{Erased: &pb.UserErased{Fields: e.Erased.Fields}}
+	}
+	return m
+}
+
+func userToProto(u User) *pb.User {
+	return &pb.User{
+		Id:        u.ID,
+		FirstName: u.FirstName,
+		LastName:  u.LastName,
+		Nickname:  u.NickName,
+		Email:     u.Email,
+		Country:   u.Country,
+		Role:      u.Role,
+		CreatedAt: u.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// MarshalProto serializes event as protobuf UserEvent message
+func MarshalProto(e *Event) ([]byte, error) {
+	return proto.Marshal(ToProto(e))
+}
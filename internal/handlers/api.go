@@ -3,13 +3,36 @@ package handlers
 import (
 	"context"
 
+	"github.com/BorisRostovskiy/ESL/internal/events"
 	"github.com/BorisRostovskiy/ESL/internal/service"
 )
 
 type UsersService interface {
 	HealthCheck(ctx context.Context) error
+	Authenticate(ctx context.Context, login, password string) (*service.Session, error)
+	Refresh(ctx context.Context, refreshToken string) (*service.TokenPair, error)
+	GetUser(ctx context.Context, id string) (*service.User, error)
+	GetUserByEmail(ctx context.Context, email string) (*service.User, error)
+	GetUserByNickname(ctx context.Context, nickname string) (*service.User, error)
 	CreateUser(ctx context.Context, in *service.User) (*service.User, error)
-	ListUsers(ctx context.Context, limit, offset int, filter *service.Filter) ([]service.User, error)
+	ListUsers(ctx context.Context, q service.ListQuery) (*service.UsersPage, error)
+	StreamUsers(ctx context.Context, q service.ListQuery, fn func(u *service.User) error) error
+	// WatchUsers calls fn for every user change as it happens, with nil event for heartbeats
+	WatchUsers(ctx context.Context, q service.WatchQuery, fn func(e *events.Event) error) error
 	UpdateUser(ctx context.Context, updated *service.User) error
 	DeleteUser(ctx context.Context, id string) error
+	// RestoreUser restores deleted user unless it is purged already
+	RestoreUser(ctx context.Context, id string) (*service.User, error)
+	BatchCreateUsers(ctx context.Context, in []*service.User, atomic bool) ([]service.BatchResult, error)
+	BatchUpdateUsers(ctx context.Context, in []*service.User, atomic bool) ([]service.BatchResult, error)
+	BatchDeleteUsers(ctx context.Context, ids []string, atomic bool) ([]service.BatchResult, error)
+	ImportUsers(ctx context.Context, in []*service.User, dryRun bool) ([]service.ImportResult, error)
+	// UserHistory returns page of the user changes, newest first
+	UserHistory(ctx context.Context, q service.HistoryQuery) (*service.HistoryPage, error)
+	// ExportUser returns everything stored about the user, deleted and erased users included
+	ExportUser(ctx context.Context, id string) (*service.UserExport, error)
+	// EraseUser irreversibly erases personal data of the user while its ID is kept
+	EraseUser(ctx context.Context, id string) error
+	// Filters fields enabled for listing filters
+	Filters() service.Filters
 }
@@ -0,0 +1,77 @@
+package grpc
+
+import (
+	"context"
+	"strings"
+
+	"github.com/BorisRostovskiy/ESL/internal/auth"
+	"github.com/BorisRostovskiy/ESL/internal/service"
+	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors"
+	grpcAuth "github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/auth"
+	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/selector"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	// publicMethods could be called without credentials
+	publicMethods = map[string]bool{
+		"/user_manager.v1.UserManager/Authenticate": true,
+		"/user_manager.v1.UserManager/RefreshToken": true,
+	}
+	publicServices = []string{
+		"/grpc.health.v1.Health/",
+		"/grpc.reflection.",
+	}
+)
+
+// AuthInterceptors unary and stream interceptors rejecting calls without valid credentials,
+// caller identity is stored into the call context. Health, reflection and login calls are public
+func AuthInterceptors(authn *auth.Authenticator, log *logrus.Logger) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	authFunc := func(ctx context.Context) (context.Context, error) {
+		creds := auth.Credentials{
+			APIKey: firstMD(ctx, strings.ToLower(auth.HeaderAPIKey)),
+			Bearer: auth.BearerToken(firstMD(ctx, "authorization")),
+		}
+		if p, ok := peer.FromContext(ctx); ok {
+			if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+				creds.TLS = &info.State
+			}
+		}
+
+		caller, err := authn.Authenticate(creds)
+		if err != nil {
+			log.WithField("component", "grpc_handler").Debugf("authentication failed: %v", err)
+			return nil, status.Error(apiErrorCodeStatus[service.ErrCodeUnauthenticated], service.ErrUnauthenticated.Message)
+		}
+		return service.WithCaller(ctx, caller), nil
+	}
+	protected := selector.MatchFunc(func(_ context.Context, c interceptors.CallMeta) bool {
+		return !isPublic(c.FullMethod())
+	})
+	return selector.UnaryServerInterceptor(grpcAuth.UnaryServerInterceptor(authFunc), protected),
+		selector.StreamServerInterceptor(grpcAuth.StreamServerInterceptor(authFunc), protected)
+}
+
+func isPublic(method string) bool {
+	if publicMethods[method] {
+		return true
+	}
+	for _, prefix := range publicServices {
+		if strings.HasPrefix(method, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func firstMD(ctx context.Context, key string) string {
+	if v := metadata.ValueFromIncomingContext(ctx, key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
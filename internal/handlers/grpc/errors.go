@@ -18,6 +18,15 @@ var apiErrorCodeStatus = map[int]codes.Code{
 	service.ErrCodeUserNotFound:      codes.NotFound,
 	service.ErrCodeConflict:          codes.AlreadyExists,
 	service.ErrCodeEmptyUpdate:       codes.InvalidArgument,
+	service.ErrCodeNotConfigured:     codes.Unimplemented,
+	service.ErrCodeWatchExpired:      codes.OutOfRange,
+	service.ErrCodeWatchLagging:      codes.Unavailable,
+	service.ErrCodeVersionMismatch:   codes.FailedPrecondition,
+
+	service.ErrCodeInvalidCredentials:  codes.Unauthenticated,
+	service.ErrCodeInvalidRefreshToken: codes.Unauthenticated,
+	service.ErrCodeUnauthenticated:     codes.Unauthenticated,
+	service.ErrCodePermissionDenied:    codes.PermissionDenied,
 }
 
 var (
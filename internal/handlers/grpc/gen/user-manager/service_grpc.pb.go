@@ -23,10 +23,27 @@ const _ = grpc.SupportPackageIsVersion7
 //
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type UserManagerClient interface {
+	Authenticate(ctx context.Context, in *AuthenticateRequest, opts ...grpc.CallOption) (*AuthenticateResponse, error)
+	RefreshToken(ctx context.Context, in *RefreshTokenRequest, opts ...grpc.CallOption) (*Tokens, error)
+	GetUser(ctx context.Context, in *GetUserRequest, opts ...grpc.CallOption) (*User, error)
 	ListUsers(ctx context.Context, in *ListUsersRequest, opts ...grpc.CallOption) (*ListUsersResponse, error)
+	// StreamUsers streams every matching user from a single consistent snapshot, e.g. for syncs
+	StreamUsers(ctx context.Context, in *StreamUsersRequest, opts ...grpc.CallOption) (UserManager_StreamUsersClient, error)
+	// WatchUsers streams user changes as they happen, heartbeats are sent while there are none
+	WatchUsers(ctx context.Context, in *WatchUsersRequest, opts ...grpc.CallOption) (UserManager_WatchUsersClient, error)
 	CreateUser(ctx context.Context, in *CreateUserRequest, opts ...grpc.CallOption) (*User, error)
 	UpdateUser(ctx context.Context, in *UpdateUserRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
 	DeleteUser(ctx context.Context, in *DeleteUserRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// RestoreUser restores deleted user unless it is purged already, returns the restored user
+	RestoreUser(ctx context.Context, in *RestoreUserRequest, opts ...grpc.CallOption) (*User, error)
+	// ListUserHistory pages through the user changes, newest first
+	ListUserHistory(ctx context.Context, in *ListUserHistoryRequest, opts ...grpc.CallOption) (*ListUserHistoryResponse, error)
+	// EraseUser irreversibly erases personal data of the user, deleted users included, its ID is kept
+	EraseUser(ctx context.Context, in *EraseUserRequest, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// batches are applied item by item reporting per item results, all or nothing if atomic
+	BatchCreateUsers(ctx context.Context, in *BatchCreateUsersRequest, opts ...grpc.CallOption) (*BatchUsersResponse, error)
+	BatchUpdateUsers(ctx context.Context, in *BatchUpdateUsersRequest, opts ...grpc.CallOption) (*BatchUsersResponse, error)
+	BatchDeleteUsers(ctx context.Context, in *BatchDeleteUsersRequest, opts ...grpc.CallOption) (*BatchUsersResponse, error)
 }
 
 type userManagerClient struct {
@@ -37,6 +54,33 @@ func NewUserManagerClient(cc grpc.ClientConnInterface) UserManagerClient {
 	return &userManagerClient{cc}
 }
 
+func (c *userManagerClient) Authenticate(ctx context.Context, in *AuthenticateRequest, opts ...grpc.CallOption) (*AuthenticateResponse, error) {
+	out := new(AuthenticateResponse)
+	err := c.cc.Invoke(ctx, "/user_manager.v1.UserManager/Authenticate", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userManagerClient) RefreshToken(ctx context.Context, in *RefreshTokenRequest, opts ...grpc.CallOption) (*Tokens, error) {
+	out := new(Tokens)
+	err := c.cc.Invoke(ctx, "/user_manager.v1.UserManager/RefreshToken", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userManagerClient) GetUser(ctx context.Context, in *GetUserRequest, opts ...grpc.CallOption) (*User, error) {
+	out := new(User)
+	err := c.cc.Invoke(ctx, "/user_manager.v1.UserManager/GetUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *userManagerClient) ListUsers(ctx context.Context, in *ListUsersRequest, opts ...grpc.CallOption) (*ListUsersResponse, error) {
 	out := new(ListUsersResponse)
 	err := c.cc.Invoke(ctx, "/user_manager.v1.UserManager/ListUsers", in, out, opts...)
@@ -46,6 +90,70 @@ func (c *userManagerClient) ListUsers(ctx context.Context, in *ListUsersRequest,
 	return out, nil
 }
 
+func (c *userManagerClient) StreamUsers(ctx context.Context, in *StreamUsersRequest, opts ...grpc.CallOption) (UserManager_StreamUsersClient, error) {
+	stream, err := c.cc.NewStream(ctx, &UserManager_ServiceDesc.Streams[0], "/user_manager.v1.UserManager/StreamUsers", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &userManagerStreamUsersClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type UserManager_StreamUsersClient interface {
+	Recv() (*User, error)
+	grpc.ClientStream
+}
+
+type userManagerStreamUsersClient struct {
+	grpc.ClientStream
+}
+
+func (x *userManagerStreamUsersClient) Recv() (*User, error) {
+	m := new(User)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *userManagerClient) WatchUsers(ctx context.Context, in *WatchUsersRequest, opts ...grpc.CallOption) (UserManager_WatchUsersClient, error) {
+	stream, err := c.cc.NewStream(ctx, &UserManager_ServiceDesc.Streams[1], "/user_manager.v1.UserManager/WatchUsers", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &userManagerWatchUsersClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type UserManager_WatchUsersClient interface {
+	Recv() (*WatchUsersResponse, error)
+	grpc.ClientStream
+}
+
+type userManagerWatchUsersClient struct {
+	grpc.ClientStream
+}
+
+func (x *userManagerWatchUsersClient) Recv() (*WatchUsersResponse, error) {
+	m := new(WatchUsersResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func (c *userManagerClient) CreateUser(ctx context.Context, in *CreateUserRequest, opts ...grpc.CallOption) (*User, error) {
 	out := new(User)
 	err := c.cc.Invoke(ctx, "/user_manager.v1.UserManager/CreateUser", in, out, opts...)
@@ -73,14 +181,85 @@ func (c *userManagerClient) DeleteUser(ctx context.Context, in *DeleteUserReques
 	return out, nil
 }
 
+func (c *userManagerClient) RestoreUser(ctx context.Context, in *RestoreUserRequest, opts ...grpc.CallOption) (*User, error) {
+	out := new(User)
+	err := c.cc.Invoke(ctx, "/user_manager.v1.UserManager/RestoreUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userManagerClient) ListUserHistory(ctx context.Context, in *ListUserHistoryRequest, opts ...grpc.CallOption) (*ListUserHistoryResponse, error) {
+	out := new(ListUserHistoryResponse)
+	err := c.cc.Invoke(ctx, "/user_manager.v1.UserManager/ListUserHistory", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userManagerClient) EraseUser(ctx context.Context, in *EraseUserRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/user_manager.v1.UserManager/EraseUser", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userManagerClient) BatchCreateUsers(ctx context.Context, in *BatchCreateUsersRequest, opts ...grpc.CallOption) (*BatchUsersResponse, error) {
+	out := new(BatchUsersResponse)
+	err := c.cc.Invoke(ctx, "/user_manager.v1.UserManager/BatchCreateUsers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userManagerClient) BatchUpdateUsers(ctx context.Context, in *BatchUpdateUsersRequest, opts ...grpc.CallOption) (*BatchUsersResponse, error) {
+	out := new(BatchUsersResponse)
+	err := c.cc.Invoke(ctx, "/user_manager.v1.UserManager/BatchUpdateUsers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *userManagerClient) BatchDeleteUsers(ctx context.Context, in *BatchDeleteUsersRequest, opts ...grpc.CallOption) (*BatchUsersResponse, error) {
+	out := new(BatchUsersResponse)
+	err := c.cc.Invoke(ctx, "/user_manager.v1.UserManager/BatchDeleteUsers", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // UserManagerServer is the server API for UserManager service.
 // All implementations must embed UnimplementedUserManagerServer
 // for forward compatibility
 type UserManagerServer interface {
+	Authenticate(context.Context, *AuthenticateRequest) (*AuthenticateResponse, error)
+	RefreshToken(context.Context, *RefreshTokenRequest) (*Tokens, error)
+	GetUser(context.Context, *GetUserRequest) (*User, error)
 	ListUsers(context.Context, *ListUsersRequest) (*ListUsersResponse, error)
+	// StreamUsers streams every matching user from a single consistent snapshot, e.g. for syncs
+	StreamUsers(*StreamUsersRequest, UserManager_StreamUsersServer) error
+	// WatchUsers streams user changes as they happen, heartbeats are sent while there are none
+	WatchUsers(*WatchUsersRequest, UserManager_WatchUsersServer) error
 	CreateUser(context.Context, *CreateUserRequest) (*User, error)
 	UpdateUser(context.Context, *UpdateUserRequest) (*emptypb.Empty, error)
 	DeleteUser(context.Context, *DeleteUserRequest) (*emptypb.Empty, error)
+	// RestoreUser restores deleted user unless it is purged already, returns the restored user
+	RestoreUser(context.Context, *RestoreUserRequest) (*User, error)
+	// ListUserHistory pages through the user changes, newest first
+	ListUserHistory(context.Context, *ListUserHistoryRequest) (*ListUserHistoryResponse, error)
+	// EraseUser irreversibly erases personal data of the user, deleted users included, its ID is kept
+	EraseUser(context.Context, *EraseUserRequest) (*emptypb.Empty, error)
+	// batches are applied item by item reporting per item results, all or nothing if atomic
+	BatchCreateUsers(context.Context, *BatchCreateUsersRequest) (*BatchUsersResponse, error)
+	BatchUpdateUsers(context.Context, *BatchUpdateUsersRequest) (*BatchUsersResponse, error)
+	BatchDeleteUsers(context.Context, *BatchDeleteUsersRequest) (*BatchUsersResponse, error)
 	mustEmbedUnimplementedUserManagerServer()
 }
 
@@ -88,9 +267,24 @@ type UserManagerServer interface {
 type UnimplementedUserManagerServer struct {
 }
 
+func (UnimplementedUserManagerServer) Authenticate(context.Context, *AuthenticateRequest) (*AuthenticateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Authenticate not implemented")
+}
+func (UnimplementedUserManagerServer) RefreshToken(context.Context, *RefreshTokenRequest) (*Tokens, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RefreshToken not implemented")
+}
+func (UnimplementedUserManagerServer) GetUser(context.Context, *GetUserRequest) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetUser not implemented")
+}
 func (UnimplementedUserManagerServer) ListUsers(context.Context, *ListUsersRequest) (*ListUsersResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ListUsers not implemented")
 }
+func (UnimplementedUserManagerServer) StreamUsers(*StreamUsersRequest, UserManager_StreamUsersServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamUsers not implemented")
+}
+func (UnimplementedUserManagerServer) WatchUsers(*WatchUsersRequest, UserManager_WatchUsersServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchUsers not implemented")
+}
 func (UnimplementedUserManagerServer) CreateUser(context.Context, *CreateUserRequest) (*User, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method CreateUser not implemented")
 }
@@ -100,6 +294,24 @@ func (UnimplementedUserManagerServer) UpdateUser(context.Context, *UpdateUserReq
 func (UnimplementedUserManagerServer) DeleteUser(context.Context, *DeleteUserRequest) (*emptypb.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method DeleteUser not implemented")
 }
+func (UnimplementedUserManagerServer) RestoreUser(context.Context, *RestoreUserRequest) (*User, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RestoreUser not implemented")
+}
+func (UnimplementedUserManagerServer) ListUserHistory(context.Context, *ListUserHistoryRequest) (*ListUserHistoryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListUserHistory not implemented")
+}
+func (UnimplementedUserManagerServer) EraseUser(context.Context, *EraseUserRequest) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method EraseUser not implemented")
+}
+func (UnimplementedUserManagerServer) BatchCreateUsers(context.Context, *BatchCreateUsersRequest) (*BatchUsersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchCreateUsers not implemented")
+}
+func (UnimplementedUserManagerServer) BatchUpdateUsers(context.Context, *BatchUpdateUsersRequest) (*BatchUsersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchUpdateUsers not implemented")
+}
+func (UnimplementedUserManagerServer) BatchDeleteUsers(context.Context, *BatchDeleteUsersRequest) (*BatchUsersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchDeleteUsers not implemented")
+}
 func (UnimplementedUserManagerServer) mustEmbedUnimplementedUserManagerServer() {}
 
 // UnsafeUserManagerServer may be embedded to opt out of forward compatibility for this service.
@@ -113,6 +325,60 @@ func RegisterUserManagerServer(s grpc.ServiceRegistrar, srv UserManagerServer) {
 	s.RegisterService(&UserManager_ServiceDesc, srv)
 }
 
+func _UserManager_Authenticate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AuthenticateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserManagerServer).Authenticate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/user_manager.v1.UserManager/Authenticate",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserManagerServer).Authenticate(ctx, req.(*AuthenticateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserManager_RefreshToken_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RefreshTokenRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserManagerServer).RefreshToken(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/user_manager.v1.UserManager/RefreshToken",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserManagerServer).RefreshToken(ctx, req.(*RefreshTokenRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserManager_GetUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserManagerServer).GetUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/user_manager.v1.UserManager/GetUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserManagerServer).GetUser(ctx, req.(*GetUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _UserManager_ListUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(ListUsersRequest)
 	if err := dec(in); err != nil {
@@ -131,6 +397,48 @@ func _UserManager_ListUsers_Handler(srv interface{}, ctx context.Context, dec fu
 	return interceptor(ctx, in, info, handler)
 }
 
+func _UserManager_StreamUsers_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamUsersRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(UserManagerServer).StreamUsers(m, &userManagerStreamUsersServer{stream})
+}
+
+type UserManager_StreamUsersServer interface {
+	Send(*User) error
+	grpc.ServerStream
+}
+
+type userManagerStreamUsersServer struct {
+	grpc.ServerStream
+}
+
+func (x *userManagerStreamUsersServer) Send(m *User) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _UserManager_WatchUsers_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchUsersRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(UserManagerServer).WatchUsers(m, &userManagerWatchUsersServer{stream})
+}
+
+type UserManager_WatchUsersServer interface {
+	Send(*WatchUsersResponse) error
+	grpc.ServerStream
+}
+
+type userManagerWatchUsersServer struct {
+	grpc.ServerStream
+}
+
+func (x *userManagerWatchUsersServer) Send(m *WatchUsersResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 func _UserManager_CreateUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(CreateUserRequest)
 	if err := dec(in); err != nil {
@@ -185,6 +493,114 @@ func _UserManager_DeleteUser_Handler(srv interface{}, ctx context.Context, dec f
 	return interceptor(ctx, in, info, handler)
 }
 
+func _UserManager_RestoreUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RestoreUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserManagerServer).RestoreUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/user_manager.v1.UserManager/RestoreUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserManagerServer).RestoreUser(ctx, req.(*RestoreUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserManager_ListUserHistory_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListUserHistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserManagerServer).ListUserHistory(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/user_manager.v1.UserManager/ListUserHistory",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserManagerServer).ListUserHistory(ctx, req.(*ListUserHistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserManager_EraseUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EraseUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserManagerServer).EraseUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/user_manager.v1.UserManager/EraseUser",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserManagerServer).EraseUser(ctx, req.(*EraseUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserManager_BatchCreateUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchCreateUsersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserManagerServer).BatchCreateUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/user_manager.v1.UserManager/BatchCreateUsers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserManagerServer).BatchCreateUsers(ctx, req.(*BatchCreateUsersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserManager_BatchUpdateUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchUpdateUsersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserManagerServer).BatchUpdateUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/user_manager.v1.UserManager/BatchUpdateUsers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserManagerServer).BatchUpdateUsers(ctx, req.(*BatchUpdateUsersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _UserManager_BatchDeleteUsers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchDeleteUsersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(UserManagerServer).BatchDeleteUsers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/user_manager.v1.UserManager/BatchDeleteUsers",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(UserManagerServer).BatchDeleteUsers(ctx, req.(*BatchDeleteUsersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // UserManager_ServiceDesc is the grpc.ServiceDesc for UserManager service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -192,6 +608,18 @@ var UserManager_ServiceDesc = grpc.ServiceDesc{
 	ServiceName: "user_manager.v1.UserManager",
 	HandlerType: (*UserManagerServer)(nil),
 	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Authenticate",
+			Handler:    _UserManager_Authenticate_Handler,
+		},
+		{
+			MethodName: "RefreshToken",
+			Handler:    _UserManager_RefreshToken_Handler,
+		},
+		{
+			MethodName: "GetUser",
+			Handler:    _UserManager_GetUser_Handler,
+		},
 		{
 			MethodName: "ListUsers",
 			Handler:    _UserManager_ListUsers_Handler,
@@ -208,7 +636,42 @@ var UserManager_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "DeleteUser",
 			Handler:    _UserManager_DeleteUser_Handler,
 		},
+		{
+			MethodName: "RestoreUser",
+			Handler:    _UserManager_RestoreUser_Handler,
+		},
+		{
+			MethodName: "ListUserHistory",
+			Handler:    _UserManager_ListUserHistory_Handler,
+		},
+		{
+			MethodName: "EraseUser",
+			Handler:    _UserManager_EraseUser_Handler,
+		},
+		{
+			MethodName: "BatchCreateUsers",
+			Handler:    _UserManager_BatchCreateUsers_Handler,
+		},
+		{
+			MethodName: "BatchUpdateUsers",
+			Handler:    _UserManager_BatchUpdateUsers_Handler,
+		},
+		{
+			MethodName: "BatchDeleteUsers",
+			Handler:    _UserManager_BatchDeleteUsers_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamUsers",
+			Handler:       _UserManager_StreamUsers_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchUsers",
+			Handler:       _UserManager_WatchUsers_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "internal/handlers/grpc/proto/user-manager/v1/service.proto",
 }
@@ -3,22 +3,30 @@ package grpc
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 
+	"github.com/BorisRostovskiy/ESL/internal/events"
 	"github.com/BorisRostovskiy/ESL/internal/handlers"
 	pb "github.com/BorisRostovskiy/ESL/internal/handlers/grpc/gen/user-manager"
+	"github.com/BorisRostovskiy/ESL/internal/service"
 )
 
 type UserManagerServer struct {
-	api handlers.UsersService
-	log *logrus.Logger
+	api   handlers.UsersService
+	log   *logrus.Logger
+	pages *handlers.PageTokens
 	pb.UnimplementedUserManagerServer
 }
 
-func New(api handlers.UsersService, log *logrus.Logger) UserManagerServer {
-	return UserManagerServer{api: api, log: log}
+func New(api handlers.UsersService, log *logrus.Logger, pages *handlers.PageTokens) UserManagerServer {
+	return UserManagerServer{api: api, log: log, pages: pages}
 }
 
 func (ums UserManagerServer) CreateUser(ctx context.Context, r *pb.CreateUserRequest) (*pb.User, error) {
@@ -40,37 +48,188 @@ func (ums UserManagerServer) CreateUser(ctx context.Context, r *pb.CreateUserReq
 	return cu.Encode(), nil
 }
 
+func (ums UserManagerServer) Authenticate(ctx context.Context, r *pb.AuthenticateRequest) (*pb.AuthenticateResponse, error) {
+	if r.GetLogin() == "" || r.GetPassword() == "" {
+		return nil, errRequest(ctx, fmt.Errorf("login and password are mandatory"))
+	}
+	session, err := ums.api.Authenticate(ctx, r.GetLogin(), r.GetPassword())
+	if err != nil {
+		ums.log.WithField("component", "grpc_handler").
+			Debugf("failed to perform authenticate: %v", err)
+		return nil, errApi(ctx, err)
+	}
+	resp := &pb.AuthenticateResponse{User: user2PB(session.User)}
+	if session.Tokens != nil {
+		resp.Tokens = tokens2PB(session.Tokens)
+	}
+	return resp, nil
+}
+
+func (ums UserManagerServer) RefreshToken(ctx context.Context, r *pb.RefreshTokenRequest) (*pb.Tokens, error) {
+	if r.GetRefreshToken() == "" {
+		return nil, errRequest(ctx, fmt.Errorf("refresh_token is mandatory"))
+	}
+	tokens, err := ums.api.Refresh(ctx, r.GetRefreshToken())
+	if err != nil {
+		ums.log.WithField("component", "grpc_handler").
+			Debugf("failed to perform refresh token: %v", err)
+		return nil, errApi(ctx, err)
+	}
+	return tokens2PB(tokens), nil
+}
+
+func (ums UserManagerServer) GetUser(ctx context.Context, r *pb.GetUserRequest) (*pb.User, error) {
+	var (
+		user *service.User
+		err  error
+	)
+	switch l := r.GetLookup().(type) {
+	case *pb.GetUserRequest_Id:
+		user, err = ums.api.GetUser(ctx, l.Id)
+	case *pb.GetUserRequest_Email:
+		user, err = ums.api.GetUserByEmail(ctx, l.Email)
+	case *pb.GetUserRequest_Nickname:
+		user, err = ums.api.GetUserByNickname(ctx, l.Nickname)
+	default:
+		return nil, errRequest(ctx, fmt.Errorf("user id, email or nickname is mandatory"))
+	}
+	if err != nil {
+		ums.log.WithField("component", "grpc_handler").
+			Debugf("failed to perform get user: %v", err)
+		return nil, errApi(ctx, err)
+	}
+	return user2PB(user), nil
+}
+
 func (ums UserManagerServer) ListUsers(ctx context.Context, r *pb.ListUsersRequest) (*pb.ListUsersResponse, error) {
 	lu := &listUsers{}
-	if err := lu.Decode(r); err != nil {
+	if err := lu.Decode(r, ums.pages, ums.api.Filters()); err != nil {
 		ums.log.WithField("component", "grpc_handler").
 			Debugf("list users decode error: %v", err)
 		return nil, errRequest(ctx, err)
 	}
 
-	users, err := ums.api.ListUsers(ctx, lu.Limit, lu.Offset, lu.Filter)
+	page, err := ums.api.ListUsers(ctx, lu.Query)
 	if err != nil {
 		ums.log.WithField("component", "grpc_handler").
 			Debugf("failed to perform list users: %v", err)
 		return nil, errApif(ctx, "could not list users: %w", err)
 	}
 
-	resp := lu.Encode(users)
+	resp := lu.Encode(page.Users)
+	lu.EncodeTotals(resp, page.Totals)
 	if len(resp.Users) == 0 {
 		return resp, nil
 	}
 
-	np, err := handlers.GenerateNextPage(lu.Limit, lu.Offset, len(users), lu.Filter)
+	np, err := ums.pages.GenerateNextPage(lu.Query, page.Next)
 	if err != nil {
 		ums.log.WithField("component", "grpc_handler").
 			Debugf("could not marshal next page: %v", err)
 		return nil, errRequestf(ctx, "could not marshal next page structure: %w", err)
 	}
-	resp.NextPage = &np
+	if np != "" {
+		resp.NextPage = &np
+	}
 
 	return resp, nil
 }
 
+// StreamUsers sends users one by one, Send blocks while the client is not ready for more,
+// so that users are read from the storage no faster than they are consumed
+func (ums UserManagerServer) StreamUsers(r *pb.StreamUsersRequest, stream pb.UserManager_StreamUsersServer) error {
+	ctx := stream.Context()
+	su := &streamUsers{}
+	if err := su.Decode(r, ums.api.Filters()); err != nil {
+		ums.log.WithField("component", "grpc_handler").
+			Debugf("stream users decode error: %v", err)
+		return errRequest(ctx, err)
+	}
+
+	err := ums.api.StreamUsers(ctx, su.Query, func(u *service.User) error {
+		return stream.Send(user2PB(u))
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		ums.log.WithField("component", "grpc_handler").
+			Debugf("failed to perform stream users: %v", err)
+		return errApif(ctx, "could not stream users: %w", err)
+	}
+	return nil
+}
+
+func (ums UserManagerServer) BatchCreateUsers(ctx context.Context, r *pb.BatchCreateUsersRequest) (*pb.BatchUsersResponse, error) {
+	bc := &batchCreate{}
+	bc.Decode(r)
+	results, err := ums.api.BatchCreateUsers(ctx, bc.Users, r.GetAtomic())
+	if err != nil {
+		ums.log.WithField("component", "grpc_handler").
+			Debugf("failed to perform batch create: %v", err)
+		return nil, errApif(ctx, "could not create users: %w", err)
+	}
+	return batch2PB(results), nil
+}
+
+func (ums UserManagerServer) BatchUpdateUsers(ctx context.Context, r *pb.BatchUpdateUsersRequest) (*pb.BatchUsersResponse, error) {
+	bu := &batchUpdate{}
+	if err := bu.Decode(r); err != nil {
+		ums.log.WithField("component", "grpc_handler").
+			Debugf("batch update decode error: %v", err)
+		return nil, errRequest(ctx, err)
+	}
+	results, err := ums.api.BatchUpdateUsers(ctx, bu.Users, r.GetAtomic())
+	if err != nil {
+		ums.log.WithField("component", "grpc_handler").
+			Debugf("failed to perform batch update: %v", err)
+		return nil, errApif(ctx, "could not update users: %w", err)
+	}
+	return batch2PB(results), nil
+}
+
+func (ums UserManagerServer) BatchDeleteUsers(ctx context.Context, r *pb.BatchDeleteUsersRequest) (*pb.BatchUsersResponse, error) {
+	results, err := ums.api.BatchDeleteUsers(ctx, r.GetIds(), r.GetAtomic())
+	if err != nil {
+		ums.log.WithField("component", "grpc_handler").
+			Debugf("failed to perform batch delete: %v", err)
+		return nil, errApif(ctx, "could not delete users: %w", err)
+	}
+	return batch2PB(results), nil
+}
+
+// WatchUsers sends user changes as they happen along with heartbeats while there are none
+func (ums UserManagerServer) WatchUsers(r *pb.WatchUsersRequest, stream pb.UserManager_WatchUsersServer) error {
+	ctx := stream.Context()
+	wu := &watchUsers{}
+	if err := wu.Decode(r); err != nil {
+		ums.log.WithField("component", "grpc_handler").
+			Debugf("watch users decode error: %v", err)
+		return errRequest(ctx, err)
+	}
+
+	err := ums.api.WatchUsers(ctx, wu.Query, func(e *events.Event) error {
+		if e == nil {
+			return stream.Send(&pb.WatchUsersResponse{Message: &pb.WatchUsersResponse_Heartbeat{
+				Heartbeat: &pb.Heartbeat{Timestamp: time.Now().UTC().Format(time.RFC3339)},
+			}})
+		}
+		return stream.Send(&pb.WatchUsersResponse{Message: &pb.WatchUsersResponse_Event{Event: events.ToProto(e)}})
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		ums.log.WithField("component", "grpc_handler").
+			Debugf("failed to perform watch users: %v", err)
+		return errApif(ctx, "could not watch users: %w", err)
+	}
+	return nil
+}
+
+// mdETag response header carrying the version of the updated user, the same entity tag HTTP sends in ETag
+const mdETag = "etag"
+
 func (ums UserManagerServer) UpdateUser(ctx context.Context, r *pb.UpdateUserRequest) (*emptypb.Empty, error) {
 	uu := &updateUser{}
 	if err := uu.Decode(r); err != nil {
@@ -85,6 +244,7 @@ func (ums UserManagerServer) UpdateUser(ctx context.Context, r *pb.UpdateUserReq
 			Debugf("failed to perform update user: %v", err)
 		return nil, errApi(ctx, err)
 	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs(mdETag, strconv.Quote(strconv.FormatInt(uu.User.Version, 10))))
 	return &emptypb.Empty{}, nil
 }
 
@@ -100,3 +260,59 @@ func (ums UserManagerServer) DeleteUser(ctx context.Context, r *pb.DeleteUserReq
 	}
 	return &emptypb.Empty{}, nil
 }
+
+func (ums UserManagerServer) RestoreUser(ctx context.Context, r *pb.RestoreUserRequest) (*pb.User, error) {
+	if r.GetId() == "" {
+		return nil, errRequest(ctx, fmt.Errorf("id is mandatory"))
+	}
+
+	user, err := ums.api.RestoreUser(ctx, r.GetId())
+	if err != nil {
+		ums.log.WithField("component", "grpc_handler").
+			Debugf("failed to perform restore user: %v", err)
+		return nil, errApi(ctx, err)
+	}
+	return user2PB(user), nil
+}
+
+func (ums UserManagerServer) EraseUser(ctx context.Context, r *pb.EraseUserRequest) (*emptypb.Empty, error) {
+	if r.GetId() == "" {
+		return nil, errRequest(ctx, fmt.Errorf("id is mandatory"))
+	}
+
+	if err := ums.api.EraseUser(ctx, r.GetId()); err != nil {
+		ums.log.WithField("component", "grpc_handler").
+			Debugf("failed to perform erase user: %v", err)
+		return nil, errApi(ctx, err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (ums UserManagerServer) ListUserHistory(ctx context.Context,
+	r *pb.ListUserHistoryRequest) (*pb.ListUserHistoryResponse, error) {
+	uh := &userHistory{}
+	if err := uh.Decode(r, ums.pages); err != nil {
+		ums.log.WithField("component", "grpc_handler").
+			Debugf("user history decode error: %v", err)
+		return nil, errRequest(ctx, err)
+	}
+
+	page, err := ums.api.UserHistory(ctx, uh.Query)
+	if err != nil {
+		ums.log.WithField("component", "grpc_handler").
+			Debugf("failed to perform user history: %v", err)
+		return nil, errApif(ctx, "could not get user history: %w", err)
+	}
+
+	resp := uh.Encode(page.Entries)
+	np, err := ums.pages.GenerateHistoryPage(uh.Query, page.Next)
+	if err != nil {
+		ums.log.WithField("component", "grpc_handler").
+			Debugf("could not marshal next page: %v", err)
+		return nil, errRequestf(ctx, "could not marshal next page structure: %w", err)
+	}
+	if np != "" {
+		resp.NextPage = &np
+	}
+	return resp, nil
+}
@@ -2,22 +2,40 @@ package grpc
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
 	"fmt"
+	"io"
 	"log"
 	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/BorisRostovskiy/ESL/internal/auth"
 	"github.com/BorisRostovskiy/ESL/internal/clients"
+	"github.com/BorisRostovskiy/ESL/internal/events"
+	"github.com/BorisRostovskiy/ESL/internal/handlers"
 	pb "github.com/BorisRostovskiy/ESL/internal/handlers/grpc/gen/user-manager"
 	"github.com/BorisRostovskiy/ESL/internal/repository"
 	"github.com/BorisRostovskiy/ESL/internal/service"
+	"github.com/BorisRostovskiy/ESL/internal/tokens"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/mock/gomock"
+	"golang.org/x/crypto/bcrypt"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/resolver"
 	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
@@ -32,16 +50,22 @@ const (
 	email2           = "user_two@gmail.com"
 	email3           = "user_three@gmail.com"
 	somethingHappens = "something happens"
-	nextPageNoFilter = "eyJsaW1pdCI6Miwib2Zmc2V0IjoyLCJmaWx0ZXJfYnkiOiIiLCJmaWx0ZXIiOiIiLCJ0aW1lIjoiMjAzNS0wNy0yMVQxODo1ODoyOS42ODc0MzUrMDI6MDAifQo="
 )
 
-func asPrt[T int32 | string](s T) *T {
+func asPrt[T int32 | int64 | bool | string](s T) *T {
 	return &s
 }
 
 var (
 	somethingHappensError = fmt.Errorf("%v", somethingHappens)
 	createdAt, _          = time.Parse(time.RFC3339, "2022-07-20T12:45:44Z")
+	pages, _              = handlers.NewPageTokens(handlers.PageTokenConfig{})
+	sortByUpdate, _       = service.ParseSort("-updated_at,last_name")
+	afterID2              = service.DefaultSort.CursorOf((&service.User{}).WithID(id2).WithCreateAt(createdAt))
+	nextPageNoFilter, _   = pages.GenerateNextPage(service.ListQuery{Limit: 2}, afterID2)
+	filters, _            = service.NewFilters([]service.FilterConfig{{Field: "country"}, {Field: "created_at"}, {Field: "nickname"}})
+	countryNL, _          = filters.NewFilter("country", "NL")
+	expression, _         = filters.ParseFilter(`country in (NL, DE) and (created_at >= 2022-01-01 or nickname prefix "user")`)
 	user                  = &service.User{
 		FirstName: "User",
 		LastName:  "One",
@@ -51,13 +75,25 @@ var (
 	}
 )
 
-func setupClient(repo *service.MockUserRepo, notification *clients.MockChannelNotificator) (pb.UserManagerClient, func()) {
+func setupClient(repo *service.MockUserRepo, notification *clients.MockChannelNotificator, opts ...service.Option) (pb.UserManagerClient, func()) {
+	return setupServer(repo, notification, nil, opts...)
+}
+
+// setupServer installs request interceptors, authentication ones unless authenticator is nil
+func setupServer(repo *service.MockUserRepo, notification *clients.MockChannelNotificator, authn *auth.Authenticator,
+	opts ...service.Option) (pb.UserManagerClient, func()) {
 	lis := bufconn.Listen(1024 * 1024)
 
 	logger := logrus.New()
-	grpcSvc := New(service.New(repo, logger, notification), logger)
+	grpcSvc := New(service.New(repo, logger, notification, opts...), logger, pages)
 
-	baseServer := grpc.NewServer()
+	unaryReq, streamReq := RequestInterceptors()
+	unaries, streams := []grpc.UnaryServerInterceptor{unaryReq}, []grpc.StreamServerInterceptor{streamReq}
+	if authn != nil {
+		unary, stream := AuthInterceptors(authn, logger)
+		unaries, streams = append(unaries, unary), append(streams, stream)
+	}
+	baseServer := grpc.NewServer(grpc.ChainUnaryInterceptor(unaries...), grpc.ChainStreamInterceptor(streams...))
 	pb.RegisterUserManagerServer(baseServer, grpcSvc)
 	go func() {
 		if err := baseServer.Serve(lis); err != nil {
@@ -120,8 +156,7 @@ func TestServer_CreateUser(t *testing.T) {
 					Return(&service.User{ID: id1, Email: email1}, nil).Times(1)
 			},
 			notify: func(n *clients.MockChannelNotificator) {
-				n.EXPECT().Notify(gomock.Any(), clients.ChannelCreate,
-					fmt.Sprintf("user with ID=%s has been created", id1))
+				n.EXPECT().Notify(gomock.Any(), clients.ChannelCreate, userEvent(events.TypeUserCreated, id1))
 			},
 			want: expectation{
 				out: &pb.User{
@@ -220,13 +255,388 @@ func TestServer_CreateUser(t *testing.T) {
 	}
 }
 
-func TestServer_ListUsers(t *testing.T) {
+func TestServer_Authenticate(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	repo := service.NewMockUserRepo(ctrl)
+	notificationSvc := clients.NewMockChannelNotificator(ctrl)
+	client, closer := setupClient(repo, notificationSvc)
+	hashedPwd, _ := bcrypt.GenerateFromPassword([]byte(pwd), 8)
+
+	defer closer()
+	type expectation struct {
+		out *pb.AuthenticateResponse
+		err error
+	}
+
+	tests := map[string]struct {
+		in   *pb.AuthenticateRequest
+		want expectation
+		repo func(r *service.MockUserRepo)
+	}{
+		"Authenticate Ok": {
+			in: &pb.AuthenticateRequest{Login: "userOne11", Password: pwd},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetCredentials(gomock.Any(), "userOne11").
+					Return(&service.User{ID: id1, Email: email1, Password: string(hashedPwd)}, nil).Times(1)
+			},
+			want: expectation{
+				out: &pb.AuthenticateResponse{User: &pb.User{Id: id1}},
+			},
+		},
+		"Authenticate wrong password error": {
+			in: &pb.AuthenticateRequest{Login: "userOne11", Password: "wrong"},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetCredentials(gomock.Any(), "userOne11").
+					Return(&service.User{ID: id1, Email: email1, Password: string(hashedPwd)}, nil).Times(1)
+			},
+			want: expectation{
+				err: status.Error(codes.Unauthenticated, service.ErrInvalidCredentials.Message),
+			},
+		},
+		"Authenticate unknown user error": {
+			in: &pb.AuthenticateRequest{Login: "unknown", Password: pwd},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetCredentials(gomock.Any(), "unknown").
+					Return(nil, repository.NoUsersFoundError).Times(1)
+			},
+			want: expectation{
+				err: status.Error(codes.Unauthenticated, service.ErrInvalidCredentials.Message),
+			},
+		},
+		"Authenticate empty login error": {
+			in:   &pb.AuthenticateRequest{Password: pwd},
+			repo: func(r *service.MockUserRepo) {},
+			want: expectation{
+				err: status.Error(codes.InvalidArgument, "login and password are mandatory"),
+			},
+		},
+	}
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*1)
+			defer cancel()
+			tt.repo(repo)
+			out, err := client.Authenticate(ctx, tt.in)
+
+			if tt.want.err == nil {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want.out.User.Id, out.User.Id)
+			} else {
+				assert.ErrorIs(t, err, tt.want.err)
+			}
+		})
+	}
+}
+
+func TestServer_RefreshToken(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	repo := service.NewMockUserRepo(ctrl)
+	tokenRepo := service.NewMockTokenRepo(ctrl)
+	notificationSvc := clients.NewMockChannelNotificator(ctrl)
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	keyFile := filepath.Join(t.TempDir(), "signing.pem")
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+	issuer, err := tokens.New(tokens.Config{Algorithm: tokens.AlgorithmEdDSA, KeyID: "test", PrivateKeyFile: keyFile})
+	require.NoError(t, err)
+
+	client, closer := setupClient(repo, notificationSvc, service.WithTokens(issuer, tokenRepo))
+	defer closer()
+	disabledClient, disabledCloser := setupClient(repo, notificationSvc)
+	defer disabledCloser()
+
+	tests := map[string]struct {
+		in     *pb.RefreshTokenRequest
+		client pb.UserManagerClient
+		err    error
+		repo   func(r *service.MockUserRepo, tr *service.MockTokenRepo)
+	}{
+		"RefreshToken Ok": {
+			in:     &pb.RefreshTokenRequest{RefreshToken: "token"},
+			client: client,
+			repo: func(r *service.MockUserRepo, tr *service.MockTokenRepo) {
+				tr.EXPECT().RotateRefreshToken(gomock.Any(), tokens.HashRefreshToken("token"), gomock.Any()).
+					Return(&service.RefreshToken{UserID: id1}, nil).Times(1)
+				r.EXPECT().GetUser(gomock.Any(), id1).Return(&service.User{ID: id1}, nil).Times(1)
+			},
+		},
+		"RefreshToken reused error": {
+			in:     &pb.RefreshTokenRequest{RefreshToken: "token"},
+			client: client,
+			repo: func(r *service.MockUserRepo, tr *service.MockTokenRepo) {
+				tr.EXPECT().RotateRefreshToken(gomock.Any(), tokens.HashRefreshToken("token"), gomock.Any()).
+					Return(nil, repository.TokenReusedError).Times(1)
+			},
+			err: status.Error(codes.Unauthenticated, service.ErrInvalidRefresh.Message),
+		},
+		"RefreshToken empty token error": {
+			in:     &pb.RefreshTokenRequest{},
+			client: client,
+			repo:   func(r *service.MockUserRepo, tr *service.MockTokenRepo) {},
+			err:    status.Error(codes.InvalidArgument, "refresh_token is mandatory"),
+		},
+		"RefreshToken tokens disabled error": {
+			in:     &pb.RefreshTokenRequest{RefreshToken: "token"},
+			client: disabledClient,
+			repo:   func(r *service.MockUserRepo, tr *service.MockTokenRepo) {},
+			err:    status.Error(codes.Unimplemented, service.ErrTokensDisabled.Message),
+		},
+	}
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*1)
+			defer cancel()
+			tt.repo(repo, tokenRepo)
+			out, err := tt.client.RefreshToken(ctx, tt.in)
+
+			if tt.err == nil {
+				assert.NoError(t, err)
+				assert.NotEmpty(t, out.GetRefreshToken())
+				claims, err := issuer.Verify(out.GetAccessToken())
+				assert.NoError(t, err)
+				assert.Equal(t, id1, claims.Subject)
+			} else {
+				assert.ErrorIs(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestAuthInterceptors(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	repo := service.NewMockUserRepo(ctrl)
+	notificationSvc := clients.NewMockChannelNotificator(ctrl)
+	keyHash := sha256.Sum256([]byte("secret-key"))
+	keys, err := auth.APIKeys(map[string]string{"ops": hex.EncodeToString(keyHash[:])})
+	require.NoError(t, err)
+	client, closer := setupServer(repo, notificationSvc, auth.New(nil, keys))
+	defer closer()
+
+	tests := map[string]struct {
+		md   metadata.MD
+		call func(ctx context.Context) error
+		err  error
+		repo func(r *service.MockUserRepo)
+	}{
+		"Protected call with API key Ok": {
+			md: metadata.Pairs("x-api-key", "secret-key"),
+			call: func(ctx context.Context) error {
+				_, err := client.GetUser(ctx, &pb.GetUserRequest{Lookup: &pb.GetUserRequest_Id{Id: id1}})
+				return err
+			},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetUser(gomock.Any(), id1).Return(user.WithID(id1), nil).Times(1)
+			},
+		},
+		"Protected call wrong API key error": {
+			md: metadata.Pairs("x-api-key", "wrong"),
+			call: func(ctx context.Context) error {
+				_, err := client.GetUser(ctx, &pb.GetUserRequest{Lookup: &pb.GetUserRequest_Id{Id: id1}})
+				return err
+			},
+			repo: func(r *service.MockUserRepo) {},
+			err:  status.Error(codes.Unauthenticated, service.ErrUnauthenticated.Message),
+		},
+		"Protected call no credentials error": {
+			md: metadata.MD{},
+			call: func(ctx context.Context) error {
+				_, err := client.DeleteUser(ctx, &pb.DeleteUserRequest{Id: id1})
+				return err
+			},
+			repo: func(r *service.MockUserRepo) {},
+			err:  status.Error(codes.Unauthenticated, service.ErrUnauthenticated.Message),
+		},
+		"Public call no credentials Ok": {
+			md: metadata.MD{},
+			call: func(ctx context.Context) error {
+				_, err := client.Authenticate(ctx, &pb.AuthenticateRequest{Login: "unknown", Password: pwd})
+				return err
+			},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetCredentials(gomock.Any(), "unknown").
+					Return(nil, repository.NoUsersFoundError).Times(1)
+			},
+			// reached the handler, rejected by the service itself
+			err: status.Error(codes.Unauthenticated, service.ErrInvalidCredentials.Message),
+		},
+	}
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*1)
+			defer cancel()
+			tt.repo(repo)
+			err := tt.call(metadata.NewOutgoingContext(ctx, tt.md))
+
+			if tt.err == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestServer_Authorization(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	repo := service.NewMockUserRepo(ctrl)
+	notificationSvc := clients.NewMockChannelNotificator(ctrl)
+	opsHash, supportHash := sha256.Sum256([]byte("ops-key")), sha256.Sum256([]byte("support-key"))
+	keys, err := auth.APIKeys(map[string]string{
+		"ops":     hex.EncodeToString(opsHash[:]),
+		"support": hex.EncodeToString(supportHash[:]),
+	})
+	require.NoError(t, err)
+	authn := auth.New(map[string]string{"ops": service.RoleAdmin, "support": service.RoleSupport}, keys)
+	client, closer := setupServer(repo, notificationSvc, authn, service.WithAuthorization())
+	defer closer()
+
+	tests := map[string]struct {
+		key    string
+		err    error
+		repo   func(r *service.MockUserRepo)
+		notify func(n *clients.MockChannelNotificator)
+	}{
+		"Admin deletes user Ok": {
+			key: "ops-key",
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetUser(gomock.Any(), id1).Return((&service.User{Country: "NL"}).WithID(id1), nil).Times(1)
+				r.EXPECT().DeleteUser(gomock.Any(), id1).Return(nil).Times(1)
+			},
+			notify: func(n *clients.MockChannelNotificator) {
+				n.EXPECT().Notify(gomock.Any(), clients.ChannelDelete, gomock.Any()).Times(1)
+			},
+		},
+		"Support deletes user denied": {
+			key:    "support-key",
+			repo:   func(r *service.MockUserRepo) {},
+			notify: func(n *clients.MockChannelNotificator) {},
+			err:    status.Error(codes.PermissionDenied, service.ErrPermissionDenied.Message),
+		},
+	}
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*1)
+			defer cancel()
+			tt.repo(repo)
+			tt.notify(notificationSvc)
+			_, err := client.DeleteUser(metadata.AppendToOutgoingContext(ctx, "x-api-key", tt.key),
+				&pb.DeleteUserRequest{Id: id1})
+
+			if tt.err == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestServer_GetUser(t *testing.T) {
 	t.Parallel()
 	ctrl := gomock.NewController(t)
 	repo := service.NewMockUserRepo(ctrl)
 	notificationSvc := clients.NewMockChannelNotificator(ctrl)
 	client, closer := setupClient(repo, notificationSvc)
 
+	defer closer()
+	type expectation struct {
+		out *pb.User
+		err error
+	}
+
+	tests := map[string]struct {
+		in   *pb.GetUserRequest
+		want expectation
+		repo func(r *service.MockUserRepo)
+	}{
+		"GetUser by id Ok": {
+			in: &pb.GetUserRequest{Lookup: &pb.GetUserRequest_Id{Id: id1}},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetUser(gomock.Any(), id1).
+					Return(&service.User{ID: id1, Email: email1}, nil).Times(1)
+			},
+			want: expectation{
+				out: &pb.User{Id: id1, Email: email1},
+			},
+		},
+		"GetUser by email Ok": {
+			in: &pb.GetUserRequest{Lookup: &pb.GetUserRequest_Email{Email: "User_One@gmail.com"}},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetUserByEmail(gomock.Any(), email1).
+					Return(&service.User{ID: id1, Email: email1}, nil).Times(1)
+			},
+			want: expectation{
+				out: &pb.User{Id: id1, Email: email1},
+			},
+		},
+		"GetUser by nickname Ok": {
+			in: &pb.GetUserRequest{Lookup: &pb.GetUserRequest_Nickname{Nickname: "userOne11"}},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetUserByNickname(gomock.Any(), "userOne11").
+					Return(&service.User{ID: id1, Email: email1}, nil).Times(1)
+			},
+			want: expectation{
+				out: &pb.User{Id: id1, Email: email1},
+			},
+		},
+		"GetUser no lookup error": {
+			in:   &pb.GetUserRequest{},
+			repo: func(r *service.MockUserRepo) {},
+			want: expectation{
+				err: status.Error(codes.InvalidArgument, "user id, email or nickname is mandatory"),
+			},
+		},
+		"GetUser not found error": {
+			in: &pb.GetUserRequest{Lookup: &pb.GetUserRequest_Id{Id: id1}},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetUser(gomock.Any(), id1).Return(nil, repository.NoUsersFoundError).Times(1)
+			},
+			want: expectation{
+				err: status.Error(codes.NotFound, service.ErrUserNotFound.Message),
+			},
+		},
+		"GetUser repo error": {
+			in: &pb.GetUserRequest{Lookup: &pb.GetUserRequest_Id{Id: id1}},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetUser(gomock.Any(), id1).Return(nil, somethingHappensError).Times(1)
+			},
+			want: expectation{
+				err: status.Error(codes.Internal, service.ErrInternal.Message),
+			},
+		},
+	}
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*1)
+			defer cancel()
+			tt.repo(repo)
+			out, err := client.GetUser(ctx, tt.in)
+
+			if tt.want.err == nil {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want.out.Id, out.Id)
+				assert.Equal(t, tt.want.out.Email, out.Email)
+			} else {
+				assert.ErrorIs(t, err, tt.want.err)
+			}
+		})
+	}
+}
+
+func TestServer_ListUsers(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	repo := service.NewMockUserRepo(ctrl)
+	notificationSvc := clients.NewMockChannelNotificator(ctrl)
+	client, closer := setupClient(repo, notificationSvc, service.WithFilters(filters))
+
 	defer closer()
 	type expectation struct {
 		out       *pb.ListUsersResponse
@@ -243,7 +653,7 @@ func TestServer_ListUsers(t *testing.T) {
 			in: &pb.ListUsersRequest{},
 			repo: func(r *service.MockUserRepo) {
 				r.EXPECT().
-					ListUsers(gomock.Any(), -1, 0, nil).
+					ListUsers(gomock.Any(), service.ListQuery{Limit: -1}).
 					Return([]service.User{
 						*user.WithID(id1).WithCreateAt(createdAt),
 						*user.WithID(id2).WithEmail(email2).WithCreateAt(createdAt),
@@ -265,7 +675,7 @@ func TestServer_ListUsers(t *testing.T) {
 			},
 			repo: func(r *service.MockUserRepo) {
 				r.EXPECT().
-					ListUsers(gomock.Any(), 2, 2, nil).
+					ListUsers(gomock.Any(), service.ListQuery{Limit: 3, After: afterID2}).
 					Return([]service.User{
 						*user.WithID(id3).
 							WithEmail(email3).
@@ -287,11 +697,14 @@ func TestServer_ListUsers(t *testing.T) {
 			},
 			repo: func(r *service.MockUserRepo) {
 				r.EXPECT().
-					ListUsers(gomock.Any(), 1, 0, &service.Filter{By: "country", Query: "NL"}).
+					ListUsers(gomock.Any(), service.ListQuery{Limit: 2, Filter: countryNL}).
 					Return([]service.User{
 						*user.WithID(id3).
 							WithEmail(email3).
 							WithCreateAt(createdAt),
+						*(&service.User{}).WithID(id1).
+							WithEmail(email1).
+							WithCreateAt(createdAt),
 					}, nil).Times(1)
 			},
 			want: expectation{
@@ -302,23 +715,117 @@ func TestServer_ListUsers(t *testing.T) {
 				}),
 			},
 		},
-		"ListUsers nextPage base64 decode Error": {
+		"ListUsers filter expression OK": {
+			in: &pb.ListUsersRequest{
+				Filter: asPrt(`COUNTRY IN (NL,DE) AND (created_at >= 2022-01-01 OR nickname prefix user)`),
+			},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().
+					ListUsers(gomock.Any(), service.ListQuery{Limit: -1, Filter: expression}).
+					Return([]service.User{
+						*user.WithID(id3).WithEmail(email3).WithCreateAt(createdAt),
+					}, nil).Times(1)
+			},
+			want: expectation{
+				err: nil,
+				out: (&listUsers{}).Encode([]service.User{
+					*((&service.User{}).WithID(id3).WithEmail(email3).WithCreateAt(createdAt)),
+				}),
+			},
+		},
+		"ListUsers invalid filter expression Error": {
+			in: &pb.ListUsersRequest{
+				Filter: asPrt("country prefix N"),
+			},
+			repo: func(r *service.MockUserRepo) {},
+			want: expectation{
+				err: status.Error(codes.InvalidArgument, "operator 'prefix' is not supported by field 'country'"),
+				out: nil,
+			},
+		},
+		"ListUsers filter not enabled Error": {
+			in: &pb.ListUsersRequest{
+				Filter: asPrt("email_domain = example.com"),
+			},
+			repo: func(r *service.MockUserRepo) {},
+			want: expectation{
+				err: status.Error(codes.InvalidArgument, "filtering by 'email_domain' is not enabled"),
+				out: nil,
+			},
+		},
+		"ListUsers sorted OK": {
+			in: &pb.ListUsersRequest{
+				Pagination: asPrt(int32(1)),
+				Sort:       asPrt("-updated_at,last_name"),
+			},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().
+					ListUsers(gomock.Any(), service.ListQuery{Limit: 2, Sort: sortByUpdate}).
+					Return([]service.User{
+						*user.WithID(id3).WithEmail(email3).WithCreateAt(createdAt),
+						*(&service.User{}).WithID(id1).WithEmail(email1).WithCreateAt(createdAt),
+					}, nil).Times(1)
+			},
+			want: expectation{
+				err:       nil,
+				paginated: true,
+				out: (&listUsers{}).Encode([]service.User{
+					*((&service.User{}).WithID(id3).WithEmail(email3).WithCreateAt(createdAt)),
+				}),
+			},
+		},
+		"ListUsers invalid sort Error": {
+			in: &pb.ListUsersRequest{
+				Sort: asPrt("password"),
+			},
+			repo: func(r *service.MockUserRepo) {},
+			want: expectation{
+				err: status.Error(codes.InvalidArgument, "sort field 'password' not supported"),
+				out: nil,
+			},
+		},
+		"ListUsers include total OK": {
+			in: &pb.ListUsersRequest{
+				Pagination:   asPrt(int32(1)),
+				Filter:       asPrt("country in (NL, DE)"),
+				IncludeTotal: asPrt(true),
+			},
+			repo: func(r *service.MockUserRepo) {
+				filter, _ := filters.ParseFilter("country in (NL, DE)")
+				r.EXPECT().
+					ListUsers(gomock.Any(), service.ListQuery{Limit: 2, Filter: filter, IncludeTotal: true}).
+					Return([]service.User{
+						*user.WithID(id3).WithEmail(email3).WithCreateAt(createdAt),
+					}, nil).Times(1)
+				r.EXPECT().CountUsers(gomock.Any(), filter).
+					Return(&service.Totals{Count: 3, Countries: map[string]int{"NL": 2, "DE": 1}}, nil).Times(1)
+			},
+			want: expectation{
+				err: nil,
+				out: &pb.ListUsersResponse{
+					Users:  []*pb.User{{Id: id3}},
+					Total:  asPrt(int64(3)),
+					Facets: &pb.Facets{Country: map[string]int64{"NL": 2, "DE": 1}},
+				},
+			},
+		},
+		"ListUsers nextPage malformed Error": {
 			in: &pb.ListUsersRequest{
 				NextPage: asPrt("@"),
 			},
 			repo: func(r *service.MockUserRepo) {},
 			want: expectation{
-				err: status.Error(codes.InvalidArgument, "could not decode next_page argument: illegal base64 data at input byte 0"),
+				err: status.Error(codes.InvalidArgument, "next_page token is invalid"),
 				out: nil,
 			},
 		},
-		"ListUsers nextPage json unmarshal Error": {
+		"ListUsers nextPage tampered Error": {
 			in: &pb.ListUsersRequest{
-				NextPage: asPrt("QAo="),
+				NextPage: asPrt(tamper(nextPageNoFilter)),
 			},
 			repo: func(r *service.MockUserRepo) {},
 			want: expectation{
-				err: status.Error(codes.InvalidArgument, "could not unmarshal limit offset: invalid character '@' looking for beginning of value"),
+				err: status.Error(codes.InvalidArgument, "next_page token is invalid"),
 				out: nil,
 			},
 		},
@@ -328,7 +835,7 @@ func TestServer_ListUsers(t *testing.T) {
 			},
 			repo: func(r *service.MockUserRepo) {},
 			want: expectation{
-				err: status.Error(codes.InvalidArgument, "parameters filter and filterBy should be used together"),
+				err: status.Error(codes.InvalidArgument, "parameter filterBy should be used along with filter"),
 				out: nil,
 			},
 		},
@@ -346,7 +853,7 @@ func TestServer_ListUsers(t *testing.T) {
 		"ListUsers repo Error": {
 			in: &pb.ListUsersRequest{},
 			repo: func(r *service.MockUserRepo) {
-				r.EXPECT().ListUsers(gomock.Any(), -1, 0, nil).
+				r.EXPECT().ListUsers(gomock.Any(), service.ListQuery{Limit: -1}).
 					Return(nil, somethingHappensError).Times(1)
 			},
 			want: expectation{
@@ -357,7 +864,7 @@ func TestServer_ListUsers(t *testing.T) {
 		"ListUsers empty response": {
 			in: &pb.ListUsersRequest{},
 			repo: func(r *service.MockUserRepo) {
-				r.EXPECT().ListUsers(gomock.Any(), -1, 0, nil).
+				r.EXPECT().ListUsers(gomock.Any(), service.ListQuery{Limit: -1}).
 					Return([]service.User{}, nil).Times(1)
 			},
 			want: expectation{
@@ -375,7 +882,9 @@ func TestServer_ListUsers(t *testing.T) {
 			out, err := client.ListUsers(ctx, tt.in)
 
 			if tt.want.paginated {
-				assert.NotEqual(t, out.NextPage, "")
+				assert.NotEmpty(t, out.GetNextPage())
+			} else if out != nil {
+				assert.Nil(t, out.NextPage, "last page has no next one")
 			}
 			if tt.want.err == nil {
 				assert.NoError(t, err)
@@ -383,6 +892,8 @@ func TestServer_ListUsers(t *testing.T) {
 				for i, u := range out.Users {
 					assert.Equal(t, u.Id, tt.want.out.Users[i].Id)
 				}
+				assert.Equal(t, tt.want.out.Total, out.Total)
+				assert.Equal(t, tt.want.out.GetFacets().GetCountry(), out.GetFacets().GetCountry())
 			} else {
 				assert.ErrorIs(t, err, tt.want.err)
 			}
@@ -390,6 +901,182 @@ func TestServer_ListUsers(t *testing.T) {
 	}
 }
 
+func TestServer_StreamUsers(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	repo := service.NewMockUserRepo(ctrl)
+	notificationSvc := clients.NewMockChannelNotificator(ctrl)
+	client, closer := setupClient(repo, notificationSvc, service.WithFilters(filters))
+	defer closer()
+
+	streamed := func(users ...service.User) func(context.Context, service.ListQuery, func(*service.User) error) error {
+		return func(_ context.Context, _ service.ListQuery, fn func(*service.User) error) error {
+			for i := range users {
+				if err := fn(&users[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	tests := map[string]struct {
+		in   *pb.StreamUsersRequest
+		repo func(r *service.MockUserRepo)
+		ids  []string
+		err  error
+	}{
+		"StreamUsers OK": {
+			in: &pb.StreamUsersRequest{
+				Filter: asPrt("country = NL"),
+				Sort:   asPrt("-updated_at,last_name"),
+			},
+			repo: func(r *service.MockUserRepo) {
+				filter, _ := filters.ParseFilter("country = NL")
+				r.EXPECT().StreamUsers(gomock.Any(), service.ListQuery{Filter: filter, Sort: sortByUpdate}, gomock.Any()).
+					DoAndReturn(streamed(
+						*(&service.User{}).WithID(id1),
+						*(&service.User{}).WithID(id2),
+						*(&service.User{}).WithID(id3),
+					)).Times(1)
+			},
+			ids: []string{id1, id2, id3},
+		},
+		"StreamUsers invalid filter Error": {
+			in: &pb.StreamUsersRequest{
+				FilterBy: asPrt("country"),
+			},
+			repo: func(r *service.MockUserRepo) {},
+			err:  status.Error(codes.InvalidArgument, "parameter filterBy should be used along with filter"),
+		},
+		"StreamUsers repo Error": {
+			in: &pb.StreamUsersRequest{},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().StreamUsers(gomock.Any(), service.ListQuery{}, gomock.Any()).
+					DoAndReturn(func(_ context.Context, _ service.ListQuery, fn func(*service.User) error) error {
+						_ = fn((&service.User{}).WithID(id1))
+						return somethingHappensError
+					}).Times(1)
+			},
+			ids: []string{id1},
+			err: status.Error(codes.Internal, "internal handlers error"),
+		},
+	}
+
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*1)
+			defer cancel()
+			tt.repo(repo)
+			stream, err := client.StreamUsers(ctx, tt.in)
+			require.NoError(t, err)
+
+			var ids []string
+			for {
+				u, err := stream.Recv()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					assert.ErrorIs(t, err, tt.err)
+					break
+				}
+				ids = append(ids, u.Id)
+			}
+			assert.Equal(t, tt.ids, ids)
+		})
+	}
+}
+
+func TestServer_StreamUsersCancel(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	repo := service.NewMockUserRepo(ctrl)
+	client, closer := setupClient(repo, clients.NewMockChannelNotificator(ctrl))
+	defer closer()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stopped := make(chan error, 1)
+	repo.EXPECT().StreamUsers(gomock.Any(), service.ListQuery{}, gomock.Any()).
+		DoAndReturn(func(ctx context.Context, _ service.ListQuery, fn func(*service.User) error) error {
+			// client stops reading after the first user, server is blocked by flow control until it cancels
+			var err error
+			for err == nil {
+				err = fn((&service.User{}).WithID(id1).WithEmail(strings.Repeat("x", 1024)))
+			}
+			stopped <- err
+			return err
+		}).Times(1)
+
+	stream, err := client.StreamUsers(ctx, &pb.StreamUsersRequest{})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	cancel()
+
+	select {
+	case err = <-stopped:
+		assert.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("streaming is not stopped on cancellation")
+	}
+}
+
+func TestServer_WatchUsers(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	repo := service.NewMockUserRepo(ctrl)
+	notificationSvc := clients.NewMockChannelNotificator(ctrl)
+	notificationSvc.EXPECT().Notify(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	hub := service.NewWatchHub(service.WatchConfig{}, notificationSvc)
+	client, closer := setupClient(repo, notificationSvc, service.WithWatch(hub))
+	defer closer()
+
+	past := events.NewUserCreated(nil, events.User{ID: id1, Country: "NL"})
+	require.NoError(t, hub.Notify(context.Background(), clients.ChannelCreate, past))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*1)
+	defer cancel()
+	stream, err := client.WatchUsers(ctx, &pb.WatchUsersRequest{AfterEventId: past.ID, Country: []string{"NL"}})
+	require.NoError(t, err)
+	msg, err := stream.Recv()
+	require.NoError(t, err)
+	require.NotNil(t, msg.GetHeartbeat(), "heartbeat once watching has started")
+
+	deleted := events.NewUserDeleted(nil, id1)
+	deleted.Country = "NL"
+	require.NoError(t, hub.Notify(ctx, clients.ChannelCreate, events.NewUserCreated(nil, events.User{ID: id2, Country: "DE"})))
+	require.NoError(t, hub.Notify(ctx, clients.ChannelDelete, deleted))
+	msg, err = stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "user.deleted", msg.GetEvent().GetType())
+	assert.Equal(t, id1, msg.GetEvent().GetUserId())
+	assert.Equal(t, "NL", msg.GetEvent().GetCountry())
+
+	tests := map[string]struct {
+		in  *pb.WatchUsersRequest
+		err error
+	}{
+		"WatchUsers expired event Error": {
+			in:  &pb.WatchUsersRequest{AfterEventId: "unknown"},
+			err: status.Error(codes.OutOfRange, "could not watch users: "+service.ErrWatchExpired.Message),
+		},
+		"WatchUsers empty country Error": {
+			in:  &pb.WatchUsersRequest{Country: []string{" "}},
+			err: status.Error(codes.InvalidArgument, "empty country"),
+		},
+	}
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			stream, err := client.WatchUsers(ctx, tt.in)
+			require.NoError(t, err)
+			_, err = stream.Recv()
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
 func TestServer_UpdateUser(t *testing.T) {
 	t.Parallel()
 
@@ -401,7 +1088,12 @@ func TestServer_UpdateUser(t *testing.T) {
 
 	defer closer()
 	type expectation struct {
-		err error
+		err     error
+		version int64
+	}
+	stored := func() *service.User {
+		return (&service.User{FirstName: "User", LastName: "One", NickName: "userOne11", Email: email1, Country: "NL",
+			Version: 3}).WithID(id1)
 	}
 
 	tests := map[string]struct {
@@ -426,12 +1118,60 @@ func TestServer_UpdateUser(t *testing.T) {
 				r.EXPECT().UpdateUser(gomock.Any(), user.WithID(id1).WithEmail(email2)).Return(nil).Times(1)
 			},
 			notify: func(n *clients.MockChannelNotificator) {
-				n.EXPECT().Notify(gomock.Any(), clients.ChannelUpdate, fmt.Sprintf("user with ID=%s has been updated", id1))
+				n.EXPECT().Notify(gomock.Any(), clients.ChannelUpdate, userEvent(events.TypeUserUpdated, id1))
 			},
 			want: expectation{
 				err: nil,
 			},
 		},
+		"Update user expected version OK": {
+			in: &pb.UpdateUserRequest{
+				Id:              id1,
+				Country:         asPrt("DE"),
+				ExpectedVersion: asPrt(int64(3)),
+			},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetUser(gomock.Any(), id1).Return(stored(), nil).Times(1)
+				r.EXPECT().UpdateUser(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, u *service.User) error {
+					u.Version++
+					return nil
+				}).Times(1)
+			},
+			notify: func(n *clients.MockChannelNotificator) {
+				n.EXPECT().Notify(gomock.Any(), clients.ChannelUpdate, userEvent(events.TypeUserUpdated, id1))
+			},
+			want: expectation{
+				version: 4,
+			},
+		},
+		"Update user outdated version error": {
+			in: &pb.UpdateUserRequest{
+				Id:              id1,
+				Country:         asPrt("DE"),
+				ExpectedVersion: asPrt(int64(2)),
+			},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetUser(gomock.Any(), id1).Return(stored(), nil).Times(1)
+			},
+			notify: func(n *clients.MockChannelNotificator) {},
+			want: expectation{
+				err: status.Error(codes.FailedPrecondition, service.ErrVersionMismatch.Message),
+			},
+		},
+		"Update user concurrently updated error": {
+			in: &pb.UpdateUserRequest{
+				Id:      id1,
+				Country: asPrt("DE"),
+			},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetUser(gomock.Any(), id1).Return(stored(), nil).Times(1)
+				r.EXPECT().UpdateUser(gomock.Any(), gomock.Any()).Return(repository.VersionMismatchError).Times(1)
+			},
+			notify: func(n *clients.MockChannelNotificator) {},
+			want: expectation{
+				err: status.Error(codes.FailedPrecondition, service.ErrVersionMismatch.Message),
+			},
+		},
 		"Update user structure is empty error": {
 			in: &pb.UpdateUserRequest{
 				Id: id1,
@@ -508,9 +1248,11 @@ func TestServer_UpdateUser(t *testing.T) {
 			defer cancel()
 			tt.repo(repo)
 			tt.notify(notificationSvc)
-			_, err := client.UpdateUser(ctx, tt.in)
+			var header metadata.MD
+			_, err := client.UpdateUser(ctx, tt.in, grpc.Header(&header))
 			if tt.want.err == nil {
-				assert.NoError(t, err)
+				require.NoError(t, err)
+				assert.Equal(t, []string{strconv.Quote(strconv.FormatInt(tt.want.version, 10))}, header.Get(mdETag))
 			} else {
 				assert.ErrorIs(t, err, tt.want.err)
 			}
@@ -518,6 +1260,106 @@ func TestServer_UpdateUser(t *testing.T) {
 	}
 }
 
+func TestServer_BatchUsers(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	repo := service.NewMockUserRepo(ctrl)
+	notificationSvc := clients.NewMockChannelNotificator(ctrl)
+	client, closer := setupClient(repo, notificationSvc)
+
+	defer closer()
+	aborted := &pb.BatchError{Code: service.ErrCodeBatchAborted, Message: service.ErrBatchAborted.Message}
+	notFound := &pb.BatchError{Code: service.ErrCodeUserNotFound, Message: service.ErrUserNotFound.Message}
+	valid := &pb.CreateUserRequest{FirstName: "User", LastName: "One", Nickname: "userOne11", Email: email1, Password: pwd, Country: "NL"}
+
+	t.Run("BatchCreate best effort Ok", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*1)
+		defer cancel()
+		repo.EXPECT().CreateUsers(gomock.Any(), gomock.Len(1), false).
+			DoAndReturn(func(_ context.Context, users []*service.User, _ bool) ([]bool, error) {
+				users[0].ID = id1
+				return []bool{true}, nil
+			}).Times(1)
+		notificationSvc.EXPECT().Notify(gomock.Any(), clients.ChannelCreate, userEvent(events.TypeUserCreated, id1)).Times(1)
+
+		resp, err := client.BatchCreateUsers(ctx, &pb.BatchCreateUsersRequest{
+			Users: []*pb.CreateUserRequest{valid, {FirstName: "User", Email: email2, Password: pwd, Country: "NL"}},
+		})
+		require.NoError(t, err)
+		require.Len(t, resp.Results, 2)
+		assert.Equal(t, id1, resp.Results[0].Id)
+		assert.Equal(t, email1, resp.Results[0].User.Email)
+		assert.Nil(t, resp.Results[0].Error)
+		assert.Equal(t, &pb.BatchError{Code: service.ErrCodeBadRequest, Message: "empty last name"}, resp.Results[1].Error)
+	})
+
+	t.Run("BatchCreate atomic aborted", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*1)
+		defer cancel()
+		repo.EXPECT().CreateUsers(gomock.Any(), gomock.Len(2), true).
+			Return([]bool{true, false}, repository.DuplicateKeyError).Times(1)
+
+		taken := &pb.CreateUserRequest{FirstName: "User", LastName: "Two", Nickname: "userOne11", Email: email2, Password: pwd, Country: "NL"}
+		resp, err := client.BatchCreateUsers(ctx, &pb.BatchCreateUsersRequest{Users: []*pb.CreateUserRequest{valid, taken}, Atomic: true})
+		require.NoError(t, err)
+		require.Len(t, resp.Results, 2)
+		assert.Equal(t, aborted, resp.Results[0].Error)
+		assert.Nil(t, resp.Results[0].User)
+		assert.Equal(t, int32(service.ErrCodeUserAlreadyExists), resp.Results[1].Error.Code)
+	})
+
+	t.Run("BatchUpdate atomic aborted", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*1)
+		defer cancel()
+		repo.EXPECT().GetUser(gomock.Any(), id1).Return(
+			(&service.User{FirstName: "User", LastName: "One", Email: email1, Country: "NL"}).WithID(id1), nil).Times(1)
+		repo.EXPECT().GetUser(gomock.Any(), id2).Return(nil, repository.NoUsersFoundError).Times(1)
+
+		resp, err := client.BatchUpdateUsers(ctx, &pb.BatchUpdateUsersRequest{
+			Users: []*pb.UpdateUserRequest{
+				{Id: id1, Country: asPrt("DE")},
+				{Id: id2, Country: asPrt("DE")},
+			},
+			Atomic: true,
+		})
+		require.NoError(t, err)
+		require.Len(t, resp.Results, 2)
+		assert.Equal(t, aborted, resp.Results[0].Error)
+		assert.Equal(t, notFound, resp.Results[1].Error)
+	})
+
+	t.Run("BatchUpdate no id error", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*1)
+		defer cancel()
+		_, err := client.BatchUpdateUsers(ctx, &pb.BatchUpdateUsersRequest{
+			Users: []*pb.UpdateUserRequest{{Id: id1}, {Country: asPrt("DE")}},
+		})
+		assert.ErrorIs(t, err, status.Error(codes.InvalidArgument, "user 1: user id is mandatory"))
+	})
+
+	t.Run("BatchDelete best effort Ok", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*1)
+		defer cancel()
+		repo.EXPECT().DeleteUsers(gomock.Any(), []string{id1, id2}, false).
+			Return([]service.User{{ID: id1, Country: "NL"}}, nil).Times(1)
+		notificationSvc.EXPECT().Notify(gomock.Any(), clients.ChannelDelete, userEvent(events.TypeUserDeleted, id1)).Times(1)
+
+		resp, err := client.BatchDeleteUsers(ctx, &pb.BatchDeleteUsersRequest{Ids: []string{id1, id2}})
+		require.NoError(t, err)
+		require.Len(t, resp.Results, 2)
+		assert.Equal(t, id1, resp.Results[0].Id)
+		assert.Nil(t, resp.Results[0].Error)
+		assert.Equal(t, notFound, resp.Results[1].Error)
+	})
+
+	t.Run("BatchDelete empty error", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*1)
+		defer cancel()
+		_, err := client.BatchDeleteUsers(ctx, &pb.BatchDeleteUsersRequest{})
+		assert.ErrorIs(t, err, status.Error(codes.InvalidArgument, "could not delete users: empty batch"))
+	})
+}
+
 func TestServer_DeleteUser(t *testing.T) {
 	t.Parallel()
 	ctrl := gomock.NewController(t)
@@ -541,10 +1383,11 @@ func TestServer_DeleteUser(t *testing.T) {
 				Id: id1,
 			},
 			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetUser(gomock.Any(), id1).Return((&service.User{Country: "NL"}).WithID(id1), nil).Times(1)
 				r.EXPECT().DeleteUser(gomock.Any(), id1).Return(nil).Times(1)
 			},
 			notify: func(n *clients.MockChannelNotificator) {
-				n.EXPECT().Notify(gomock.Any(), clients.ChannelDelete, fmt.Sprintf("user with ID=%s has been deleted", id1))
+				n.EXPECT().Notify(gomock.Any(), clients.ChannelDelete, userEvent(events.TypeUserDeleted, id1))
 			},
 			want: expectation{
 				err: nil,
@@ -565,7 +1408,7 @@ func TestServer_DeleteUser(t *testing.T) {
 				Id: id1,
 			},
 			repo: func(r *service.MockUserRepo) {
-				r.EXPECT().DeleteUser(gomock.Any(), id1).Return(repository.NoUsersFoundError).Times(1)
+				r.EXPECT().GetUser(gomock.Any(), id1).Return(nil, repository.NoUsersFoundError).Times(1)
 			},
 			notify: func(n *clients.MockChannelNotificator) {},
 			want: expectation{
@@ -577,6 +1420,7 @@ func TestServer_DeleteUser(t *testing.T) {
 				Id: id1,
 			},
 			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetUser(gomock.Any(), id1).Return((&service.User{Country: "NL"}).WithID(id1), nil).Times(1)
 				r.EXPECT().DeleteUser(gomock.Any(), id1).Return(somethingHappensError).Times(1)
 			},
 			notify: func(n *clients.MockChannelNotificator) {},
@@ -600,3 +1444,282 @@ func TestServer_DeleteUser(t *testing.T) {
 		})
 	}
 }
+
+func TestServer_RestoreUser(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	repo := service.NewMockUserRepo(ctrl)
+	notificationSvc := clients.NewMockChannelNotificator(ctrl)
+	client, closer := setupClient(repo, notificationSvc)
+
+	defer closer()
+	type expectation struct {
+		user *pb.User
+		err  error
+	}
+
+	tests := map[string]struct {
+		in     *pb.RestoreUserRequest
+		want   expectation
+		repo   func(r *service.MockUserRepo)
+		notify func(n *clients.MockChannelNotificator)
+	}{
+		"RestoreUser Ok": {
+			in: &pb.RestoreUserRequest{
+				Id: id1,
+			},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().RestoreUser(gomock.Any(), id1).
+					Return(&service.User{ID: id1, NickName: "user1", Email: email1, Country: "NL", Version: 4}, nil).Times(1)
+			},
+			notify: func(n *clients.MockChannelNotificator) {
+				n.EXPECT().Notify(gomock.Any(), clients.ChannelCreate, userEvent(events.TypeUserRestored, id1))
+			},
+			want: expectation{
+				user: &pb.User{Id: id1, Nickname: "user1", Email: email1, Country: "NL", Version: 4},
+			},
+		},
+		"Restore user no id error": {
+			in:     &pb.RestoreUserRequest{},
+			repo:   func(r *service.MockUserRepo) {},
+			notify: func(n *clients.MockChannelNotificator) {},
+			want: expectation{
+				err: status.Error(codes.InvalidArgument, "id is mandatory"),
+			},
+		},
+		"Restore user not found error": {
+			in: &pb.RestoreUserRequest{
+				Id: id1,
+			},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().RestoreUser(gomock.Any(), id1).Return(nil, repository.NoUsersFoundError).Times(1)
+			},
+			notify: func(n *clients.MockChannelNotificator) {},
+			want: expectation{
+				err: status.Error(codes.NotFound, service.ErrUserNotFound.Message),
+			},
+		},
+		"Restore user email taken error": {
+			in: &pb.RestoreUserRequest{
+				Id: id1,
+			},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().RestoreUser(gomock.Any(), id1).Return(nil, repository.DuplicateKeyError).Times(1)
+			},
+			notify: func(n *clients.MockChannelNotificator) {},
+			want: expectation{
+				err: status.Error(codes.AlreadyExists, service.ErrRestoreConflict.Message),
+			},
+		},
+	}
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*1)
+			defer cancel()
+			tt.repo(repo)
+			tt.notify(notificationSvc)
+			got, err := client.RestoreUser(ctx, tt.in)
+			if tt.want.err != nil {
+				assert.ErrorIs(t, err, tt.want.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want.user.Id, got.Id)
+			assert.Equal(t, tt.want.user.Email, got.Email)
+			assert.Equal(t, tt.want.user.Version, got.Version)
+		})
+	}
+}
+
+// userEvent matches event of the type on the user
+func userEvent(t events.Type, userID string) gomock.Matcher {
+	return gomock.Cond(func(e *events.Event) bool {
+		return e.Type == t && e.UserID == userID && e.SchemaVersion == events.SchemaVersion
+	})
+}
+
+// tamper changes a character of the sealed token ciphertext
+func tamper(token string) string {
+	i := len(token) - 5
+	c := byte('A')
+	if token[i] == c {
+		c = 'B'
+	}
+	return token[:i] + string(c) + token[i+1:]
+}
+
+func TestServer_ListUserHistory(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	repo := service.NewMockUserRepo(ctrl)
+	audit := service.NewMockAuditRepo(ctrl)
+	notificationSvc := clients.NewMockChannelNotificator(ctrl)
+	client, closer := setupClient(repo, notificationSvc, service.WithAudit(audit))
+	defer closer()
+
+	entries := []service.AuditEntry{
+		{ID: 7, UserID: id1, Action: service.AuditUpdated, Actor: &service.Caller{Subject: id2, Role: service.RoleAdmin},
+			Transport: service.TransportHTTP, RequestID: "req-7", CreatedAt: createdAt,
+			Changes: []events.Change{{Field: "country", Old: "NL", New: "DE"}}},
+		{ID: 5, UserID: id1, Action: service.AuditCreated, CreatedAt: createdAt},
+		{ID: 2, UserID: id1, Action: service.AuditCreated, CreatedAt: createdAt},
+	}
+	nextPage, _ := pages.GenerateHistoryPage(service.HistoryQuery{UserID: id1, Limit: 2}, 5)
+	otherUserPage, _ := pages.GenerateHistoryPage(service.HistoryQuery{UserID: id2, Limit: 2}, 5)
+
+	type expectation struct {
+		ids      []int64
+		nextPage bool
+		err      error
+	}
+	tests := map[string]struct {
+		in    *pb.ListUserHistoryRequest
+		audit func(a *service.MockAuditRepo)
+		want  expectation
+	}{
+		"ListUserHistory first page Ok": {
+			in: &pb.ListUserHistoryRequest{Id: id1, Pagination: asPrt(int32(2))},
+			audit: func(a *service.MockAuditRepo) {
+				a.EXPECT().UserHistory(gomock.Any(), id1, int64(0), 3).Return(entries, nil).Times(1)
+			},
+			want: expectation{ids: []int64{7, 5}, nextPage: true},
+		},
+		"ListUserHistory next page Ok": {
+			in: &pb.ListUserHistoryRequest{Id: id1, NextPage: asPrt(nextPage)},
+			audit: func(a *service.MockAuditRepo) {
+				a.EXPECT().UserHistory(gomock.Any(), id1, int64(5), 3).Return(entries[2:], nil).Times(1)
+			},
+			want: expectation{ids: []int64{2}},
+		},
+		"ListUserHistory no id error": {
+			in:    &pb.ListUserHistoryRequest{},
+			audit: func(a *service.MockAuditRepo) {},
+			want:  expectation{err: status.Error(codes.InvalidArgument, "id is mandatory")},
+		},
+		"ListUserHistory next page of other user error": {
+			in:    &pb.ListUserHistoryRequest{Id: id1, NextPage: asPrt(otherUserPage)},
+			audit: func(a *service.MockAuditRepo) {},
+			want:  expectation{err: status.Error(codes.InvalidArgument, "next_page token is invalid")},
+		},
+		"ListUserHistory repo error": {
+			in: &pb.ListUserHistoryRequest{Id: id1},
+			audit: func(a *service.MockAuditRepo) {
+				a.EXPECT().UserHistory(gomock.Any(), id1, int64(0), service.DefaultHistoryLimit+1).
+					Return(nil, somethingHappensError).Times(1)
+			},
+			want: expectation{err: status.Error(codes.Internal, "could not get user history: service internal error")},
+		},
+	}
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*1)
+			defer cancel()
+			tt.audit(audit)
+			got, err := client.ListUserHistory(ctx, tt.in)
+			if tt.want.err != nil {
+				assert.ErrorIs(t, err, tt.want.err)
+				return
+			}
+			require.NoError(t, err)
+			ids := make([]int64, len(got.Entries))
+			for i, e := range got.Entries {
+				ids[i] = e.Id
+			}
+			assert.Equal(t, tt.want.ids, ids)
+			assert.Equal(t, tt.want.nextPage, got.NextPage != nil)
+		})
+	}
+
+	t.Run("ListUserHistory entry encoding", func(t *testing.T) {
+		audit.EXPECT().UserHistory(gomock.Any(), id1, int64(0), 2).Return(entries[:1], nil).Times(1)
+		got, err := client.ListUserHistory(context.Background(), &pb.ListUserHistoryRequest{Id: id1, Pagination: asPrt(int32(1))})
+		require.NoError(t, err)
+		require.Len(t, got.Entries, 1)
+		e := got.Entries[0]
+		assert.Equal(t, "updated", e.Action)
+		assert.Equal(t, id2, e.Actor.Subject)
+		assert.Equal(t, "http", e.Transport)
+		assert.Equal(t, "req-7", e.RequestId)
+		require.Len(t, e.Changes, 1)
+		assert.Equal(t, "DE", e.Changes[0].New)
+		assert.Equal(t, createdAt.Format(time.RFC3339Nano), e.CreatedAt)
+	})
+}
+
+func TestServer_AuditRequestID(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	repo := service.NewMockUserRepo(ctrl)
+	audit := service.NewMockAuditRepo(ctrl)
+	notificationSvc := clients.NewMockChannelNotificator(ctrl)
+	client, closer := setupClient(repo, notificationSvc, service.WithAudit(audit))
+	defer closer()
+
+	repo.EXPECT().CreateUser(gomock.Any(), gomock.Any()).
+		Return(&service.User{ID: id1, NickName: "user1", Email: email1, Country: "NL", Version: 1}, nil).Times(1)
+	audit.EXPECT().AddAuditEntry(gomock.Any(), gomock.Cond(func(e *service.AuditEntry) bool {
+		return e.UserID == id1 && e.Action == service.AuditCreated &&
+			e.Transport == service.TransportGRPC && e.RequestID == "req-1"
+	})).Return(nil).Times(1)
+	notificationSvc.EXPECT().Notify(gomock.Any(), clients.ChannelCreate, userEvent(events.TypeUserCreated, id1))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "req-1")
+	var header metadata.MD
+	_, err := client.CreateUser(ctx, &pb.CreateUserRequest{
+		FirstName: "first", LastName: "last", Nickname: "user1", Email: email1, Password: "password1", Country: "NL",
+	}, grpc.Header(&header))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"req-1"}, header.Get("x-request-id"))
+}
+
+func TestServer_EraseUser(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	repo := service.NewMockUserRepo(ctrl)
+	privacy := service.NewMockPrivacyRepo(ctrl)
+	notificationSvc := clients.NewMockChannelNotificator(ctrl)
+	client, closer := setupClient(repo, notificationSvc, service.WithPrivacy(privacy))
+	defer closer()
+
+	tests := map[string]struct {
+		in      *pb.EraseUserRequest
+		prepare func(p *service.MockPrivacyRepo, n *clients.MockChannelNotificator)
+		err     error
+	}{
+		"EraseUser Ok": {
+			in: &pb.EraseUserRequest{Id: id1},
+			prepare: func(p *service.MockPrivacyRepo, n *clients.MockChannelNotificator) {
+				p.EXPECT().UserRecord(gomock.Any(), id1).
+					Return(&service.UserRecord{User: service.User{ID: id1, Email: email1, Country: "NL"}}, nil).Times(1)
+				p.EXPECT().EraseUser(gomock.Any(), id1).Return(nil).Times(1)
+				p.EXPECT().UserNotifications(gomock.Any(), id1).Return(nil, nil).Times(1)
+				n.EXPECT().Notify(gomock.Any(), clients.ChannelDelete, userEvent(events.TypeUserErased, id1))
+			},
+		},
+		"EraseUser no id error": {
+			in:      &pb.EraseUserRequest{},
+			prepare: func(p *service.MockPrivacyRepo, n *clients.MockChannelNotificator) {},
+			err:     status.Error(codes.InvalidArgument, "id is mandatory"),
+		},
+		"EraseUser not found error": {
+			in: &pb.EraseUserRequest{Id: id1},
+			prepare: func(p *service.MockPrivacyRepo, n *clients.MockChannelNotificator) {
+				p.EXPECT().UserRecord(gomock.Any(), id1).Return(nil, repository.NoUsersFoundError).Times(1)
+			},
+			err: status.Error(codes.NotFound, service.ErrUserNotFound.Message),
+		},
+	}
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*1)
+			defer cancel()
+			tt.prepare(privacy, notificationSvc)
+			_, err := client.EraseUser(ctx, tt.in)
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
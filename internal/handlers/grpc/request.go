@@ -15,12 +15,7 @@ type createUser struct {
 }
 
 func (cu *createUser) Decode(from *pb.CreateUserRequest) error {
-	cu.User.FirstName = from.GetFirstName()
-	cu.User.LastName = from.GetLastName()
-	cu.User.NickName = from.GetNickname()
-	cu.User.Email = strings.ToLower(from.GetEmail())
-	cu.User.Password = from.GetPassword()
-	cu.User.Country = from.GetCountry()
+	cu.User = *pb2User(from)
 	return cu.User.Validate(false)
 }
 func (cu *createUser) Encode() *pb.User {
@@ -29,30 +24,29 @@ func (cu *createUser) Encode() *pb.User {
 
 // ListUsers
 type listUsers struct {
-	NextPage string
-	Limit    int
-	Offset   int
-	Filter   *service.Filter
+	Query service.ListQuery
 }
 
-func (lu *listUsers) Decode(r *pb.ListUsersRequest) error {
-	np, err := nextPage(r)
+func (lu *listUsers) Decode(r *pb.ListUsersRequest, pages *handlers.PageTokens, filters service.Filters) error {
+	np, err := nextPage(r, pages)
 	if err != nil {
 		return err
 	}
-	return lu.decode(np)
+	return lu.decode(np, filters)
 }
-func (lu *listUsers) decode(np *handlers.NextPage) error {
-	if np.FilterBy != "" {
-		filter, err := service.NewFilter(np.FilterBy, np.Filter)
-		if err != nil {
-			return err
-		}
-		lu.Filter = filter
+func (lu *listUsers) decode(np *handlers.NextPage, filters service.Filters) error {
+	filter, err := np.ParseFilter(filters)
+	if err != nil {
+		return err
 	}
-	lu.Limit = np.Limit
-	lu.Offset = np.Offset
-	return nil
+	lu.Query.Filter = filter
+	if lu.Query.Sort, err = service.ParseSort(np.Sort); err != nil {
+		return err
+	}
+	lu.Query.Limit = np.Limit
+	lu.Query.IncludeTotal = np.IncludeTotal
+	lu.Query.After = np.Cursor()
+	return lu.Query.Sort.Validate(lu.Query.After)
 }
 func (lu *listUsers) Encode(users []service.User) *pb.ListUsersResponse {
 	r := &pb.ListUsersResponse{
@@ -68,6 +62,53 @@ func (lu *listUsers) Encode(users []service.User) *pb.ListUsersResponse {
 	return r
 }
 
+func (lu *listUsers) EncodeTotals(r *pb.ListUsersResponse, t *service.Totals) {
+	if t == nil {
+		return
+	}
+	total := int64(t.Count)
+	r.Total = &total
+	r.Facets = &pb.Facets{Country: make(map[string]int64, len(t.Countries))}
+	for country, count := range t.Countries {
+		r.Facets.Country[country] = int64(count)
+	}
+}
+
+// StreamUsers
+type streamUsers struct {
+	Query service.ListQuery
+}
+
+func (su *streamUsers) Decode(r *pb.StreamUsersRequest, filters service.Filters) error {
+	if r.GetFilter() == "" && r.GetFilterBy() != "" {
+		return fmt.Errorf("parameter filterBy should be used along with filter")
+	}
+	criteria := handlers.NextPage{Filter: r.GetFilter(), FilterBy: r.GetFilterBy()}
+	filter, err := criteria.ParseFilter(filters)
+	if err != nil {
+		return err
+	}
+	su.Query.Filter = filter
+	su.Query.Sort, err = service.ParseSort(r.GetSort())
+	return err
+}
+
+// WatchUsers
+type watchUsers struct {
+	Query service.WatchQuery
+}
+
+func (wu *watchUsers) Decode(r *pb.WatchUsersRequest) error {
+	wu.Query.After = r.GetAfterEventId()
+	for _, c := range r.GetCountry() {
+		if c = strings.TrimSpace(c); c == "" {
+			return fmt.Errorf("empty country")
+		}
+		wu.Query.Countries = append(wu.Query.Countries, c)
+	}
+	return nil
+}
+
 type updateUser struct {
 	service.User
 }
@@ -92,6 +133,15 @@ func (uu *updateUser) Decode(r *pb.UpdateUserRequest) error {
 	if r.Email != nil {
 		uu.User.WithEmail(r.GetEmail())
 	}
+	if r.Role != nil {
+		uu.User.WithRole(r.GetRole())
+	}
+	if r.ExpectedVersion != nil {
+		if r.GetExpectedVersion() <= 0 {
+			return fmt.Errorf("expected version must be positive")
+		}
+		uu.User.WithVersion(r.GetExpectedVersion())
+	}
 
 	if r.Id == "" {
 		return fmt.Errorf("user id is mandatory")
@@ -100,12 +150,112 @@ func (uu *updateUser) Decode(r *pb.UpdateUserRequest) error {
 	return nil
 }
 
-func nextPage(r *pb.ListUsersRequest) (*handlers.NextPage, error) {
-	return handlers.LoadNextPage(r.GetNextPage(), r.GetFilter(), r.GetFilterBy(), func() (int, error) {
+// batchCreate users are validated by the service one by one
+type batchCreate struct {
+	Users []*service.User
+}
+
+func (bc *batchCreate) Decode(r *pb.BatchCreateUsersRequest) {
+	bc.Users = make([]*service.User, len(r.GetUsers()))
+	for i, u := range r.GetUsers() {
+		bc.Users[i] = pb2User(u)
+	}
+}
+
+type batchUpdate struct {
+	Users []*service.User
+}
+
+func (bu *batchUpdate) Decode(r *pb.BatchUpdateUsersRequest) error {
+	bu.Users = make([]*service.User, len(r.GetUsers()))
+	for i, u := range r.GetUsers() {
+		uu := &updateUser{}
+		if err := uu.Decode(u); err != nil {
+			return fmt.Errorf("user %d: %w", i, err)
+		}
+		bu.Users[i] = &uu.User
+	}
+	return nil
+}
+
+func batch2PB(results []service.BatchResult) *pb.BatchUsersResponse {
+	resp := &pb.BatchUsersResponse{Results: make([]*pb.BatchResult, len(results))}
+	for i, r := range results {
+		resp.Results[i] = &pb.BatchResult{Id: r.ID}
+		if r.User != nil {
+			resp.Results[i].User = user2PB(r.User)
+		}
+		if r.Err != nil {
+			resp.Results[i].Error = &pb.BatchError{Code: int32(r.Err.Code), Message: r.Err.Message}
+		}
+	}
+	return resp
+}
+
+type userHistory struct {
+	Query service.HistoryQuery
+}
+
+func (uh *userHistory) Decode(r *pb.ListUserHistoryRequest, pages *handlers.PageTokens) error {
+	if r.GetId() == "" {
+		return fmt.Errorf("id is mandatory")
+	}
+	if r.GetPagination() < 0 {
+		return fmt.Errorf("malformed pagination")
+	}
+	q, err := pages.LoadHistoryPage(r.GetId(), r.GetNextPage(), int(r.GetPagination()))
+	if err != nil {
+		return err
+	}
+	uh.Query = q
+	return nil
+}
+
+func (uh *userHistory) Encode(entries []service.AuditEntry) *pb.ListUserHistoryResponse {
+	r := &pb.ListUserHistoryResponse{Entries: make([]*pb.AuditEntry, len(entries))}
+	for i, e := range entries {
+		changes := make([]*pb.FieldChange, len(e.Changes))
+		for j, c := range e.Changes {
+			changes[j] = &pb.FieldChange{Field: c.Field, Old: c.Old, New: c.New, Redacted: c.Redacted}
+		}
+		r.Entries[i] = &pb.AuditEntry{
+			Id:        e.ID,
+			Action:    string(e.Action),
+			Transport: e.Transport,
+			RequestId: e.RequestID,
+			Changes:   changes,
+			CreatedAt: e.CreatedAt.Format(time.RFC3339Nano),
+		}
+		if a := e.Actor; a != nil {
+			r.Entries[i].Actor = &pb.Actor{Subject: a.Subject, Method: a.Method, Role: a.Role}
+		}
+	}
+	return r
+}
+
+func nextPage(r *pb.ListUsersRequest, pages *handlers.PageTokens) (*handlers.NextPage, error) {
+	return pages.LoadNextPage(r.GetNextPage(), handlers.NextPage{
+		Filter:       r.GetFilter(),
+		FilterBy:     r.GetFilterBy(),
+		Sort:         r.GetSort(),
+		IncludeTotal: r.GetIncludeTotal(),
+	}, func() (int, error) {
 		return int(r.GetPagination()), nil
 	})
 }
 
+func pb2User(from *pb.CreateUserRequest) *service.User {
+	return &service.User{
+		FirstName: from.GetFirstName(),
+		LastName:  from.GetLastName(),
+		NickName:  from.GetNickname(),
+		Email:     strings.ToLower(from.GetEmail()),
+		Password:  from.GetPassword(),
+		Country:   from.GetCountry(),
+		Role:      from.GetRole(),
+	}
+}
+
 func user2PB(u *service.User) *pb.User {
 	return &pb.User{
 		Id:        u.ID,
@@ -114,7 +264,19 @@ func user2PB(u *service.User) *pb.User {
 		Nickname:  u.NickName,
 		Email:     u.Email,
 		Country:   u.Country,
+		Role:      u.Role,
 		CreatedAt: u.CreatedAt.Format(time.RFC3339),
 		UpdatedAt: u.UpdatedAt.Format(time.RFC3339),
+		Version:   u.Version,
+	}
+}
+
+func tokens2PB(t *service.TokenPair) *pb.Tokens {
+	return &pb.Tokens{
+		TokenType:        "Bearer",
+		AccessToken:      t.AccessToken,
+		AccessExpiresAt:  t.AccessExpiresAt.Format(time.RFC3339),
+		RefreshToken:     t.RefreshToken,
+		RefreshExpiresAt: t.RefreshExpiresAt.Format(time.RFC3339),
 	}
 }
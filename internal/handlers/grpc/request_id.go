@@ -0,0 +1,37 @@
+package grpc
+
+import (
+	"context"
+
+	"github.com/BorisRostovskiy/ESL/internal/handlers"
+	"github.com/BorisRostovskiy/ESL/internal/service"
+	middleware "github.com/grpc-ecosystem/go-grpc-middleware/v2"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// mdRequestID metadata key of the request id, sent back in the response header
+const mdRequestID = "x-request-id"
+
+// RequestInterceptors unary and stream interceptors storing request details into the call context,
+// request id is taken from the x-request-id metadata or generated
+func RequestInterceptors() (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	unary := func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, id := withRequest(ctx)
+		_ = grpc.SetHeader(ctx, metadata.Pairs(mdRequestID, id))
+		return handler(ctx, req)
+	}
+	stream := func(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		wrapped := middleware.WrapServerStream(ss)
+		ctx, id := withRequest(ss.Context())
+		wrapped.WrappedContext = ctx
+		_ = ss.SetHeader(metadata.Pairs(mdRequestID, id))
+		return handler(srv, wrapped)
+	}
+	return unary, stream
+}
+
+func withRequest(ctx context.Context) (context.Context, string) {
+	id := handlers.RequestID(firstMD(ctx, mdRequestID))
+	return service.WithRequest(ctx, &service.Request{Transport: service.TransportGRPC, ID: id}), id
+}
@@ -0,0 +1,37 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/BorisRostovskiy/ESL/internal/auth"
+	"github.com/BorisRostovskiy/ESL/internal/log"
+	"github.com/BorisRostovskiy/ESL/internal/service"
+)
+
+const (
+	HeaderAuthorization   = "Authorization"
+	HeaderWWWAuthenticate = "WWW-Authenticate"
+)
+
+// authenticate rejects requests without valid credentials, caller identity is stored into the request context
+func (h handler) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		creds := auth.Credentials{
+			APIKey: r.Header.Get(auth.HeaderAPIKey),
+			Bearer: auth.BearerToken(r.Header.Get(HeaderAuthorization)),
+			TLS:    r.TLS,
+		}
+		if creds.TLS == nil {
+			creds.TLS = auth.TLSStateFrom(r.Context())
+		}
+
+		caller, err := h.authn.Authenticate(creds)
+		if err != nil {
+			log.WithError(r, err)
+			w.Header().Set(HeaderWWWAuthenticate, "Bearer")
+			h.respond(w, errResponse(http.StatusUnauthorized, service.ErrCodeUnauthenticated, service.ErrUnauthenticated))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(service.WithCaller(r.Context(), caller)))
+	})
+}
@@ -16,6 +16,15 @@ var (
 		service.ErrCodeUserNotFound:      http.StatusNotFound,
 		service.ErrCodeConflict:          http.StatusConflict,
 		service.ErrCodeEmptyUpdate:       http.StatusBadRequest,
+		service.ErrCodeNotConfigured:     http.StatusNotImplemented,
+		service.ErrCodeWatchExpired:      http.StatusGone,
+		service.ErrCodeWatchLagging:      http.StatusServiceUnavailable,
+		service.ErrCodeVersionMismatch:   http.StatusPreconditionFailed,
+
+		service.ErrCodeInvalidCredentials:  http.StatusUnauthorized,
+		service.ErrCodeInvalidRefreshToken: http.StatusUnauthorized,
+		service.ErrCodeUnauthenticated:     http.StatusUnauthorized,
+		service.ErrCodePermissionDenied:    http.StatusForbidden,
 	}
 	ErrInternal = &Error{
 		Status:  http.StatusInternalServerError,
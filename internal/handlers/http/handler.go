@@ -1,9 +1,13 @@
 package http
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
+	"time"
 
-	"github.com/BorisRostovskiy/ESL/internal/handlers"
+	"github.com/BorisRostovskiy/ESL/internal/events"
+	"github.com/BorisRostovskiy/ESL/internal/service"
 )
 
 // Create user
@@ -23,34 +27,111 @@ func (h handler) createUser(r *http.Request) response {
 	return cu
 }
 
+// Login verifies user credentials
+func (h handler) login(r *http.Request) response {
+	l := &login{}
+	if err := l.Decode(r); err != nil {
+		h.log.WithField("component", "http_handler").
+			Debugf("login decode error: %v", err)
+		return errRequestf(r, "failed to parse request: %w", err)
+	}
+
+	session, err := h.api.Authenticate(r.Context(), l.Login, l.Password)
+	if err != nil {
+		h.log.WithField("component", "http_handler").
+			Debugf("failed to perform login: %v", err)
+		return errApi(r, "could not authenticate user: %w", err)
+	}
+
+	l.Session = session
+	return l
+}
+
+// Refresh rotates refresh token issuing a new token pair
+func (h handler) refresh(r *http.Request) response {
+	rf := &refresh{}
+	if err := rf.Decode(r); err != nil {
+		h.log.WithField("component", "http_handler").
+			Debugf("refresh decode error: %v", err)
+		return errRequestf(r, "failed to parse request: %w", err)
+	}
+
+	tokens, err := h.api.Refresh(r.Context(), rf.RefreshToken)
+	if err != nil {
+		h.log.WithField("component", "http_handler").
+			Debugf("failed to perform refresh: %v", err)
+		return errApi(r, "could not refresh tokens: %w", err)
+	}
+
+	rf.Tokens = tokens
+	return rf
+}
+
+// JWKS public keys access tokens could be verified with
+func (h handler) jwks(_ *http.Request) response {
+	return &jwks{JWKSet: h.keys.JWKS()}
+}
+
+// Get user by ID, email or nickname
+func (h handler) getUser(r *http.Request) response {
+	gu := &getUser{}
+	if err := gu.Decode(r); err != nil {
+		h.log.WithField("component", "http_handler").
+			Debugf("get user decode error: %v", err)
+		return errRequestf(r, "failed to parse request: %w", err)
+	}
+
+	var (
+		user *service.User
+		err  error
+	)
+	switch gu.By {
+	case lookupByEmail:
+		user, err = h.api.GetUserByEmail(r.Context(), gu.Value)
+	case lookupByNickname:
+		user, err = h.api.GetUserByNickname(r.Context(), gu.Value)
+	default:
+		user, err = h.api.GetUser(r.Context(), gu.Value)
+	}
+	if err != nil {
+		h.log.WithField("component", "http_handler").
+			Debugf("failed to perform get user: %v", err)
+		return errApi(r, "could not get user: %w", err)
+	}
+
+	gu.User = user
+	return gu
+}
+
 // List users
 func (h handler) listUsers(r *http.Request) response {
 	lu := &listUsers{}
-	if err := lu.Decode(r); err != nil {
+	if err := lu.Decode(r, h.pages, h.api.Filters()); err != nil {
 		h.log.WithField("component", "http_handler").
 			Debugf("list users decode error: %v", err)
 		return errRequest(r, err)
 	}
 
-	users, err := h.api.ListUsers(r.Context(), lu.Limit, lu.Offset, lu.Filter)
+	page, err := h.api.ListUsers(r.Context(), lu.Query)
 	if err != nil {
 		h.log.WithField("component", "http_handler").
 			Debugf("failed to perform list users: %v", err)
 		return errApi(r, "could not list users: %w", err)
 	}
 
-	lu.Users = make([]User, len(users))
-	if len(users) == 0 {
+	lu.totals(page.Totals)
+	lu.Users = make([]User, len(page.Users))
+	if len(page.Users) == 0 {
 		return lu
 	}
 
-	for i, su := range users {
+	for i, su := range page.Users {
 		var u User
 		u.marshal(&su)
 		lu.Users[i] = u
 	}
 
-	np, err := handlers.GenerateNextPage(lu.Limit, lu.Offset, len(users), lu.Filter)
+	np, err := h.pages.GenerateNextPage(lu.Query, page.Next)
 	if err != nil {
 		h.log.WithField("component", "http_handler").
 			Debugf("could not marshal next page: %v", err)
@@ -62,6 +143,186 @@ func (h handler) listUsers(r *http.Request) response {
 	return lu
 }
 
+// Batch create users, per item results are returned
+func (h handler) batchCreateUsers(r *http.Request) response {
+	bu := &batchUsers{}
+	users, err := bu.Decode(r)
+	if err != nil {
+		h.log.WithField("component", "http_handler").
+			Debugf("batch create decode error: %v", err)
+		return errRequest(r, err)
+	}
+	results, err := h.api.BatchCreateUsers(r.Context(), users, bu.Atomic)
+	if err != nil {
+		return errApi(r, "could not create users: %w", err)
+	}
+	br := &batchResults{}
+	br.marshal(results)
+	return br
+}
+
+// Batch update users, per item results are returned
+func (h handler) batchUpdateUsers(r *http.Request) response {
+	bu := &batchUsers{}
+	users, err := bu.Decode(r)
+	if err != nil {
+		h.log.WithField("component", "http_handler").
+			Debugf("batch update decode error: %v", err)
+		return errRequest(r, err)
+	}
+	results, err := h.api.BatchUpdateUsers(r.Context(), users, bu.Atomic)
+	if err != nil {
+		return errApi(r, "could not update users: %w", err)
+	}
+	br := &batchResults{}
+	br.marshal(results)
+	return br
+}
+
+// Batch delete users, per item results are returned
+func (h handler) batchDeleteUsers(r *http.Request) response {
+	bd := &batchDelete{}
+	if err := bd.Decode(r); err != nil {
+		h.log.WithField("component", "http_handler").
+			Debugf("batch delete decode error: %v", err)
+		return errRequest(r, err)
+	}
+	results, err := h.api.BatchDeleteUsers(r.Context(), bd.IDs, bd.Atomic)
+	if err != nil {
+		return errApi(r, "could not delete users: %w", err)
+	}
+	br := &batchResults{}
+	br.marshal(results)
+	return br
+}
+
+// Export users streams users matching the filter as CSV or NDJSON file, users are written as they are read
+func (h handler) exportUsers(w http.ResponseWriter, r *http.Request) {
+	eu := &exportUsers{}
+	if err := eu.Decode(r, h.api.Filters()); err != nil {
+		h.log.WithField("component", "http_handler").
+			Debugf("export users decode error: %v", err)
+		h.respond(w, errRequest(r, err))
+		return
+	}
+
+	var uw usersWriter
+	start := func() error {
+		// status is sent along with the first user, errors before that are regular responses
+		w.Header().Set(HeaderContentType, eu.Format.contentType())
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="users.%s"`, eu.Format))
+		w.WriteHeader(http.StatusOK)
+		var err error
+		uw, err = newUsersWriter(w, eu.Format)
+		return err
+	}
+	err := h.api.StreamUsers(r.Context(), eu.Query, func(u *service.User) error {
+		if uw == nil {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		return uw.Write(u)
+	})
+	if err != nil && uw == nil {
+		h.respond(w, errApi(r, "could not export users: %w", err))
+		return
+	}
+	if err == nil && uw == nil {
+		err = start()
+	}
+	if err == nil {
+		err = uw.Flush()
+	}
+	if err != nil {
+		h.log.WithField("component", "http_handler").
+			Debugf("export users is interrupted: %v", err)
+	}
+}
+
+// Import users from CSV or NDJSON file, every row is reported. Rejected rows are responded as file on request
+func (h handler) importUsers(r *http.Request) response {
+	iu := &importUsers{}
+	if err := iu.Decode(r); err != nil {
+		h.log.WithField("component", "http_handler").
+			Debugf("import users decode error: %v", err)
+		return errRequest(r, err)
+	}
+
+	users, positions := iu.Users()
+	var results []service.ImportResult
+	if len(users) > 0 {
+		var err error
+		if results, err = h.api.ImportUsers(r.Context(), users, iu.DryRun); err != nil {
+			h.log.WithField("component", "http_handler").
+				Debugf("failed to perform import users: %v", err)
+			return errApi(r, "could not import users: %w", err)
+		}
+	} else if len(iu.Rows) == 0 {
+		return errRequestf(r, "empty import")
+	}
+
+	ir := &importReport{}
+	ir.marshal(iu, positions, results)
+	return ir
+}
+
+// Watch users streams user changes as Server-Sent Events named after the event type. Events carry their ids,
+// so that EventSource resumes right after the last event it got on reconnect
+func (h handler) watchUsers(w http.ResponseWriter, r *http.Request) {
+	wu := &watchUsers{}
+	if err := wu.Decode(r); err != nil {
+		h.log.WithField("component", "http_handler").
+			Debugf("watch users decode error: %v", err)
+		h.respond(w, errRequest(r, err))
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	started := false
+	err := h.api.WatchUsers(r.Context(), wu.Query, func(e *events.Event) error {
+		if !started {
+			// status is sent along with the first heartbeat, errors before that are regular responses
+			w.Header().Set(HeaderContentType, "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("X-Accel-Buffering", "no")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+		if err := writeSSE(w, e); err != nil {
+			return err
+		}
+		return rc.Flush()
+	})
+	if err == nil || r.Context().Err() != nil {
+		return
+	}
+	if !started {
+		h.respond(w, errApi(r, "could not watch users: %w", err))
+		return
+	}
+	h.log.WithField("component", "http_handler").
+		Debugf("watch users is interrupted: %v", err)
+}
+
+// writeSSE writes event as Server-Sent Event, nil event as heartbeat
+func writeSSE(w http.ResponseWriter, e *events.Event) error {
+	if e == nil {
+		data, err := json.Marshal(heartbeat{Timestamp: time.Now().UTC()})
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "event: heartbeat\ndata: %s\n\n", data)
+		return err
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
+
 // Update user
 func (h handler) updateUser(r *http.Request) response {
 	uu := &updateUser{}
@@ -99,3 +360,85 @@ func (h handler) deleteUser(r *http.Request) response {
 
 	return du
 }
+
+// History of the user changes
+func (h handler) userHistory(r *http.Request) response {
+	uh := &userHistory{}
+	if err := uh.Decode(r, h.pages); err != nil {
+		h.log.WithField("component", "http_handler").
+			Debugf("user history decode error: %v", err)
+		return errRequestf(r, "failed to parse request: %w", err)
+	}
+
+	page, err := h.api.UserHistory(r.Context(), uh.Query)
+	if err != nil {
+		h.log.WithField("component", "http_handler").
+			Debugf("failed to perform user history: %v", err)
+		return errApi(r, "could not get user history: %w", err)
+	}
+
+	uh.encode(page.Entries)
+	if uh.NextPage, err = h.pages.GenerateHistoryPage(uh.Query, page.Next); err != nil {
+		h.log.WithField("component", "http_handler").
+			Debugf("could not marshal next page: %v", err)
+		return errRequestf(r, "could not marshal next page structure: %w", err)
+	}
+	return uh
+}
+
+// Export everything stored about the user
+func (h handler) exportUser(r *http.Request) response {
+	eu := &exportUser{}
+	if err := eu.Decode(r); err != nil {
+		h.log.WithField("component", "http_handler").
+			Debugf("export user decode error: %v", err)
+		return errRequestf(r, "failed to parse request: %w", err)
+	}
+
+	export, err := h.api.ExportUser(r.Context(), eu.ID)
+	if err != nil {
+		h.log.WithField("component", "http_handler").
+			Debugf("failed to perform export user: %v", err)
+		return errApi(r, "could not export user: %w", err)
+	}
+
+	eu.encode(export)
+	return eu
+}
+
+// Erase personal data of the user
+func (h handler) eraseUser(r *http.Request) response {
+	eu := &eraseUser{}
+	if err := eu.Decode(r); err != nil {
+		h.log.WithField("component", "http_handler").
+			Debugf("erase user decode error: %v", err)
+		return errRequestf(r, "failed to parse request: %w", err)
+	}
+
+	if err := h.api.EraseUser(r.Context(), eu.ID); err != nil {
+		h.log.WithField("component", "http_handler").
+			Debugf("failed to perform erase user: %v", err)
+		return errApi(r, "could not erase user: %w", err)
+	}
+	return eu
+}
+
+// Restore deleted user
+func (h handler) restoreUser(r *http.Request) response {
+	ru := &restoreUser{}
+	if err := ru.Decode(r); err != nil {
+		h.log.WithField("component", "http_handler").
+			Debugf("restore user decode error: %v", err)
+		return errRequestf(r, "failed to parse request: %w", err)
+	}
+
+	user, err := h.api.RestoreUser(r.Context(), ru.ID)
+	if err != nil {
+		h.log.WithField("component", "http_handler").
+			Debugf("failed to perform restore user: %v", err)
+		return errApi(r, "could not restore user: %w", err)
+	}
+
+	ru.User = user
+	return ru
+}
@@ -1,26 +1,42 @@
 package http
 
 import (
+	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
-	"reflect"
+	"net/textproto"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/BorisRostovskiy/ESL/internal/auth"
 	"github.com/BorisRostovskiy/ESL/internal/clients"
+	"github.com/BorisRostovskiy/ESL/internal/events"
+	"github.com/BorisRostovskiy/ESL/internal/handlers"
 	"github.com/BorisRostovskiy/ESL/internal/repository"
 	"github.com/BorisRostovskiy/ESL/internal/service"
+	"github.com/BorisRostovskiy/ESL/internal/tokens"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"go.uber.org/mock/gomock"
+	"golang.org/x/crypto/bcrypt"
 )
 
 const (
@@ -32,7 +48,6 @@ const (
 	email2           = "user_two@gmail.com"
 	email3           = "user_three@gmail.com"
 	somethingHappens = "something happens"
-	nextPageNoFilter = "eyJsaW1pdCI6Miwib2Zmc2V0IjoyLCJmaWx0ZXJfYnkiOiIiLCJmaWx0ZXIiOiIiLCJ0aW1lIjoiMjAzNS0wNy0yMVQxODo1ODoyOS42ODc0MzUrMDI6MDAifQo="
 )
 
 func addChiURLParams(r *http.Request, params map[string]string) *http.Request {
@@ -47,6 +62,13 @@ func addChiURLParams(r *http.Request, params map[string]string) *http.Request {
 var (
 	somethingHappensError = fmt.Errorf("%v", somethingHappens)
 	createdAt, _          = time.Parse(time.RFC3339, "2022-07-20T12:45:44Z")
+	pages, _              = handlers.NewPageTokens(handlers.PageTokenConfig{})
+	sortByUpdate, _       = service.ParseSort("-updated_at,last_name")
+	afterID2              = service.DefaultSort.CursorOf((&service.User{}).WithID(id2).WithCreateAt(createdAt))
+	nextPageNoFilter, _   = pages.GenerateNextPage(service.ListQuery{Limit: 2}, afterID2)
+	filters, _            = service.NewFilters([]service.FilterConfig{{Field: "country"}, {Field: "created_at"}, {Field: "nickname"}})
+	countryNL, _          = filters.NewFilter("country", "NL")
+	expression, _         = filters.ParseFilter(`country in (NL, DE) and (created_at >= 2022-01-01 or nickname prefix "user")`)
 	user                  = &service.User{
 		FirstName: "User",
 		LastName:  "One",
@@ -92,12 +114,11 @@ func TestServer_CreateUser(t *testing.T) {
 					Return(&service.User{ID: id1}, nil).Times(1)
 			},
 			notify: func(n *clients.MockChannelNotificator) {
-				n.EXPECT().Notify(gomock.Any(), clients.ChannelCreate,
-					fmt.Sprintf("user with ID=%s has been created", id1))
+				n.EXPECT().Notify(gomock.Any(), clients.ChannelCreate, userEvent(events.TypeUserCreated, id1))
 			},
 			want: expectation{
 				responseCode:    http.StatusCreated,
-				responsePayload: `{"id":"67cfa917-1cec-48ff-913c-243fe5749e92","first_name":"User5","last_name":"Lastname5","nickname":"user5_lastname","email":"user5@gmail.com","country":"NL","created_at":"0001-01-01T00:00:00Z","updated_at":"0001-01-01T00:00:00Z"}`,
+				responsePayload: `{"id":"67cfa917-1cec-48ff-913c-243fe5749e92","first_name":"User5","last_name":"Lastname5","nickname":"user5_lastname","email":"user5@gmail.com","country":"NL","role":"self","created_at":"0001-01-01T00:00:00Z","updated_at":"0001-01-01T00:00:00Z"}`,
 			},
 		},
 		"No Email Error": {
@@ -178,352 +199,308 @@ func TestServer_CreateUser(t *testing.T) {
 		})
 	}
 }
-func TestServer_ListUsers(t *testing.T) {
+func TestServer_Login(t *testing.T) {
 	t.Parallel()
 	ctrl := gomock.NewController(t)
 	logger := logrus.New()
-	//logger.Level = logrus.DebugLevel
 	notificationSvc := clients.NewMockChannelNotificator(ctrl)
 	repo := service.NewMockUserRepo(ctrl)
 	httpSvc := handler{log: logger, api: service.New(repo, logger, notificationSvc)}
+	hashedPwd, _ := bcrypt.GenerateFromPassword([]byte(pwd), 8)
 
 	type expectation struct {
 		responseCode    int
 		responsePayload string
-		paginated       bool
 		errResponse     string
 	}
-	type input struct {
-		reqPayload    io.Reader
-		requestParams map[string]string
-		urlVars       map[string]string
-	}
 
 	tests := map[string]struct {
-		in   input
+		in   io.Reader
 		want expectation
 		repo func(r *service.MockUserRepo)
 	}{
-		"ListUsers no pagination Ok": {
-			in: input{},
+		"Login Ok": {
+			in: strings.NewReader(fmt.Sprintf(`{"login": "%s", "password": "%s"}`, email1, pwd)),
 			repo: func(r *service.MockUserRepo) {
-				r.EXPECT().
-					ListUsers(gomock.Any(), -1, 0, nil).
-					Return([]service.User{
-						*user.WithID(id1).WithCreateAt(createdAt),
-						*user.WithID(id2).WithEmail(email2).WithCreateAt(createdAt),
-						*user.WithID(id3).WithEmail(email3).WithCreateAt(createdAt),
-					}, nil).Times(1)
+				r.EXPECT().GetCredentials(gomock.Any(), email1).
+					Return(&service.User{ID: id1, Email: email1, Password: string(hashedPwd)}, nil).Times(1)
 			},
 			want: expectation{
 				responseCode:    http.StatusOK,
-				responsePayload: `{"users":[{"id":"49afc235-3lry-23rf-343h-223jq6849e926","first_name":"User","last_name":"One","nickname":"userOne11","email":"user_three@gmail.com","country":"NL","created_at":"2022-07-20T12:45:44Z","updated_at":"0001-01-01T00:00:00Z"},{"id":"49afc235-3lry-23rf-343h-223jq6849e926","first_name":"User","last_name":"One","nickname":"userOne11","email":"user_three@gmail.com","country":"NL","created_at":"2022-07-20T12:45:44Z","updated_at":"0001-01-01T00:00:00Z"},{"id":"49afc235-3lry-23rf-343h-223jq6849e926","first_name":"User","last_name":"One","nickname":"userOne11","email":"user_three@gmail.com","country":"NL","created_at":"2022-07-20T12:45:44Z","updated_at":"0001-01-01T00:00:00Z"}]}`,
+				responsePayload: `{"user":{"id":"67cfa917-1cec-48ff-913c-243fe5749e92","first_name":"","last_name":"","nickname":"","email":"user_one@gmail.com","country":"","created_at":"0001-01-01T00:00:00Z","updated_at":"0001-01-01T00:00:00Z"}}`,
 			},
 		},
-		"ListUsers nextPage no filter Ok": {
-			in: input{
-				requestParams: map[string]string{"next_page": nextPageNoFilter},
-			},
+		"Login wrong password error": {
+			in: strings.NewReader(fmt.Sprintf(`{"login": "%s", "password": "wrong"}`, email1)),
 			repo: func(r *service.MockUserRepo) {
-				r.EXPECT().
-					ListUsers(gomock.Any(), 2, 2, nil).
-					Return([]service.User{
-						*user.WithID(id3).WithEmail(email3).WithCreateAt(createdAt),
-					}, nil).Times(1)
+				r.EXPECT().GetCredentials(gomock.Any(), email1).
+					Return(&service.User{ID: id1, Email: email1, Password: string(hashedPwd)}, nil).Times(1)
 			},
 			want: expectation{
-				responseCode:    http.StatusOK,
-				responsePayload: `{"users":[{"id":"49afc235-3lry-23rf-343h-223jq6849e926","first_name":"User","last_name":"One","nickname":"userOne11","email":"user_three@gmail.com","country":"NL","created_at":"2022-07-20T12:45:44Z","updated_at":"0001-01-01T00:00:00Z"}]}`,
+				responseCode: http.StatusUnauthorized,
+				errResponse:  `{"code":400,"message":"invalid login or password"}`,
 			},
 		},
-		"ListUsers pagination and filtering OK": {
-			in: input{
-				requestParams: map[string]string{
-					"pagination": "1",
-					"filterBy":   "country",
-					"filter":     "NL",
-				},
-			},
+		"Login unknown user error": {
+			in: strings.NewReader(fmt.Sprintf(`{"login": "unknown", "password": "%s"}`, pwd)),
 			repo: func(r *service.MockUserRepo) {
-				r.EXPECT().
-					ListUsers(gomock.Any(), 1, 0, &service.Filter{By: "country", Query: "NL"}).
-					Return([]service.User{
-						*user.WithID(id3).WithEmail(email3).WithCreateAt(createdAt),
-					}, nil).Times(1)
-			},
-			want: expectation{
-				paginated:       true,
-				responseCode:    http.StatusOK,
-				responsePayload: `{"users":[{"id":"49afc235-3lry-23rf-343h-223jq6849e926","first_name":"User","last_name":"One","nickname":"userOne11","email":"user_three@gmail.com","country":"NL","created_at":"2022-07-20T12:45:44Z","updated_at":"0001-01-01T00:00:00Z"}],"next_page":"eyJsaW1pdCI6MSwib2Zmc2V0IjoxLCJmaWx0ZXJfYnkiOiJjb3VudHJ5IiwiZmlsdGVyIjoiTkwiLCJ0aW1lIjoiMjAyNC0wOC0wN1QxMTozMDoyOS4yOTA4NDgrMDI6MDAifQ=="}`,
-			},
-		},
-		"ListUsers nextPage base64 decode Error": {
-			in: input{
-				requestParams: map[string]string{
-					"next_page": "@",
-				},
+				r.EXPECT().GetCredentials(gomock.Any(), "unknown").
+					Return(nil, repository.NoUsersFoundError).Times(1)
 			},
-			repo: func(r *service.MockUserRepo) {},
-			want: expectation{
-				responseCode: http.StatusBadRequest,
-				errResponse:  `{"code":101,"message":"could not load nextPage: could not decode next_page argument: illegal base64 data at input byte 0"}`,
-			},
-		},
-		"ListUsers nextPage json unmarshal Error": {
-			in: input{
-				requestParams: map[string]string{
-					"next_page": "QAo=",
-				},
-			},
-			repo: func(r *service.MockUserRepo) {},
 			want: expectation{
-				responseCode: http.StatusBadRequest,
-				errResponse:  `{"code":101,"message":"could not load nextPage: could not unmarshal limit offset: invalid character '@' looking for beginning of value"}`,
+				responseCode: http.StatusUnauthorized,
+				errResponse:  `{"code":400,"message":"invalid login or password"}`,
 			},
 		},
-		"ListUsers filterBy and filter Error": {
-			in: input{
-				requestParams: map[string]string{
-					"filterBy": "FirstName",
-				},
-			},
+		"Login no password error": {
+			in:   strings.NewReader(fmt.Sprintf(`{"login": "%s"}`, email1)),
 			repo: func(r *service.MockUserRepo) {},
 			want: expectation{
 				responseCode: http.StatusBadRequest,
-				errResponse:  `{"code":101,"message":"could not load nextPage: parameters filter and filterBy should be used together"}`,
+				errResponse:  `{"code":101,"message":"failed to parse request: login and password are mandatory"}`,
 			},
 		},
-		"ListUsers repo Error": {
-			in: input{},
+		"Login repo error": {
+			in: strings.NewReader(fmt.Sprintf(`{"login": "%s", "password": "%s"}`, email1, pwd)),
 			repo: func(r *service.MockUserRepo) {
-				r.EXPECT().ListUsers(gomock.Any(), -1, 0, nil).
-					Return(nil, somethingHappensError).Times(1)
+				r.EXPECT().GetCredentials(gomock.Any(), email1).Return(nil, somethingHappensError).Times(1)
 			},
 			want: expectation{
 				responseCode: http.StatusInternalServerError,
-				errResponse:  `{"code":100,"message":"internal handlers error"}`,
-			},
-		},
-		"ListUsers empty response": {
-			in: input{},
-			repo: func(r *service.MockUserRepo) {
-				r.EXPECT().ListUsers(gomock.Any(), -1, 0, nil).
-					Return([]service.User{}, nil).Times(1)
-			},
-			want: expectation{
-				responseCode:    http.StatusOK,
-				responsePayload: `{"users":[]}`,
+				errResponse:  `{"code":100,"message":"service internal error"}`,
 			},
 		},
 	}
-
 	for scenario, tt := range tests {
 		t.Run(scenario, func(t *testing.T) {
 			tt.repo(repo)
+			r := httptest.NewRequest(http.MethodPost, "/service/v1/auth/login", tt.in)
 			w := httptest.NewRecorder()
-			r := httptest.NewRequest(http.MethodGet, "/service/v1/users", tt.in.reqPayload)
-			r = mux.SetURLVars(r, tt.in.urlVars)
-
-			values := r.URL.Query()
-			for k, v := range tt.in.requestParams {
-				values.Add(k, v)
-			}
-			r.URL.RawQuery = values.Encode()
 
-			err := httpSvc.listUsers(r).WriteTo(w)
+			err := httpSvc.login(r).WriteTo(w)
 			assert.NoError(t, err)
 			res := w.Result()
 			defer func() { _ = res.Body.Close() }()
 			data, err := io.ReadAll(res.Body)
-
 			assert.NoError(t, err)
 			assert.Equal(t, tt.want.responseCode, res.StatusCode)
 			if tt.want.errResponse == "" {
-				if tt.want.paginated {
-					var got listUsers
-					err = json.Unmarshal(data, &got)
-					assert.NoError(t, err)
-
-					var pl listUsers
-					err = json.Unmarshal(data, &pl)
-					assert.NoError(t, err)
-
-					assert.True(t, got.NextPage == pl.NextPage)
-					assert.True(t, reflect.DeepEqual(got, pl))
-				} else {
-					assert.Equal(t, tt.want.responsePayload, string(data))
-				}
+				assert.Equal(t, tt.want.responsePayload, string(data))
 			} else {
 				assert.Equal(t, tt.want.errResponse, string(data))
 			}
 		})
 	}
 }
-func TestServer_UpdateUser(t *testing.T) {
-	t.Parallel()
 
+func newTestIssuer(t *testing.T) *tokens.Issuer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	file := filepath.Join(t.TempDir(), "signing.pem")
+	require.NoError(t, os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600))
+
+	issuer, err := tokens.New(tokens.Config{Algorithm: tokens.AlgorithmEdDSA, KeyID: "test", PrivateKeyFile: file})
+	require.NoError(t, err)
+	return issuer
+}
+
+func TestServer_Refresh(t *testing.T) {
+	t.Parallel()
 	ctrl := gomock.NewController(t)
 	logger := logrus.New()
-	//logger.Level = logrus.DebugLevel
 	notificationSvc := clients.NewMockChannelNotificator(ctrl)
 	repo := service.NewMockUserRepo(ctrl)
-	httpSvc := handler{log: logger, api: service.New(repo, logger, notificationSvc)}
+	tokenRepo := service.NewMockTokenRepo(ctrl)
+	issuer := newTestIssuer(t)
+	httpSvc := handler{log: logger, api: service.New(repo, logger, notificationSvc, service.WithTokens(issuer, tokenRepo))}
+	disabledSvc := handler{log: logger, api: service.New(repo, logger, notificationSvc)}
+
 	type expectation struct {
-		responseCode    int
-		responsePayload string
-		errResponse     string
-	}
-	type input struct {
-		reqUrl     string
-		reqPayload io.Reader
-		urlVars    map[string]string
+		responseCode int
+		errResponse  string
 	}
 
 	tests := map[string]struct {
-		in     input
-		want   expectation
-		repo   func(r *service.MockUserRepo)
-		notify func(n *clients.MockChannelNotificator)
+		in      io.Reader
+		handler handler
+		want    expectation
+		repo    func(r *service.MockUserRepo, tr *service.MockTokenRepo)
 	}{
-		"Update user OK": {
-			in: input{
-				reqUrl:     fmt.Sprintf("/service/v1/users/%s", id1),
-				urlVars:    map[string]string{"uid": id1},
-				reqPayload: strings.NewReader(fmt.Sprintf(`{"email": "%s"}`, email1)),
+		"Refresh Ok": {
+			in:      strings.NewReader(`{"refresh_token": "token"}`),
+			handler: httpSvc,
+			repo: func(r *service.MockUserRepo, tr *service.MockTokenRepo) {
+				tr.EXPECT().RotateRefreshToken(gomock.Any(), tokens.HashRefreshToken("token"), gomock.Any()).
+					Return(&service.RefreshToken{UserID: id1}, nil).Times(1)
+				r.EXPECT().GetUser(gomock.Any(), id1).Return(&service.User{ID: id1}, nil).Times(1)
 			},
-			repo: func(r *service.MockUserRepo) {
-				r.EXPECT().GetUser(gomock.Any(), id1).Return(
-					user.WithID(id1).
-						WithFirstName("User").
-						WithLastName("One").
-						WithNickName("userOne11").
-						WithCountry("NL").
-						WithEmail(email1), nil).Times(1)
-				r.EXPECT().UpdateUser(gomock.Any(), user.WithID(id1).WithEmail(email2)).Return(nil).Times(1)
+			want: expectation{
+				responseCode: http.StatusOK,
 			},
-			notify: func(n *clients.MockChannelNotificator) {
-				n.EXPECT().Notify(gomock.Any(), clients.ChannelUpdate, fmt.Sprintf("user with ID=%s has been updated", id1))
+		},
+		"Refresh token reused error": {
+			in:      strings.NewReader(`{"refresh_token": "token"}`),
+			handler: httpSvc,
+			repo: func(r *service.MockUserRepo, tr *service.MockTokenRepo) {
+				tr.EXPECT().RotateRefreshToken(gomock.Any(), tokens.HashRefreshToken("token"), gomock.Any()).
+					Return(nil, repository.TokenReusedError).Times(1)
 			},
 			want: expectation{
-				responseCode:    http.StatusOK,
-				responsePayload: `null`,
+				responseCode: http.StatusUnauthorized,
+				errResponse:  `{"code":401,"message":"invalid or expired refresh token"}`,
 			},
 		},
-		"Update user structure is empty error": {
-			in: input{
-				reqUrl:     fmt.Sprintf("/service/v1/users/%s", id1),
-				urlVars:    map[string]string{"uid": id1},
-				reqPayload: strings.NewReader(`{}`),
-			},
-			repo: func(r *service.MockUserRepo) {
-				r.EXPECT().GetUser(gomock.Any(), id1).Return(
-					user.WithID(id1).
-						WithFirstName("User").
-						WithLastName("One").
-						WithNickName("userOne11").
-						WithCountry("NL").
-						WithEmail(email1), nil).Times(1)
+		"Refresh user deleted error": {
+			in:      strings.NewReader(`{"refresh_token": "token"}`),
+			handler: httpSvc,
+			repo: func(r *service.MockUserRepo, tr *service.MockTokenRepo) {
+				tr.EXPECT().RotateRefreshToken(gomock.Any(), tokens.HashRefreshToken("token"), gomock.Any()).
+					Return(&service.RefreshToken{UserID: id1}, nil).Times(1)
+				r.EXPECT().GetUser(gomock.Any(), id1).Return(nil, repository.NoUsersFoundError).Times(1)
 			},
-			notify: func(n *clients.MockChannelNotificator) {},
 			want: expectation{
-				responseCode: http.StatusBadRequest,
-				errResponse:  `{"code":103,"message":"empty request"}`,
+				responseCode: http.StatusUnauthorized,
+				errResponse:  `{"code":401,"message":"invalid or expired refresh token"}`,
 			},
 		},
-		"Update user id empty error": {
-			in: input{
-				reqUrl:     fmt.Sprintf("/service/v1/users/%s", id1),
-				urlVars:    map[string]string{},
-				reqPayload: strings.NewReader(fmt.Sprintf(`{"email": "%s"}`, email2)),
-			},
-			repo:   func(r *service.MockUserRepo) {},
-			notify: func(n *clients.MockChannelNotificator) {},
+		"Refresh no token error": {
+			in:      strings.NewReader(`{}`),
+			handler: httpSvc,
+			repo:    func(r *service.MockUserRepo, tr *service.MockTokenRepo) {},
 			want: expectation{
 				responseCode: http.StatusBadRequest,
-				errResponse:  `{"code":101,"message":"failed to parse request: user id is mandatory"}`,
+				errResponse:  `{"code":101,"message":"failed to parse request: refresh_token is mandatory"}`,
 			},
 		},
-		"Update no users found error": {
-			in: input{
-				reqUrl:     fmt.Sprintf("/service/v1/users/%s", id1),
-				urlVars:    map[string]string{"uid": id1},
-				reqPayload: strings.NewReader(fmt.Sprintf(`{"email": "%s"}`, email2)),
-			},
-			repo: func(r *service.MockUserRepo) {
-				r.EXPECT().GetUser(gomock.Any(), id1).Return(nil, repository.NoUsersFoundError).Times(1)
-			},
-			notify: func(n *clients.MockChannelNotificator) {},
+		"Refresh tokens disabled error": {
+			in:      strings.NewReader(`{"refresh_token": "token"}`),
+			handler: disabledSvc,
+			repo:    func(r *service.MockUserRepo, tr *service.MockTokenRepo) {},
 			want: expectation{
-				responseCode: http.StatusNotFound,
-				errResponse:  `{"code":200,"message":"user not found"}`,
+				responseCode: http.StatusNotImplemented,
+				errResponse:  `{"code":104,"message":"tokens issuing is not configured"}`,
 			},
 		},
-		"Update duplicate key error": {
-			in: input{
-				reqUrl:     fmt.Sprintf("/service/v1/users/%s", id1),
-				urlVars:    map[string]string{"uid": id1},
-				reqPayload: strings.NewReader(fmt.Sprintf(`{"email": "%s"}`, email1)),
-			},
-			repo: func(r *service.MockUserRepo) {
-				r.EXPECT().GetUser(gomock.Any(), id1).Return(
-					user.WithID(id1).
-						WithFirstName("User").
-						WithLastName("One").
-						WithNickName("userOne11").
-						WithCountry("NL").
-						WithEmail(email1), nil).Times(1)
-				r.EXPECT().UpdateUser(gomock.Any(), user.WithID(id1).WithEmail(email2)).Return(repository.DuplicateKeyError).Times(1)
+	}
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			tt.repo(repo, tokenRepo)
+			r := httptest.NewRequest(http.MethodPost, "/service/v1/auth/refresh", tt.in)
+			w := httptest.NewRecorder()
+
+			err := tt.handler.refresh(r).WriteTo(w)
+			assert.NoError(t, err)
+			res := w.Result()
+			defer func() { _ = res.Body.Close() }()
+			data, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want.responseCode, res.StatusCode)
+			if tt.want.errResponse == "" {
+				var got Tokens
+				assert.NoError(t, json.Unmarshal(data, &got))
+				assert.Equal(t, "Bearer", got.TokenType)
+				assert.NotEmpty(t, got.RefreshToken)
+				claims, err := issuer.Verify(got.AccessToken)
+				assert.NoError(t, err)
+				assert.Equal(t, id1, claims.Subject)
+			} else {
+				assert.Equal(t, tt.want.errResponse, string(data))
+			}
+		})
+	}
+}
+
+func TestServer_Authenticate(t *testing.T) {
+	t.Parallel()
+	logger := logrus.New()
+	keyHash := sha256.Sum256([]byte("secret-key"))
+	keys, err := auth.APIKeys(map[string]string{"ops": hex.EncodeToString(keyHash[:])})
+	require.NoError(t, err)
+	issuer := newTestIssuer(t)
+	accessToken, _, err := issuer.Sign(id1, service.RoleSelf, time.Now())
+	require.NoError(t, err)
+	httpSvc := handler{log: logger, authn: auth.New(nil, keys, auth.AccessTokens(issuer))}
+
+	type expectation struct {
+		responseCode int
+		caller       *service.Caller
+		errResponse  string
+	}
+
+	tests := map[string]struct {
+		headers map[string]string
+		want    expectation
+	}{
+		"API key Ok": {
+			headers: map[string]string{auth.HeaderAPIKey: "secret-key"},
+			want: expectation{
+				responseCode: http.StatusOK,
+				caller:       &service.Caller{Subject: "ops", Method: auth.MethodAPIKey},
 			},
-			notify: func(n *clients.MockChannelNotificator) {},
+		},
+		"Access token Ok": {
+			headers: map[string]string{HeaderAuthorization: "Bearer " + accessToken},
 			want: expectation{
-				responseCode: http.StatusConflict,
-				errResponse:  `{"code":102,"message":"duplicate key error"}`,
+				responseCode: http.StatusOK,
+				caller:       &service.Caller{Subject: id1, Method: auth.MethodJWT, Role: service.RoleSelf},
 			},
 		},
-		"Update repo something happens error": {
-			in: input{
-				reqUrl:     fmt.Sprintf("/service/v1/users/%s", id1),
-				urlVars:    map[string]string{"uid": id1},
-				reqPayload: strings.NewReader(fmt.Sprintf(`{"email": "%s"}`, email1)),
+		"Wrong API key error": {
+			headers: map[string]string{auth.HeaderAPIKey: "wrong"},
+			want: expectation{
+				responseCode: http.StatusUnauthorized,
+				errResponse:  `{"code":402,"message":"missing or invalid credentials"}`,
 			},
-			repo: func(r *service.MockUserRepo) {
-				r.EXPECT().GetUser(gomock.Any(), id1).Return(user.WithID(id1).WithEmail(email1), nil).Times(1)
-				r.EXPECT().UpdateUser(gomock.Any(), user.WithID(id1).WithEmail(email2)).Return(somethingHappensError).Times(1)
+		},
+		"Malformed access token error": {
+			headers: map[string]string{HeaderAuthorization: "Bearer " + accessToken + "x"},
+			want: expectation{
+				responseCode: http.StatusUnauthorized,
+				errResponse:  `{"code":402,"message":"missing or invalid credentials"}`,
 			},
-			notify: func(n *clients.MockChannelNotificator) {},
+		},
+		"No credentials error": {
 			want: expectation{
-				responseCode: http.StatusInternalServerError,
-				errResponse:  `{"code":100,"message":"internal handlers error"}`,
+				responseCode: http.StatusUnauthorized,
+				errResponse:  `{"code":402,"message":"missing or invalid credentials"}`,
 			},
 		},
 	}
 	for scenario, tt := range tests {
 		t.Run(scenario, func(t *testing.T) {
-			tt.repo(repo)
-			tt.notify(notificationSvc)
-			r := addChiURLParams(httptest.NewRequest(http.MethodPut, tt.in.reqUrl, tt.in.reqPayload), tt.in.urlVars)
+			var caller *service.Caller
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				caller = service.CallerFrom(r.Context())
+				w.WriteHeader(http.StatusOK)
+			})
+			r := httptest.NewRequest(http.MethodGet, "/service/v1/users", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
 			w := httptest.NewRecorder()
 
-			err := httpSvc.updateUser(r).WriteTo(w)
-			assert.NoError(t, err)
+			httpSvc.authenticate(next).ServeHTTP(w, r)
 			res := w.Result()
 			defer func() { _ = res.Body.Close() }()
 			data, err := io.ReadAll(res.Body)
 			assert.NoError(t, err)
 			assert.Equal(t, tt.want.responseCode, res.StatusCode)
-			if tt.want.errResponse == "" {
-				assert.Equal(t, tt.want.responsePayload, string(data))
-			} else {
+			assert.Equal(t, tt.want.caller, caller)
+			if tt.want.errResponse != "" {
 				assert.Equal(t, tt.want.errResponse, string(data))
+				assert.Equal(t, "Bearer", res.Header.Get(HeaderWWWAuthenticate))
 			}
 		})
 	}
 }
-func TestServer_DeleteUser(t *testing.T) {
+
+func TestServer_GetUser(t *testing.T) {
 	t.Parallel()
 	ctrl := gomock.NewController(t)
 	logger := logrus.New()
-	//logger.Level = logrus.DebugLevel
 	notificationSvc := clients.NewMockChannelNotificator(ctrl)
 	repo := service.NewMockUserRepo(ctrl)
 	httpSvc := handler{log: logger, api: service.New(repo, logger, notificationSvc)}
@@ -534,82 +511,103 @@ func TestServer_DeleteUser(t *testing.T) {
 		errResponse     string
 	}
 	type input struct {
-		reqUrl     string
-		reqPayload io.Reader
-		urlVars    map[string]string
+		reqUrl  string
+		urlVars map[string]string
 	}
 
 	tests := map[string]struct {
-		in     input
-		want   expectation
-		repo   func(r *service.MockUserRepo)
-		notify func(n *clients.MockChannelNotificator)
+		in   input
+		want expectation
+		repo func(r *service.MockUserRepo)
 	}{
-		"DeleteUser Ok": {
+		"GetUser by id Ok": {
 			in: input{
 				reqUrl:  fmt.Sprintf("/service/v1/users/%s", id1),
 				urlVars: map[string]string{"uid": id1},
 			},
 			repo: func(r *service.MockUserRepo) {
-				r.EXPECT().DeleteUser(gomock.Any(), id1).Return(nil).Times(1)
+				r.EXPECT().GetUser(gomock.Any(), id1).
+					Return(&service.User{ID: id1, FirstName: "User", LastName: "One", NickName: "userOne11",
+						Email: email1, Country: "NL", Password: "hashed", CreatedAt: createdAt}, nil).Times(1)
 			},
-			notify: func(n *clients.MockChannelNotificator) {
-				n.EXPECT().Notify(gomock.Any(), clients.ChannelDelete, fmt.Sprintf("user with ID=%s has been deleted", id1))
+			want: expectation{
+				responseCode:    http.StatusOK,
+				responsePayload: `{"id":"67cfa917-1cec-48ff-913c-243fe5749e92","first_name":"User","last_name":"One","nickname":"userOne11","email":"user_one@gmail.com","country":"NL","created_at":"2022-07-20T12:45:44Z","updated_at":"0001-01-01T00:00:00Z"}`,
+			},
+		},
+		"GetUser by email Ok": {
+			in: input{
+				reqUrl:  "/service/v1/users/email/User_One@gmail.com",
+				urlVars: map[string]string{"email": "User_One@gmail.com"},
+			},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetUserByEmail(gomock.Any(), email1).
+					Return(&service.User{ID: id1, Email: email1}, nil).Times(1)
 			},
 			want: expectation{
 				responseCode:    http.StatusOK,
-				responsePayload: `null`,
+				responsePayload: `{"id":"67cfa917-1cec-48ff-913c-243fe5749e92","first_name":"","last_name":"","nickname":"","email":"user_one@gmail.com","country":"","created_at":"0001-01-01T00:00:00Z","updated_at":"0001-01-01T00:00:00Z"}`,
 			},
 		},
-		"Delete user no id error": {
+		"GetUser by nickname Ok": {
 			in: input{
-				reqUrl:  fmt.Sprintf("/service/v1/users/%s", id1),
+				reqUrl:  "/service/v1/users/nickname/userOne11",
+				urlVars: map[string]string{"nickname": "userOne11"},
+			},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetUserByNickname(gomock.Any(), "userOne11").
+					Return(&service.User{ID: id1, NickName: "userOne11"}, nil).Times(1)
+			},
+			want: expectation{
+				responseCode:    http.StatusOK,
+				responsePayload: `{"id":"67cfa917-1cec-48ff-913c-243fe5749e92","first_name":"","last_name":"","nickname":"userOne11","email":"","country":"","created_at":"0001-01-01T00:00:00Z","updated_at":"0001-01-01T00:00:00Z"}`,
+			},
+		},
+		"GetUser no id error": {
+			in: input{
+				reqUrl:  "/service/v1/users/",
 				urlVars: map[string]string{},
 			},
-			repo:   func(r *service.MockUserRepo) {},
-			notify: func(n *clients.MockChannelNotificator) {},
+			repo: func(r *service.MockUserRepo) {},
 			want: expectation{
 				responseCode: http.StatusBadRequest,
-				errResponse:  `{"code":101,"message":"failed to parse request: id is mandatory"}`,
+				errResponse:  `{"code":101,"message":"failed to parse request: user id, email or nickname is mandatory"}`,
 			},
 		},
-		"Delete user not found error": {
+		"GetUser not found error": {
 			in: input{
 				reqUrl:  fmt.Sprintf("/service/v1/users/%s", id1),
 				urlVars: map[string]string{"uid": id1},
 			},
 			repo: func(r *service.MockUserRepo) {
-				r.EXPECT().DeleteUser(gomock.Any(), id1).Return(repository.NoUsersFoundError).Times(1)
+				r.EXPECT().GetUser(gomock.Any(), id1).Return(nil, repository.NoUsersFoundError).Times(1)
 			},
-			notify: func(n *clients.MockChannelNotificator) {},
 			want: expectation{
 				responseCode: http.StatusNotFound,
 				errResponse:  `{"code":200,"message":"user not found"}`,
 			},
 		},
-		"Delete user repo error": {
+		"GetUser repo error": {
 			in: input{
 				reqUrl:  fmt.Sprintf("/service/v1/users/%s", id1),
 				urlVars: map[string]string{"uid": id1},
 			},
 			repo: func(r *service.MockUserRepo) {
-				r.EXPECT().DeleteUser(gomock.Any(), id1).Return(somethingHappensError).Times(1)
+				r.EXPECT().GetUser(gomock.Any(), id1).Return(nil, somethingHappensError).Times(1)
 			},
-			notify: func(n *clients.MockChannelNotificator) {},
 			want: expectation{
 				responseCode: http.StatusInternalServerError,
-				errResponse:  `{"code":100,"message":"internal handlers error"}`,
+				errResponse:  `{"code":100,"message":"service internal error"}`,
 			},
 		},
 	}
 	for scenario, tt := range tests {
 		t.Run(scenario, func(t *testing.T) {
 			tt.repo(repo)
-			tt.notify(notificationSvc)
-			r := addChiURLParams(httptest.NewRequest(http.MethodDelete, tt.in.reqUrl, tt.in.reqPayload), tt.in.urlVars)
+			r := addChiURLParams(httptest.NewRequest(http.MethodGet, tt.in.reqUrl, nil), tt.in.urlVars)
 			w := httptest.NewRecorder()
 
-			err := httpSvc.deleteUser(r).WriteTo(w)
+			err := httpSvc.getUser(r).WriteTo(w)
 			assert.NoError(t, err)
 			res := w.Result()
 			defer func() { _ = res.Body.Close() }()
@@ -624,3 +622,1862 @@ func TestServer_DeleteUser(t *testing.T) {
 		})
 	}
 }
+
+func TestServer_ListUsers(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	logger := logrus.New()
+	//logger.Level = logrus.DebugLevel
+	notificationSvc := clients.NewMockChannelNotificator(ctrl)
+	repo := service.NewMockUserRepo(ctrl)
+	httpSvc := handler{log: logger, api: service.New(repo, logger, notificationSvc, service.WithFilters(filters)), pages: pages}
+
+	type expectation struct {
+		responseCode    int
+		responsePayload string
+		// nextPageAfter id of the user next page starts after, next_page is sealed and compared once opened
+		nextPageAfter string
+		errResponse   string
+	}
+	type input struct {
+		reqPayload    io.Reader
+		requestParams map[string]string
+		urlVars       map[string]string
+	}
+
+	tests := map[string]struct {
+		in   input
+		want expectation
+		repo func(r *service.MockUserRepo)
+	}{
+		"ListUsers no pagination Ok": {
+			in: input{},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().
+					ListUsers(gomock.Any(), service.ListQuery{Limit: -1}).
+					Return([]service.User{
+						*user.WithID(id1).WithCreateAt(createdAt),
+						*user.WithID(id2).WithEmail(email2).WithCreateAt(createdAt),
+						*user.WithID(id3).WithEmail(email3).WithCreateAt(createdAt),
+					}, nil).Times(1)
+			},
+			want: expectation{
+				responseCode:    http.StatusOK,
+				responsePayload: `{"users":[{"id":"49afc235-3lry-23rf-343h-223jq6849e926","first_name":"User","last_name":"One","nickname":"userOne11","email":"user_three@gmail.com","country":"NL","created_at":"2022-07-20T12:45:44Z","updated_at":"0001-01-01T00:00:00Z"},{"id":"49afc235-3lry-23rf-343h-223jq6849e926","first_name":"User","last_name":"One","nickname":"userOne11","email":"user_three@gmail.com","country":"NL","created_at":"2022-07-20T12:45:44Z","updated_at":"0001-01-01T00:00:00Z"},{"id":"49afc235-3lry-23rf-343h-223jq6849e926","first_name":"User","last_name":"One","nickname":"userOne11","email":"user_three@gmail.com","country":"NL","created_at":"2022-07-20T12:45:44Z","updated_at":"0001-01-01T00:00:00Z"}]}`,
+			},
+		},
+		"ListUsers nextPage no filter Ok": {
+			in: input{
+				requestParams: map[string]string{"next_page": nextPageNoFilter},
+			},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().
+					ListUsers(gomock.Any(), service.ListQuery{Limit: 3, After: afterID2}).
+					Return([]service.User{
+						*user.WithID(id3).WithEmail(email3).WithCreateAt(createdAt),
+					}, nil).Times(1)
+			},
+			want: expectation{
+				responseCode:    http.StatusOK,
+				responsePayload: `{"users":[{"id":"49afc235-3lry-23rf-343h-223jq6849e926","first_name":"User","last_name":"One","nickname":"userOne11","email":"user_three@gmail.com","country":"NL","created_at":"2022-07-20T12:45:44Z","updated_at":"0001-01-01T00:00:00Z"}]}`,
+			},
+		},
+		"ListUsers pagination and filtering OK": {
+			in: input{
+				requestParams: map[string]string{
+					"pagination": "1",
+					"filterBy":   "country",
+					"filter":     "NL",
+				},
+			},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().
+					ListUsers(gomock.Any(), service.ListQuery{Limit: 2, Filter: countryNL}).
+					Return([]service.User{
+						*user.WithID(id3).WithEmail(email3).WithCreateAt(createdAt),
+						*(&service.User{}).WithID(id1).WithEmail(email1).WithCreateAt(createdAt),
+					}, nil).Times(1)
+			},
+			want: expectation{
+				responseCode:    http.StatusOK,
+				responsePayload: `{"users":[{"id":"49afc235-3lry-23rf-343h-223jq6849e926","first_name":"User","last_name":"One","nickname":"userOne11","email":"user_three@gmail.com","country":"NL","created_at":"2022-07-20T12:45:44Z","updated_at":"0001-01-01T00:00:00Z"}]}`,
+				nextPageAfter:   id3,
+			},
+		},
+		"ListUsers filter expression OK": {
+			in: input{
+				requestParams: map[string]string{
+					"filter": `COUNTRY IN (NL,DE) AND (created_at >= 2022-01-01 OR nickname prefix user)`,
+				},
+			},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().
+					ListUsers(gomock.Any(), service.ListQuery{Limit: -1, Filter: expression}).
+					Return([]service.User{
+						*user.WithID(id3).WithEmail(email3).WithCreateAt(createdAt),
+					}, nil).Times(1)
+			},
+			want: expectation{
+				responseCode:    http.StatusOK,
+				responsePayload: `{"users":[{"id":"49afc235-3lry-23rf-343h-223jq6849e926","first_name":"User","last_name":"One","nickname":"userOne11","email":"user_three@gmail.com","country":"NL","created_at":"2022-07-20T12:45:44Z","updated_at":"0001-01-01T00:00:00Z"}]}`,
+			},
+		},
+		"ListUsers invalid filter expression Error": {
+			in: input{
+				requestParams: map[string]string{
+					"filter": "country = NL and",
+				},
+			},
+			repo: func(r *service.MockUserRepo) {},
+			want: expectation{
+				responseCode: http.StatusBadRequest,
+				errResponse:  `{"code":101,"message":"unexpected end of filter, field name expected"}`,
+			},
+		},
+		"ListUsers filter not enabled Error": {
+			in: input{
+				requestParams: map[string]string{
+					"filterBy": "email",
+					"filter":   "user_one@gmail.com",
+				},
+			},
+			repo: func(r *service.MockUserRepo) {},
+			want: expectation{
+				responseCode: http.StatusBadRequest,
+				errResponse:  `{"code":101,"message":"filterBy parameter 'email' not supported"}`,
+			},
+		},
+		"ListUsers sorted OK": {
+			in: input{
+				requestParams: map[string]string{
+					"pagination": "1",
+					"sort":       "-updated_at,last_name",
+				},
+			},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().
+					ListUsers(gomock.Any(), service.ListQuery{Limit: 2, Sort: sortByUpdate}).
+					Return([]service.User{
+						*user.WithID(id3).WithEmail(email3).WithCreateAt(createdAt),
+						*(&service.User{}).WithID(id1).WithEmail(email1).WithCreateAt(createdAt),
+					}, nil).Times(1)
+			},
+			want: expectation{
+				responseCode:    http.StatusOK,
+				responsePayload: `{"users":[{"id":"49afc235-3lry-23rf-343h-223jq6849e926","first_name":"User","last_name":"One","nickname":"userOne11","email":"user_three@gmail.com","country":"NL","created_at":"2022-07-20T12:45:44Z","updated_at":"0001-01-01T00:00:00Z"}]}`,
+				nextPageAfter:   id3,
+			},
+		},
+		"ListUsers invalid sort Error": {
+			in: input{
+				requestParams: map[string]string{
+					"sort": "password",
+				},
+			},
+			repo: func(r *service.MockUserRepo) {},
+			want: expectation{
+				responseCode: http.StatusBadRequest,
+				errResponse:  `{"code":101,"message":"sort field 'password' not supported"}`,
+			},
+		},
+		"ListUsers include total OK": {
+			in: input{
+				requestParams: map[string]string{
+					"filter":        "country in (NL, DE)",
+					"include_total": "true",
+				},
+			},
+			repo: func(r *service.MockUserRepo) {
+				filter, _ := filters.ParseFilter("country in (NL, DE)")
+				r.EXPECT().
+					ListUsers(gomock.Any(), service.ListQuery{Limit: -1, Filter: filter, IncludeTotal: true}).
+					Return([]service.User{}, nil).Times(1)
+				r.EXPECT().CountUsers(gomock.Any(), filter).
+					Return(&service.Totals{Count: 0, Countries: map[string]int{}}, nil).Times(1)
+			},
+			want: expectation{
+				responseCode:    http.StatusOK,
+				responsePayload: `{"users":[],"total":0,"facets":{"country":{}}}`,
+			},
+		},
+		"ListUsers malformed include total Error": {
+			in: input{
+				requestParams: map[string]string{
+					"include_total": "maybe",
+				},
+			},
+			repo: func(r *service.MockUserRepo) {},
+			want: expectation{
+				responseCode: http.StatusBadRequest,
+				errResponse:  `{"code":101,"message":"could not load nextPage: malformed include_total"}`,
+			},
+		},
+		"ListUsers nextPage malformed Error": {
+			in: input{
+				requestParams: map[string]string{
+					"next_page": "@",
+				},
+			},
+			repo: func(r *service.MockUserRepo) {},
+			want: expectation{
+				responseCode: http.StatusBadRequest,
+				errResponse:  `{"code":101,"message":"could not load nextPage: next_page token is invalid"}`,
+			},
+		},
+		"ListUsers nextPage tampered Error": {
+			in: input{
+				requestParams: map[string]string{
+					"next_page": tamper(nextPageNoFilter),
+				},
+			},
+			repo: func(r *service.MockUserRepo) {},
+			want: expectation{
+				responseCode: http.StatusBadRequest,
+				errResponse:  `{"code":101,"message":"could not load nextPage: next_page token is invalid"}`,
+			},
+		},
+		"ListUsers nextPage forged Error": {
+			in: input{
+				requestParams: map[string]string{
+					// plain base64 JSON with a huge limit
+					"next_page": "eyJsaW1pdCI6MTAwMDAwMCwiZmlsdGVyX2J5IjoiIiwiZmlsdGVyIjoiIiwiaWQiOiIxIn0=",
+				},
+			},
+			repo: func(r *service.MockUserRepo) {},
+			want: expectation{
+				responseCode: http.StatusBadRequest,
+				errResponse:  `{"code":101,"message":"could not load nextPage: next_page token is invalid"}`,
+			},
+		},
+		"ListUsers filterBy and filter Error": {
+			in: input{
+				requestParams: map[string]string{
+					"filterBy": "FirstName",
+				},
+			},
+			repo: func(r *service.MockUserRepo) {},
+			want: expectation{
+				responseCode: http.StatusBadRequest,
+				errResponse:  `{"code":101,"message":"could not load nextPage: parameter filterBy should be used along with filter"}`,
+			},
+		},
+		"ListUsers repo Error": {
+			in: input{},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().ListUsers(gomock.Any(), service.ListQuery{Limit: -1}).
+					Return(nil, somethingHappensError).Times(1)
+			},
+			want: expectation{
+				responseCode: http.StatusInternalServerError,
+				errResponse:  `{"code":100,"message":"internal handlers error"}`,
+			},
+		},
+		"ListUsers empty response": {
+			in: input{},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().ListUsers(gomock.Any(), service.ListQuery{Limit: -1}).
+					Return([]service.User{}, nil).Times(1)
+			},
+			want: expectation{
+				responseCode:    http.StatusOK,
+				responsePayload: `{"users":[]}`,
+			},
+		},
+	}
+
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			tt.repo(repo)
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/service/v1/users", tt.in.reqPayload)
+			r = mux.SetURLVars(r, tt.in.urlVars)
+
+			values := r.URL.Query()
+			for k, v := range tt.in.requestParams {
+				values.Add(k, v)
+			}
+			r.URL.RawQuery = values.Encode()
+
+			err := httpSvc.listUsers(r).WriteTo(w)
+			assert.NoError(t, err)
+			res := w.Result()
+			defer func() { _ = res.Body.Close() }()
+			data, err := io.ReadAll(res.Body)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want.responseCode, res.StatusCode)
+			if tt.want.errResponse == "" {
+				var got listUsers
+				require.NoError(t, json.Unmarshal(data, &got))
+				if tt.want.nextPageAfter != "" {
+					np, err := pages.Open(got.NextPage)
+					require.NoError(t, err)
+					assert.Equal(t, tt.want.nextPageAfter, np.ID)
+					data = bytes.Replace(data, []byte(`,"next_page":"`+got.NextPage+`"`), nil, 1)
+				} else {
+					assert.Empty(t, got.NextPage)
+				}
+				assert.Equal(t, tt.want.responsePayload, string(data))
+			} else {
+				assert.Equal(t, tt.want.errResponse, string(data))
+			}
+		})
+	}
+}
+func TestServer_UpdateUser(t *testing.T) {
+	t.Parallel()
+
+	ctrl := gomock.NewController(t)
+	logger := logrus.New()
+	//logger.Level = logrus.DebugLevel
+	notificationSvc := clients.NewMockChannelNotificator(ctrl)
+	repo := service.NewMockUserRepo(ctrl)
+	httpSvc := handler{log: logger, api: service.New(repo, logger, notificationSvc)}
+	type expectation struct {
+		responseCode    int
+		responsePayload string
+		errResponse     string
+		etag            string
+	}
+	type input struct {
+		reqUrl     string
+		reqPayload io.Reader
+		urlVars    map[string]string
+		ifMatch    string
+	}
+	stored := func() *service.User {
+		return (&service.User{FirstName: "User", LastName: "One", NickName: "userOne11", Email: email1, Country: "NL",
+			Version: 3}).WithID(id1)
+	}
+
+	tests := map[string]struct {
+		in     input
+		want   expectation
+		repo   func(r *service.MockUserRepo)
+		notify func(n *clients.MockChannelNotificator)
+	}{
+		"Update user OK": {
+			in: input{
+				reqUrl:     fmt.Sprintf("/service/v1/users/%s", id1),
+				urlVars:    map[string]string{"uid": id1},
+				reqPayload: strings.NewReader(fmt.Sprintf(`{"email": "%s"}`, email1)),
+			},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetUser(gomock.Any(), id1).Return(
+					user.WithID(id1).
+						WithFirstName("User").
+						WithLastName("One").
+						WithNickName("userOne11").
+						WithCountry("NL").
+						WithEmail(email1), nil).Times(1)
+				r.EXPECT().UpdateUser(gomock.Any(), user.WithID(id1).WithEmail(email2)).Return(nil).Times(1)
+			},
+			notify: func(n *clients.MockChannelNotificator) {
+				n.EXPECT().Notify(gomock.Any(), clients.ChannelUpdate, userEvent(events.TypeUserUpdated, id1))
+			},
+			want: expectation{
+				responseCode:    http.StatusOK,
+				responsePayload: `null`,
+			},
+		},
+		"Update user If-Match OK": {
+			in: input{
+				reqUrl:     fmt.Sprintf("/service/v1/users/%s", id1),
+				urlVars:    map[string]string{"uid": id1},
+				reqPayload: strings.NewReader(`{"country": "DE"}`),
+				ifMatch:    `"3"`,
+			},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetUser(gomock.Any(), id1).Return(stored(), nil).Times(1)
+				r.EXPECT().UpdateUser(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, u *service.User) error {
+					assert.Equal(t, int64(3), u.Version, "the version read is updated")
+					u.Version++
+					return nil
+				}).Times(1)
+			},
+			notify: func(n *clients.MockChannelNotificator) {
+				n.EXPECT().Notify(gomock.Any(), clients.ChannelUpdate, userEvent(events.TypeUserUpdated, id1))
+			},
+			want: expectation{
+				responseCode:    http.StatusOK,
+				responsePayload: `null`,
+				etag:            `"4"`,
+			},
+		},
+		"Update user outdated If-Match error": {
+			in: input{
+				reqUrl:     fmt.Sprintf("/service/v1/users/%s", id1),
+				urlVars:    map[string]string{"uid": id1},
+				reqPayload: strings.NewReader(`{"country": "DE"}`),
+				ifMatch:    `"2"`,
+			},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetUser(gomock.Any(), id1).Return(stored(), nil).Times(1)
+			},
+			notify: func(n *clients.MockChannelNotificator) {},
+			want: expectation{
+				responseCode: http.StatusPreconditionFailed,
+				errResponse:  `{"code":108,"message":"user is modified since the expected version"}`,
+			},
+		},
+		"Update user concurrently updated error": {
+			in: input{
+				reqUrl:     fmt.Sprintf("/service/v1/users/%s", id1),
+				urlVars:    map[string]string{"uid": id1},
+				reqPayload: strings.NewReader(`{"country": "DE"}`),
+			},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetUser(gomock.Any(), id1).Return(stored(), nil).Times(1)
+				r.EXPECT().UpdateUser(gomock.Any(), gomock.Any()).Return(repository.VersionMismatchError).Times(1)
+			},
+			notify: func(n *clients.MockChannelNotificator) {},
+			want: expectation{
+				responseCode: http.StatusPreconditionFailed,
+				errResponse:  `{"code":108,"message":"user is modified since the expected version"}`,
+			},
+		},
+		"Update user weak If-Match error": {
+			in: input{
+				reqUrl:     fmt.Sprintf("/service/v1/users/%s", id1),
+				urlVars:    map[string]string{"uid": id1},
+				reqPayload: strings.NewReader(`{"country": "DE"}`),
+				ifMatch:    `W/"3"`,
+			},
+			repo:   func(r *service.MockUserRepo) {},
+			notify: func(n *clients.MockChannelNotificator) {},
+			want: expectation{
+				responseCode: http.StatusBadRequest,
+				errResponse:  `{"code":101,"message":"failed to parse request: malformed If-Match, single strong entity tag expected"}`,
+			},
+		},
+		"Update user structure is empty error": {
+			in: input{
+				reqUrl:     fmt.Sprintf("/service/v1/users/%s", id1),
+				urlVars:    map[string]string{"uid": id1},
+				reqPayload: strings.NewReader(`{}`),
+			},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetUser(gomock.Any(), id1).Return(
+					user.WithID(id1).
+						WithFirstName("User").
+						WithLastName("One").
+						WithNickName("userOne11").
+						WithCountry("NL").
+						WithEmail(email1), nil).Times(1)
+			},
+			notify: func(n *clients.MockChannelNotificator) {},
+			want: expectation{
+				responseCode: http.StatusBadRequest,
+				errResponse:  `{"code":103,"message":"empty request"}`,
+			},
+		},
+		"Update user id empty error": {
+			in: input{
+				reqUrl:     fmt.Sprintf("/service/v1/users/%s", id1),
+				urlVars:    map[string]string{},
+				reqPayload: strings.NewReader(fmt.Sprintf(`{"email": "%s"}`, email2)),
+			},
+			repo:   func(r *service.MockUserRepo) {},
+			notify: func(n *clients.MockChannelNotificator) {},
+			want: expectation{
+				responseCode: http.StatusBadRequest,
+				errResponse:  `{"code":101,"message":"failed to parse request: user id is mandatory"}`,
+			},
+		},
+		"Update no users found error": {
+			in: input{
+				reqUrl:     fmt.Sprintf("/service/v1/users/%s", id1),
+				urlVars:    map[string]string{"uid": id1},
+				reqPayload: strings.NewReader(fmt.Sprintf(`{"email": "%s"}`, email2)),
+			},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetUser(gomock.Any(), id1).Return(nil, repository.NoUsersFoundError).Times(1)
+			},
+			notify: func(n *clients.MockChannelNotificator) {},
+			want: expectation{
+				responseCode: http.StatusNotFound,
+				errResponse:  `{"code":200,"message":"user not found"}`,
+			},
+		},
+		"Update duplicate key error": {
+			in: input{
+				reqUrl:     fmt.Sprintf("/service/v1/users/%s", id1),
+				urlVars:    map[string]string{"uid": id1},
+				reqPayload: strings.NewReader(fmt.Sprintf(`{"email": "%s"}`, email1)),
+			},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetUser(gomock.Any(), id1).Return(
+					user.WithID(id1).
+						WithFirstName("User").
+						WithLastName("One").
+						WithNickName("userOne11").
+						WithCountry("NL").
+						WithEmail(email1), nil).Times(1)
+				r.EXPECT().UpdateUser(gomock.Any(), user.WithID(id1).WithEmail(email2)).Return(repository.DuplicateKeyError).Times(1)
+			},
+			notify: func(n *clients.MockChannelNotificator) {},
+			want: expectation{
+				responseCode: http.StatusConflict,
+				errResponse:  `{"code":102,"message":"duplicate key error"}`,
+			},
+		},
+		"Update repo something happens error": {
+			in: input{
+				reqUrl:     fmt.Sprintf("/service/v1/users/%s", id1),
+				urlVars:    map[string]string{"uid": id1},
+				reqPayload: strings.NewReader(fmt.Sprintf(`{"email": "%s"}`, email1)),
+			},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetUser(gomock.Any(), id1).Return(user.WithID(id1).WithEmail(email1), nil).Times(1)
+				r.EXPECT().UpdateUser(gomock.Any(), user.WithID(id1).WithEmail(email2)).Return(somethingHappensError).Times(1)
+			},
+			notify: func(n *clients.MockChannelNotificator) {},
+			want: expectation{
+				responseCode: http.StatusInternalServerError,
+				errResponse:  `{"code":100,"message":"internal handlers error"}`,
+			},
+		},
+	}
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			tt.repo(repo)
+			tt.notify(notificationSvc)
+			r := addChiURLParams(httptest.NewRequest(http.MethodPut, tt.in.reqUrl, tt.in.reqPayload), tt.in.urlVars)
+			if tt.in.ifMatch != "" {
+				r.Header.Set(HeaderIfMatch, tt.in.ifMatch)
+			}
+			w := httptest.NewRecorder()
+
+			err := httpSvc.updateUser(r).WriteTo(w)
+			assert.NoError(t, err)
+			res := w.Result()
+			defer func() { _ = res.Body.Close() }()
+			data, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want.responseCode, res.StatusCode)
+			if tt.want.etag != "" {
+				assert.Equal(t, tt.want.etag, res.Header.Get(HeaderETag))
+			}
+			if tt.want.errResponse == "" {
+				assert.Equal(t, tt.want.responsePayload, string(data))
+			} else {
+				assert.Equal(t, tt.want.errResponse, string(data))
+			}
+		})
+	}
+}
+func TestServer_WatchUsers(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	logger := logrus.New()
+	notificationSvc := clients.NewMockChannelNotificator(ctrl)
+	notificationSvc.EXPECT().Notify(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	repo := service.NewMockUserRepo(ctrl)
+	hub := service.NewWatchHub(service.WatchConfig{}, notificationSvc)
+	httpSvc := handler{log: logger, api: service.New(repo, logger, notificationSvc, service.WithWatch(hub))}
+
+	past := events.NewUserCreated(nil, events.User{ID: id1, Country: "NL"})
+	otherCountry := events.NewUserCreated(nil, events.User{ID: id2, Country: "DE"})
+	deleted := events.NewUserDeleted(nil, id1)
+	deleted.Country = "NL"
+	for _, e := range []*events.Event{past, otherCountry, deleted} {
+		require.NoError(t, hub.Notify(context.Background(), clients.ChannelCreate, e))
+	}
+	deletedData, _ := json.Marshal(deleted)
+
+	type expectation struct {
+		responseCode int
+		contentType  string
+		events       []string
+		errResponse  string
+	}
+
+	tests := map[string]struct {
+		url         string
+		lastEventID string
+		want        expectation
+	}{
+		"WatchUsers resume from Last-Event-ID Ok": {
+			url:         "/service/v1/users/watch?country=NL",
+			lastEventID: past.ID,
+			want: expectation{
+				responseCode: http.StatusOK,
+				contentType:  "text/event-stream",
+				events:       []string{fmt.Sprintf("id: %s\nevent: user.deleted\ndata: %s\n\n", deleted.ID, deletedData)},
+			},
+		},
+		"WatchUsers resume from last_event_id Ok": {
+			url: "/service/v1/users/watch?country=DE,NL&last_event_id=" + otherCountry.ID,
+			want: expectation{
+				responseCode: http.StatusOK,
+				contentType:  "text/event-stream",
+				events:       []string{fmt.Sprintf("id: %s\nevent: user.deleted\ndata: %s\n\n", deleted.ID, deletedData)},
+			},
+		},
+		"WatchUsers expired event Error": {
+			url:         "/service/v1/users/watch",
+			lastEventID: "unknown",
+			want: expectation{
+				responseCode: http.StatusGone,
+				contentType:  "application/json; charset=utf-8",
+				errResponse:  `{"code":105,"message":"events after the requested one are no longer available"}`,
+			},
+		},
+		"WatchUsers empty country Error": {
+			url: "/service/v1/users/watch?country=NL,",
+			want: expectation{
+				responseCode: http.StatusBadRequest,
+				contentType:  "application/json; charset=utf-8",
+				errResponse:  `{"code":101,"message":"empty country"}`,
+			},
+		},
+	}
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			// backlog is written right away, the watch ends along with the request
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, tt.url, nil).WithContext(ctx)
+			if tt.lastEventID != "" {
+				r.Header.Set(HeaderLastEventID, tt.lastEventID)
+			}
+
+			httpSvc.watchUsers(w, r)
+			res := w.Result()
+			defer func() { _ = res.Body.Close() }()
+			data, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want.responseCode, res.StatusCode)
+			assert.Equal(t, tt.want.contentType, res.Header.Get(HeaderContentType))
+			if tt.want.errResponse != "" {
+				assert.Equal(t, tt.want.errResponse, string(data))
+				return
+			}
+			// heartbeat is sent once watching has started
+			assert.True(t, strings.HasPrefix(string(data), "event: heartbeat\ndata: {\"timestamp\":"), string(data))
+			var got []string
+			for _, message := range strings.Split(string(data), "\n\n") {
+				if strings.HasPrefix(message, "id: ") {
+					got = append(got, message+"\n\n")
+				}
+			}
+			assert.Equal(t, tt.want.events, got)
+		})
+	}
+}
+
+func TestServer_BatchUsers(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	logger := logrus.New()
+	notificationSvc := clients.NewMockChannelNotificator(ctrl)
+	repo := service.NewMockUserRepo(ctrl)
+	mux := router(&handler{log: logger, api: service.New(repo, logger, notificationSvc)}, logger, nil)
+
+	valid := `{"first_name":"User","last_name":"One","nickname":"userOne11","email":"User_One@gmail.com","password":"qwerty","country":"NL"}`
+	invalid := `{"first_name":"User","email":"user_two@gmail.com","password":"qwerty","country":"NL"}`
+	taken := `{"first_name":"User","last_name":"Three","nickname":"userOne11","email":"user_three@gmail.com","password":"qwerty","country":"DE"}`
+	stored := func(id string) *service.User {
+		return (&service.User{FirstName: "User", LastName: "One", Email: email1, Country: "NL"}).WithID(id)
+	}
+
+	type expectation struct {
+		responseCode int
+		results      []batchResult
+		errResponse  string
+	}
+
+	tests := map[string]struct {
+		path    string
+		payload string
+		repo    func(r *service.MockUserRepo)
+		notify  func(n *clients.MockChannelNotificator)
+		want    expectation
+	}{
+		"BatchCreate best effort Ok": {
+			path:    "/service/v1/users:batchCreate",
+			payload: fmt.Sprintf(`{"users":[%s,%s,%s]}`, valid, invalid, taken),
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().CreateUsers(gomock.Any(), gomock.Len(2), false).
+					DoAndReturn(func(_ context.Context, users []*service.User, _ bool) ([]bool, error) {
+						assert.Equal(t, email1, users[0].Email)
+						assert.Equal(t, service.RoleSelf, users[0].Role)
+						users[0].ID = id1
+						return []bool{true, false}, nil
+					}).Times(1)
+			},
+			notify: func(n *clients.MockChannelNotificator) {
+				n.EXPECT().Notify(gomock.Any(), clients.ChannelCreate, userEvent(events.TypeUserCreated, id1)).Times(1)
+			},
+			want: expectation{
+				responseCode: http.StatusOK,
+				results: []batchResult{
+					{ID: id1, User: &User{ID: id1, FirstName: "User", LastName: "One", NickName: "userOne11", Email: email1, Country: "NL", Role: service.RoleSelf}},
+					{Error: &service.Error{Code: service.ErrCodeBadRequest, Message: "empty last name"}},
+					{Error: service.ErrUserAlreadyExists},
+				},
+			},
+		},
+		"BatchCreate atomic aborted": {
+			path:    "/service/v1/users:batchCreate",
+			payload: fmt.Sprintf(`{"users":[%s,%s],"atomic":true}`, valid, taken),
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().CreateUsers(gomock.Any(), gomock.Len(2), true).
+					Return([]bool{true, false}, repository.DuplicateKeyError).Times(1)
+			},
+			notify: func(n *clients.MockChannelNotificator) {},
+			want: expectation{
+				responseCode: http.StatusOK,
+				results:      []batchResult{{Error: service.ErrBatchAborted}, {Error: service.ErrUserAlreadyExists}},
+			},
+		},
+		"BatchCreate atomic invalid user aborted": {
+			path:    "/service/v1/users:batchCreate",
+			payload: fmt.Sprintf(`{"users":[%s,%s],"atomic":true}`, valid, invalid),
+			repo:    func(r *service.MockUserRepo) {},
+			notify:  func(n *clients.MockChannelNotificator) {},
+			want: expectation{
+				responseCode: http.StatusOK,
+				results: []batchResult{
+					{Error: service.ErrBatchAborted},
+					{Error: &service.Error{Code: service.ErrCodeBadRequest, Message: "empty last name"}},
+				},
+			},
+		},
+		"BatchCreate empty Error": {
+			path:    "/service/v1/users:batchCreate",
+			payload: `{"users":[]}`,
+			repo:    func(r *service.MockUserRepo) {},
+			notify:  func(n *clients.MockChannelNotificator) {},
+			want: expectation{
+				responseCode: http.StatusBadRequest,
+				errResponse:  `{"code":101,"message":"empty batch"}`,
+			},
+		},
+		"BatchUpdate best effort Ok": {
+			path:    "/service/v1/users:batchUpdate",
+			payload: fmt.Sprintf(`{"users":[{"id":"%s","country":"DE"},{"id":"%s","country":"DE"},{"id":"%s"}]}`, id1, id2, id1),
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetUser(gomock.Any(), id1).Return(stored(id1), nil).Times(1)
+				r.EXPECT().UpdateUser(gomock.Any(), gomock.Any()).Return(nil).Times(1)
+				r.EXPECT().GetUser(gomock.Any(), id2).Return(nil, repository.NoUsersFoundError).Times(1)
+			},
+			notify: func(n *clients.MockChannelNotificator) {
+				n.EXPECT().Notify(gomock.Any(), clients.ChannelUpdate, userEvent(events.TypeUserUpdated, id1)).Times(1)
+			},
+			want: expectation{
+				responseCode: http.StatusOK,
+				results: []batchResult{
+					{ID: id1},
+					{ID: id2, Error: service.ErrUserNotFound},
+					{ID: id1, Error: &service.Error{Code: service.ErrCodeBadRequest, Message: "user is repeated in the batch"}},
+				},
+			},
+		},
+		"BatchUpdate atomic aborted": {
+			path:    "/service/v1/users:batchUpdate",
+			payload: fmt.Sprintf(`{"users":[{"id":"%s","country":"DE"},{"id":"%s","email":"%s"}],"atomic":true}`, id1, id2, email1),
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetUser(gomock.Any(), id1).Return(stored(id1), nil).Times(1)
+				r.EXPECT().GetUser(gomock.Any(), id2).Return(stored(id2).WithEmail(email2), nil).Times(1)
+				r.EXPECT().UpdateUsers(gomock.Any(), gomock.Len(2)).Return(1, repository.DuplicateKeyError).Times(1)
+			},
+			notify: func(n *clients.MockChannelNotificator) {},
+			want: expectation{
+				responseCode: http.StatusOK,
+				results: []batchResult{
+					{ID: id1, Error: service.ErrBatchAborted},
+					{ID: id2, Error: service.ErrDuplicateKeyError},
+				},
+			},
+		},
+		"BatchDelete best effort Ok": {
+			path:    "/service/v1/users:batchDelete",
+			payload: fmt.Sprintf(`{"ids":["%s","%s"]}`, id1, id2),
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().DeleteUsers(gomock.Any(), []string{id1, id2}, false).
+					Return([]service.User{{ID: id1, Country: "NL"}}, nil).Times(1)
+			},
+			notify: func(n *clients.MockChannelNotificator) {
+				n.EXPECT().Notify(gomock.Any(), clients.ChannelDelete, userEvent(events.TypeUserDeleted, id1)).Times(1)
+			},
+			want: expectation{
+				responseCode: http.StatusOK,
+				results:      []batchResult{{ID: id1}, {ID: id2, Error: service.ErrUserNotFound}},
+			},
+		},
+		"BatchDelete atomic aborted": {
+			path:    "/service/v1/users:batchDelete",
+			payload: fmt.Sprintf(`{"ids":["%s","%s"],"atomic":true}`, id1, id2),
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().DeleteUsers(gomock.Any(), []string{id1, id2}, true).
+					Return([]service.User{{ID: id1, Country: "NL"}}, repository.NoUsersFoundError).Times(1)
+			},
+			notify: func(n *clients.MockChannelNotificator) {},
+			want: expectation{
+				responseCode: http.StatusOK,
+				results:      []batchResult{{ID: id1, Error: service.ErrBatchAborted}, {ID: id2, Error: service.ErrUserNotFound}},
+			},
+		},
+		"BatchDelete repo Error": {
+			path:    "/service/v1/users:batchDelete",
+			payload: fmt.Sprintf(`{"ids":["%s"]}`, id1),
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().DeleteUsers(gomock.Any(), []string{id1}, false).Return(nil, somethingHappensError).Times(1)
+			},
+			notify: func(n *clients.MockChannelNotificator) {},
+			want: expectation{
+				responseCode: http.StatusInternalServerError,
+				errResponse:  `{"code":100,"message":"service internal error"}`,
+			},
+		},
+	}
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			tt.repo(repo)
+			tt.notify(notificationSvc)
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.payload)))
+			res := w.Result()
+			defer func() { _ = res.Body.Close() }()
+			data, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want.responseCode, res.StatusCode)
+			if tt.want.errResponse != "" {
+				assert.Equal(t, tt.want.errResponse, string(data))
+				return
+			}
+			var got batchResults
+			require.NoError(t, json.Unmarshal(data, &got))
+			for _, r := range got.Results {
+				if r.User != nil {
+					r.User.CreatedAt, r.User.UpdatedAt = time.Time{}, time.Time{}
+				}
+			}
+			assert.Equal(t, tt.want.results, got.Results)
+		})
+	}
+}
+
+func TestServer_ExportUsers(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	logger := logrus.New()
+	notificationSvc := clients.NewMockChannelNotificator(ctrl)
+	repo := service.NewMockUserRepo(ctrl)
+	mux := router(&handler{log: logger, api: service.New(repo, logger, notificationSvc, service.WithFilters(filters))},
+		logger, nil)
+
+	created := time.Date(2024, 8, 7, 12, 1, 52, 0, time.UTC)
+	users := []*service.User{
+		{ID: id1, FirstName: "User", LastName: "One, Jr", NickName: "userOne11", Email: email1, Country: "NL",
+			Role: service.RoleSelf, CreatedAt: created, UpdatedAt: created},
+		{ID: id2, FirstName: "User", LastName: "Two", NickName: "userTwo22", Email: email2, Country: "NL",
+			Role: service.RoleAdmin, CreatedAt: created, UpdatedAt: created},
+	}
+	stream := func(r *service.MockUserRepo) {
+		r.EXPECT().StreamUsers(gomock.Any(), gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, q service.ListQuery, fn func(u *service.User) error) error {
+				assert.Equal(t, "country = NL", q.Filter.String())
+				for _, u := range users {
+					if err := fn(u); err != nil {
+						return err
+					}
+				}
+				return nil
+			}).Times(1)
+	}
+
+	type expectation struct {
+		responseCode int
+		contentType  string
+		body         string
+	}
+
+	tests := map[string]struct {
+		url  string
+		repo func(r *service.MockUserRepo)
+		want expectation
+	}{
+		"ExportUsers csv Ok": {
+			url:  "/service/v1/users:export?format=csv&filter=country%20%3D%20NL",
+			repo: stream,
+			want: expectation{
+				responseCode: http.StatusOK,
+				contentType:  "text/csv; charset=utf-8",
+				body: "id,first_name,last_name,nickname,email,country,role,created_at,updated_at\n" +
+					id1 + ",User,\"One, Jr\",userOne11," + email1 + ",NL,self,2024-08-07T12:01:52Z,2024-08-07T12:01:52Z\n" +
+					id2 + ",User,Two,userTwo22," + email2 + ",NL,admin,2024-08-07T12:01:52Z,2024-08-07T12:01:52Z\n",
+			},
+		},
+		"ExportUsers ndjson Ok": {
+			url:  "/service/v1/users:export?format=ndjson&filterBy=country&filter=NL",
+			repo: stream,
+			want: expectation{
+				responseCode: http.StatusOK,
+				contentType:  "application/x-ndjson",
+				body: `{"id":"` + id1 + `","first_name":"User","last_name":"One, Jr","nickname":"userOne11","email":"` + email1 +
+					`","country":"NL","role":"self","created_at":"2024-08-07T12:01:52Z","updated_at":"2024-08-07T12:01:52Z"}` + "\n" +
+					`{"id":"` + id2 + `","first_name":"User","last_name":"Two","nickname":"userTwo22","email":"` + email2 +
+					`","country":"NL","role":"admin","created_at":"2024-08-07T12:01:52Z","updated_at":"2024-08-07T12:01:52Z"}` + "\n",
+			},
+		},
+		"ExportUsers nothing found Ok": {
+			url: "/service/v1/users:export?format=csv",
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().StreamUsers(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).Times(1)
+			},
+			want: expectation{
+				responseCode: http.StatusOK,
+				contentType:  "text/csv; charset=utf-8",
+				body:         "id,first_name,last_name,nickname,email,country,role,created_at,updated_at\n",
+			},
+		},
+		"ExportUsers format Error": {
+			url:  "/service/v1/users:export?format=xlsx",
+			repo: func(r *service.MockUserRepo) {},
+			want: expectation{
+				responseCode: http.StatusBadRequest,
+				contentType:  "application/json; charset=utf-8",
+				body:         `{"code":101,"message":"unsupported format 'xlsx', either csv or ndjson expected"}`,
+			},
+		},
+		"ExportUsers repo Error": {
+			url: "/service/v1/users:export?format=csv",
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().StreamUsers(gomock.Any(), gomock.Any(), gomock.Any()).Return(somethingHappensError).Times(1)
+			},
+			want: expectation{
+				responseCode: http.StatusInternalServerError,
+				contentType:  "application/json; charset=utf-8",
+				body:         `{"code":100,"message":"internal handlers error"}`,
+			},
+		},
+	}
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			tt.repo(repo)
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			res := w.Result()
+			defer func() { _ = res.Body.Close() }()
+			data, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want.responseCode, res.StatusCode)
+			assert.Equal(t, tt.want.contentType, res.Header.Get(HeaderContentType))
+			assert.Equal(t, tt.want.body, string(data))
+		})
+	}
+}
+
+func TestServer_ImportUsers(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	logger := logrus.New()
+	notificationSvc := clients.NewMockChannelNotificator(ctrl)
+	repo := service.NewMockUserRepo(ctrl)
+	mux := router(&handler{log: logger, api: service.New(repo, logger, notificationSvc)}, logger, nil)
+
+	csvFile := "Name,Surname,nickname,E-mail,password,country\n" +
+		"User,One,userOne11,User_One@gmail.com,qwerty,NL\n" +
+		"User,,userTwo22,user_two@gmail.com,qwerty,NL\n" +
+		"User,Three,userThree33,user_one@gmail.com,qwerty,NL\n" +
+		"User,Four,userFour44,user_four@gmail.com,qwerty,DE\n" +
+		"User,Five\n"
+	mapping := "&mapping=first_name:Name,last_name:Surname,email:E-mail"
+	form := &bytes.Buffer{}
+	mw := multipart.NewWriter(form)
+	require.NoError(t, mw.WriteField("comment", "league players"))
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Disposition": {`form-data; name="file"; filename="users.csv"`},
+		HeaderContentType:     {"text/csv"},
+	})
+	require.NoError(t, err)
+	_, err = part.Write([]byte(csvFile))
+	require.NoError(t, err)
+	require.NoError(t, mw.Close())
+	// email of the 4th line is taken by the existing user
+	taken := func(r *service.MockUserRepo) {
+		r.EXPECT().TakenLogins(gomock.Any(), gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, emails, nicknames []string) (*service.Logins, error) {
+				assert.ElementsMatch(t, []string{email1, "user_four@gmail.com"}, emails)
+				assert.ElementsMatch(t, []string{"userOne11", "userThree33", "userFour44"}, nicknames)
+				return &service.Logins{Emails: map[string]string{"user_four@gmail.com": id3}}, nil
+			})
+	}
+	report := func(dryRun bool, id string) *importReport {
+		return &importReport{
+			DryRun: dryRun, Total: 5, Accepted: 1, Rejected: 4,
+			Rows: []importRowResult{
+				{Line: 2, ID: id},
+				{Line: 3, Error: &service.Error{Code: service.ErrCodeBadRequest, Message: "empty last name"}},
+				{Line: 4, Error: service.ErrUserAlreadyExists, Conflicts: []conflict{{Field: "email", Value: email1, Line: 2}}},
+				{Line: 5, Error: service.ErrUserAlreadyExists,
+					Conflicts: []conflict{{Field: "email", Value: "user_four@gmail.com", UserID: id3}}},
+				{Line: 6, Error: &service.Error{Code: service.ErrCodeBadRequest, Message: "expected 6 columns, got 2"}},
+			},
+		}
+	}
+
+	type expectation struct {
+		responseCode int
+		report       *importReport
+		body         string
+	}
+
+	tests := map[string]struct {
+		url         string
+		contentType string
+		payload     string
+		repo        func(r *service.MockUserRepo)
+		notify      func(n *clients.MockChannelNotificator)
+		want        expectation
+	}{
+		"ImportUsers dry run Ok": {
+			url:     "/service/v1/users:import?format=csv&dry_run=true" + mapping,
+			payload: csvFile,
+			repo:    taken,
+			notify:  func(n *clients.MockChannelNotificator) {},
+			want: expectation{
+				responseCode: http.StatusOK,
+				report:       report(true, ""),
+			},
+		},
+		"ImportUsers Ok": {
+			url:         "/service/v1/users:import?" + mapping[1:],
+			contentType: "text/csv",
+			payload:     csvFile,
+			repo: func(r *service.MockUserRepo) {
+				taken(r)
+				r.EXPECT().CreateUsers(gomock.Any(), gomock.Len(1), false).
+					DoAndReturn(func(_ context.Context, users []*service.User, _ bool) ([]bool, error) {
+						assert.Equal(t, email1, users[0].Email)
+						users[0].ID = id1
+						return []bool{true}, nil
+					}).Times(1)
+			},
+			notify: func(n *clients.MockChannelNotificator) {
+				n.EXPECT().Notify(gomock.Any(), clients.ChannelCreate, userEvent(events.TypeUserCreated, id1)).Times(1)
+			},
+			want: expectation{
+				responseCode: http.StatusOK,
+				report:       report(false, id1),
+			},
+		},
+		"ImportUsers multipart Ok": {
+			url:         "/service/v1/users:import?dry_run=true" + mapping,
+			contentType: mw.FormDataContentType(),
+			payload:     form.String(),
+			repo:        taken,
+			notify:      func(n *clients.MockChannelNotificator) {},
+			want: expectation{
+				responseCode: http.StatusOK,
+				report:       report(true, ""),
+			},
+		},
+		"ImportUsers errors file Ok": {
+			url:     "/service/v1/users:import?format=csv&dry_run=true&errors_file=true" + mapping,
+			payload: csvFile,
+			repo:    taken,
+			notify:  func(n *clients.MockChannelNotificator) {},
+			want: expectation{
+				responseCode: http.StatusOK,
+				body: "Name,Surname,nickname,E-mail,password,country,error\n" +
+					"User,,userTwo22,user_two@gmail.com,,NL,empty last name\n" +
+					"User,Three,userThree33,user_one@gmail.com,,NL,email 'user_one@gmail.com' is taken by line 2\n" +
+					"User,Four,userFour44,user_four@gmail.com,,DE,email 'user_four@gmail.com' is taken by user " + id3 + "\n" +
+					"User,Five,,,,,\"expected 6 columns, got 2\"\n",
+			},
+		},
+		"ImportUsers ndjson Ok": {
+			url:         "/service/v1/users:import?dry_run=true",
+			contentType: "application/x-ndjson",
+			payload: `{"first_name":"User","last_name":"One","nickname":"userOne11","email":"user_one@gmail.com","password":"qwerty","country":"NL"}` + "\n\n" +
+				`{"first_name":"User","last_name":1}` + "\n" +
+				`{"first_name":` + "\n",
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().TakenLogins(gomock.Any(), []string{"user_one@gmail.com"}, []string{"userOne11"}).
+					Return(&service.Logins{}, nil)
+			},
+			notify: func(n *clients.MockChannelNotificator) {},
+			want: expectation{
+				responseCode: http.StatusOK,
+				report: &importReport{
+					DryRun: true, Total: 3, Accepted: 1, Rejected: 2,
+					Rows: []importRowResult{
+						{Line: 1},
+						{Line: 3, Error: &service.Error{Code: service.ErrCodeBadRequest, Message: "'last_name' is not a string"}},
+						{Line: 4, Error: &service.Error{Code: service.ErrCodeBadRequest, Message: "malformed json: unexpected end of JSON input"}},
+					},
+				},
+			},
+		},
+		"ImportUsers unknown mapping Error": {
+			url:     "/service/v1/users:import?format=csv&mapping=id:ID",
+			payload: csvFile,
+			repo:    func(r *service.MockUserRepo) {},
+			notify:  func(n *clients.MockChannelNotificator) {},
+			want: expectation{
+				responseCode: http.StatusBadRequest,
+				body:         `{"code":101,"message":"unknown mapping field 'id'"}`,
+			},
+		},
+		"ImportUsers no format Error": {
+			url:     "/service/v1/users:import",
+			payload: csvFile,
+			repo:    func(r *service.MockUserRepo) {},
+			notify:  func(n *clients.MockChannelNotificator) {},
+			want: expectation{
+				responseCode: http.StatusBadRequest,
+				body:         `{"code":101,"message":"format is mandatory unless content type is text/csv or application/x-ndjson"}`,
+			},
+		},
+		"ImportUsers empty Error": {
+			url:     "/service/v1/users:import?format=csv",
+			payload: "first_name,last_name\n",
+			repo:    func(r *service.MockUserRepo) {},
+			notify:  func(n *clients.MockChannelNotificator) {},
+			want: expectation{
+				responseCode: http.StatusBadRequest,
+				body:         `{"code":101,"message":"empty import"}`,
+			},
+		},
+	}
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			tt.repo(repo)
+			tt.notify(notificationSvc)
+
+			r := httptest.NewRequest(http.MethodPost, tt.url, strings.NewReader(tt.payload))
+			if tt.contentType != "" {
+				r.Header.Set(HeaderContentType, tt.contentType)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+			res := w.Result()
+			defer func() { _ = res.Body.Close() }()
+			data, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want.responseCode, res.StatusCode)
+			if tt.want.report == nil {
+				assert.Equal(t, tt.want.body, string(data))
+				return
+			}
+			var got importReport
+			require.NoError(t, json.Unmarshal(data, &got))
+			assert.Equal(t, *tt.want.report, got)
+		})
+	}
+}
+
+func TestServer_ImportUsersErrorsFilePassword(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	logger := logrus.New()
+	repo := service.NewMockUserRepo(ctrl)
+	repo.EXPECT().TakenLogins(gomock.Any(), gomock.Any(), gomock.Any()).Return(&service.Logins{}, nil).AnyTimes()
+	mux := router(&handler{log: logger, api: service.New(repo, logger, clients.NewMockChannelNotificator(ctrl))},
+		logger, nil)
+
+	tests := map[string]struct {
+		url     string
+		payload string
+		body    string
+	}{
+		"csv": {
+			url: "/service/v1/users:import?format=csv&dry_run=true&errors_file=true&mapping=password:Secret",
+			payload: "first_name,last_name,nickname,email,Secret\n" +
+				"User,,userTwo22,user_two@gmail.com,s3cr3t-pwd\n" +
+				"User,Five\n",
+			body: "first_name,last_name,nickname,email,Secret,error\n" +
+				"User,,userTwo22,user_two@gmail.com,,empty last name\n" +
+				"User,Five,,,,\"expected 5 columns, got 2\"\n",
+		},
+		"ndjson": {
+			url: "/service/v1/users:import?format=ndjson&dry_run=true&errors_file=true&mapping=password:Secret",
+			payload: `{"first_name":"User","nickname":"userTwo22","email":"user_two@gmail.com","Secret":"s3cr3t-pwd"}` + "\n" +
+				`{"first_name":"User","Secret":"s3cr3t-pwd"` + "\n",
+			body: `{"email":"user_two@gmail.com","error":"empty last name","first_name":"User","nickname":"userTwo22"}` + "\n" +
+				`{"error":"malformed json: unexpected end of JSON input","line":2}` + "\n",
+		},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tc.url, strings.NewReader(tc.payload)))
+			res := w.Result()
+			defer func() { _ = res.Body.Close() }()
+			data, err := io.ReadAll(res.Body)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+			assert.Equal(t, tc.body, string(data))
+			assert.NotContains(t, string(data), "s3cr3t-pwd")
+		})
+	}
+}
+
+func TestServer_DeleteUser(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	logger := logrus.New()
+	//logger.Level = logrus.DebugLevel
+	notificationSvc := clients.NewMockChannelNotificator(ctrl)
+	repo := service.NewMockUserRepo(ctrl)
+	httpSvc := handler{log: logger, api: service.New(repo, logger, notificationSvc)}
+
+	type expectation struct {
+		responseCode    int
+		responsePayload string
+		errResponse     string
+	}
+	type input struct {
+		reqUrl     string
+		reqPayload io.Reader
+		urlVars    map[string]string
+	}
+
+	tests := map[string]struct {
+		in     input
+		want   expectation
+		repo   func(r *service.MockUserRepo)
+		notify func(n *clients.MockChannelNotificator)
+	}{
+		"DeleteUser Ok": {
+			in: input{
+				reqUrl:  fmt.Sprintf("/service/v1/users/%s", id1),
+				urlVars: map[string]string{"uid": id1},
+			},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetUser(gomock.Any(), id1).Return((&service.User{Country: "NL"}).WithID(id1), nil).Times(1)
+				r.EXPECT().DeleteUser(gomock.Any(), id1).Return(nil).Times(1)
+			},
+			notify: func(n *clients.MockChannelNotificator) {
+				n.EXPECT().Notify(gomock.Any(), clients.ChannelDelete, userEvent(events.TypeUserDeleted, id1))
+			},
+			want: expectation{
+				responseCode:    http.StatusOK,
+				responsePayload: `null`,
+			},
+		},
+		"Delete user no id error": {
+			in: input{
+				reqUrl:  fmt.Sprintf("/service/v1/users/%s", id1),
+				urlVars: map[string]string{},
+			},
+			repo:   func(r *service.MockUserRepo) {},
+			notify: func(n *clients.MockChannelNotificator) {},
+			want: expectation{
+				responseCode: http.StatusBadRequest,
+				errResponse:  `{"code":101,"message":"failed to parse request: id is mandatory"}`,
+			},
+		},
+		"Delete user not found error": {
+			in: input{
+				reqUrl:  fmt.Sprintf("/service/v1/users/%s", id1),
+				urlVars: map[string]string{"uid": id1},
+			},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetUser(gomock.Any(), id1).Return(nil, repository.NoUsersFoundError).Times(1)
+			},
+			notify: func(n *clients.MockChannelNotificator) {},
+			want: expectation{
+				responseCode: http.StatusNotFound,
+				errResponse:  `{"code":200,"message":"user not found"}`,
+			},
+		},
+		"Delete user repo error": {
+			in: input{
+				reqUrl:  fmt.Sprintf("/service/v1/users/%s", id1),
+				urlVars: map[string]string{"uid": id1},
+			},
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetUser(gomock.Any(), id1).Return((&service.User{Country: "NL"}).WithID(id1), nil).Times(1)
+				r.EXPECT().DeleteUser(gomock.Any(), id1).Return(somethingHappensError).Times(1)
+			},
+			notify: func(n *clients.MockChannelNotificator) {},
+			want: expectation{
+				responseCode: http.StatusInternalServerError,
+				errResponse:  `{"code":100,"message":"internal handlers error"}`,
+			},
+		},
+	}
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			tt.repo(repo)
+			tt.notify(notificationSvc)
+			r := addChiURLParams(httptest.NewRequest(http.MethodDelete, tt.in.reqUrl, tt.in.reqPayload), tt.in.urlVars)
+			w := httptest.NewRecorder()
+
+			err := httpSvc.deleteUser(r).WriteTo(w)
+			assert.NoError(t, err)
+			res := w.Result()
+			defer func() { _ = res.Body.Close() }()
+			data, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want.responseCode, res.StatusCode)
+			if tt.want.errResponse == "" {
+				assert.Equal(t, tt.want.responsePayload, string(data))
+			} else {
+				assert.Equal(t, tt.want.errResponse, string(data))
+			}
+		})
+	}
+}
+
+func TestServer_RestoreUser(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	logger := logrus.New()
+	notificationSvc := clients.NewMockChannelNotificator(ctrl)
+	repo := service.NewMockUserRepo(ctrl)
+	mux := router(&handler{log: logger, api: service.New(repo, logger, notificationSvc)}, logger, nil)
+
+	restored := func() *service.User {
+		u := *user
+		u.Version = 4
+		return u.WithID(id1).WithCreateAt(createdAt)
+	}
+
+	type expectation struct {
+		responseCode    int
+		responsePayload string
+		etag            string
+		errResponse     string
+	}
+
+	tests := map[string]struct {
+		path   string
+		repo   func(r *service.MockUserRepo)
+		notify func(n *clients.MockChannelNotificator)
+		want   expectation
+	}{
+		"RestoreUser Ok": {
+			path: fmt.Sprintf("/service/v1/users/%s:restore", id1),
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().RestoreUser(gomock.Any(), id1).Return(restored(), nil).Times(1)
+			},
+			notify: func(n *clients.MockChannelNotificator) {
+				n.EXPECT().Notify(gomock.Any(), clients.ChannelCreate, userEvent(events.TypeUserRestored, id1))
+			},
+			want: expectation{
+				responseCode: http.StatusOK,
+				responsePayload: fmt.Sprintf(`{"id":"%s","first_name":"User","last_name":"One","nickname":"userOne11",`+
+					`"email":"%s","country":"NL","created_at":"2022-07-20T12:45:44Z","updated_at":"0001-01-01T00:00:00Z",`+
+					`"version":4}`, id1, email1),
+				etag: `"4"`,
+			},
+		},
+		"Restore user not found error": {
+			path: fmt.Sprintf("/service/v1/users/%s:restore", id1),
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().RestoreUser(gomock.Any(), id1).Return(nil, repository.NoUsersFoundError).Times(1)
+			},
+			notify: func(n *clients.MockChannelNotificator) {},
+			want: expectation{
+				responseCode: http.StatusNotFound,
+				errResponse:  `{"code":200,"message":"user not found"}`,
+			},
+		},
+		"Restore user email taken error": {
+			path: fmt.Sprintf("/service/v1/users/%s:restore", id1),
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().RestoreUser(gomock.Any(), id1).Return(nil, repository.DuplicateKeyError).Times(1)
+			},
+			notify: func(n *clients.MockChannelNotificator) {},
+			want: expectation{
+				responseCode: http.StatusConflict,
+				errResponse:  `{"code":300,"message":"email or nickname of the user is taken by another user"}`,
+			},
+		},
+		"Restore user repo error": {
+			path: fmt.Sprintf("/service/v1/users/%s:restore", id1),
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().RestoreUser(gomock.Any(), id1).Return(nil, somethingHappensError).Times(1)
+			},
+			notify: func(n *clients.MockChannelNotificator) {},
+			want: expectation{
+				responseCode: http.StatusInternalServerError,
+				errResponse:  `{"code":100,"message":"service internal error"}`,
+			},
+		},
+	}
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			tt.repo(repo)
+			tt.notify(notificationSvc)
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tt.path, nil))
+			res := w.Result()
+			defer func() { _ = res.Body.Close() }()
+			data, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want.responseCode, res.StatusCode)
+			if tt.want.errResponse != "" {
+				assert.Equal(t, tt.want.errResponse, string(data))
+				return
+			}
+			assert.Equal(t, tt.want.responsePayload, string(data))
+			assert.Equal(t, tt.want.etag, res.Header.Get(HeaderETag))
+		})
+	}
+}
+
+func TestServer_Authorization(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	logger := logrus.New()
+	notificationSvc := clients.NewMockChannelNotificator(ctrl)
+	repo := service.NewMockUserRepo(ctrl)
+	httpSvc := handler{log: logger, api: service.New(repo, logger, notificationSvc, service.WithAuthorization())}
+
+	admin := &service.Caller{Subject: "ops", Role: service.RoleAdmin}
+	support := &service.Caller{Subject: id2, Role: service.RoleSupport}
+	self := &service.Caller{Subject: id1, Role: service.RoleSelf}
+	denied := `{"code":403,"message":"permission denied"}`
+
+	type expectation struct {
+		responseCode int
+		errResponse  string
+	}
+
+	tests := map[string]struct {
+		caller  *service.Caller
+		method  string
+		payload string
+		call    func(r *http.Request) response
+		want    expectation
+		repo    func(r *service.MockUserRepo)
+		notify  func(n *clients.MockChannelNotificator)
+	}{
+		"Admin deletes user Ok": {
+			caller: admin,
+			method: http.MethodDelete,
+			call:   httpSvc.deleteUser,
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetUser(gomock.Any(), id1).Return((&service.User{Country: "NL"}).WithID(id1), nil).Times(1)
+				r.EXPECT().DeleteUser(gomock.Any(), id1).Return(nil).Times(1)
+			},
+			notify: func(n *clients.MockChannelNotificator) {
+				n.EXPECT().Notify(gomock.Any(), clients.ChannelDelete, gomock.Any()).Times(1)
+			},
+			want: expectation{responseCode: http.StatusOK},
+		},
+		"Support deletes user denied": {
+			caller: support,
+			method: http.MethodDelete,
+			call:   httpSvc.deleteUser,
+			repo:   func(r *service.MockUserRepo) {},
+			notify: func(n *clients.MockChannelNotificator) {},
+			want:   expectation{responseCode: http.StatusForbidden, errResponse: denied},
+		},
+		"Anonymous deletes user denied": {
+			method: http.MethodDelete,
+			call:   httpSvc.deleteUser,
+			repo:   func(r *service.MockUserRepo) {},
+			notify: func(n *clients.MockChannelNotificator) {},
+			want:   expectation{responseCode: http.StatusForbidden, errResponse: denied},
+		},
+		"Self updates own email Ok": {
+			caller:  self,
+			method:  http.MethodPut,
+			payload: `{"email": "new@gmail.com"}`,
+			call:    httpSvc.updateUser,
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetUser(gomock.Any(), id1).
+					Return(&service.User{ID: id1, FirstName: "User", LastName: "One", Country: "NL", Email: email1, Role: service.RoleSelf}, nil).Times(1)
+				r.EXPECT().UpdateUser(gomock.Any(), gomock.Any()).Return(nil).Times(1)
+			},
+			notify: func(n *clients.MockChannelNotificator) {
+				n.EXPECT().Notify(gomock.Any(), clients.ChannelUpdate, gomock.Any()).Times(1)
+			},
+			want: expectation{responseCode: http.StatusOK},
+		},
+		"Self assigns own role denied": {
+			caller:  self,
+			method:  http.MethodPut,
+			payload: `{"role": "admin"}`,
+			call:    httpSvc.updateUser,
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetUser(gomock.Any(), id1).
+					Return(&service.User{ID: id1, FirstName: "User", LastName: "One", Country: "NL", Role: service.RoleSelf}, nil).Times(1)
+			},
+			notify: func(n *clients.MockChannelNotificator) {},
+			want:   expectation{responseCode: http.StatusForbidden, errResponse: denied},
+		},
+		"Support changes email of others denied": {
+			caller:  support,
+			method:  http.MethodPut,
+			payload: `{"email": "new@gmail.com"}`,
+			call:    httpSvc.updateUser,
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetUser(gomock.Any(), id1).
+					Return(&service.User{ID: id1, FirstName: "User", LastName: "One", Country: "NL", Email: email1, Role: service.RoleSelf}, nil).Times(1)
+			},
+			notify: func(n *clients.MockChannelNotificator) {},
+			want:   expectation{responseCode: http.StatusForbidden, errResponse: denied},
+		},
+		"Self reads other user denied": {
+			caller: &service.Caller{Subject: id3, Role: service.RoleSelf},
+			method: http.MethodGet,
+			call:   httpSvc.getUser,
+			repo: func(r *service.MockUserRepo) {
+				r.EXPECT().GetUser(gomock.Any(), id1).Return(&service.User{ID: id1}, nil).Times(1)
+			},
+			notify: func(n *clients.MockChannelNotificator) {},
+			want:   expectation{responseCode: http.StatusForbidden, errResponse: denied},
+		},
+	}
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			tt.repo(repo)
+			tt.notify(notificationSvc)
+			r := addChiURLParams(httptest.NewRequest(tt.method, fmt.Sprintf("/service/v1/users/%s", id1),
+				strings.NewReader(tt.payload)), map[string]string{"uid": id1})
+			if tt.caller != nil {
+				r = r.WithContext(service.WithCaller(r.Context(), tt.caller))
+			}
+			w := httptest.NewRecorder()
+
+			err := tt.call(r).WriteTo(w)
+			assert.NoError(t, err)
+			res := w.Result()
+			defer func() { _ = res.Body.Close() }()
+			data, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want.responseCode, res.StatusCode)
+			if tt.want.errResponse != "" {
+				assert.Equal(t, tt.want.errResponse, string(data))
+			}
+		})
+	}
+}
+
+// userEvent matches event of the type on the user
+func userEvent(t events.Type, userID string) gomock.Matcher {
+	return gomock.Cond(func(e *events.Event) bool {
+		return e.Type == t && e.UserID == userID && e.SchemaVersion == events.SchemaVersion
+	})
+}
+
+// tamper changes a character of the sealed token ciphertext
+func tamper(token string) string {
+	i := len(token) - 5
+	c := byte('A')
+	if token[i] == c {
+		c = 'B'
+	}
+	return token[:i] + string(c) + token[i+1:]
+}
+
+func TestServer_UserHistory(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	logger := logrus.New()
+	notificationSvc := clients.NewMockChannelNotificator(ctrl)
+	repo := service.NewMockUserRepo(ctrl)
+	audit := service.NewMockAuditRepo(ctrl)
+	mux := router(&handler{log: logger, api: service.New(repo, logger, notificationSvc, service.WithAudit(audit)), pages: pages},
+		logger, nil)
+
+	entries := []service.AuditEntry{
+		{ID: 7, UserID: id1, Action: service.AuditUpdated, Actor: &service.Caller{Subject: id2, Role: service.RoleAdmin},
+			Transport: service.TransportGRPC, RequestID: "req-7", CreatedAt: createdAt,
+			Changes: []events.Change{{Field: "country", Old: "NL", New: "DE"}}},
+		{ID: 5, UserID: id1, Action: service.AuditCreated, CreatedAt: createdAt},
+	}
+	nextPage, _ := pages.GenerateHistoryPage(service.HistoryQuery{UserID: id1, Limit: 1}, 7)
+	otherUserPage, _ := pages.GenerateHistoryPage(service.HistoryQuery{UserID: id2, Limit: 1}, 7)
+
+	type expectation struct {
+		responseCode    int
+		responsePayload string
+		nextPage        bool
+		errResponse     string
+	}
+
+	tests := map[string]struct {
+		path  string
+		audit func(a *service.MockAuditRepo)
+		want  expectation
+	}{
+		"UserHistory Ok": {
+			path: fmt.Sprintf("/service/v1/users/%s/history?pagination=1", id1),
+			audit: func(a *service.MockAuditRepo) {
+				a.EXPECT().UserHistory(gomock.Any(), id1, int64(0), 2).Return(entries, nil).Times(1)
+			},
+			want: expectation{
+				responseCode: http.StatusOK,
+				responsePayload: fmt.Sprintf(`{"entries":[{"id":7,"action":"updated","actor":{"subject":"%s","method":"","role":"admin"},`+
+					`"transport":"grpc","request_id":"req-7","changes":[{"field":"country","old":"NL","new":"DE"}],`+
+					`"created_at":"2022-07-20T12:45:44Z"}],`, id2),
+				nextPage: true,
+			},
+		},
+		"UserHistory next page Ok": {
+			path: fmt.Sprintf("/service/v1/users/%s/history?next_page=%s", id1, nextPage),
+			audit: func(a *service.MockAuditRepo) {
+				a.EXPECT().UserHistory(gomock.Any(), id1, int64(7), 2).Return(entries[1:], nil).Times(1)
+			},
+			want: expectation{
+				responseCode: http.StatusOK,
+				responsePayload: `{"entries":[{"id":5,"action":"created","changes":[],` +
+					`"created_at":"2022-07-20T12:45:44Z"}]}`,
+			},
+		},
+		"UserHistory malformed pagination error": {
+			path:  fmt.Sprintf("/service/v1/users/%s/history?pagination=-1", id1),
+			audit: func(a *service.MockAuditRepo) {},
+			want: expectation{
+				responseCode: http.StatusBadRequest,
+				errResponse:  `{"code":101,"message":"failed to parse request: malformed pagination"}`,
+			},
+		},
+		"UserHistory next page of other user error": {
+			path:  fmt.Sprintf("/service/v1/users/%s/history?next_page=%s", id1, otherUserPage),
+			audit: func(a *service.MockAuditRepo) {},
+			want: expectation{
+				responseCode: http.StatusBadRequest,
+				errResponse:  `{"code":101,"message":"failed to parse request: next_page token is invalid"}`,
+			},
+		},
+		"UserHistory repo error": {
+			path: fmt.Sprintf("/service/v1/users/%s/history", id1),
+			audit: func(a *service.MockAuditRepo) {
+				a.EXPECT().UserHistory(gomock.Any(), id1, int64(0), service.DefaultHistoryLimit+1).
+					Return(nil, somethingHappensError).Times(1)
+			},
+			want: expectation{
+				responseCode: http.StatusInternalServerError,
+				errResponse:  `{"code":100,"message":"service internal error"}`,
+			},
+		},
+	}
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			tt.audit(audit)
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			res := w.Result()
+			defer func() { _ = res.Body.Close() }()
+			data, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want.responseCode, res.StatusCode)
+			if tt.want.errResponse != "" {
+				assert.Equal(t, tt.want.errResponse, string(data))
+				return
+			}
+			if tt.want.nextPage {
+				assert.True(t, strings.HasPrefix(string(data), tt.want.responsePayload), string(data))
+				assert.Contains(t, string(data), `"next_page":"`)
+				return
+			}
+			assert.Equal(t, tt.want.responsePayload, string(data))
+		})
+	}
+
+	t.Run("history is not configured", func(t *testing.T) {
+		mux := router(&handler{log: logger, api: service.New(repo, logger, notificationSvc), pages: pages}, logger, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/service/v1/users/%s/history", id1), nil))
+		assert.Equal(t, http.StatusNotImplemented, w.Code)
+	})
+}
+
+func TestServer_RequestID(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	logger := logrus.New()
+	notificationSvc := clients.NewMockChannelNotificator(ctrl)
+	repo := service.NewMockUserRepo(ctrl)
+	audit := service.NewMockAuditRepo(ctrl)
+	mux := router(&handler{log: logger, api: service.New(repo, logger, notificationSvc, service.WithAudit(audit))}, logger, nil)
+
+	repo.EXPECT().RestoreUser(gomock.Any(), id1).Return(user.WithID(id1), nil).Times(2)
+	audit.EXPECT().AddAuditEntry(gomock.Any(), gomock.Cond(func(e *service.AuditEntry) bool {
+		return e.UserID == id1 && e.Action == service.AuditRestored &&
+			e.Transport == service.TransportHTTP && e.RequestID == "req-1"
+	})).Return(nil).Times(1)
+	audit.EXPECT().AddAuditEntry(gomock.Any(), gomock.Cond(func(e *service.AuditEntry) bool {
+		return e.Transport == service.TransportHTTP && e.RequestID != "" && e.RequestID != "req-1"
+	})).Return(nil).Times(1)
+	notificationSvc.EXPECT().Notify(gomock.Any(), clients.ChannelCreate, userEvent(events.TypeUserRestored, id1)).Times(2)
+
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/service/v1/users/%s:restore", id1), nil)
+	req.Header.Set(HeaderRequestID, "req-1")
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "req-1", w.Header().Get(HeaderRequestID))
+
+	// request id is generated if it is not passed
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/service/v1/users/%s:restore", id1), nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotEmpty(t, w.Header().Get(HeaderRequestID))
+}
+
+func TestServer_ExportUser(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	logger := logrus.New()
+	notificationSvc := clients.NewMockChannelNotificator(ctrl)
+	repo := service.NewMockUserRepo(ctrl)
+	privacy := service.NewMockPrivacyRepo(ctrl)
+	audit := service.NewMockAuditRepo(ctrl)
+	mux := router(&handler{log: logger, api: service.New(repo, logger, notificationSvc,
+		service.WithAudit(audit), service.WithPrivacy(privacy))}, logger, nil)
+
+	erasedAt := createdAt.Add(time.Hour)
+	record := func() *service.UserRecord {
+		return &service.UserRecord{User: service.User{ID: id1, Country: "NL", CreatedAt: createdAt, UpdatedAt: erasedAt,
+			Version: 3}, DeletedAt: &erasedAt, ErasedAt: &erasedAt}
+	}
+
+	type expectation struct {
+		responseCode    int
+		responsePayload string
+		errResponse     string
+	}
+
+	tests := map[string]struct {
+		prepare func(p *service.MockPrivacyRepo, a *service.MockAuditRepo)
+		want    expectation
+	}{
+		"ExportUser Ok": {
+			prepare: func(p *service.MockPrivacyRepo, a *service.MockAuditRepo) {
+				p.EXPECT().UserRecord(gomock.Any(), id1).Return(record(), nil).Times(1)
+				a.EXPECT().UserHistory(gomock.Any(), id1, int64(0), service.MaxHistoryLimit).Return([]service.AuditEntry{
+					{ID: 2, UserID: id1, Action: service.AuditErased, CreatedAt: erasedAt,
+						Changes: []events.Change{{Field: "email", Redacted: true}}},
+				}, nil).Times(1)
+				p.EXPECT().UserNotifications(gomock.Any(), id1).Return([]service.Notification{
+					{OutboxEvent: service.OutboxEvent{ID: 5, UserID: id1, Channel: "delete",
+						Payload: `{"type":"user.erased"}`, CreatedAt: erasedAt, Attempts: 1}, LastError: "unavailable"},
+				}, nil).Times(1)
+			},
+			want: expectation{
+				responseCode: http.StatusOK,
+				responsePayload: fmt.Sprintf(`"user":{"id":"%s","first_name":"","last_name":"","nickname":"","email":"",`+
+					`"country":"NL","created_at":"2022-07-20T12:45:44Z","updated_at":"2022-07-20T13:45:44Z","version":3,`+
+					`"deleted_at":"2022-07-20T13:45:44Z","erased_at":"2022-07-20T13:45:44Z"},`+
+					`"history":[{"id":2,"action":"erased","changes":[{"field":"email","redacted":true}],`+
+					`"created_at":"2022-07-20T13:45:44Z"}],`+
+					`"notifications":[{"id":5,"channel":"delete","event":{"type":"user.erased"},`+
+					`"created_at":"2022-07-20T13:45:44Z","attempts":1,"last_error":"unavailable"}]}`, id1),
+			},
+		},
+		"ExportUser not found error": {
+			prepare: func(p *service.MockPrivacyRepo, a *service.MockAuditRepo) {
+				p.EXPECT().UserRecord(gomock.Any(), id1).Return(nil, repository.NoUsersFoundError).Times(1)
+			},
+			want: expectation{
+				responseCode: http.StatusNotFound,
+				errResponse:  `{"code":200,"message":"user not found"}`,
+			},
+		},
+		"ExportUser repo error": {
+			prepare: func(p *service.MockPrivacyRepo, a *service.MockAuditRepo) {
+				p.EXPECT().UserRecord(gomock.Any(), id1).Return(record(), nil).Times(1)
+				a.EXPECT().UserHistory(gomock.Any(), id1, int64(0), service.MaxHistoryLimit).
+					Return(nil, somethingHappensError).Times(1)
+			},
+			want: expectation{
+				responseCode: http.StatusInternalServerError,
+				errResponse:  `{"code":100,"message":"service internal error"}`,
+			},
+		},
+	}
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			tt.prepare(privacy, audit)
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/service/v1/users/%s:export", id1), nil))
+			res := w.Result()
+			defer func() { _ = res.Body.Close() }()
+			data, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want.responseCode, res.StatusCode)
+			if tt.want.errResponse != "" {
+				assert.Equal(t, tt.want.errResponse, string(data))
+				return
+			}
+			// exported_at goes first and is the time of the request
+			assert.True(t, strings.HasSuffix(string(data), tt.want.responsePayload), string(data))
+			assert.Equal(t, fmt.Sprintf(`attachment; filename="user-%s.json"`, id1), res.Header.Get("Content-Disposition"))
+		})
+	}
+}
+
+func TestServer_EraseUser(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	logger := logrus.New()
+	notificationSvc := clients.NewMockChannelNotificator(ctrl)
+	repo := service.NewMockUserRepo(ctrl)
+	privacy := service.NewMockPrivacyRepo(ctrl)
+	mux := router(&handler{log: logger, api: service.New(repo, logger, notificationSvc, service.WithPrivacy(privacy))},
+		logger, nil)
+
+	type expectation struct {
+		responseCode int
+		errResponse  string
+	}
+
+	tests := map[string]struct {
+		prepare func(p *service.MockPrivacyRepo, n *clients.MockChannelNotificator)
+		want    expectation
+	}{
+		"EraseUser Ok": {
+			prepare: func(p *service.MockPrivacyRepo, n *clients.MockChannelNotificator) {
+				p.EXPECT().UserRecord(gomock.Any(), id1).Return(&service.UserRecord{User: *user.WithID(id1)}, nil).Times(1)
+				p.EXPECT().EraseUser(gomock.Any(), id1).Return(nil).Times(1)
+				p.EXPECT().UserNotifications(gomock.Any(), id1).Return(nil, nil).Times(1)
+				n.EXPECT().Notify(gomock.Any(), clients.ChannelDelete, userEvent(events.TypeUserErased, id1))
+			},
+			want: expectation{responseCode: http.StatusOK},
+		},
+		"EraseUser not found error": {
+			prepare: func(p *service.MockPrivacyRepo, n *clients.MockChannelNotificator) {
+				p.EXPECT().UserRecord(gomock.Any(), id1).Return(nil, repository.NoUsersFoundError).Times(1)
+			},
+			want: expectation{
+				responseCode: http.StatusNotFound,
+				errResponse:  `{"code":200,"message":"user not found"}`,
+			},
+		},
+		"EraseUser repo error": {
+			prepare: func(p *service.MockPrivacyRepo, n *clients.MockChannelNotificator) {
+				p.EXPECT().UserRecord(gomock.Any(), id1).Return(&service.UserRecord{User: *user.WithID(id1)}, nil).Times(1)
+				p.EXPECT().EraseUser(gomock.Any(), id1).Return(somethingHappensError).Times(1)
+			},
+			want: expectation{
+				responseCode: http.StatusInternalServerError,
+				errResponse:  `{"code":100,"message":"service internal error"}`,
+			},
+		},
+	}
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			tt.prepare(privacy, notificationSvc)
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/service/v1/users/%s:erase", id1), nil))
+			res := w.Result()
+			defer func() { _ = res.Body.Close() }()
+			data, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want.responseCode, res.StatusCode)
+			if tt.want.errResponse != "" {
+				assert.Equal(t, tt.want.errResponse, string(data))
+			}
+		})
+	}
+}
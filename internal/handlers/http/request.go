@@ -3,14 +3,17 @@ package http
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/BorisRostovskiy/ESL/internal/events"
 	"github.com/BorisRostovskiy/ESL/internal/handlers"
 
 	"github.com/BorisRostovskiy/ESL/internal/service"
+	"github.com/BorisRostovskiy/ESL/internal/tokens"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -23,8 +26,11 @@ type User struct {
 	Password  string    `json:"password,omitempty"`
 	Email     string    `json:"email"`
 	Country   string    `json:"country"`
+	Role      string    `json:"role,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	// Version current version of the user, the expected one for batch updates
+	Version int64 `json:"version,omitempty"`
 }
 
 func (u *User) marshal(su *service.User) {
@@ -34,8 +40,32 @@ func (u *User) marshal(su *service.User) {
 	u.NickName = su.NickName
 	u.Email = su.Email
 	u.Country = su.Country
+	u.Role = su.Role
 	u.CreatedAt = su.CreatedAt
 	u.UpdatedAt = su.UpdatedAt
+	u.Version = su.Version
+}
+
+// etag strong entity tag of the user version
+func etag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// parseIfMatch expected user version of If-Match header, 0 if any version is fine
+func parseIfMatch(h string) (int64, error) {
+	h = strings.TrimSpace(h)
+	if h == "" || h == "*" {
+		return 0, nil
+	}
+	unquoted, err := strconv.Unquote(h)
+	if err != nil || strings.HasPrefix(h, "W/") {
+		return 0, fmt.Errorf("malformed If-Match, single strong entity tag expected")
+	}
+	version, err := strconv.ParseInt(unquoted, 10, 64)
+	if err != nil || version <= 0 {
+		return 0, fmt.Errorf("unknown entity tag %s", h)
+	}
+	return version, nil
 }
 
 type CreateUser struct {
@@ -55,6 +85,7 @@ func (cu *CreateUser) Decode(r *http.Request) error {
 		Password:  u.Password,
 		Email:     strings.ToLower(u.Email),
 		Country:   u.Country,
+		Role:      u.Role,
 	}
 	if err := cu.User.Validate(false); err != nil {
 		return fmt.Errorf("invalid user: %w", err)
@@ -65,42 +96,189 @@ func (cu *CreateUser) Decode(r *http.Request) error {
 func (cu *CreateUser) WriteTo(w http.ResponseWriter) error {
 	var u User
 	u.marshal(cu.User)
+	w.Header().Set(HeaderETag, etag(u.Version))
 	return responseObject(w, http.StatusCreated, u)
 }
 
+type Tokens struct {
+	TokenType        string    `json:"token_type"`
+	AccessToken      string    `json:"access_token"`
+	AccessExpiresAt  time.Time `json:"access_expires_at"`
+	RefreshToken     string    `json:"refresh_token"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+}
+
+func (t *Tokens) marshal(tp *service.TokenPair) {
+	t.TokenType = "Bearer"
+	t.AccessToken = tp.AccessToken
+	t.AccessExpiresAt = tp.AccessExpiresAt
+	t.RefreshToken = tp.RefreshToken
+	t.RefreshExpiresAt = tp.RefreshExpiresAt
+}
+
+// Login
+type login struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+	Session  *service.Session
+}
+
+func (l *login) Decode(r *http.Request) error {
+	if err := json.NewDecoder(r.Body).Decode(l); err != nil {
+		return fmt.Errorf("malformed login data: %w", err)
+	}
+	if l.Login == "" || l.Password == "" {
+		return fmt.Errorf("login and password are mandatory")
+	}
+	return nil
+}
+func (l *login) WriteTo(w http.ResponseWriter) error {
+	out := struct {
+		User   User    `json:"user"`
+		Tokens *Tokens `json:"tokens,omitempty"`
+	}{}
+	out.User.marshal(l.Session.User)
+	if l.Session.Tokens != nil {
+		out.Tokens = &Tokens{}
+		out.Tokens.marshal(l.Session.Tokens)
+	}
+	return responseObject(w, http.StatusOK, out)
+}
+
+// Refresh
+type refresh struct {
+	RefreshToken string `json:"refresh_token"`
+	Tokens       *service.TokenPair
+}
+
+func (rf *refresh) Decode(r *http.Request) error {
+	if err := json.NewDecoder(r.Body).Decode(rf); err != nil {
+		return fmt.Errorf("malformed refresh data: %w", err)
+	}
+	if rf.RefreshToken == "" {
+		return fmt.Errorf("refresh_token is mandatory")
+	}
+	return nil
+}
+func (rf *refresh) WriteTo(w http.ResponseWriter) error {
+	var t Tokens
+	t.marshal(rf.Tokens)
+	return responseObject(w, http.StatusOK, t)
+}
+
+// JWKS
+type jwks struct {
+	tokens.JWKSet
+}
+
+func (j *jwks) WriteTo(w http.ResponseWriter) error {
+	return responseObject(w, http.StatusOK, j.JWKSet)
+}
+
+// GetUser
+const (
+	lookupByID       = "uid"
+	lookupByEmail    = "email"
+	lookupByNickname = "nickname"
+)
+
+type getUser struct {
+	By    string
+	Value string
+	User  *service.User
+}
+
+func (gu *getUser) Decode(r *http.Request) error {
+	for _, by := range []string{lookupByID, lookupByEmail, lookupByNickname} {
+		if v := chi.URLParam(r, by); v != "" {
+			gu.By = by
+			gu.Value = v
+			return nil
+		}
+	}
+	return fmt.Errorf("user id, email or nickname is mandatory")
+}
+func (gu *getUser) WriteTo(w http.ResponseWriter) error {
+	var u User
+	u.marshal(gu.User)
+	w.Header().Set(HeaderETag, etag(u.Version))
+	return responseObject(w, http.StatusOK, u)
+}
+
 // ListUsers
 type listUsers struct {
-	Users    []User          `json:"users"`
-	Limit    int             `json:"-"`
-	Offset   int             `json:"-"`
-	Filter   *service.Filter `json:"-"`
-	NextPage string          `json:"next_page,omitempty"`
+	Users    []User            `json:"users"`
+	Query    service.ListQuery `json:"-"`
+	NextPage string            `json:"next_page,omitempty"`
+	// Total and Facets are set only if include_total is requested
+	Total  *int    `json:"total,omitempty"`
+	Facets *Facets `json:"facets,omitempty"`
 }
 
-func (lu *listUsers) Decode(r *http.Request) error {
-	np, err := nextPage(r)
+// Facets users count per field value
+type Facets struct {
+	Country map[string]int `json:"country"`
+}
+
+func (lu *listUsers) Decode(r *http.Request, pages *handlers.PageTokens, filters service.Filters) error {
+	np, err := nextPage(r, pages)
 	if err != nil {
 		return fmt.Errorf("could not load nextPage: %v", err)
 	}
-	return lu.decode(np)
+	return lu.decode(np, filters)
 }
-func (lu *listUsers) decode(np *handlers.NextPage) error {
-	if np.FilterBy != "" {
-		filter, err := service.NewFilter(np.FilterBy, np.Filter)
-		if err != nil {
-			return err
-		}
-		lu.Filter = filter
+func (lu *listUsers) decode(np *handlers.NextPage, filters service.Filters) error {
+	filter, err := np.ParseFilter(filters)
+	if err != nil {
+		return err
 	}
-	lu.Limit = np.Limit
-	lu.Offset = np.Offset
-	return nil
+	lu.Query.Filter = filter
+	if lu.Query.Sort, err = service.ParseSort(np.Sort); err != nil {
+		return err
+	}
+	lu.Query.Limit = np.Limit
+	lu.Query.IncludeTotal = np.IncludeTotal
+	lu.Query.After = np.Cursor()
+	return lu.Query.Sort.Validate(lu.Query.After)
+}
+func (lu *listUsers) totals(t *service.Totals) {
+	if t == nil {
+		return
+	}
+	lu.Total = &t.Count
+	lu.Facets = &Facets{Country: t.Countries}
 }
 func (lu *listUsers) WriteTo(w http.ResponseWriter) error {
 	return responseObject(w, http.StatusOK, lu)
 }
 
 // UpdateUser
+// watchUsers watch request, Last-Event-ID header is set by EventSource on reconnect
+type watchUsers struct {
+	Query service.WatchQuery
+}
+
+func (wu *watchUsers) Decode(r *http.Request) error {
+	wu.Query.After = r.Header.Get(HeaderLastEventID)
+	if after := r.URL.Query().Get("last_event_id"); after != "" {
+		wu.Query.After = after
+	}
+	for _, param := range r.URL.Query()["country"] {
+		for _, c := range strings.Split(param, ",") {
+			if c = strings.TrimSpace(c); c == "" {
+				return fmt.Errorf("empty country")
+			}
+			wu.Query.Countries = append(wu.Query.Countries, c)
+		}
+	}
+	return nil
+}
+
+// heartbeat SSE heartbeat payload
+type heartbeat struct {
+	Timestamp time.Time `json:"timestamp"`
+}
+
 type updateUser struct {
 	service.User
 }
@@ -113,6 +291,7 @@ func (uu *updateUser) Decode(r *http.Request) error {
 		Password  *string `json:"password"`
 		Email     *string `json:"email"`
 		Country   *string `json:"country"`
+		Role      *string `json:"role"`
 	}{}
 	if err := json.NewDecoder(r.Body).Decode(&tmp); err != nil {
 		return fmt.Errorf("malformed update user data: %w", err)
@@ -137,15 +316,25 @@ func (uu *updateUser) Decode(r *http.Request) error {
 	if tmp.Email != nil {
 		uu.User.WithEmail(*tmp.Email)
 	}
+	if tmp.Role != nil {
+		uu.User.WithRole(*tmp.Role)
+	}
 
 	uid := chi.URLParam(r, "uid")
 	if uid == "" {
 		return fmt.Errorf("user id is mandatory")
 	}
 	uu.User.ID = uid
+
+	version, err := parseIfMatch(r.Header.Get(HeaderIfMatch))
+	if err != nil {
+		return err
+	}
+	uu.User.WithVersion(version)
 	return nil
 }
 func (uu *updateUser) WriteTo(w http.ResponseWriter) error {
+	w.Header().Set(HeaderETag, etag(uu.User.Version))
 	return responseObject(w, http.StatusOK, nil)
 }
 
@@ -165,10 +354,433 @@ func (du *deleteUser) WriteTo(w http.ResponseWriter) error {
 	return responseObject(w, http.StatusOK, nil)
 }
 
-func nextPage(r *http.Request) (*handlers.NextPage, error) {
-	return handlers.LoadNextPage(r.URL.Query().Get("next_page"),
-		r.URL.Query().Get("filter"),
-		r.URL.Query().Get("filterBy"),
+// restoreUser restore of the deleted user, responds with the restored user
+type restoreUser struct {
+	ID   string
+	User *service.User
+}
+
+func (ru *restoreUser) Decode(r *http.Request) error {
+	ru.ID = chi.URLParam(r, "uid")
+	if ru.ID == "" {
+		return fmt.Errorf("id is mandatory")
+	}
+	return nil
+}
+func (ru *restoreUser) WriteTo(w http.ResponseWriter) error {
+	var u User
+	u.marshal(ru.User)
+	w.Header().Set(HeaderETag, etag(u.Version))
+	return responseObject(w, http.StatusOK, u)
+}
+
+// userHistory page of the user changes, newest first
+type userHistory struct {
+	Query    service.HistoryQuery `json:"-"`
+	Entries  []auditEntry         `json:"entries"`
+	NextPage string               `json:"next_page,omitempty"`
+}
+
+type auditEntry struct {
+	ID     int64  `json:"id"`
+	Action string `json:"action"`
+	// Actor who made the change, nil if authentication is disabled
+	Actor     *events.Actor   `json:"actor,omitempty"`
+	Transport string          `json:"transport,omitempty"`
+	RequestID string          `json:"request_id,omitempty"`
+	Changes   []events.Change `json:"changes"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+func (uh *userHistory) Decode(r *http.Request, pages *handlers.PageTokens) error {
+	id := chi.URLParam(r, "uid")
+	if id == "" {
+		return fmt.Errorf("id is mandatory")
+	}
+	limit := 0
+	if v := r.URL.Query().Get("pagination"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			return fmt.Errorf("malformed pagination")
+		}
+	}
+	q, err := pages.LoadHistoryPage(id, r.URL.Query().Get("next_page"), limit)
+	if err != nil {
+		return err
+	}
+	uh.Query = q
+	return nil
+}
+
+func (uh *userHistory) encode(entries []service.AuditEntry) {
+	uh.Entries = auditEntries(entries)
+}
+
+func (uh *userHistory) WriteTo(w http.ResponseWriter) error {
+	return responseObject(w, http.StatusOK, uh)
+}
+
+func auditEntries(entries []service.AuditEntry) []auditEntry {
+	result := make([]auditEntry, len(entries))
+	for i, e := range entries {
+		result[i] = auditEntry{
+			ID:        e.ID,
+			Action:    string(e.Action),
+			Transport: e.Transport,
+			RequestID: e.RequestID,
+			Changes:   e.Changes,
+			CreatedAt: e.CreatedAt,
+		}
+		if e.Actor != nil {
+			result[i].Actor = &events.Actor{Subject: e.Actor.Subject, Method: e.Actor.Method, Role: e.Actor.Role}
+		}
+		if result[i].Changes == nil {
+			result[i].Changes = []events.Change{}
+		}
+	}
+	return result
+}
+
+// exportUser everything stored about the user as a single JSON document, answer to the data subject access request
+type exportUser struct {
+	ID            string         `json:"-"`
+	ExportedAt    time.Time      `json:"exported_at"`
+	User          userRecord     `json:"user"`
+	History       []auditEntry   `json:"history"`
+	Notifications []notification `json:"notifications"`
+}
+
+type userRecord struct {
+	User
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	ErasedAt  *time.Time `json:"erased_at,omitempty"`
+}
+
+// notification event stored for the subscribers along with its delivery state
+type notification struct {
+	ID      int64  `json:"id"`
+	Channel string `json:"channel"`
+	// Event as it is delivered to the subscribers
+	Event       json.RawMessage `json:"event"`
+	CreatedAt   time.Time       `json:"created_at"`
+	Attempts    int             `json:"attempts"`
+	DeliveredAt *time.Time      `json:"delivered_at,omitempty"`
+	FailedAt    *time.Time      `json:"failed_at,omitempty"`
+	LastError   string          `json:"last_error,omitempty"`
+}
+
+func (eu *exportUser) Decode(r *http.Request) error {
+	eu.ID = chi.URLParam(r, "uid")
+	if eu.ID == "" {
+		return fmt.Errorf("id is mandatory")
+	}
+	return nil
+}
+
+func (eu *exportUser) encode(export *service.UserExport) {
+	eu.ExportedAt = export.ExportedAt
+	eu.User.marshal(&export.User.User)
+	eu.User.DeletedAt = export.User.DeletedAt
+	eu.User.ErasedAt = export.User.ErasedAt
+	eu.History = auditEntries(export.History)
+	eu.Notifications = make([]notification, len(export.Notifications))
+	for i, n := range export.Notifications {
+		eu.Notifications[i] = notification{
+			ID:          n.ID,
+			Channel:     n.Channel,
+			Event:       json.RawMessage(n.Payload),
+			CreatedAt:   n.CreatedAt,
+			Attempts:    n.Attempts,
+			DeliveredAt: n.DeliveredAt,
+			FailedAt:    n.FailedAt,
+			LastError:   n.LastError,
+		}
+	}
+}
+
+func (eu *exportUser) WriteTo(w http.ResponseWriter) error {
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="user-%s.json"`, eu.ID))
+	return responseObject(w, http.StatusOK, eu)
+}
+
+// eraseUser erasure of the user personal data
+type eraseUser struct {
+	ID string
+}
+
+func (eu *eraseUser) Decode(r *http.Request) error {
+	eu.ID = chi.URLParam(r, "uid")
+	if eu.ID == "" {
+		return fmt.Errorf("id is mandatory")
+	}
+	return nil
+}
+func (eu *eraseUser) WriteTo(w http.ResponseWriter) error {
+	return responseObject(w, http.StatusOK, nil)
+}
+
+// batchUsers batch create and update request, users are validated by the service one by one
+type batchUsers struct {
+	Users  []User `json:"users"`
+	Atomic bool   `json:"atomic"`
+}
+
+func (bu *batchUsers) Decode(r *http.Request) ([]*service.User, error) {
+	if err := json.NewDecoder(r.Body).Decode(bu); err != nil {
+		return nil, fmt.Errorf("malformed batch data: %w", err)
+	}
+	users := make([]*service.User, len(bu.Users))
+	for i, u := range bu.Users {
+		users[i] = &service.User{
+			ID:        u.ID,
+			FirstName: u.FirstName,
+			LastName:  u.LastName,
+			NickName:  u.NickName,
+			Password:  u.Password,
+			Email:     strings.ToLower(u.Email),
+			Country:   u.Country,
+			Role:      u.Role,
+			Version:   u.Version,
+		}
+	}
+	return users, nil
+}
+
+type batchDelete struct {
+	IDs    []string `json:"ids"`
+	Atomic bool     `json:"atomic"`
+}
+
+func (bd *batchDelete) Decode(r *http.Request) error {
+	if err := json.NewDecoder(r.Body).Decode(bd); err != nil {
+		return fmt.Errorf("malformed batch data: %w", err)
+	}
+	return nil
+}
+
+// batchResults per item results in the order of the request items
+type batchResults struct {
+	Results []batchResult `json:"results"`
+}
+
+type batchResult struct {
+	ID    string         `json:"id,omitempty"`
+	User  *User          `json:"user,omitempty"`
+	Error *service.Error `json:"error,omitempty"`
+}
+
+func (br *batchResults) marshal(results []service.BatchResult) {
+	br.Results = make([]batchResult, len(results))
+	for i, r := range results {
+		br.Results[i] = batchResult{ID: r.ID, Error: r.Err}
+		if r.User != nil {
+			br.Results[i].User = &User{}
+			br.Results[i].User.marshal(r.User)
+		}
+	}
+}
+
+func (br *batchResults) WriteTo(w http.ResponseWriter) error {
+	return responseObject(w, http.StatusOK, br)
+}
+
+// exportUsers export request, users are filtered and sorted the way listing does
+type exportUsers struct {
+	Format fileFormat
+	Query  service.ListQuery
+}
+
+func (eu *exportUsers) Decode(r *http.Request, filters service.Filters) error {
+	var err error
+	if eu.Format, err = parseFormat(r.URL.Query().Get("format")); err != nil {
+		return err
+	}
+	np := handlers.NextPage{
+		Filter:   r.URL.Query().Get("filter"),
+		FilterBy: r.URL.Query().Get("filterBy"),
+	}
+	if eu.Query.Filter, err = np.ParseFilter(filters); err != nil {
+		return err
+	}
+	eu.Query.Sort, err = service.ParseSort(r.URL.Query().Get("sort"))
+	return err
+}
+
+// maxImportBytes largest users file accepted
+const maxImportBytes = 64 << 20
+
+// importUsers import request, the file is either the request body or the "file" part of multipart form
+type importUsers struct {
+	Format fileFormat
+	DryRun bool
+	// ErrorsFile respond with the rejected rows in the uploaded format instead of the report
+	ErrorsFile bool
+	Header     []string
+	Rows       []importRow
+}
+
+func (iu *importUsers) Decode(r *http.Request) error {
+	var err error
+	for param, v := range map[string]*bool{"dry_run": &iu.DryRun, "errors_file": &iu.ErrorsFile} {
+		if s := r.URL.Query().Get(param); s != "" {
+			if *v, err = strconv.ParseBool(s); err != nil {
+				return fmt.Errorf("malformed %s", param)
+			}
+		}
+	}
+	mapping, err := parseMapping(r.URL.Query().Get("mapping"))
+	if err != nil {
+		return err
+	}
+
+	r.Body = http.MaxBytesReader(nil, r.Body, maxImportBytes)
+	body, contentType := r.Body, r.Header.Get(HeaderContentType)
+	if strings.HasPrefix(contentType, "multipart/form-data") {
+		if body, contentType, err = uploadedFile(r); err != nil {
+			return err
+		}
+		defer func() { _ = body.Close() }()
+	}
+	if f := r.URL.Query().Get("format"); f != "" {
+		if iu.Format, err = parseFormat(f); err != nil {
+			return err
+		}
+	} else if format, ok := formatOf(contentType); ok {
+		iu.Format = format
+	} else {
+		return fmt.Errorf("format is mandatory unless content type is text/csv or application/x-ndjson")
+	}
+
+	iu.Header, iu.Rows, err = readUsers(body, iu.Format, mapping)
+	return err
+}
+
+// uploadedFile "file" part of the multipart form, along with its content type
+func uploadedFile(r *http.Request) (io.ReadCloser, string, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, "", fmt.Errorf("malformed multipart form: %w", err)
+	}
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			return nil, "", fmt.Errorf("file part is mandatory: %w", err)
+		}
+		if part.FormName() == "file" {
+			return part, part.Header.Get(HeaderContentType), nil
+		}
+		_ = part.Close()
+	}
+}
+
+// Users rows parsed into users, along with their positions
+func (iu *importUsers) Users() ([]*service.User, []int) {
+	users := make([]*service.User, 0, len(iu.Rows))
+	positions := make([]int, 0, len(iu.Rows))
+	for i, row := range iu.Rows {
+		if row.Err == nil {
+			users = append(users, row.User)
+			positions = append(positions, i)
+		}
+	}
+	return users, positions
+}
+
+// importReport outcome of every uploaded row, accepted rows are created or would be created on dry run
+type importReport struct {
+	DryRun   bool              `json:"dry_run"`
+	Total    int               `json:"total"`
+	Accepted int               `json:"accepted"`
+	Rejected int               `json:"rejected"`
+	Rows     []importRowResult `json:"rows"`
+
+	format  fileFormat
+	header  []string
+	rows    []importRow
+	reasons map[int]string
+	// errorsFile rejected rows are written instead of the report
+	errorsFile bool
+}
+
+type importRowResult struct {
+	Line      int            `json:"line"`
+	ID        string         `json:"id,omitempty"`
+	Error     *service.Error `json:"error,omitempty"`
+	Conflicts []conflict     `json:"conflicts,omitempty"`
+}
+
+// conflict email or nickname taken by an existing user or earlier line of the file
+type conflict struct {
+	Field  string `json:"field"`
+	Value  string `json:"value"`
+	UserID string `json:"user_id,omitempty"`
+	Line   int    `json:"line,omitempty"`
+}
+
+func (ir *importReport) marshal(iu *importUsers, positions []int, results []service.ImportResult) {
+	ir.DryRun = iu.DryRun
+	ir.Total = len(iu.Rows)
+	ir.Rows = make([]importRowResult, len(iu.Rows))
+	ir.format, ir.header, ir.rows, ir.errorsFile = iu.Format, iu.Header, iu.Rows, iu.ErrorsFile
+	ir.reasons = make(map[int]string)
+	for i, row := range iu.Rows {
+		ir.Rows[i].Line = row.Line
+		if row.Err != nil {
+			ir.Rows[i].Error = &service.Error{Code: service.ErrCodeBadRequest, Message: row.Err.Error()}
+			ir.reasons[i] = row.Err.Error()
+		}
+	}
+	for j, res := range results {
+		i := positions[j]
+		ir.Rows[i].ID = res.ID
+		ir.Rows[i].Error = res.Err
+		reasons := make([]string, 0, len(res.Conflicts))
+		for _, c := range res.Conflicts {
+			cf := conflict{Field: c.Field, Value: c.Value, UserID: c.UserID}
+			taken := "user " + c.UserID
+			if c.Item >= 0 {
+				cf.Line = iu.Rows[positions[c.Item]].Line
+				taken = "line " + strconv.Itoa(cf.Line)
+			}
+			ir.Rows[i].Conflicts = append(ir.Rows[i].Conflicts, cf)
+			reasons = append(reasons, fmt.Sprintf("%s '%s' is taken by %s", c.Field, c.Value, taken))
+		}
+		if res.Err != nil {
+			ir.reasons[i] = res.Err.Message
+			if len(reasons) > 0 {
+				ir.reasons[i] = strings.Join(reasons, "; ")
+			}
+		}
+	}
+	ir.Rejected = len(ir.reasons)
+	ir.Accepted = ir.Total - ir.Rejected
+}
+
+func (ir *importReport) WriteTo(w http.ResponseWriter) error {
+	if !ir.errorsFile {
+		return responseObject(w, http.StatusOK, ir)
+	}
+	w.Header().Set(HeaderContentType, ir.format.contentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="rejected.%s"`, ir.format))
+	w.WriteHeader(http.StatusOK)
+	return writeRejected(w, ir.format, ir.header, ir.rows, ir.reasons)
+}
+
+func nextPage(r *http.Request, pages *handlers.PageTokens) (*handlers.NextPage, error) {
+	var includeTotal bool
+	if v := r.URL.Query().Get("include_total"); v != "" {
+		var err error
+		if includeTotal, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("malformed include_total")
+		}
+	}
+	return pages.LoadNextPage(r.URL.Query().Get("next_page"),
+		handlers.NextPage{
+			Filter:       r.URL.Query().Get("filter"),
+			FilterBy:     r.URL.Query().Get("filterBy"),
+			Sort:         r.URL.Query().Get("sort"),
+			IncludeTotal: includeTotal,
+		},
 		func() (int, error) {
 			if r.URL.Query().Get("pagination") != "" {
 				p, err := strconv.ParseInt(r.URL.Query().Get("pagination"), 10, 64)
@@ -1,12 +1,16 @@
 package http
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"strconv"
 
+	"github.com/BorisRostovskiy/ESL/internal/auth"
 	"github.com/BorisRostovskiy/ESL/internal/handlers"
 	"github.com/BorisRostovskiy/ESL/internal/log"
+	"github.com/BorisRostovskiy/ESL/internal/service"
+	"github.com/BorisRostovskiy/ESL/internal/tokens"
 	health "github.com/hellofresh/health-go/v5"
 
 	"github.com/go-chi/chi/v5"
@@ -17,6 +21,10 @@ import (
 const (
 	HeaderContentType   = "Content-Type"
 	HeaderContentLength = "Content-Length"
+	HeaderLastEventID   = "Last-Event-ID"
+	HeaderETag          = "ETag"
+	HeaderIfMatch       = "If-Match"
+	HeaderRequestID     = "X-Request-Id"
 )
 
 type response interface {
@@ -24,14 +32,23 @@ type response interface {
 }
 
 type handler struct {
-	log *logrus.Logger
-	api handlers.UsersService
+	log   *logrus.Logger
+	api   handlers.UsersService
+	keys  *tokens.Issuer
+	authn *auth.Authenticator
+	pages *handlers.PageTokens
 }
 
-func New(log *logrus.Logger, api handlers.UsersService, h *health.Health) http.Handler {
+// New creates HTTP handler, keys are optional and used to publish JWKS,
+// users API is open to everyone if authenticator is nil
+func New(log *logrus.Logger, api handlers.UsersService, h *health.Health, keys *tokens.Issuer,
+	authn *auth.Authenticator, pages *handlers.PageTokens) http.Handler {
 	return router(&handler{
-		log: log,
-		api: api,
+		log:   log,
+		api:   api,
+		keys:  keys,
+		authn: authn,
+		pages: pages,
 	}, log, h)
 }
 
@@ -48,20 +65,63 @@ func (h handler) respond(w http.ResponseWriter, r response) {
 	}
 }
 
+// requestID stores request details into the context, request id is taken from X-Request-Id header or generated.
+// The id is sent back in the same header
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := handlers.RequestID(r.Header.Get(HeaderRequestID))
+		w.Header().Set(HeaderRequestID, id)
+		// requests are logged along with their id
+		ctx := context.WithValue(r.Context(), middleware.RequestIDKey, id)
+		ctx = service.WithRequest(ctx, &service.Request{Transport: service.TransportHTTP, ID: id})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 func router(h *handler, l *logrus.Logger, hh *health.Health) *chi.Mux {
 	r := chi.NewRouter()
 
+	r.Use(requestID)
 	r.Use(log.LoggerWithLevel("router", l, l.Level))
 	r.Use(middleware.Recoverer)
 
+	if h.keys != nil {
+		r.Get("/.well-known/jwks.json", h.handle(h.jwks))
+	}
+
 	r.Route("/service/v1", func(r chi.Router) {
+		r.Route("/auth", func(r chi.Router) {
+			r.Post("/login", h.handle(h.login))
+			r.Post("/refresh", h.handle(h.refresh))
+		})
+		r.Group(func(r chi.Router) {
+			if h.authn != nil {
+				r.Use(h.authenticate)
+			}
+			r.Post("/users:batchCreate", h.handle(h.batchCreateUsers))
+			r.Post("/users:batchUpdate", h.handle(h.batchUpdateUsers))
+			r.Post("/users:batchDelete", h.handle(h.batchDeleteUsers))
+			r.Get("/users:export", h.exportUsers)
+			r.Post("/users:import", h.handle(h.importUsers))
+		})
 		r.Route("/users", func(r chi.Router) {
+			if h.authn != nil {
+				r.Use(h.authenticate)
+			}
 			r.Get("/", h.handle(h.listUsers))
+			r.Get("/watch", h.watchUsers)
 			r.Post("/", h.handle(h.createUser))
+			r.Get("/email/{email}", h.handle(h.getUser))
+			r.Get("/nickname/{nickname}", h.handle(h.getUser))
+			r.Post("/{uid}:restore", h.handle(h.restoreUser))
+			r.Get("/{uid}:export", h.handle(h.exportUser))
+			r.Post("/{uid}:erase", h.handle(h.eraseUser))
 
 			r.Route("/{uid}", func(r chi.Router) {
+				r.Get("/", h.handle(h.getUser))
 				r.Put("/", h.handle(h.updateUser))
 				r.Delete("/", h.handle(h.deleteUser))
+				r.Get("/history", h.handle(h.userHistory))
 			})
 		})
 		r.Get("/health", hh.HandlerFunc)
@@ -0,0 +1,328 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/BorisRostovskiy/ESL/internal/service"
+)
+
+// fileFormat format of exported and imported users files
+type fileFormat string
+
+const (
+	formatCSV    fileFormat = "csv"
+	formatNDJSON fileFormat = "ndjson"
+
+	// maxImportLine longest NDJSON line accepted
+	maxImportLine = 1 << 20
+)
+
+var (
+	// exportColumns columns of the exported users, in the order of the CSV ones
+	exportColumns = []string{"id", "first_name", "last_name", "nickname", "email", "country", "role",
+		"created_at", "updated_at"}
+	// importFields user fields imported, read from the columns of the same name unless they are mapped
+	importFields = []string{"first_name", "last_name", "nickname", "password", "email", "country", "role"}
+
+	errImportTooLarge = fmt.Errorf("import exceeds %d users", service.MaxImportSize)
+)
+
+func parseFormat(v string) (fileFormat, error) {
+	switch f := fileFormat(strings.ToLower(v)); f {
+	case formatCSV, formatNDJSON:
+		return f, nil
+	}
+	return "", fmt.Errorf("unsupported format '%s', either csv or ndjson expected", v)
+}
+
+// formatOf format of the uploaded file by its content type
+func formatOf(contentType string) (fileFormat, bool) {
+	switch {
+	case strings.HasPrefix(contentType, "text/csv"):
+		return formatCSV, true
+	case strings.HasPrefix(contentType, "application/x-ndjson"), strings.HasPrefix(contentType, "application/jsonl"):
+		return formatNDJSON, true
+	}
+	return "", false
+}
+
+func (f fileFormat) contentType() string {
+	if f == formatNDJSON {
+		return "application/x-ndjson"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// usersWriter writes users one by one, nothing is kept once written
+type usersWriter interface {
+	Write(u *service.User) error
+	Flush() error
+}
+
+func newUsersWriter(w io.Writer, f fileFormat) (usersWriter, error) {
+	if f == formatNDJSON {
+		return &ndjsonUsers{enc: json.NewEncoder(w)}, nil
+	}
+	cw := &csvUsers{w: csv.NewWriter(w)}
+	return cw, cw.w.Write(exportColumns)
+}
+
+type csvUsers struct {
+	w *csv.Writer
+}
+
+func (cw *csvUsers) Write(u *service.User) error {
+	return cw.w.Write([]string{u.ID, u.FirstName, u.LastName, u.NickName, u.Email, u.Country, u.Role,
+		u.CreatedAt.UTC().Format(time.RFC3339), u.UpdatedAt.UTC().Format(time.RFC3339)})
+}
+
+func (cw *csvUsers) Flush() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+type ndjsonUsers struct {
+	enc *json.Encoder
+}
+
+func (nw *ndjsonUsers) Write(u *service.User) error {
+	var out User
+	out.marshal(u)
+	return nw.enc.Encode(out)
+}
+
+func (nw *ndjsonUsers) Flush() error {
+	return nil
+}
+
+// importRow uploaded row, either parsed into user or failed
+type importRow struct {
+	Line int
+	User *service.User
+	Err  error
+	// record CSV record as uploaded, written back to the errors file with the password blanked
+	record []string
+	// object NDJSON line as uploaded without the password, nil if the line is malformed
+	object []byte
+}
+
+// parseMapping parses field:column pairs, e.g. "email:E-mail,nickname:Login"
+func parseMapping(v string) (map[string]string, error) {
+	mapping := make(map[string]string)
+	if strings.TrimSpace(v) == "" {
+		return mapping, nil
+	}
+	for _, pair := range strings.Split(v, ",") {
+		field, column, ok := strings.Cut(pair, ":")
+		field, column = strings.TrimSpace(field), strings.TrimSpace(column)
+		if !ok || column == "" {
+			return nil, fmt.Errorf("malformed mapping '%s', field:column expected", pair)
+		}
+		if !isImportField(field) {
+			return nil, fmt.Errorf("unknown mapping field '%s'", field)
+		}
+		if _, ok = mapping[field]; ok {
+			return nil, fmt.Errorf("field '%s' is mapped twice", field)
+		}
+		mapping[field] = column
+	}
+	return mapping, nil
+}
+
+func isImportField(field string) bool {
+	for _, f := range importFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// column column the field is read from
+func column(mapping map[string]string, field string) string {
+	if c, ok := mapping[field]; ok {
+		return c
+	}
+	return field
+}
+
+// readUsers parses uploaded rows, malformed rows fail on their own
+func readUsers(r io.Reader, f fileFormat, mapping map[string]string) (header []string, rows []importRow, err error) {
+	if f == formatNDJSON {
+		rows, err = readNDJSON(r, mapping)
+		return nil, rows, err
+	}
+	return readCSV(r, mapping)
+}
+
+func readCSV(r io.Reader, mapping map[string]string) ([]string, []importRow, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, nil, fmt.Errorf("empty csv file")
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("malformed csv header: %w", err)
+	}
+	if len(header) > 0 {
+		// spreadsheets tend to save UTF-8 files with BOM
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+	index := make(map[string]int, len(importFields))
+	for _, field := range importFields {
+		for i, h := range header {
+			if strings.EqualFold(strings.TrimSpace(h), column(mapping, field)) {
+				index[field] = i
+				break
+			}
+		}
+		if _, ok := index[field]; !ok && mapping[field] != "" {
+			return nil, nil, fmt.Errorf("column '%s' mapped to '%s' is not found", mapping[field], field)
+		}
+	}
+
+	var rows []importRow
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return header, rows, nil
+		}
+		line, _ := cr.FieldPos(0)
+		if err != nil && !errors.Is(err, csv.ErrFieldCount) {
+			return nil, nil, fmt.Errorf("malformed csv: %w", err)
+		}
+		if len(rows) == service.MaxImportSize {
+			return nil, nil, errImportTooLarge
+		}
+		row := importRow{Line: line, record: record}
+		if err != nil {
+			row.Err = fmt.Errorf("expected %d columns, got %d", len(header), len(record))
+		} else {
+			row.User = importedUser(func(field string) string {
+				if i, ok := index[field]; ok {
+					return record[i]
+				}
+				return ""
+			})
+		}
+		// the errors file must not disclose the password
+		if i, ok := index["password"]; ok && i < len(record) {
+			record[i] = ""
+		}
+		rows = append(rows, row)
+	}
+}
+
+func readNDJSON(r io.Reader, mapping map[string]string) ([]importRow, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxImportLine)
+	var rows []importRow
+	for line := 1; scanner.Scan(); line++ {
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		if len(rows) == service.MaxImportSize {
+			return nil, errImportTooLarge
+		}
+		row := importRow{Line: line}
+		var object map[string]interface{}
+		if err := json.Unmarshal(data, &object); err != nil {
+			row.Err = fmt.Errorf("malformed json: %v", err)
+			rows = append(rows, row)
+			continue
+		}
+		for _, field := range importFields {
+			if v, ok := object[column(mapping, field)]; ok && v != nil {
+				if _, ok = v.(string); !ok {
+					row.Err = fmt.Errorf("'%s' is not a string", column(mapping, field))
+					break
+				}
+			}
+		}
+		if row.Err == nil {
+			row.User = importedUser(func(field string) string {
+				v, _ := object[column(mapping, field)].(string)
+				return v
+			})
+		}
+		// the errors file must not disclose the password
+		delete(object, column(mapping, "password"))
+		row.object, _ = json.Marshal(object)
+		rows = append(rows, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("malformed ndjson: %w", err)
+	}
+	return rows, nil
+}
+
+// importedUser user of the field values, surrounding spaces are trimmed from all but the password
+func importedUser(value func(field string) string) *service.User {
+	trimmed := func(field string) string {
+		return strings.TrimSpace(value(field))
+	}
+	return &service.User{
+		FirstName: trimmed("first_name"),
+		LastName:  trimmed("last_name"),
+		NickName:  trimmed("nickname"),
+		Password:  value("password"),
+		Email:     strings.ToLower(trimmed("email")),
+		Country:   trimmed("country"),
+		Role:      trimmed("role"),
+	}
+}
+
+// writeRejected writes rejected rows as they are uploaded but the password along with the reason they are rejected,
+// as extra error column of CSV or error member of NDJSON objects. Malformed NDJSON lines are written as their line
+// numbers, since the password could not be told apart in them
+func writeRejected(w io.Writer, f fileFormat, header []string, rows []importRow, reasons map[int]string) error {
+	if f == formatNDJSON {
+		enc := json.NewEncoder(w)
+		for i, row := range rows {
+			reason, ok := reasons[i]
+			if !ok {
+				continue
+			}
+			object := map[string]interface{}{"line": row.Line}
+			if row.object != nil {
+				object = map[string]interface{}{}
+				if err := json.Unmarshal(row.object, &object); err != nil {
+					return err
+				}
+			}
+			object["error"] = reason
+			if err := enc.Encode(object); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(append(append([]string(nil), header...), "error")); err != nil {
+		return err
+	}
+	for i, row := range rows {
+		reason, ok := reasons[i]
+		if !ok {
+			continue
+		}
+		// short records are padded, so that reason is in the error column
+		record := make([]string, max(len(header), len(row.record)), max(len(header), len(row.record))+1)
+		copy(record, row.record)
+		if err := cw.Write(append(record, reason)); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
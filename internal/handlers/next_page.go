@@ -1,77 +1,88 @@
 package handlers
 
 import (
-	b64 "encoding/base64"
-	"encoding/json"
 	"fmt"
+	"strconv"
 	"time"
 
 	"github.com/BorisRostovskiy/ESL/internal/service"
 )
 
-const (
-	milliseconds30Minutes = 1800000
-)
-
+// NextPage next_page token content, key of the last user seen along with the listing criteria
 type NextPage struct {
-	Limit    int       `json:"limit"`
-	Offset   int       `json:"offset"`
-	FilterBy string    `json:"filter_by"`
-	Filter   string    `json:"filter"`
-	Time     time.Time `json:"time"`
+	Limit int `json:"limit"`
+	// FilterBy set along with Filter for the legacy single field filter, Filter is an expression otherwise
+	FilterBy string `json:"filter_by"`
+	Filter   string `json:"filter"`
+	Sort     string `json:"sort"`
+	// IncludeTotal totals are returned along with every page
+	IncludeTotal bool `json:"include_total"`
+	// After sort key values of the last user seen
+	After []string `json:"after"`
+	ID    string   `json:"id"`
+	// UserID user the history is of, set for history pages only, ID is the last history entry seen then
+	UserID string `json:"user_id,omitempty"`
+	// ExpiresAt set on sealing
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
-func GenerateNextPage(limit, offset, numUsers int, filter *service.Filter) (string, error) {
-	if (limit > 0 && offset >= 0) && (numUsers-(offset+limit) > 0) {
-		nextPage := NextPage{
-			Offset:   limit,
-			Limit:    limit,
-			Time:     time.Now(),
-			Filter:   "",
-			FilterBy: "",
-		}
-		if filter != nil && filter.IsValid() {
-			nextPage.FilterBy = filter.By.String()
-			nextPage.Filter = filter.Query
-		}
-		var np []byte
-		np, err := json.Marshal(nextPage)
+// Cursor cursor the page starts after, nil for the first page
+func (np *NextPage) Cursor() *service.Cursor {
+	if np.ID == "" {
+		return nil
+	}
+	return &service.Cursor{Values: np.After, ID: np.ID}
+}
 
-		if err != nil {
-			return "", err
-		}
-		return b64.StdEncoding.EncodeToString(np), nil
+// ParseFilter filter of the listing restricted to enabled fields, nil if it is not filtered
+func (np *NextPage) ParseFilter(fs service.Filters) (*service.Filter, error) {
+	switch {
+	case np.FilterBy != "":
+		return fs.NewFilter(np.FilterBy, np.Filter)
+	case np.Filter != "":
+		return fs.ParseFilter(np.Filter)
 	}
-	return "", nil
+	return nil, nil
 }
 
-// LoadNextPage helper function to load and validate next page criteria
-func LoadNextPage(nPage, filter, filterBy string, paginationFn func() (int, error)) (*NextPage, error) {
+// GenerateNextPage seals criteria of the query along with the cursor, empty if there is no next page
+func (pt *PageTokens) GenerateNextPage(q service.ListQuery, next *service.Cursor) (string, error) {
+	if next == nil {
+		return "", nil
+	}
+	nextPage := NextPage{
+		Limit: q.Limit,
+		// filter is kept in its canonical form, legacy filters included
+		Filter:       q.Filter.String(),
+		Sort:         q.Sort.String(),
+		IncludeTotal: q.IncludeTotal,
+		After:        next.Values,
+		ID:           next.ID,
+	}
+	return pt.Seal(&nextPage)
+}
+
+// LoadNextPage helper function to load and validate next page criteria,
+// criteria of the first page (filter, filterBy, sort and include total) are taken from the request ones.
+// History tokens are refused
+func (pt *PageTokens) LoadNextPage(nPage string, request NextPage,
+	paginationFn func() (int, error)) (*NextPage, error) {
 	np := &NextPage{
 		Limit: -1,
 	}
 
-	// ignore other parameters if next_page is loaded, and it was made less than 30 minutes ago
+	// ignore other parameters if next_page is loaded, it carries the criteria of the first page
 	if nPage != "" {
-		sDec, err := b64.StdEncoding.DecodeString(nPage)
+		loaded, err := pt.Open(nPage)
 		if err != nil {
-			return nil, fmt.Errorf("could not decode next_page argument: %w", err)
+			return nil, err
 		}
-
-		if err = json.Unmarshal(sDec, np); err != nil {
-			return nil, fmt.Errorf("could not unmarshal limit offset: %w", err)
-		}
-
-		// if next_page were build more than 30 minutes ago, let's consider it as an expired one
-		if time.Since(np.Time).Milliseconds() < milliseconds30Minutes {
-			return np, nil
+		if loaded.UserID != "" {
+			return nil, ErrPageTokenInvalid
 		}
+		return loaded, nil
 	}
 
-	// if we are here, then either next page was not used, or it was expired.
-	np.Limit = -1
-	np.Offset = 0
-
 	pagination, err := paginationFn()
 	if err != nil {
 		return nil, err
@@ -80,13 +91,42 @@ func LoadNextPage(nPage, filter, filterBy string, paginationFn func() (int, erro
 	if pagination > 0 && pagination != np.Limit {
 		np.Limit = pagination
 	}
-	if (filter == "" && filterBy != "") || (filter != "" && filterBy == "") {
-		return nil, fmt.Errorf("parameters filter and filterBy should be used together")
-	}
-	if filter != "" && filterBy != "" {
-		np.Filter = filter
-		np.FilterBy = filterBy
+	if request.Filter == "" && request.FilterBy != "" {
+		return nil, fmt.Errorf("parameter filterBy should be used along with filter")
 	}
+	np.Filter = request.Filter
+	np.FilterBy = request.FilterBy
+	np.Sort = request.Sort
+	np.IncludeTotal = request.IncludeTotal
 
 	return np, nil
 }
+
+// GenerateHistoryPage seals the user history cursor, empty if there is no next page
+func (pt *PageTokens) GenerateHistoryPage(q service.HistoryQuery, next int64) (string, error) {
+	if next == 0 {
+		return "", nil
+	}
+	return pt.Seal(&NextPage{Limit: q.Limit, UserID: q.UserID, ID: strconv.FormatInt(next, 10)})
+}
+
+// LoadHistoryPage history query of the user, the page starts after the next_page cursor if it is set
+func (pt *PageTokens) LoadHistoryPage(userID, nPage string, limit int) (service.HistoryQuery, error) {
+	q := service.HistoryQuery{UserID: userID, Limit: limit}
+	if nPage == "" {
+		return q, nil
+	}
+	np, err := pt.Open(nPage)
+	if err != nil {
+		return q, err
+	}
+	// listing tokens and history tokens of other users are refused
+	if np.UserID == "" || np.UserID != userID {
+		return q, ErrPageTokenInvalid
+	}
+	if q.Before, err = strconv.ParseInt(np.ID, 10, 64); err != nil {
+		return q, ErrPageTokenInvalid
+	}
+	q.Limit = np.Limit
+	return q, nil
+}
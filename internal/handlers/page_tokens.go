@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	b64 "encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPageTokenTTL = 30 * time.Minute
+	pageTokenKeySize    = 32
+)
+
+var (
+	// ErrPageTokenInvalid causes when next_page token is malformed, tampered or sealed with unknown key
+	ErrPageTokenInvalid = errors.New("next_page token is invalid")
+	// ErrPageTokenExpired causes when next_page token is older than its TTL
+	ErrPageTokenExpired = errors.New("next_page token has expired")
+)
+
+type (
+	// PageTokenConfig keys next_page tokens are sealed with
+	PageTokenConfig struct {
+		// KeyID identifies the key new tokens are sealed with
+		KeyID string `yaml:"key_id"`
+		// KeyFile hex encoded 256 bits AES key, e.g. `openssl rand -hex 32`
+		KeyFile string `yaml:"key_file"`
+		// PreviousKeyFiles previous keys (key id -> key file) tokens are still opened with during rotation
+		PreviousKeyFiles map[string]string `yaml:"previous_key_files"`
+		TTL              time.Duration     `yaml:"ttl"`
+	}
+
+	// PageTokens seals next_page tokens with AES-GCM, so that clients could neither read nor forge them
+	PageTokens struct {
+		keyID string
+		keys  map[string]cipher.AEAD
+		ttl   time.Duration
+	}
+)
+
+// NewPageTokens loads keys, random key is generated if none is configured,
+// tokens are valid within the process then
+func NewPageTokens(cfg PageTokenConfig) (*PageTokens, error) {
+	pt := &PageTokens{keyID: cfg.KeyID, keys: make(map[string]cipher.AEAD), ttl: cfg.TTL}
+	if pt.ttl <= 0 {
+		pt.ttl = defaultPageTokenTTL
+	}
+
+	if cfg.KeyFile == "" {
+		key := make([]byte, pageTokenKeySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("could not generate page token key: %w", err)
+		}
+		pt.keyID = "ephemeral"
+		return pt, pt.addKey(pt.keyID, key)
+	}
+
+	if cfg.KeyID == "" {
+		return nil, fmt.Errorf("page token key_id is mandatory")
+	}
+	files := map[string]string{cfg.KeyID: cfg.KeyFile}
+	for kid, file := range cfg.PreviousKeyFiles {
+		if kid == cfg.KeyID {
+			return nil, fmt.Errorf("page token key '%s' is both current and previous", kid)
+		}
+		files[kid] = file
+	}
+	for kid, file := range files {
+		key, err := loadPageTokenKey(file)
+		if err != nil {
+			return nil, err
+		}
+		if err = pt.addKey(kid, key); err != nil {
+			return nil, err
+		}
+	}
+	return pt, nil
+}
+
+// Seal encrypts next page along with its expiration time, token is `<key id>.<base64url(nonce|ciphertext)>`
+func (pt *PageTokens) Seal(np *NextPage) (string, error) {
+	sealed := *np
+	sealed.ExpiresAt = time.Now().Add(pt.ttl).UTC()
+	plain, err := json.Marshal(sealed)
+	if err != nil {
+		return "", err
+	}
+
+	aead := pt.keys[pt.keyID]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err = rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("could not generate nonce: %w", err)
+	}
+	data := aead.Seal(nonce, nonce, plain, []byte(pt.keyID))
+	return pt.keyID + "." + b64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// Open decrypts and verifies token, expired tokens are rejected
+func (pt *PageTokens) Open(token string) (*NextPage, error) {
+	kid, encoded, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrPageTokenInvalid
+	}
+	aead, ok := pt.keys[kid]
+	if !ok {
+		return nil, ErrPageTokenInvalid
+	}
+	data, err := b64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(data) < aead.NonceSize() {
+		return nil, ErrPageTokenInvalid
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(kid))
+	if err != nil {
+		return nil, ErrPageTokenInvalid
+	}
+
+	np := &NextPage{}
+	if err = json.Unmarshal(plain, np); err != nil {
+		return nil, ErrPageTokenInvalid
+	}
+	if !time.Now().Before(np.ExpiresAt) {
+		return nil, ErrPageTokenExpired
+	}
+	return np, nil
+}
+
+func (pt *PageTokens) addKey(kid string, key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return fmt.Errorf("page token key '%s': %w", kid, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return fmt.Errorf("page token key '%s': %w", kid, err)
+	}
+	pt.keys[kid] = aead
+	return nil
+}
+
+func loadPageTokenKey(file string) ([]byte, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read page token key: %w", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("page token key '%s' is not hex encoded: %w", file, err)
+	}
+	if len(key) != pageTokenKeySize {
+		return nil, fmt.Errorf("page token key '%s' must be %d bytes", file, pageTokenKeySize)
+	}
+	return key, nil
+}
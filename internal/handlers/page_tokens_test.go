@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/BorisRostovskiy/ESL/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeKey(t *testing.T) string {
+	t.Helper()
+	key := make([]byte, pageTokenKeySize)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	file := filepath.Join(t.TempDir(), "page.key")
+	require.NoError(t, os.WriteFile(file, []byte(hex.EncodeToString(key)+"\n"), 0o600))
+	return file
+}
+
+func TestPageTokens_SealOpen(t *testing.T) {
+	t.Parallel()
+	pt, err := NewPageTokens(PageTokenConfig{KeyID: "k1", KeyFile: writeKey(t)})
+	require.NoError(t, err)
+
+	np := &NextPage{Limit: 10, Filter: "country = NL", Sort: "-updated_at,last_name",
+		After: []string{"2024-01-01T00:00:00.000000000Z", "Doe"}, ID: "user"}
+	token, err := pt.Seal(np)
+	require.NoError(t, err)
+	assert.NotContains(t, token, "country", "content must not be readable")
+
+	opened, err := pt.Open(token)
+	require.NoError(t, err)
+	assert.Equal(t, np.Limit, opened.Limit)
+	assert.Equal(t, np.Filter, opened.Filter)
+	assert.Equal(t, np.Sort, opened.Sort)
+	assert.Equal(t, np.ID, opened.ID)
+	assert.Equal(t, np.After, opened.After)
+
+	for name, forged := range map[string]string{
+		"empty":         "",
+		"no key id":     token[3:],
+		"unknown key":   "k2" + token[2:],
+		"not base64":    "k1.@@@",
+		"short":         "k1.AAAA",
+		"truncated":     token[:len(token)-1],
+		"plain payload": "k1.eyJsaW1pdCI6MTAwMH0",
+	} {
+		_, err = pt.Open(forged)
+		assert.ErrorIs(t, err, ErrPageTokenInvalid, name)
+	}
+}
+
+func TestPageTokens_Swapped(t *testing.T) {
+	t.Parallel()
+	pt, err := NewPageTokens(PageTokenConfig{KeyID: "k1", KeyFile: writeKey(t)})
+	require.NoError(t, err)
+	noPagination := func() (int, error) { return 0, nil }
+
+	list, err := pt.GenerateNextPage(service.ListQuery{Limit: 10}, &service.Cursor{ID: "user"})
+	require.NoError(t, err)
+	history, err := pt.GenerateHistoryPage(service.HistoryQuery{UserID: "user1", Limit: 10}, 42)
+	require.NoError(t, err)
+
+	np, err := pt.LoadNextPage(list, NextPage{}, noPagination)
+	require.NoError(t, err)
+	assert.Equal(t, "user", np.ID)
+	q, err := pt.LoadHistoryPage("user1", history, 20)
+	require.NoError(t, err)
+	assert.EqualValues(t, 42, q.Before)
+
+	_, err = pt.LoadNextPage(history, NextPage{}, noPagination)
+	assert.ErrorIs(t, err, ErrPageTokenInvalid, "history token must not list users")
+	_, err = pt.LoadHistoryPage("user1", list, 20)
+	assert.ErrorIs(t, err, ErrPageTokenInvalid, "listing token must not page history")
+	_, err = pt.LoadHistoryPage("user2", history, 20)
+	assert.ErrorIs(t, err, ErrPageTokenInvalid, "history token of another user")
+}
+
+func TestPageTokens_Expired(t *testing.T) {
+	t.Parallel()
+	pt, err := NewPageTokens(PageTokenConfig{TTL: time.Millisecond})
+	require.NoError(t, err)
+
+	token, err := pt.Seal(&NextPage{Limit: 1, ID: "user"})
+	require.NoError(t, err)
+	time.Sleep(2 * time.Millisecond)
+	_, err = pt.Open(token)
+	assert.ErrorIs(t, err, ErrPageTokenExpired)
+}
+
+func TestPageTokens_Rotation(t *testing.T) {
+	t.Parallel()
+	oldKey, newKey := writeKey(t), writeKey(t)
+
+	before, err := NewPageTokens(PageTokenConfig{KeyID: "k1", KeyFile: oldKey})
+	require.NoError(t, err)
+	token, err := before.Seal(&NextPage{Limit: 1, ID: "user"})
+	require.NoError(t, err)
+
+	rotated, err := NewPageTokens(PageTokenConfig{KeyID: "k2", KeyFile: newKey,
+		PreviousKeyFiles: map[string]string{"k1": oldKey}})
+	require.NoError(t, err)
+	np, err := rotated.Open(token)
+	require.NoError(t, err, "tokens sealed with the previous key are still accepted")
+	assert.Equal(t, "user", np.ID)
+
+	token, err = rotated.Seal(np)
+	require.NoError(t, err)
+	assert.Regexp(t, `^k2\.`, token)
+	_, err = before.Open(token)
+	assert.ErrorIs(t, err, ErrPageTokenInvalid)
+
+	// the key id is authenticated, the same ciphertext under another id is rejected
+	sameKeys, err := NewPageTokens(PageTokenConfig{KeyID: "k3", KeyFile: newKey})
+	require.NoError(t, err)
+	_, err = sameKeys.Open("k3" + token[2:])
+	assert.ErrorIs(t, err, ErrPageTokenInvalid)
+}
+
+func TestNewPageTokens_Errors(t *testing.T) {
+	t.Parallel()
+	short := filepath.Join(t.TempDir(), "short.key")
+	require.NoError(t, os.WriteFile(short, []byte("00ff"), 0o600))
+
+	_, err := NewPageTokens(PageTokenConfig{KeyFile: writeKey(t)})
+	assert.EqualError(t, err, "page token key_id is mandatory")
+	_, err = NewPageTokens(PageTokenConfig{KeyID: "k1", KeyFile: short})
+	assert.EqualError(t, err, "page token key '"+short+"' must be 32 bytes")
+}
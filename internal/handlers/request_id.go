@@ -0,0 +1,20 @@
+package handlers
+
+import "github.com/google/uuid"
+
+// maxRequestIDLength longest request id accepted from clients
+const maxRequestIDLength = 128
+
+// RequestID request id passed by the client, a new one is generated if it is missing, too long
+// or not printable ASCII
+func RequestID(passed string) string {
+	if passed == "" || len(passed) > maxRequestIDLength {
+		return uuid.New().String()
+	}
+	for i := 0; i < len(passed); i++ {
+		if passed[i] < ' ' || passed[i] > '~' {
+			return uuid.New().String()
+		}
+	}
+	return passed
+}
@@ -9,6 +9,13 @@ var (
 	NoUsersFoundError = fmt.Errorf("no users found")
 	// DuplicateKeyError causes when Create or Update performed on already created items
 	DuplicateKeyError = fmt.Errorf("duplicate key value violates unique constraint")
+	// VersionMismatchError causes when updated user is not of the expected version anymore
+	VersionMismatchError = fmt.Errorf("user version mismatch")
 	// HashingPwdError causes when user password could not been hashed
 	GeneratePwdError = fmt.Errorf("could not generate hashed user password")
+	// TokenNotFoundError causes when refresh token is unknown or expired
+	TokenNotFoundError = fmt.Errorf("refresh token not found")
+	// TokenReusedError causes when already rotated refresh token is presented again,
+	// the whole token family is revoked then
+	TokenReusedError = fmt.Errorf("refresh token reused")
 )
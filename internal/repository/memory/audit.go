@@ -0,0 +1,34 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/BorisRostovskiy/ESL/internal/service"
+)
+
+// AddAuditEntry appends entry with the next sequential ID
+func (r *Repo) AddAuditEntry(_ context.Context, e *service.AuditEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e.ID = int64(len(r.audit) + 1)
+	e.CreatedAt = time.Now().UTC()
+	r.audit = append(r.audit, *e)
+	return nil
+}
+
+// UserHistory returns up to limit entries of the user preceding the entry with id before, newest first
+func (r *Repo) UserHistory(_ context.Context, userID string, before int64, limit int) ([]service.AuditEntry, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entries := make([]service.AuditEntry, 0)
+	for i := len(r.audit) - 1; i >= 0 && len(entries) < limit; i-- {
+		e := r.audit[i]
+		if e.UserID == userID && (before == 0 || e.ID < before) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
@@ -0,0 +1,45 @@
+package memory
+
+import (
+	"context"
+	"testing"
+
+	"github.com/BorisRostovskiy/ESL/internal/service"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepo_UserHistory(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	repo := New(logrus.New())
+
+	for _, userID := range []string{"first", "second", "first", "first"} {
+		require.NoError(t, repo.AddAuditEntry(ctx, &service.AuditEntry{UserID: userID, Action: service.AuditUpdated}))
+	}
+
+	entries, err := repo.UserHistory(ctx, "first", 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{4, 3, 1}, auditIDs(entries), "newest entries go first")
+
+	entries, err = repo.UserHistory(ctx, "first", 0, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{4, 3}, auditIDs(entries))
+
+	entries, err = repo.UserHistory(ctx, "first", 3, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{1}, auditIDs(entries), "page continues after the entry given")
+
+	entries, err = repo.UserHistory(ctx, "unknown", 0, 2)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func auditIDs(entries []service.AuditEntry) []int64 {
+	ids := make([]int64, len(entries))
+	for i, e := range entries {
+		ids[i] = e.ID
+	}
+	return ids
+}
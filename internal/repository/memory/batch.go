@@ -0,0 +1,106 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/BorisRostovskiy/ESL/internal/repository"
+	"github.com/BorisRostovskiy/ESL/internal/service"
+	"github.com/google/uuid"
+)
+
+// CreateUsers creates users skipping the ones conflicting with the existing users or earlier users of the batch.
+// Returns which users are created, nothing is created if atomic and some are skipped
+func (r *Repo) CreateUsers(_ context.Context, users []*service.User, atomic bool) ([]bool, error) {
+	passwords := make([]string, len(users))
+	for i, u := range users {
+		passwords[i] = u.Password
+	}
+	hashed, err := repository.HashPasswords(passwords)
+	if err != nil {
+		r.log.Errorf("generate pwd error: %v", err)
+		return nil, repository.GeneratePwdError
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	created := make([]bool, len(users))
+	emails := make(map[string]bool, len(users))
+	nicknames := make(map[string]bool, len(users))
+	skipped := false
+	for i, u := range users {
+		if r.isTaken("", u.Email, u.NickName) || emails[u.Email] || nicknames[u.NickName] {
+			skipped = true
+			continue
+		}
+		emails[u.Email], nicknames[u.NickName] = true, true
+		created[i] = true
+	}
+	if atomic && skipped {
+		return created, repository.DuplicateKeyError
+	}
+
+	now := time.Now()
+	for i, u := range users {
+		if !created[i] {
+			continue
+		}
+		u.ID = uuid.New().String()
+		u.CreatedAt = now
+		u.UpdatedAt = now
+		u.Version = 1
+		stored := *u
+		stored.Password = hashed[i]
+		r.store(stored)
+	}
+	return created, nil
+}
+
+// UpdateUsers updates all the users or none of them, returns index of the user failed the batch
+// along with the error, -1 if none failed
+func (r *Repo) UpdateUsers(_ context.Context, users []*service.User) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	previous := make([]service.User, 0, len(users))
+	for i, u := range users {
+		existed := r.users[u.ID]
+		if err := r.update(u); err != nil {
+			// updates applied so far are reverted in reverse order, versions of the users included
+			for j := len(previous) - 1; j >= 0; j-- {
+				r.remove(r.users[previous[j].ID])
+				r.store(previous[j])
+				users[j].Version = previous[j].Version
+			}
+			return i, err
+		}
+		previous = append(previous, existed)
+	}
+	return -1, nil
+}
+
+// DeleteUsers soft-deletes users by ids, returns the deleted ones. Nothing is deleted if atomic and some of
+// the users are not found, the ones found are returned along with the error
+func (r *Repo) DeleteUsers(_ context.Context, ids []string, atomic bool) ([]service.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deleted := make([]service.User, 0, len(ids))
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if u, ok := r.users[id]; ok && !seen[id] {
+			seen[id] = true
+			u.Password = ""
+			deleted = append(deleted, u)
+		}
+	}
+	if atomic && len(deleted) < len(ids) {
+		return deleted, repository.NoUsersFoundError
+	}
+	now := time.Now()
+	for _, u := range deleted {
+		r.softDelete(r.users[u.ID], now)
+	}
+	return deleted, nil
+}
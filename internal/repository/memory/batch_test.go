@@ -0,0 +1,89 @@
+package memory
+
+import (
+	"context"
+	"testing"
+
+	"github.com/BorisRostovskiy/ESL/internal/repository"
+	"github.com/BorisRostovskiy/ESL/internal/service"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepo_CreateUsers(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	repo := New(logrus.New())
+	_, err := repo.CreateUser(ctx, newUser("user1", "user1@gmail.com", "NL"))
+	require.NoError(t, err)
+
+	batch := func() []*service.User {
+		return []*service.User{
+			newUser("user2", "user2@gmail.com", "NL"),
+			newUser("user1", "other@gmail.com", "NL"),
+			newUser("user3", "user2@gmail.com", "DE"),
+			newUser("user4", "user4@gmail.com", "DE"),
+		}
+	}
+
+	created, err := repo.CreateUsers(ctx, batch(), true)
+	assert.ErrorIs(t, err, repository.DuplicateKeyError)
+	assert.Equal(t, []bool{true, false, false, true}, created)
+	all, err := repo.ListUsers(ctx, service.ListQuery{})
+	require.NoError(t, err)
+	assert.Len(t, all, 1, "nothing is created if atomic")
+
+	users := batch()
+	created, err = repo.CreateUsers(ctx, users, false)
+	require.NoError(t, err)
+	assert.Equal(t, []bool{true, false, false, true}, created)
+	assert.NotEmpty(t, users[0].ID)
+	assert.Empty(t, users[1].ID)
+	stored, err := repo.GetCredentials(ctx, "user4")
+	require.NoError(t, err)
+	assert.NotEqual(t, users[3].Password, stored.Password, "password is hashed")
+}
+
+func TestRepo_UpdateDeleteUsers(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	repo := New(logrus.New())
+	u1, err := repo.CreateUser(ctx, newUser("user1", "user1@gmail.com", "NL"))
+	require.NoError(t, err)
+	u2, err := repo.CreateUser(ctx, newUser("user2", "user2@gmail.com", "DE"))
+	require.NoError(t, err)
+
+	renamed := *u1
+	renamed.FirstName = "Renamed"
+	taken := *u2
+	taken.Email = u1.Email
+	failed, err := repo.UpdateUsers(ctx, []*service.User{&renamed, &taken})
+	assert.ErrorIs(t, err, repository.DuplicateKeyError)
+	assert.Equal(t, 1, failed)
+	got, err := repo.GetUser(ctx, u1.ID)
+	require.NoError(t, err)
+	assert.Equal(t, u1.FirstName, got.FirstName, "applied updates are reverted")
+
+	moved := *u2
+	moved.Country = "NL"
+	failed, err = repo.UpdateUsers(ctx, []*service.User{&renamed, &moved})
+	require.NoError(t, err)
+	assert.Equal(t, -1, failed)
+	got, err = repo.GetUser(ctx, u1.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Renamed", got.FirstName)
+
+	deleted, err := repo.DeleteUsers(ctx, []string{u1.ID, "unknown"}, true)
+	assert.ErrorIs(t, err, repository.NoUsersFoundError)
+	require.Len(t, deleted, 1)
+	_, err = repo.GetUser(ctx, u1.ID)
+	require.NoError(t, err, "nothing is deleted if atomic")
+
+	deleted, err = repo.DeleteUsers(ctx, []string{u1.ID, "unknown", u2.ID}, false)
+	require.NoError(t, err)
+	require.Len(t, deleted, 2)
+	assert.Equal(t, "NL", deleted[1].Country)
+	_, err = repo.GetUser(ctx, u2.ID)
+	assert.ErrorIs(t, err, repository.NoUsersFoundError)
+}
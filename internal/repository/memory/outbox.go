@@ -0,0 +1,159 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/BorisRostovskiy/ESL/internal/service"
+)
+
+type outboxEvent struct {
+	service.OutboxEvent
+	nextAttemptAt time.Time
+	lastError     string
+	deliveredAt   time.Time
+	failedAt      time.Time
+	deliveries    []service.SubscriberDelivery
+}
+
+func (e *outboxEvent) pending() bool {
+	return e.deliveredAt.IsZero() && e.failedAt.IsZero()
+}
+
+// RunInTx calls fn as is, memory storage has no transactions and every change is applied immediately
+func (r *Repo) RunInTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+// AddEvent stores event with the next sequential ID
+func (r *Repo) AddEvent(_ context.Context, e *service.OutboxEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e.ID = int64(len(r.outbox) + 1)
+	e.CreatedAt = time.Now().UTC()
+	r.outbox = append(r.outbox, &outboxEvent{OutboxEvent: *e, nextAttemptAt: e.CreatedAt})
+	return nil
+}
+
+// ClaimEvents returns events due for delivery ordered by id, skipping users whose earlier events are
+// waiting for retry or claimed. Returned events are not due till `until`
+func (r *Repo) ClaimEvents(_ context.Context, limit int, until time.Time) ([]service.OutboxEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	waiting := make(map[string]bool)
+	events := make([]service.OutboxEvent, 0)
+	for _, e := range r.outbox {
+		if len(events) == limit {
+			break
+		}
+		if !e.pending() || waiting[e.UserID] {
+			continue
+		}
+		if e.nextAttemptAt.After(now) {
+			waiting[e.UserID] = true
+			continue
+		}
+		e.nextAttemptAt = until
+		events = append(events, e.OutboxEvent)
+	}
+	return events, nil
+}
+
+// MarkDelivered records successful delivery
+func (r *Repo) MarkDelivered(_ context.Context, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if e := r.outboxEvent(id); e != nil {
+		e.deliveredAt = time.Now().UTC()
+	}
+	return nil
+}
+
+// MarkAttemptFailed records failed attempt, event is parked for good if retryAt is nil
+func (r *Repo) MarkAttemptFailed(_ context.Context, id int64, reason string, retryAt *time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e := r.outboxEvent(id)
+	if e == nil {
+		return nil
+	}
+	e.Attempts++
+	e.lastError = reason
+	if retryAt == nil {
+		e.failedAt = time.Now().UTC()
+	} else {
+		e.nextAttemptAt = *retryAt
+	}
+	return nil
+}
+
+// RenewClaim extends claim of the event till `until` if it is still claimed till `claimed`,
+// returns false if the claim has been taken over or the event is not pending anymore
+func (r *Repo) RenewClaim(_ context.Context, id int64, claimed, until time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e := r.outboxEvent(id)
+	if e == nil || !e.pending() || !e.nextAttemptAt.Equal(claimed) {
+		return false, nil
+	}
+	e.nextAttemptAt = until
+	return true, nil
+}
+
+// ReleaseEvents makes events still claimed till `claimed` due right away
+func (r *Repo) ReleaseEvents(_ context.Context, ids []int64, claimed time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for _, id := range ids {
+		if e := r.outboxEvent(id); e != nil && e.pending() && e.nextAttemptAt.Equal(claimed) {
+			e.nextAttemptAt = now
+		}
+	}
+	return nil
+}
+
+// AddSubscriberDelivery records that subscriber is done with the event, recording it once again changes nothing
+func (r *Repo) AddSubscriberDelivery(_ context.Context, d *service.SubscriberDelivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e := r.outboxEvent(d.EventID)
+	if e == nil {
+		return nil
+	}
+	for _, done := range e.deliveries {
+		if done.Subscriber == d.Subscriber {
+			return nil
+		}
+	}
+	d.CreatedAt = time.Now().UTC()
+	e.deliveries = append(e.deliveries, *d)
+	return nil
+}
+
+// SubscriberDeliveries returns subscribers done with the event
+func (r *Repo) SubscriberDeliveries(_ context.Context, eventID int64) ([]service.SubscriberDelivery, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	deliveries := make([]service.SubscriberDelivery, 0)
+	if e := r.outboxEvent(eventID); e != nil {
+		deliveries = append(deliveries, e.deliveries...)
+	}
+	return deliveries, nil
+}
+
+func (r *Repo) outboxEvent(id int64) *outboxEvent {
+	if id < 1 || id > int64(len(r.outbox)) {
+		return nil
+	}
+	return r.outbox[id-1]
+}
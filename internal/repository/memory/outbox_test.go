@@ -0,0 +1,100 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/BorisRostovskiy/ESL/internal/service"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepo_ClaimEvents(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	repo := New(logrus.New())
+
+	for _, userID := range []string{"first", "second", "first", "second", "third"} {
+		require.NoError(t, repo.AddEvent(ctx, &service.OutboxEvent{UserID: userID, Channel: "create", Payload: userID}))
+	}
+	claim := time.Now().Add(time.Minute)
+
+	events, err := repo.ClaimEvents(ctx, 2, claim)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{1, 2}, eventIDs(events))
+
+	// claimed events hold their users back
+	events, err = repo.ClaimEvents(ctx, 10, claim)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{5}, eventIDs(events))
+
+	// delivered events are gone, events after the one waiting for retry are held back
+	require.NoError(t, repo.MarkDelivered(ctx, 1))
+	retryAt := time.Now().Add(time.Hour)
+	require.NoError(t, repo.MarkAttemptFailed(ctx, 2, "unavailable", &retryAt))
+	events, err = repo.ClaimEvents(ctx, 10, claim)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{3}, eventIDs(events))
+
+	// claim is renewed by its holder only
+	renewed, err := repo.RenewClaim(ctx, 3, claim, claim.Add(time.Minute))
+	require.NoError(t, err)
+	assert.True(t, renewed)
+	renewed, err = repo.RenewClaim(ctx, 3, claim, claim.Add(time.Minute))
+	require.NoError(t, err)
+	assert.False(t, renewed, "claim has been renewed already")
+	renewed, err = repo.RenewClaim(ctx, 1, claim, claim.Add(time.Minute))
+	require.NoError(t, err)
+	assert.False(t, renewed, "delivered event is not claimed")
+
+	// released events are due right away, unless their claim has changed
+	require.NoError(t, repo.ReleaseEvents(ctx, []int64{3, 5}, claim))
+	events, err = repo.ClaimEvents(ctx, 10, claim)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{5}, eventIDs(events))
+	require.NoError(t, repo.ReleaseEvents(ctx, []int64{3}, claim.Add(time.Minute)))
+	require.NoError(t, repo.ReleaseEvents(ctx, []int64{5}, claim))
+	events, err = repo.ClaimEvents(ctx, 10, claim)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{3, 5}, eventIDs(events))
+
+	// parked events no longer hold the user back
+	require.NoError(t, repo.MarkAttemptFailed(ctx, 2, "unavailable", nil))
+	events, err = repo.ClaimEvents(ctx, 10, claim)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{4}, eventIDs(events))
+}
+
+func TestRepo_SubscriberDeliveries(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	repo := New(logrus.New())
+	require.NoError(t, repo.AddEvent(ctx, &service.OutboxEvent{UserID: "first", Channel: "create"}))
+
+	deliveries, err := repo.SubscriberDeliveries(ctx, 1)
+	require.NoError(t, err)
+	assert.Empty(t, deliveries)
+
+	require.NoError(t, repo.AddSubscriberDelivery(ctx, &service.SubscriberDelivery{EventID: 1, Subscriber: "http://crm"}))
+	require.NoError(t, repo.AddSubscriberDelivery(ctx, &service.SubscriberDelivery{EventID: 1, Subscriber: "http://legacy",
+		Rejected: "subscriber rejected event with 410"}))
+	// recorded once only
+	require.NoError(t, repo.AddSubscriberDelivery(ctx, &service.SubscriberDelivery{EventID: 1, Subscriber: "http://crm"}))
+
+	deliveries, err = repo.SubscriberDeliveries(ctx, 1)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2)
+	assert.Equal(t, "http://crm", deliveries[0].Subscriber)
+	assert.Empty(t, deliveries[0].Rejected)
+	assert.Equal(t, "subscriber rejected event with 410", deliveries[1].Rejected)
+}
+
+func eventIDs(events []service.OutboxEvent) []int64 {
+	ids := make([]int64, len(events))
+	for i, e := range events {
+		ids[i] = e.ID
+	}
+	return ids
+}
@@ -0,0 +1,106 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/BorisRostovskiy/ESL/internal/events"
+	"github.com/BorisRostovskiy/ESL/internal/repository"
+	"github.com/BorisRostovskiy/ESL/internal/service"
+)
+
+// UserRecord returns user by ID, deleted and erased users included
+func (r *Repo) UserRecord(_ context.Context, userID string) (*service.UserRecord, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var record service.UserRecord
+	if u, ok := r.users[userID]; ok {
+		record.User = u
+	} else if d, ok := r.deleted[userID]; ok {
+		record.User = d.User
+		record.DeletedAt = timeOrNil(d.deletedAt)
+		record.ErasedAt = timeOrNil(d.erasedAt)
+	} else {
+		return nil, repository.NoUsersFoundError
+	}
+	record.Password = ""
+	return &record, nil
+}
+
+// UserNotifications returns every outbox event of the user ordered by id, delivered ones included
+func (r *Repo) UserNotifications(_ context.Context, userID string) ([]service.Notification, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	notifications := make([]service.Notification, 0)
+	for _, e := range r.outbox {
+		if e.UserID != userID {
+			continue
+		}
+		notifications = append(notifications, service.Notification{
+			OutboxEvent: e.OutboxEvent,
+			DeliveredAt: timeOrNil(e.deliveredAt),
+			FailedAt:    timeOrNil(e.failedAt),
+			LastError:   e.lastError,
+		})
+	}
+	return notifications, nil
+}
+
+// EraseUser clears personal data and password of the user and deletes its refresh tokens, the user is soft-deleted
+// unless it is already. Its version is incremented, NoUsersFoundError is returned if the user is erased already
+func (r *Repo) EraseUser(_ context.Context, userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if u, ok := r.users[userID]; ok {
+		r.softDelete(u, now)
+	}
+	d, ok := r.deleted[userID]
+	if !ok || !d.erasedAt.IsZero() {
+		return repository.NoUsersFoundError
+	}
+	d.FirstName, d.LastName, d.NickName, d.Email, d.Password = "", "", "", "", ""
+	d.Version++
+	d.UpdatedAt = now
+	d.erasedAt = now
+	r.deleted[userID] = d
+
+	for hash, t := range r.tokens {
+		if t.UserID == userID {
+			delete(r.tokens, hash)
+		}
+	}
+	return nil
+}
+
+// UpdateNotificationPayload replaces payload of the outbox event
+func (r *Repo) UpdateNotificationPayload(_ context.Context, id int64, payload string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if e := r.outboxEvent(id); e != nil {
+		e.Payload = payload
+	}
+	return nil
+}
+
+// UpdateAuditChanges replaces changes of the audit entry
+func (r *Repo) UpdateAuditChanges(_ context.Context, id int64, changes []events.Change) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if id >= 1 && id <= int64(len(r.audit)) {
+		r.audit[id-1].Changes = changes
+	}
+	return nil
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,89 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/BorisRostovskiy/ESL/internal/events"
+	"github.com/BorisRostovskiy/ESL/internal/repository"
+	"github.com/BorisRostovskiy/ESL/internal/service"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepo_EraseUser(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	repo := New(logrus.New())
+
+	u1, err := repo.CreateUser(ctx, newUser("user1", "user1@gmail.com", "NL"))
+	require.NoError(t, err)
+	require.NoError(t, repo.CreateRefreshToken(ctx, &service.RefreshToken{Hash: "hash1", UserID: u1.ID}))
+
+	require.NoError(t, repo.EraseUser(ctx, u1.ID))
+	record, err := repo.UserRecord(ctx, u1.ID)
+	require.NoError(t, err)
+	assert.Equal(t, u1.ID, record.ID, "erased user keeps its ID")
+	assert.Equal(t, "NL", record.Country)
+	assert.Empty(t, record.FirstName+record.LastName+record.NickName+record.Email+record.Password)
+	assert.Equal(t, int64(2), record.Version)
+	require.NotNil(t, record.DeletedAt)
+	require.NotNil(t, record.ErasedAt)
+
+	_, err = repo.GetUser(ctx, u1.ID)
+	assert.ErrorIs(t, err, repository.NoUsersFoundError, "erased user is deleted")
+	_, err = repo.GetCredentials(ctx, "user1@gmail.com")
+	assert.ErrorIs(t, err, repository.NoUsersFoundError)
+	_, err = repo.RotateRefreshToken(ctx, "hash1", &service.RefreshToken{Hash: "hash2"})
+	assert.ErrorIs(t, err, repository.TokenNotFoundError, "tokens of the erased user are deleted")
+	_, err = repo.RestoreUser(ctx, u1.ID)
+	assert.ErrorIs(t, err, repository.NoUsersFoundError, "erased user could not be restored")
+	n, err := repo.PurgeUsers(ctx, time.Now().Add(time.Hour), 10)
+	require.NoError(t, err)
+	assert.Zero(t, n, "erased user is never purged")
+	assert.ErrorIs(t, repo.EraseUser(ctx, u1.ID), repository.NoUsersFoundError)
+
+	// deleted users are erased as well
+	u2, err := repo.CreateUser(ctx, newUser("user2", "user2@gmail.com", "NL"))
+	require.NoError(t, err)
+	require.NoError(t, repo.DeleteUser(ctx, u2.ID))
+	require.NoError(t, repo.EraseUser(ctx, u2.ID))
+	record, err = repo.UserRecord(ctx, u2.ID)
+	require.NoError(t, err)
+	assert.Empty(t, record.Email)
+
+	_, err = repo.UserRecord(ctx, "unknown")
+	assert.ErrorIs(t, err, repository.NoUsersFoundError)
+}
+
+func TestRepo_UserNotifications(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	repo := New(logrus.New())
+
+	for _, userID := range []string{"first", "second", "first"} {
+		require.NoError(t, repo.AddEvent(ctx, &service.OutboxEvent{UserID: userID, Channel: "create", Payload: userID}))
+	}
+	require.NoError(t, repo.MarkDelivered(ctx, 1))
+	require.NoError(t, repo.MarkAttemptFailed(ctx, 3, "unavailable", nil))
+	require.NoError(t, repo.UpdateNotificationPayload(ctx, 3, "redacted"))
+
+	notifications, err := repo.UserNotifications(ctx, "first")
+	require.NoError(t, err)
+	require.Len(t, notifications, 2)
+	assert.NotNil(t, notifications[0].DeliveredAt)
+	assert.Nil(t, notifications[0].FailedAt)
+	assert.Equal(t, int64(3), notifications[1].ID)
+	assert.NotNil(t, notifications[1].FailedAt)
+	assert.Equal(t, "unavailable", notifications[1].LastError)
+	assert.Equal(t, "redacted", notifications[1].Payload)
+
+	require.NoError(t, repo.AddAuditEntry(ctx, &service.AuditEntry{UserID: "first", Action: service.AuditUpdated,
+		Changes: []events.Change{{Field: "email", Old: "a@gmail.com", New: "b@gmail.com"}}}))
+	require.NoError(t, repo.UpdateAuditChanges(ctx, 1, []events.Change{{Field: "email", Redacted: true}}))
+	entries, err := repo.UserHistory(ctx, "first", 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []events.Change{{Field: "email", Redacted: true}}, entries[0].Changes)
+}
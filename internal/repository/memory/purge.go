@@ -0,0 +1,39 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// PurgeUsers hard-deletes up to limit users soft-deleted before the given time along with their refresh tokens,
+// the earliest deleted first. Returns number of purged users, erased users are kept for good
+func (r *Repo) PurgeUsers(_ context.Context, deletedBefore time.Time, limit int) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	due := make([]deletedUser, 0)
+	for _, u := range r.deleted {
+		if u.deletedAt.Before(deletedBefore) && u.erasedAt.IsZero() {
+			due = append(due, u)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].deletedAt.Before(due[j].deletedAt)
+	})
+	if limit > 0 && len(due) > limit {
+		due = due[:limit]
+	}
+
+	purged := make(map[string]bool, len(due))
+	for _, u := range due {
+		delete(r.deleted, u.ID)
+		purged[u.ID] = true
+	}
+	for hash, t := range r.tokens {
+		if purged[t.UserID] {
+			delete(r.tokens, hash)
+		}
+	}
+	return len(due), nil
+}
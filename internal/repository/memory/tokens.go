@@ -0,0 +1,53 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/BorisRostovskiy/ESL/internal/repository"
+	"github.com/BorisRostovskiy/ESL/internal/service"
+)
+
+type refreshToken struct {
+	service.RefreshToken
+	revoked bool
+}
+
+// CreateRefreshToken stores refresh token starting a new family
+func (r *Repo) CreateRefreshToken(_ context.Context, t *service.RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tokens[t.Hash] = &refreshToken{RefreshToken: *t}
+	return nil
+}
+
+// RotateRefreshToken revokes token and stores the next one within the same family.
+// Presenting already revoked token revokes the whole family as it could be stolen
+func (r *Repo) RotateRefreshToken(_ context.Context, hash string, next *service.RefreshToken) (*service.RefreshToken, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	old, ok := r.tokens[hash]
+	if !ok {
+		return nil, repository.TokenNotFoundError
+	}
+	if old.revoked {
+		for _, t := range r.tokens {
+			if t.FamilyID == old.FamilyID {
+				t.revoked = true
+			}
+		}
+		return nil, repository.TokenReusedError
+	}
+	if !old.ExpiresAt.After(time.Now()) {
+		return nil, repository.TokenNotFoundError
+	}
+
+	old.revoked = true
+	next.UserID = old.UserID
+	next.FamilyID = old.FamilyID
+	r.tokens[next.Hash] = &refreshToken{RefreshToken: *next}
+
+	revoked := old.RefreshToken
+	return &revoked, nil
+}
@@ -0,0 +1,49 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/BorisRostovskiy/ESL/internal/repository"
+	"github.com/BorisRostovskiy/ESL/internal/service"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepo_RotateRefreshToken(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	repo := New(logrus.New())
+	expiresAt := time.Now().Add(time.Hour)
+
+	require.NoError(t, repo.CreateRefreshToken(ctx, &service.RefreshToken{
+		Hash: "first", UserID: "user", FamilyID: "family", ExpiresAt: expiresAt,
+	}))
+
+	old, err := repo.RotateRefreshToken(ctx, "first", &service.RefreshToken{Hash: "second", ExpiresAt: expiresAt})
+	require.NoError(t, err)
+	assert.Equal(t, "user", old.UserID)
+
+	_, err = repo.RotateRefreshToken(ctx, "unknown", &service.RefreshToken{Hash: "third", ExpiresAt: expiresAt})
+	assert.ErrorIs(t, err, repository.TokenNotFoundError)
+
+	// reuse of the rotated token revokes the whole family, including the latest token
+	_, err = repo.RotateRefreshToken(ctx, "first", &service.RefreshToken{Hash: "third", ExpiresAt: expiresAt})
+	assert.ErrorIs(t, err, repository.TokenReusedError)
+	_, err = repo.RotateRefreshToken(ctx, "second", &service.RefreshToken{Hash: "third", ExpiresAt: expiresAt})
+	assert.ErrorIs(t, err, repository.TokenReusedError)
+}
+
+func TestRepo_RotateRefreshToken_Expired(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	repo := New(logrus.New())
+
+	require.NoError(t, repo.CreateRefreshToken(ctx, &service.RefreshToken{
+		Hash: "first", UserID: "user", FamilyID: "family", ExpiresAt: time.Now().Add(-time.Second),
+	}))
+	_, err := repo.RotateRefreshToken(ctx, "first", &service.RefreshToken{Hash: "second"})
+	assert.ErrorIs(t, err, repository.TokenNotFoundError)
+}
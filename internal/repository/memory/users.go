@@ -0,0 +1,312 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BorisRostovskiy/ESL/internal/repository"
+	"github.com/BorisRostovskiy/ESL/internal/service"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type (
+	// Repo implements service.UserRepo, service.TokenRepo, service.OutboxRepo, service.AuditRepo and
+	// service.PrivacyRepo interfaces keeping all the users in memory.
+	// Uniqueness rules are the same as in the Postgres schema: users_email_uq and users_nickname_uq,
+	// deleted users do not take their email and nickname
+	Repo struct {
+		mu        sync.RWMutex
+		users     map[string]service.User
+		emails    map[string]string
+		nicknames map[string]string
+		// deleted soft-deleted users kept until they are purged
+		deleted map[string]deletedUser
+		tokens  map[string]*refreshToken
+		outbox  []*outboxEvent
+		audit   []service.AuditEntry
+		log     *logrus.Logger
+	}
+
+	deletedUser struct {
+		service.User
+		deletedAt time.Time
+		// erasedAt zero unless personal data of the user is erased, erased users are kept for good
+		erasedAt time.Time
+	}
+)
+
+// New sets up a new in-memory repository.
+func New(log *logrus.Logger) *Repo {
+	return &Repo{
+		users:     make(map[string]service.User),
+		emails:    make(map[string]string),
+		nicknames: make(map[string]string),
+		deleted:   make(map[string]deletedUser),
+		tokens:    make(map[string]*refreshToken),
+		log:       log,
+	}
+}
+
+// CreateUser creates new user with generated ID
+func (r *Repo) CreateUser(_ context.Context, newUser *service.User) (*service.User, error) {
+	hashedPwd, err := bcrypt.GenerateFromPassword([]byte(newUser.Password), repository.PasswordCost)
+	if err != nil {
+		r.log.Errorf("generate pwd error: %v", err)
+		return nil, repository.GeneratePwdError
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.isTaken("", newUser.Email, newUser.NickName) {
+		return nil, repository.DuplicateKeyError
+	}
+
+	newUser.ID = uuid.New().String()
+	newUser.CreatedAt = time.Now()
+	// as postgres does, so that users could be sorted by updated_at
+	newUser.UpdatedAt = newUser.CreatedAt
+	newUser.Version = 1
+
+	stored := *newUser
+	stored.Password = string(hashedPwd)
+	r.store(stored)
+
+	return newUser, nil
+}
+
+// ListUsers get (filtered) list of users in the query sort order, starting right after the cursor
+func (r *Repo) ListUsers(_ context.Context, q service.ListQuery) ([]service.User, error) {
+	if err := q.Sort.Validate(q.After); err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make([]service.User, 0, len(r.users))
+	for _, u := range r.users {
+		if !q.Filter.Match(&u) {
+			continue
+		}
+		if q.After != nil && q.Sort.Seen(q.After, &u) {
+			continue
+		}
+		u.Password = ""
+		result = append(result, u)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return q.Sort.Compare(&result[i], &result[j]) < 0
+	})
+
+	if q.Limit > 0 && q.Limit < len(result) {
+		result = result[:q.Limit]
+	}
+	return result, nil
+}
+
+// StreamUsers calls fn for every user of the snapshot taken at the start, the lock is not held while streaming
+func (r *Repo) StreamUsers(ctx context.Context, q service.ListQuery, fn func(u *service.User) error) error {
+	users, err := r.ListUsers(ctx, service.ListQuery{Filter: q.Filter, Sort: q.Sort})
+	if err != nil {
+		return err
+	}
+	for i := range users {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if err = fn(&users[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CountUsers counts users matching the filter, in total and per country
+func (r *Repo) CountUsers(_ context.Context, f *service.Filter) (*service.Totals, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	totals := &service.Totals{Countries: make(map[string]int)}
+	for _, u := range r.users {
+		if !f.Match(&u) {
+			continue
+		}
+		totals.Count++
+		totals.Countries[u.Country]++
+	}
+	return totals, nil
+}
+
+// UpdateUser update all user fields, password only if it is set
+func (r *Repo) UpdateUser(_ context.Context, user *service.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.update(user)
+}
+
+// update updates the user of its version, any version if it is 0. Must be called under lock
+func (r *Repo) update(user *service.User) error {
+	existed, ok := r.users[user.ID]
+	if !ok {
+		return repository.NoUsersFoundError
+	}
+	if user.Version != 0 && user.Version != existed.Version {
+		return repository.VersionMismatchError
+	}
+	if r.isTaken(user.ID, user.Email, user.NickName) {
+		return repository.DuplicateKeyError
+	}
+
+	user.Version = existed.Version + 1
+	updated := *user
+	updated.CreatedAt = existed.CreatedAt
+	updated.UpdatedAt = time.Now()
+	if updated.Password == "" {
+		updated.Password = existed.Password
+	}
+
+	r.remove(existed)
+	r.store(updated)
+	return nil
+}
+
+// GetUser retrieve user by ID
+func (r *Repo) GetUser(_ context.Context, userID string) (*service.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.get(userID)
+}
+
+// GetUserByEmail retrieve user by email
+func (r *Repo) GetUserByEmail(_ context.Context, email string) (*service.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.get(r.emails[email])
+}
+
+// GetUserByNickname retrieve user by nickname
+func (r *Repo) GetUserByNickname(_ context.Context, nickname string) (*service.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.get(r.nicknames[nickname])
+}
+
+// TakenLogins looks up which of the emails and nicknames are taken by the existing users
+func (r *Repo) TakenLogins(_ context.Context, emails, nicknames []string) (*service.Logins, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	taken := &service.Logins{Emails: make(map[string]string), Nicknames: make(map[string]string)}
+	for _, email := range emails {
+		if id, ok := r.emails[email]; ok {
+			taken.Emails[email] = id
+		}
+	}
+	for _, nickname := range nicknames {
+		if id, ok := r.nicknames[nickname]; ok {
+			taken.Nicknames[nickname] = id
+		}
+	}
+	return taken, nil
+}
+
+// GetCredentials retrieve user along with hashed password by email or nickname
+func (r *Repo) GetCredentials(_ context.Context, login string) (*service.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	id, ok := r.emails[strings.ToLower(login)]
+	if !ok {
+		id = r.nicknames[login]
+	}
+	u, ok := r.users[id]
+	if !ok {
+		return nil, repository.NoUsersFoundError
+	}
+	return &u, nil
+}
+
+// DeleteUser soft-deletes user by ID, the user is kept until it is purged
+func (r *Repo) DeleteUser(_ context.Context, userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existed, ok := r.users[userID]
+	if !ok {
+		return repository.NoUsersFoundError
+	}
+	r.softDelete(existed, time.Now())
+	return nil
+}
+
+// RestoreUser restores soft-deleted user by ID unless it is erased, its version is incremented.
+// DuplicateKeyError is returned if its email or nickname are taken meanwhile
+func (r *Repo) RestoreUser(_ context.Context, userID string) (*service.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deleted, ok := r.deleted[userID]
+	if !ok || !deleted.erasedAt.IsZero() {
+		return nil, repository.NoUsersFoundError
+	}
+	if r.isTaken(userID, deleted.Email, deleted.NickName) {
+		return nil, repository.DuplicateKeyError
+	}
+
+	restored := deleted.User
+	restored.Version++
+	restored.UpdatedAt = time.Now()
+	delete(r.deleted, userID)
+	r.store(restored)
+	return r.get(userID)
+}
+
+// TestConnection in-memory storage is always available
+func (r *Repo) TestConnection(_ context.Context) error {
+	return nil
+}
+
+// get returns a copy of the stored user without password, must be called under lock
+func (r *Repo) get(userID string) (*service.User, error) {
+	u, ok := r.users[userID]
+	if !ok {
+		return nil, repository.NoUsersFoundError
+	}
+	u.Password = ""
+	return &u, nil
+}
+
+// isTaken checks unique email and nickname against all users except the given one
+func (r *Repo) isTaken(exceptID, email, nickname string) bool {
+	if id, ok := r.emails[email]; ok && id != exceptID {
+		return true
+	}
+	if id, ok := r.nicknames[nickname]; ok && id != exceptID {
+		return true
+	}
+	return false
+}
+
+func (r *Repo) store(u service.User) {
+	r.users[u.ID] = u
+	r.emails[u.Email] = u.ID
+	r.nicknames[u.NickName] = u.ID
+}
+
+// softDelete moves user to the deleted ones, its email and nickname are freed
+func (r *Repo) softDelete(u service.User, at time.Time) {
+	r.remove(u)
+	r.deleted[u.ID] = deletedUser{User: u, deletedAt: at}
+}
+
+func (r *Repo) remove(u service.User) {
+	delete(r.users, u.ID)
+	delete(r.emails, u.Email)
+	delete(r.nicknames, u.NickName)
+}
@@ -0,0 +1,261 @@
+package memory
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/BorisRostovskiy/ESL/internal/repository"
+	"github.com/BorisRostovskiy/ESL/internal/service"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newUser(nickname, email, country string) *service.User {
+	return &service.User{
+		FirstName: "User",
+		LastName:  "One",
+		NickName:  nickname,
+		Email:     email,
+		Country:   country,
+		Password:  "qwerty",
+	}
+}
+
+func TestRepo_CreateUser(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	repo := New(logrus.New())
+
+	created, err := repo.CreateUser(ctx, newUser("user1", "user1@gmail.com", "NL"))
+	require.NoError(t, err)
+	assert.NotEmpty(t, created.ID)
+
+	_, err = repo.CreateUser(ctx, newUser("user2", "user1@gmail.com", "NL"))
+	assert.ErrorIs(t, err, repository.DuplicateKeyError, "email_uq")
+
+	_, err = repo.CreateUser(ctx, newUser("user1", "user2@gmail.com", "NL"))
+	assert.ErrorIs(t, err, repository.DuplicateKeyError, "nickname_uq")
+
+	got, err := repo.GetUserByEmail(ctx, "user1@gmail.com")
+	require.NoError(t, err)
+	assert.Equal(t, created.ID, got.ID)
+	assert.Empty(t, got.Password)
+
+	creds, err := repo.GetCredentials(ctx, "user1")
+	require.NoError(t, err)
+	assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(creds.Password), []byte("qwerty")))
+}
+
+func TestRepo_ListUsers(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	repo := New(logrus.New())
+
+	for _, u := range []*service.User{
+		newUser("user1", "user1@gmail.com", "NL"),
+		newUser("user2", "user2@gmail.com", "DE"),
+		newUser("user3", "user3@gmail.com", "NL"),
+	} {
+		_, err := repo.CreateUser(ctx, u)
+		require.NoError(t, err)
+		time.Sleep(time.Millisecond)
+	}
+
+	all, err := repo.ListUsers(ctx, service.ListQuery{Limit: -1})
+	require.NoError(t, err)
+	require.Len(t, all, 3)
+	assert.Equal(t, "user3", all[0].NickName, "newest first")
+
+	page, err := repo.ListUsers(ctx, service.ListQuery{Limit: 1, After: service.DefaultSort.CursorOf(&all[0])})
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, "user2", page[0].NickName)
+
+	// users created after the cursor was made do not shift the next page
+	_, err = repo.CreateUser(ctx, newUser("user4", "user4@gmail.com", "NL"))
+	require.NoError(t, err)
+	page, err = repo.ListUsers(ctx, service.ListQuery{Limit: 2, After: service.DefaultSort.CursorOf(&page[0])})
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, "user1", page[0].NickName)
+
+	filters, err := service.NewFilters([]service.FilterConfig{{Field: "country"}, {Field: "nickname"}})
+	require.NoError(t, err)
+	nl, err := filters.NewFilter("country", "NL")
+	require.NoError(t, err)
+	filtered, err := repo.ListUsers(ctx, service.ListQuery{Filter: nl})
+	require.NoError(t, err)
+	assert.Len(t, filtered, 3)
+
+	expr, err := filters.ParseFilter("country = NL and nickname in (user1, user4)")
+	require.NoError(t, err)
+	filtered, err = repo.ListUsers(ctx, service.ListQuery{Filter: expr})
+	require.NoError(t, err)
+	assert.Len(t, filtered, 2)
+
+	// country ascending, nickname descending within the country
+	sort, err := service.ParseSort("country,-nickname")
+	require.NoError(t, err)
+	page, err = repo.ListUsers(ctx, service.ListQuery{Limit: 2, Sort: sort})
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	assert.Equal(t, "user2", page[0].NickName)
+	assert.Equal(t, "user4", page[1].NickName)
+	page, err = repo.ListUsers(ctx, service.ListQuery{Limit: 2, Sort: sort, After: sort.CursorOf(&page[1])})
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	assert.Equal(t, "user3", page[0].NickName)
+	assert.Equal(t, "user1", page[1].NickName)
+
+	_, err = repo.ListUsers(ctx, service.ListQuery{Sort: sort, After: service.DefaultSort.CursorOf(&page[1])})
+	assert.EqualError(t, err, "cursor does not match sort 'country,-nickname'")
+
+	totals, err := repo.CountUsers(ctx, nil)
+	require.NoError(t, err)
+	assert.Equal(t, &service.Totals{Count: 4, Countries: map[string]int{"NL": 3, "DE": 1}}, totals)
+	totals, err = repo.CountUsers(ctx, expr)
+	require.NoError(t, err)
+	assert.Equal(t, &service.Totals{Count: 2, Countries: map[string]int{"NL": 2}}, totals)
+}
+
+func TestRepo_StreamUsers(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	repo := New(logrus.New())
+	for _, u := range []*service.User{
+		newUser("user1", "user1@gmail.com", "NL"),
+		newUser("user2", "user2@gmail.com", "DE"),
+		newUser("user3", "user3@gmail.com", "NL"),
+	} {
+		_, err := repo.CreateUser(ctx, u)
+		require.NoError(t, err)
+	}
+	sort, err := service.ParseSort("nickname")
+	require.NoError(t, err)
+
+	var nicknames []string
+	err = repo.StreamUsers(ctx, service.ListQuery{Sort: sort}, func(u *service.User) error {
+		nicknames = append(nicknames, u.NickName)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"user1", "user2", "user3"}, nicknames)
+
+	// streaming stops at the first error
+	nicknames = nil
+	err = repo.StreamUsers(ctx, service.ListQuery{Sort: sort}, func(u *service.User) error {
+		nicknames = append(nicknames, u.NickName)
+		return io.ErrClosedPipe
+	})
+	assert.ErrorIs(t, err, io.ErrClosedPipe)
+	assert.Equal(t, []string{"user1"}, nicknames)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	err = repo.StreamUsers(cancelled, service.ListQuery{}, func(u *service.User) error { return nil })
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestRepo_UpdateDeleteUser(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	repo := New(logrus.New())
+
+	u1, err := repo.CreateUser(ctx, newUser("user1", "user1@gmail.com", "NL"))
+	require.NoError(t, err)
+	u2, err := repo.CreateUser(ctx, newUser("user2", "user2@gmail.com", "NL"))
+	require.NoError(t, err)
+
+	update := *u2
+	update.Email = u1.Email
+	assert.ErrorIs(t, repo.UpdateUser(ctx, &update), repository.DuplicateKeyError)
+
+	update.Email = "user2_new@gmail.com"
+	require.NoError(t, repo.UpdateUser(ctx, &update))
+	assert.Equal(t, int64(2), update.Version)
+	_, err = repo.GetUserByEmail(ctx, "user2@gmail.com")
+	assert.ErrorIs(t, err, repository.NoUsersFoundError, "old email must be released")
+
+	// u2 is of the version before the update
+	assert.ErrorIs(t, repo.UpdateUser(ctx, u2), repository.VersionMismatchError)
+	u2.Version = 0
+	require.NoError(t, repo.UpdateUser(ctx, u2), "any version is updated")
+	got, err := repo.GetUser(ctx, u2.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), got.Version)
+	assert.Equal(t, "user2@gmail.com", got.Email)
+
+	require.NoError(t, repo.DeleteUser(ctx, u1.ID))
+	assert.ErrorIs(t, repo.DeleteUser(ctx, u1.ID), repository.NoUsersFoundError)
+	assert.ErrorIs(t, repo.UpdateUser(ctx, u1), repository.NoUsersFoundError)
+}
+
+func TestRepo_RestorePurgeUser(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	repo := New(logrus.New())
+
+	u1, err := repo.CreateUser(ctx, newUser("user1", "user1@gmail.com", "NL"))
+	require.NoError(t, err)
+	require.NoError(t, repo.CreateRefreshToken(ctx, &service.RefreshToken{Hash: "hash1", UserID: u1.ID}))
+	require.NoError(t, repo.DeleteUser(ctx, u1.ID))
+
+	_, err = repo.GetUser(ctx, u1.ID)
+	assert.ErrorIs(t, err, repository.NoUsersFoundError, "deleted user must not be found")
+	users, err := repo.ListUsers(ctx, service.ListQuery{})
+	require.NoError(t, err)
+	assert.Empty(t, users)
+
+	// email of the deleted user is free
+	u2, err := repo.CreateUser(ctx, newUser("user2", "user1@gmail.com", "NL"))
+	require.NoError(t, err)
+	_, err = repo.RestoreUser(ctx, u1.ID)
+	assert.ErrorIs(t, err, repository.DuplicateKeyError)
+
+	require.NoError(t, repo.DeleteUser(ctx, u2.ID))
+	restored, err := repo.RestoreUser(ctx, u1.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "user1@gmail.com", restored.Email)
+	assert.Equal(t, int64(2), restored.Version)
+	assert.Empty(t, restored.Password)
+	_, err = repo.RestoreUser(ctx, u1.ID)
+	assert.ErrorIs(t, err, repository.NoUsersFoundError, "restored user is not deleted anymore")
+
+	require.NoError(t, repo.DeleteUser(ctx, u1.ID))
+	n, err := repo.PurgeUsers(ctx, time.Now().Add(-time.Hour), 10)
+	require.NoError(t, err)
+	assert.Zero(t, n, "users deleted within the retention are kept")
+
+	n, err = repo.PurgeUsers(ctx, time.Now(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = repo.PurgeUsers(ctx, time.Now(), 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	_, err = repo.RestoreUser(ctx, u1.ID)
+	assert.ErrorIs(t, err, repository.NoUsersFoundError, "purged user could not be restored")
+	_, err = repo.RotateRefreshToken(ctx, "hash1", &service.RefreshToken{Hash: "hash2"})
+	assert.ErrorIs(t, err, repository.TokenNotFoundError, "tokens of the purged user are purged along")
+}
+
+func TestRepo_TakenLogins(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	repo := New(logrus.New())
+
+	user1, err := repo.CreateUser(ctx, newUser("user1", "user1@gmail.com", "NL"))
+	require.NoError(t, err)
+	user2, err := repo.CreateUser(ctx, newUser("user2", "user2@gmail.com", "NL"))
+	require.NoError(t, err)
+	require.NoError(t, repo.DeleteUser(ctx, user2.ID))
+
+	taken, err := repo.TakenLogins(ctx, []string{"user1@gmail.com", "user2@gmail.com"}, []string{"user2", "user3"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"user1@gmail.com": user1.ID}, taken.Emails)
+	assert.Empty(t, taken.Nicknames, "logins of deleted users are free")
+}
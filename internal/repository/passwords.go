@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordCost bcrypt cost user passwords are hashed with
+const PasswordCost = 8
+
+// HashPasswords hashes passwords concurrently on all the available CPUs, so that a batch takes
+// a fraction of the time hashing one by one does
+func HashPasswords(passwords []string) ([]string, error) {
+	hashed := make([]string, len(passwords))
+	errs := make([]error, len(passwords))
+	sem := make(chan struct{}, runtime.GOMAXPROCS(0))
+	var wg sync.WaitGroup
+	for i, pwd := range passwords {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, pwd string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			h, err := bcrypt.GenerateFromPassword([]byte(pwd), PasswordCost)
+			hashed[i], errs[i] = string(h), err
+		}(i, pwd)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", GeneratePwdError, err)
+		}
+	}
+	return hashed, nil
+}
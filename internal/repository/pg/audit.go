@@ -0,0 +1,141 @@
+package pg
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/BorisRostovskiy/ESL/internal/events"
+	"github.com/BorisRostovskiy/ESL/internal/service"
+)
+
+// auditTable table of the audit entries, sealed changes are bound to it
+const auditTable = "user_audit"
+
+// AuditEntry storage user audit entry representation
+type AuditEntry struct {
+	ID           int64          `db:"id"`
+	UserID       string         `db:"user_id"`
+	Action       string         `db:"action"`
+	ActorSubject sql.NullString `db:"actor_subject"`
+	ActorMethod  sql.NullString `db:"actor_method"`
+	ActorRole    sql.NullString `db:"actor_role"`
+	Transport    sql.NullString `db:"transport"`
+	RequestID    sql.NullString `db:"request_id"`
+	Changes      string         `db:"changes"`
+	CreatedAt    time.Time      `db:"created_at"`
+	DataKeyID    sql.NullString `db:"data_key_id"`
+	DataKey      sql.NullString `db:"data_key"`
+}
+
+func (e *AuditEntry) toService() (*service.AuditEntry, error) {
+	entry := &service.AuditEntry{
+		ID:        e.ID,
+		UserID:    e.UserID,
+		Action:    service.AuditAction(e.Action),
+		Transport: e.Transport.String,
+		RequestID: e.RequestID.String,
+		CreatedAt: e.CreatedAt,
+	}
+	if e.ActorSubject.Valid {
+		entry.Actor = &service.Caller{Subject: e.ActorSubject.String, Method: e.ActorMethod.String, Role: e.ActorRole.String}
+	}
+	if err := json.Unmarshal([]byte(e.Changes), &entry.Changes); err != nil {
+		return nil, fmt.Errorf("malformed changes of audit entry %d: %w", e.ID, err)
+	}
+	return entry, nil
+}
+
+// AddAuditEntry appends entry, must be called within the transaction of the change
+func (r *Repo) AddAuditEntry(ctx context.Context, e *service.AuditEntry) error {
+	changes := e.Changes
+	if changes == nil {
+		changes = []events.Change{}
+	}
+	data, err := json.Marshal(changes)
+	if err != nil {
+		return fmt.Errorf("could not marshal audit changes: %w", err)
+	}
+	sealed, keyID, wrapped, err := r.crypto.sealChanges(e.UserID, string(data))
+	if err != nil {
+		return err
+	}
+	var subject, method, role sql.NullString
+	if a := e.Actor; a != nil {
+		subject = sql.NullString{String: a.Subject, Valid: true}
+		method = sql.NullString{String: a.Method, Valid: true}
+		role = sql.NullString{String: a.Role, Valid: a.Role != ""}
+	}
+
+	e.CreatedAt = time.Now().UTC()
+	err = r.q(ctx).GetContext(ctx, &e.ID,
+		`INSERT INTO user_audit (user_id, action, actor_subject, actor_method, actor_role, transport, request_id,
+				changes, created_at, data_key_id, data_key)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id`,
+		e.UserID, string(e.Action), subject, method, role, nullString(e.Transport), nullString(e.RequestID),
+		sealed, e.CreatedAt, keyID, wrapped)
+	if err != nil {
+		return fmt.Errorf("could not add audit entry: %w", err)
+	}
+	return nil
+}
+
+// UserHistory returns up to limit entries of the user preceding the entry with id before, newest first
+func (r *Repo) UserHistory(ctx context.Context, userID string, before int64, limit int) ([]service.AuditEntry, error) {
+	query := `SELECT id, user_id, action, actor_subject, actor_method, actor_role, transport, request_id,
+			changes, created_at, data_key_id, data_key
+		FROM user_audit WHERE user_id=$1 AND ($2::BIGINT = 0 OR id < $2)
+		ORDER BY id DESC
+		LIMIT $3`
+
+	entries := make([]AuditEntry, 0)
+	if err := r.q(ctx).SelectContext(ctx, &entries, query, userID, before, limit); err != nil {
+		return nil, fmt.Errorf("could not select user history: %w", err)
+	}
+	result := make([]service.AuditEntry, len(entries))
+	for i := range entries {
+		var err error
+		entry := &entries[i]
+		if entry.Changes, err = r.crypto.openChanges(entry.UserID, entry.Changes, entry.DataKeyID, entry.DataKey); err != nil {
+			return nil, err
+		}
+		e, err := entry.toService()
+		if err != nil {
+			return nil, err
+		}
+		result[i] = *e
+	}
+	return result, nil
+}
+
+// sealChanges encrypts changes JSON of the user audit entry, the sealed value is stored as JSON string since
+// the column is JSONB. Changes are returned as they are if encryption is not configured
+func (c *fieldCipher) sealChanges(userID, changes string) (string, sql.NullString, sql.NullString, error) {
+	sealed, keyID, wrapped, err := c.sealRecord(auditTable, userID, changes)
+	if err != nil || !keyID.Valid {
+		return sealed, keyID, wrapped, err
+	}
+	data, err := json.Marshal(sealed)
+	if err != nil {
+		return "", sql.NullString{}, sql.NullString{}, fmt.Errorf("could not marshal sealed audit changes: %w", err)
+	}
+	return string(data), keyID, wrapped, nil
+}
+
+// openChanges decrypts changes sealed by sealChanges, plaintext changes are returned as they are
+func (c *fieldCipher) openChanges(userID, changes string, keyID, wrapped sql.NullString) (string, error) {
+	if !keyID.Valid {
+		return changes, nil
+	}
+	var sealed string
+	if err := json.Unmarshal([]byte(changes), &sealed); err != nil {
+		return "", fmt.Errorf("malformed sealed audit changes of user %s: %w", userID, err)
+	}
+	return c.openRecord(auditTable, userID, sealed, keyID, wrapped)
+}
+
+func nullString(v string) sql.NullString {
+	return sql.NullString{String: v, Valid: v != ""}
+}
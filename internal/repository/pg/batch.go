@@ -0,0 +1,120 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/BorisRostovskiy/ESL/internal/repository"
+	"github.com/BorisRostovskiy/ESL/internal/service"
+	"github.com/google/uuid"
+)
+
+// CreateUsers creates users with a single multi-row insert, users conflicting with the existing ones or earlier
+// users of the batch are skipped. Returns which users are created, nothing is created if atomic and some are skipped
+func (r *Repo) CreateUsers(ctx context.Context, users []*service.User, atomic bool) ([]bool, error) {
+	passwords := make([]string, len(users))
+	for i, u := range users {
+		passwords[i] = u.Password
+	}
+	hashed, err := repository.HashPasswords(passwords)
+	if err != nil {
+		r.log.Errorf("generate pwd error: %v", err)
+		return nil, repository.GeneratePwdError
+	}
+
+	now := time.Now()
+	values := make([]string, len(users))
+	args := make([]interface{}, 0, len(users)*14)
+	for i, u := range users {
+		u.ID = uuid.New().String()
+		u.CreatedAt = now
+		u.Version = 1
+		stored := fromService(u)
+		stored.Password = hashed[i]
+		if err = r.crypto.seal(stored); err != nil {
+			return nil, err
+		}
+		row := stored.insertArgs()
+		placeholders := make([]string, len(row))
+		for j := range row {
+			placeholders[j] = fmt.Sprintf("$%d", len(args)+j+1)
+		}
+		args = append(args, row...)
+		values[i] = "(" + strings.Join(placeholders, ", ") + ")"
+	}
+	query := `INSERT INTO users (` + insertColumns + `)
+				VALUES ` + strings.Join(values, ", ") + `
+				ON CONFLICT DO NOTHING
+				RETURNING id`
+
+	created := make([]bool, len(users))
+	err = r.RunInTx(ctx, func(ctx context.Context) error {
+		ids := make([]string, 0, len(users))
+		if err := r.q(ctx).SelectContext(ctx, &ids, query, args...); err != nil {
+			return fmt.Errorf("could not create users: %w", err)
+		}
+		inserted := make(map[string]bool, len(ids))
+		for _, id := range ids {
+			inserted[id] = true
+		}
+		for i, u := range users {
+			created[i] = inserted[u.ID]
+		}
+		if atomic && len(ids) < len(users) {
+			return repository.DuplicateKeyError
+		}
+		return nil
+	})
+	return created, err
+}
+
+// UpdateUsers updates all the users within a single transaction or none of them,
+// returns index of the user failed the batch along with the error, -1 if none failed
+func (r *Repo) UpdateUsers(ctx context.Context, users []*service.User) (int, error) {
+	failed := -1
+	versions := make([]int64, len(users))
+	err := r.RunInTx(ctx, func(ctx context.Context) error {
+		for i, u := range users {
+			versions[i] = u.Version
+			if err := r.UpdateUser(ctx, u); err != nil {
+				failed = i
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// versions incremented by the updates rolled back are restored
+		for i := 0; i < failed; i++ {
+			users[i].Version = versions[i]
+		}
+	}
+	return failed, err
+}
+
+// DeleteUsers soft-deletes users with a single statement, returns the deleted ones.
+// Nothing is deleted if atomic and some of the users are not found, the ones found are returned along with the error
+func (r *Repo) DeleteUsers(ctx context.Context, ids []string, atomic bool) ([]service.User, error) {
+	deleted := make([]User, 0, len(ids))
+	err := r.RunInTx(ctx, func(ctx context.Context) error {
+		err := r.q(ctx).SelectContext(ctx, &deleted,
+			`UPDATE users SET deleted_at=$1 WHERE id = ANY($2) AND deleted_at IS NULL
+				RETURNING `+userColumns,
+			time.Now(), ids)
+		if err != nil {
+			return fmt.Errorf("could not delete users: %w", err)
+		}
+		if atomic && len(deleted) < len(ids) {
+			return repository.NoUsersFoundError
+		}
+		return nil
+	})
+
+	result, openErr := r.toServiceAll(deleted)
+	if err != nil {
+		return result, err
+	}
+	return result, openErr
+}
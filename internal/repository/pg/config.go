@@ -10,4 +10,9 @@ type Config struct {
 	MaxIdleConns    string        `yaml:"max_idle_conns"`
 	MaxOpenConns    string        `yaml:"max_open_conns"`
 	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	// SkipMigrations disables applying pending migrations on start,
+	// schema is expected to be managed with the `migrate` command then
+	SkipMigrations bool `yaml:"skip_migrations"`
+	// Encryption personal data of the users is stored in plaintext unless it is configured
+	Encryption EncryptionConfig `yaml:"encryption"`
 }
@@ -0,0 +1,307 @@
+package pg
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	b64 "encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/BorisRostovskiy/ESL/internal/service"
+)
+
+const encryptionKeySize = 32
+
+type (
+	// EncryptionConfig keys personal data of the users is encrypted with, it is stored in plaintext if
+	// key file is not set
+	EncryptionConfig struct {
+		// KeyID identifies the master key data keys of the users are wrapped with
+		KeyID string `yaml:"key_id"`
+		// KeyFile hex encoded 256 bits AES master key, e.g. `openssl rand -hex 32`
+		KeyFile string `yaml:"key_file"`
+		// PreviousKeyFiles previous master keys (key id -> key file) users are still decrypted with
+		// until they are re-encrypted with the `rotate-keys` command
+		PreviousKeyFiles map[string]string `yaml:"previous_key_files"`
+		// IndexKeyFile hex encoded 256 bits HMAC key of the blind indexes email, nickname and country are
+		// looked up by. It is never rotated, since the indexes of all the users would change along with it
+		IndexKeyFile string `yaml:"index_key_file"`
+	}
+
+	// fieldCipher envelope encryption of the users personal data. Every user has its own random data key,
+	// fields are sealed with it by AES-GCM and it is wrapped with the master key in turn. Records holding
+	// personal data of the user, audit changes and outbox events, are sealed the same way one by one
+	fieldCipher struct {
+		keyID   string
+		masters map[string]cipher.AEAD
+		index   []byte
+	}
+)
+
+// encryptedFields fields stored encrypted, users could be neither sorted by them nor filtered by their parts
+var encryptedFields = map[string]bool{
+	"first_name":   true,
+	"last_name":    true,
+	"nickname":     true,
+	"email":        true,
+	"email_domain": true,
+	"country":      true,
+}
+
+// blindIndexes encrypted field -> column of its blind index, the field is compared for equality with it
+var blindIndexes = map[string]string{
+	"nickname": "nickname_bidx",
+	"email":    "email_bidx",
+	"country":  "country_bidx",
+}
+
+// newFieldCipher loads the keys, nil is returned if encryption is not configured
+func newFieldCipher(cfg EncryptionConfig) (*fieldCipher, error) {
+	if cfg.KeyFile == "" {
+		return nil, nil
+	}
+	if cfg.KeyID == "" {
+		return nil, fmt.Errorf("encryption key_id is mandatory")
+	}
+	if cfg.IndexKeyFile == "" {
+		return nil, fmt.Errorf("encryption index_key_file is mandatory")
+	}
+
+	c := &fieldCipher{keyID: cfg.KeyID, masters: make(map[string]cipher.AEAD)}
+	files := map[string]string{cfg.KeyID: cfg.KeyFile}
+	for kid, file := range cfg.PreviousKeyFiles {
+		if kid == cfg.KeyID {
+			return nil, fmt.Errorf("encryption key '%s' is both current and previous", kid)
+		}
+		files[kid] = file
+	}
+	for kid, file := range files {
+		key, err := loadEncryptionKey(file)
+		if err != nil {
+			return nil, err
+		}
+		if c.masters[kid], err = newAEAD(key); err != nil {
+			return nil, fmt.Errorf("encryption key '%s': %w", kid, err)
+		}
+	}
+
+	var err error
+	if c.index, err = loadEncryptionKey(cfg.IndexKeyFile); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// validateFilters refuses filters encrypted fields could not be compared with, blind indexes support equality only
+func (c *fieldCipher) validateFilters(filters service.Filters) error {
+	for field, ops := range filters {
+		if !encryptedFields[field] {
+			continue
+		}
+		if _, ok := blindIndexes[field]; !ok {
+			return fmt.Errorf("filter field '%s' is encrypted", field)
+		}
+		for _, op := range ops {
+			if !equality(op) {
+				return fmt.Errorf("operator '%s' is not supported by encrypted filter field '%s'", op, field)
+			}
+		}
+	}
+	return nil
+}
+
+// validateSortFields refuses sort fields which are encrypted, their order is not kept by ciphertexts
+func (c *fieldCipher) validateSortFields(sf service.SortFields) error {
+	for field := range sf {
+		if encryptedFields[field] {
+			return fmt.Errorf("sort field '%s' is encrypted", field)
+		}
+	}
+	return nil
+}
+
+// seal encrypts personal data of the user with a new data key wrapped with the current master key,
+// blind indexes are set along with it. Nothing is encrypted if encryption is not configured
+func (c *fieldCipher) seal(u *User) error {
+	if c == nil {
+		return nil
+	}
+	dataKey, wrapped, err := c.newDataKey(u.Id)
+	if err != nil {
+		return err
+	}
+	u.EmailIndex = nullString(c.blindIndex("email", u.Email))
+	u.NicknameIndex = nullString(c.blindIndex("nickname", u.NickName))
+	u.CountryIndex = nullString(c.blindIndex("country", u.Country))
+	for column, field := range u.personal() {
+		if *field, err = sealValue(dataKey, *field, []byte(u.Id+"/"+column)); err != nil {
+			return err
+		}
+	}
+	u.DataKeyID = nullString(c.keyID)
+	u.DataKey = nullString(wrapped)
+	return nil
+}
+
+// open decrypts personal data of the user, plaintext users are left as they are
+func (c *fieldCipher) open(u *User) error {
+	if !u.DataKeyID.Valid {
+		return nil
+	}
+	if c == nil {
+		return fmt.Errorf("user %s is encrypted while encryption is not configured", u.Id)
+	}
+	dataKey, err := c.dataKey(u.DataKeyID.String, u.DataKey.String, u.Id, "user "+u.Id)
+	if err != nil {
+		return err
+	}
+	for column, field := range u.personal() {
+		if *field, err = openValue(dataKey, *field, []byte(u.Id+"/"+column)); err != nil {
+			return fmt.Errorf("could not decrypt %s of user %s: %w", column, u.Id, err)
+		}
+	}
+	u.DataKeyID, u.DataKey = nullString(""), nullString("")
+	return nil
+}
+
+// sealRecord encrypts value of the user record stored in the table, e.g. audit changes, with a new data key
+// wrapped with the current master key. Returns the value as is along with NULL keys if encryption is not configured
+func (c *fieldCipher) sealRecord(table, userID, value string) (string, sql.NullString, sql.NullString, error) {
+	if c == nil {
+		return value, sql.NullString{}, sql.NullString{}, nil
+	}
+	owner := table + "/" + userID
+	dataKey, wrapped, err := c.newDataKey(owner)
+	if err != nil {
+		return "", sql.NullString{}, sql.NullString{}, err
+	}
+	sealed, err := sealValue(dataKey, value, []byte(owner))
+	if err != nil {
+		return "", sql.NullString{}, sql.NullString{}, err
+	}
+	return sealed, nullString(c.keyID), nullString(wrapped), nil
+}
+
+// openRecord decrypts value sealed by sealRecord, plaintext values are returned as they are
+func (c *fieldCipher) openRecord(table, userID, value string, keyID, wrapped sql.NullString) (string, error) {
+	if !keyID.Valid {
+		return value, nil
+	}
+	if c == nil {
+		return "", fmt.Errorf("%s of user %s is encrypted while encryption is not configured", table, userID)
+	}
+	owner := table + "/" + userID
+	dataKey, err := c.dataKey(keyID.String, wrapped.String, owner, table+" of user "+userID)
+	if err != nil {
+		return "", err
+	}
+	plain, err := openValue(dataKey, value, []byte(owner))
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt %s of user %s: %w", table, userID, err)
+	}
+	return plain, nil
+}
+
+// newDataKey generates random data key of the owner, returns it along with its wrapped form
+func (c *fieldCipher) newDataKey(owner string) (cipher.AEAD, string, error) {
+	key := make([]byte, encryptionKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, "", fmt.Errorf("could not generate data key: %w", err)
+	}
+	dataKey, err := newAEAD(key)
+	if err != nil {
+		return nil, "", err
+	}
+	wrapped, err := sealValue(c.masters[c.keyID], string(key), []byte(c.keyID+"/"+owner))
+	if err != nil {
+		return nil, "", err
+	}
+	return dataKey, wrapped, nil
+}
+
+// dataKey unwraps data key of the owner with the master key it is wrapped with, errors refer to the subject
+func (c *fieldCipher) dataKey(keyID, wrapped, owner, subject string) (cipher.AEAD, error) {
+	master, ok := c.masters[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%s is encrypted with unknown key '%s'", subject, keyID)
+	}
+	key, err := openValue(master, wrapped, []byte(keyID+"/"+owner))
+	if err != nil {
+		return nil, fmt.Errorf("could not unwrap data key of %s: %w", subject, err)
+	}
+	return newAEAD([]byte(key))
+}
+
+// blindIndex keyed hash of the field value, equal values have equal indexes. Empty values are not indexed
+func (c *fieldCipher) blindIndex(field, value string) string {
+	if value == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, c.index)
+	mac.Write([]byte(field + "\x00" + value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// sealValue encrypts value with the associated data it is bound to, `base64(nonce|ciphertext)`.
+// Empty values are kept empty, so that erased fields are told apart
+func sealValue(aead cipher.AEAD, value string, ad []byte) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(value)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("could not generate nonce: %w", err)
+	}
+	return b64.RawStdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(value), ad)), nil
+}
+
+func openValue(aead cipher.AEAD, sealed string, ad []byte) (string, error) {
+	if sealed == "" {
+		return "", nil
+	}
+	data, err := b64.RawStdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < aead.NonceSize() {
+		return "", fmt.Errorf("ciphertext is too short")
+	}
+	plain, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], ad)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func equality(op service.FilterOp) bool {
+	return op == service.FilterEq || op == service.FilterNe || op == service.FilterIn
+}
+
+func loadEncryptionKey(file string) ([]byte, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read encryption key: %w", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("encryption key '%s' is not hex encoded: %w", file, err)
+	}
+	if len(key) != encryptionKeySize {
+		return nil, fmt.Errorf("encryption key '%s' must be %d bytes", file, encryptionKeySize)
+	}
+	return key, nil
+}
@@ -0,0 +1,290 @@
+package pg
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/BorisRostovskiy/ESL/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeKey(t *testing.T) string {
+	t.Helper()
+	key := make([]byte, encryptionKeySize)
+	_, err := rand.Read(key)
+	require.NoError(t, err)
+	file := filepath.Join(t.TempDir(), "master.key")
+	require.NoError(t, os.WriteFile(file, []byte(hex.EncodeToString(key)+"\n"), 0o600))
+	return file
+}
+
+func TestFieldCipher_SealOpen(t *testing.T) {
+	t.Parallel()
+	index, k1 := writeKey(t), writeKey(t)
+	previous, err := newFieldCipher(EncryptionConfig{KeyID: "k1", KeyFile: k1, IndexKeyFile: index})
+	require.NoError(t, err)
+
+	plain := User{Id: "user1", FirstName: "John", LastName: "Doe", NickName: "jd", Email: "jd@example.com",
+		Country: "NL", Role: "self"}
+	sealed := plain
+	require.NoError(t, previous.seal(&sealed))
+	assert.Equal(t, "k1", sealed.DataKeyID.String)
+	for column, value := range sealed.personal() {
+		assert.NotEqual(t, *plain.personal()[column], *value, "%s must not be readable", column)
+	}
+	assert.Equal(t, "self", sealed.Role)
+	assert.Equal(t, previous.blindIndex("email", "jd@example.com"), sealed.EmailIndex.String)
+	assert.NotEqual(t, previous.blindIndex("nickname", "NL"), previous.blindIndex("country", "NL"),
+		"indexes of different fields must differ")
+
+	// the previous key is still used to decrypt until the user is re-encrypted
+	current, err := newFieldCipher(EncryptionConfig{KeyID: "k2", KeyFile: writeKey(t), IndexKeyFile: index,
+		PreviousKeyFiles: map[string]string{"k1": k1}})
+	require.NoError(t, err)
+	opened := sealed
+	require.NoError(t, current.open(&opened))
+	assert.Equal(t, plain.personal(), opened.personal())
+	assert.False(t, opened.DataKeyID.Valid)
+
+	require.NoError(t, current.seal(&opened))
+	assert.Equal(t, "k2", opened.DataKeyID.String)
+	assert.Equal(t, sealed.EmailIndex, opened.EmailIndex, "blind indexes survive key rotation")
+
+	swapped := sealed
+	swapped.Id = "user2"
+	assert.Error(t, current.open(&swapped), "data key is bound to the user")
+	swapped = sealed
+	swapped.FirstName, swapped.LastName = sealed.LastName, sealed.FirstName
+	assert.Error(t, current.open(&swapped), "values are bound to their columns")
+
+	unknown, err := newFieldCipher(EncryptionConfig{KeyID: "k3", KeyFile: writeKey(t), IndexKeyFile: index})
+	require.NoError(t, err)
+	assert.EqualError(t, unknown.open(&sealed), "user user1 is encrypted with unknown key 'k1'")
+	assert.EqualError(t, (*fieldCipher)(nil).open(&sealed),
+		"user user1 is encrypted while encryption is not configured")
+}
+
+func TestFieldCipher_ErasedUser(t *testing.T) {
+	t.Parallel()
+	c, err := newFieldCipher(EncryptionConfig{KeyID: "k1", KeyFile: writeKey(t), IndexKeyFile: writeKey(t)})
+	require.NoError(t, err)
+
+	erased := User{Id: "user1", Country: "NL"}
+	require.NoError(t, c.seal(&erased))
+	assert.Empty(t, erased.Email)
+	assert.False(t, erased.EmailIndex.Valid, "empty values are not indexed")
+	assert.True(t, erased.CountryIndex.Valid)
+	require.NoError(t, c.open(&erased))
+	assert.Equal(t, "NL", erased.Country)
+}
+
+func TestNewFieldCipher(t *testing.T) {
+	t.Parallel()
+	key := writeKey(t)
+	short := filepath.Join(t.TempDir(), "short.key")
+	require.NoError(t, os.WriteFile(short, []byte("abcd"), 0o600))
+
+	c, err := newFieldCipher(EncryptionConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, c, "users are stored in plaintext unless the key is configured")
+
+	tests := map[string]struct {
+		cfg EncryptionConfig
+		err string
+	}{
+		"no key id": {
+			cfg: EncryptionConfig{KeyFile: key, IndexKeyFile: key},
+			err: "encryption key_id is mandatory",
+		},
+		"no index key": {
+			cfg: EncryptionConfig{KeyID: "k1", KeyFile: key},
+			err: "encryption index_key_file is mandatory",
+		},
+		"short key": {
+			cfg: EncryptionConfig{KeyID: "k1", KeyFile: short, IndexKeyFile: key},
+			err: "encryption key '" + short + "' must be 32 bytes",
+		},
+		"current key is previous": {
+			cfg: EncryptionConfig{KeyID: "k1", KeyFile: key, IndexKeyFile: key,
+				PreviousKeyFiles: map[string]string{"k1": key}},
+			err: "encryption key 'k1' is both current and previous",
+		},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			_, err := newFieldCipher(tc.cfg)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
+
+func TestWhereFilter_Encrypted(t *testing.T) {
+	t.Parallel()
+	c, err := newFieldCipher(EncryptionConfig{KeyID: "k1", KeyFile: writeKey(t), IndexKeyFile: writeKey(t)})
+	require.NoError(t, err)
+
+	f, err := allFilters.ParseFilter("email = jd@example.com and country in (NL, DE) and role != admin")
+	require.NoError(t, err)
+	var args []interface{}
+	where, err := whereFilter(f.Expr, &args, c)
+	require.NoError(t, err)
+	assert.Equal(t, "(email_bidx = $1 AND country_bidx IN ($2, $3) AND role <> $4)", where)
+	assert.Equal(t, []interface{}{c.blindIndex("email", "jd@example.com"), c.blindIndex("country", "NL"),
+		c.blindIndex("country", "DE"), "admin"}, args)
+
+	for expr, msg := range map[string]string{
+		"nickname prefix jd":           "operator 'prefix' is not supported by encrypted filter field 'nickname'",
+		"last_name = Doe":              "operator '=' is not supported by encrypted filter field 'last_name'",
+		"email_domain = example.com":   "operator '=' is not supported by encrypted filter field 'email_domain'",
+		"created_at > 2024-01-01":      "",
+		"first_name in (John, Jane)":   "operator 'in' is not supported by encrypted filter field 'first_name'",
+		"country = NL or email != jd@": "",
+	} {
+		f, err := allFilters.ParseFilter(expr)
+		require.NoError(t, err, expr)
+		_, err = whereFilter(f.Expr, &args, c)
+		if msg == "" {
+			assert.NoError(t, err, expr)
+		} else {
+			assert.EqualError(t, err, msg, expr)
+		}
+	}
+
+	assert.NoError(t, c.validateFilters(service.Filters{"email": {service.FilterEq}, "created_at": nil}))
+	assert.EqualError(t, c.validateFilters(service.Filters{"country": {service.FilterEq, service.FilterPrefix}}),
+		"operator 'prefix' is not supported by encrypted filter field 'country'")
+	assert.EqualError(t, c.validateFilters(service.Filters{"first_name": {service.FilterEq}}),
+		"filter field 'first_name' is encrypted")
+
+	assert.NoError(t, c.validateSortFields(service.SortFields{"created_at": true, "updated_at": true}))
+	assert.EqualError(t, c.validateSortFields(service.SortFields{"created_at": true, "nickname": true}),
+		"sort field 'nickname' is encrypted")
+}
+
+func TestRepo_ListUsersEncryptedSort(t *testing.T) {
+	t.Parallel()
+	c, err := newFieldCipher(EncryptionConfig{KeyID: "k1", KeyFile: writeKey(t), IndexKeyFile: writeKey(t)})
+	require.NoError(t, err)
+	sort, err := service.ParseSort("-updated_at,last_name")
+	require.NoError(t, err)
+
+	// no query is sent, so that connection is not needed
+	repo := &Repo{crypto: c}
+	_, err = repo.ListUsers(context.Background(), service.ListQuery{Sort: sort})
+	assert.EqualError(t, err, "sorting by 'last_name' is not supported, it is encrypted")
+}
+
+func TestFieldCipher_SealOpenRecord(t *testing.T) {
+	t.Parallel()
+	c, err := newFieldCipher(EncryptionConfig{KeyID: "k1", KeyFile: writeKey(t), IndexKeyFile: writeKey(t)})
+	require.NoError(t, err)
+
+	payload := `{"email":"jd@example.com"}`
+	sealed, keyID, wrapped, err := c.sealRecord(outboxTable, "user1", payload)
+	require.NoError(t, err)
+	assert.NotContains(t, sealed, "jd@example.com")
+	assert.Equal(t, "k1", keyID.String)
+	opened, err := c.openRecord(outboxTable, "user1", sealed, keyID, wrapped)
+	require.NoError(t, err)
+	assert.Equal(t, payload, opened)
+
+	_, err = c.openRecord(outboxTable, "user2", sealed, keyID, wrapped)
+	assert.Error(t, err, "record is bound to the user")
+	_, err = c.openRecord(auditTable, "user1", sealed, keyID, wrapped)
+	assert.Error(t, err, "record is bound to the table")
+	_, err = (*fieldCipher)(nil).openRecord(outboxTable, "user1", sealed, keyID, wrapped)
+	assert.EqualError(t, err, "outbox of user user1 is encrypted while encryption is not configured")
+
+	plain, keyID, _, err := (*fieldCipher)(nil).sealRecord(outboxTable, "user1", payload)
+	require.NoError(t, err)
+	assert.Equal(t, payload, plain)
+	assert.False(t, keyID.Valid)
+	opened, err = c.openRecord(outboxTable, "user1", plain, keyID, keyID)
+	require.NoError(t, err)
+	assert.Equal(t, payload, opened, "plaintext records are read as they are")
+}
+
+func TestFieldCipher_SealOpenChanges(t *testing.T) {
+	t.Parallel()
+	c, err := newFieldCipher(EncryptionConfig{KeyID: "k1", KeyFile: writeKey(t), IndexKeyFile: writeKey(t)})
+	require.NoError(t, err)
+
+	changes := `[{"field":"email","old":"jd@example.com","new":"john@example.com"}]`
+	sealed, keyID, wrapped, err := c.sealChanges("user1", changes)
+	require.NoError(t, err)
+	assert.NotContains(t, sealed, "example.com")
+	var value string
+	require.NoError(t, json.Unmarshal([]byte(sealed), &value), "sealed changes must be a JSON string")
+	opened, err := c.openChanges("user1", sealed, keyID, wrapped)
+	require.NoError(t, err)
+	assert.Equal(t, changes, opened)
+
+	// rotation re-encrypts both records sealed by the table
+	for _, table := range sealedTables {
+		sealed, keyID, wrapped, err := table.seal(c, "user1", changes)
+		require.NoError(t, err, table.name)
+		opened, err := table.open(c, "user1", sealed, keyID, wrapped)
+		require.NoError(t, err, table.name)
+		assert.Equal(t, changes, opened, table.name)
+	}
+}
+
+func TestCheckEncryption(t *testing.T) {
+	t.Parallel()
+	c, err := newFieldCipher(EncryptionConfig{KeyID: "k1", KeyFile: writeKey(t), IndexKeyFile: writeKey(t)})
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		crypto     *fieldCipher
+		mismatched string
+		err        string
+	}{
+		"consistent": {crypto: c},
+		"plaintext users": {
+			crypto:     c,
+			mismatched: "users",
+			err:        "users are stored in plaintext, encrypt them with the rotate-keys command first",
+		},
+		"plaintext audit entries": {
+			crypto:     c,
+			mismatched: "user_audit",
+			err:        "audit entries are stored in plaintext, encrypt them with the rotate-keys command first",
+		},
+		"encrypted outbox events": {
+			mismatched: "outbox",
+			err:        "outbox events are encrypted while encryption is not configured",
+		},
+		"consistent plaintext": {},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			db := &fakeDB{
+				query: func(query string, _ []driver.NamedValue) (driver.Rows, error) {
+					mismatched := tc.mismatched != "" && strings.Contains(query, " FROM "+tc.mismatched+" ")
+					return &fakeRows{columns: []string{"exists"}, values: [][]driver.Value{{mismatched}}}, nil
+				},
+			}
+			repo := newFakeRepo(t, db)
+
+			err := checkEncryption(repo.conn, tc.crypto)
+			if tc.err == "" {
+				assert.NoError(t, err)
+				assert.Len(t, db.Queries(), 3, "users, audit entries and outbox events are checked")
+				return
+			}
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
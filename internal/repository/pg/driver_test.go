@@ -0,0 +1,109 @@
+package pg
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+type (
+	// fakeDB is a database/sql connector answering queries with the configured callbacks, so that the
+	// repository could be tested without Postgres server
+	fakeDB struct {
+		mu      sync.Mutex
+		queries []string
+		// exec answers ExecContext, all rows are affected by default
+		exec func(query string, args []driver.NamedValue) (driver.Result, error)
+		// query answers QueryContext, no rows are returned by default
+		query func(query string, args []driver.NamedValue) (driver.Rows, error)
+	}
+
+	fakeConn struct{ db *fakeDB }
+
+	fakeTx struct{}
+
+	// fakeRows rows of the given columns
+	fakeRows struct {
+		columns []string
+		values  [][]driver.Value
+	}
+)
+
+// newFakeRepo sets up a repository on top of the fake database
+func newFakeRepo(t *testing.T, db *fakeDB) *Repo {
+	t.Helper()
+	conn := sqlx.NewDb(sql.OpenDB(db), "pgx")
+	t.Cleanup(func() { _ = conn.Close() })
+	return &Repo{conn: conn, log: logrus.New()}
+}
+
+// Queries returns all queries sent so far
+func (db *fakeDB) Queries() []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return append([]string(nil), db.queries...)
+}
+
+func (db *fakeDB) record(query string) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.queries = append(db.queries, query)
+}
+
+func (db *fakeDB) Connect(context.Context) (driver.Conn, error) { return &fakeConn{db: db}, nil }
+
+func (db *fakeDB) Driver() driver.Driver { return nil }
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+// CheckNamedValue passes arguments as they are, so that arrays reach the callbacks like they reach pgx
+func (c *fakeConn) CheckNamedValue(*driver.NamedValue) error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.db.record("BEGIN")
+	return fakeTx{}, nil
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.db.record(query)
+	if c.db.exec == nil {
+		return driver.RowsAffected(1), nil
+	}
+	return c.db.exec(query, args)
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.db.record(query)
+	if c.db.query == nil {
+		return &fakeRows{}, nil
+	}
+	return c.db.query(query, args)
+}
+
+func (fakeTx) Commit() error { return nil }
+
+func (fakeTx) Rollback() error { return nil }
+
+func (r *fakeRows) Columns() []string { return r.columns }
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
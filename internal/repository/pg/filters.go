@@ -0,0 +1,100 @@
+package pg
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/BorisRostovskiy/ESL/internal/service"
+)
+
+// filterColumns filter field -> SQL expression it is compared with
+var filterColumns = map[string]string{
+	"first_name":   "first_name",
+	"last_name":    "last_name",
+	"nickname":     "nickname",
+	"email":        "email",
+	"email_domain": "lower(split_part(email, '@', 2))",
+	"country":      "country",
+	"role":         "role",
+	"created_at":   "created_at",
+	"updated_at":   "updated_at",
+}
+
+var filterOperators = map[service.FilterOp]string{
+	service.FilterEq:  "=",
+	service.FilterNe:  "<>",
+	service.FilterLt:  "<",
+	service.FilterLte: "<=",
+	service.FilterGt:  ">",
+	service.FilterGte: ">=",
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// whereFilter translates filter expression to SQL condition, values are appended to args
+// and referenced by their positions only. Encrypted fields are compared by their blind indexes if enc is set
+func whereFilter(e service.FilterExpr, args *[]interface{}, enc *fieldCipher) (string, error) {
+	switch e := e.(type) {
+	case *service.Logical:
+		parts := make([]string, len(e.Operands))
+		for i, o := range e.Operands {
+			part, err := whereFilter(o, args, enc)
+			if err != nil {
+				return "", err
+			}
+			parts[i] = part
+		}
+		sep := " AND "
+		if e.Op == service.FilterOr {
+			sep = " OR "
+		}
+		return "(" + strings.Join(parts, sep) + ")", nil
+	case *service.Condition:
+		return whereCondition(e, args, enc)
+	default:
+		return "", fmt.Errorf("unsupported filter expression %T", e)
+	}
+}
+
+func whereCondition(c *service.Condition, args *[]interface{}, enc *fieldCipher) (string, error) {
+	column, ok := filterColumns[c.Field]
+	if !ok {
+		return "", fmt.Errorf("unsupported filter field '%s'", c.Field)
+	}
+	index, blind := blindIndexes[c.Field]
+	if enc != nil && encryptedFields[c.Field] {
+		if !blind || !equality(c.Op) {
+			return "", fmt.Errorf("operator '%s' is not supported by encrypted filter field '%s'", c.Op, c.Field)
+		}
+		column = index
+	}
+	arg := func(i int) string {
+		if enc != nil && blind {
+			*args = append(*args, enc.blindIndex(c.Field, c.Values[i]))
+		} else if c.Times != nil {
+			*args = append(*args, c.Times[i])
+		} else if c.Field == "email_domain" {
+			*args = append(*args, strings.ToLower(c.Values[i]))
+		} else {
+			*args = append(*args, c.Values[i])
+		}
+		return fmt.Sprintf("$%d", len(*args))
+	}
+
+	switch c.Op {
+	case service.FilterIn:
+		placeholders := make([]string, len(c.Values))
+		for i := range c.Values {
+			placeholders[i] = arg(i)
+		}
+		return fmt.Sprintf("%s IN (%s)", column, strings.Join(placeholders, ", ")), nil
+	case service.FilterPrefix:
+		*args = append(*args, likeEscaper.Replace(c.Values[0])+"%")
+		return fmt.Sprintf("%s LIKE $%d", column, len(*args)), nil
+	}
+	op, ok := filterOperators[c.Op]
+	if !ok {
+		return "", fmt.Errorf("unsupported filter operator '%s'", c.Op)
+	}
+	return fmt.Sprintf("%s %s %s", column, op, arg(0)), nil
+}
@@ -0,0 +1,74 @@
+package pg
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/BorisRostovskiy/ESL/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// allFilters every field with all its operators enabled
+var allFilters, _ = service.NewFilters([]service.FilterConfig{
+	{Field: "first_name"}, {Field: "last_name"}, {Field: "nickname"}, {Field: "email"}, {Field: "email_domain"},
+	{Field: "country"}, {Field: "role"}, {Field: "created_at"}, {Field: "updated_at"},
+})
+
+func TestWhereFilter(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		expr  string
+		where string
+		args  []interface{}
+	}{
+		"equality": {
+			expr:  "country = NL",
+			where: "country = $2",
+			args:  []interface{}{"NL"},
+		},
+		"in and time range": {
+			expr:  "country in (NL, DE) and created_at > 2024-01-01",
+			where: "(country IN ($2, $3) AND created_at > $4)",
+			args:  []interface{}{"NL", "DE", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		},
+		"prefix is escaped": {
+			expr:  `first_name prefix "50%_a\\b" or last_name != Doe`,
+			where: `(first_name LIKE $2 OR last_name <> $3)`,
+			args:  []interface{}{`50\%\_a\\b%`, "Doe"},
+		},
+		"email domain": {
+			expr:  "email_domain = Example.com",
+			where: "lower(split_part(email, '@', 2)) = $2",
+			args:  []interface{}{"example.com"},
+		},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			f, err := allFilters.ParseFilter(tc.expr)
+			require.NoError(t, err)
+
+			// placeholders continue after the arguments already collected
+			args := []interface{}{"before"}
+			where, err := whereFilter(f.Expr, &args, nil)
+			require.NoError(t, err)
+			assert.Equal(t, tc.where, where)
+			assert.Equal(t, tc.args, args[1:])
+		})
+	}
+}
+
+func TestRepo_ListUsersFilterNotEnabled(t *testing.T) {
+	t.Parallel()
+	f, err := allFilters.ParseFilter("country = NL or email_domain = example.com")
+	require.NoError(t, err)
+
+	// no query is sent, so that connection is not needed
+	repo := &Repo{filters: service.Filters{"country": {service.FilterEq}}}
+	_, err = repo.ListUsers(context.Background(), service.ListQuery{Filter: f})
+	assert.EqualError(t, err, "filter refused: filtering by 'email_domain' is not enabled")
+}
@@ -0,0 +1,250 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// lockID random but stable key of the advisory lock, protects from concurrent migrations
+	// performed by several instances of the service started at the same time
+	lockID = 7205124911
+
+	bookkeeping = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER NOT NULL PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+	);`
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+// file name format: <version>_<name>.<up|down>.sql, e.g. 0001_create_users.up.sql
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type (
+	// Migration single versioned schema change
+	Migration struct {
+		Version int
+		Name    string
+		Up      string
+		Down    string
+	}
+
+	// Status migration along with the time it has been applied, nil if it is pending
+	Status struct {
+		Version   int
+		Name      string
+		AppliedAt *time.Time
+	}
+
+	// Migrator applies embedded migrations to the database
+	Migrator struct {
+		db         *sqlx.DB
+		log        logrus.FieldLogger
+		migrations []Migration
+	}
+)
+
+// New creates migrator with all the migrations embedded into the binary
+func New(db *sqlx.DB, log *logrus.Logger) (*Migrator, error) {
+	migrations, err := load(files)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		db:         db,
+		log:        log.WithField("component", "migrations"),
+		migrations: migrations,
+	}, nil
+}
+
+// Up applies all pending migrations in order, returns number of applied ones
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	applied := 0
+	err := m.locked(ctx, func(conn *sqlx.Conn, done map[int]time.Time) error {
+		for _, mg := range m.migrations {
+			if _, ok := done[mg.Version]; ok {
+				continue
+			}
+			if err := apply(ctx, conn, mg.Up,
+				`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, mg.Version, mg.Name); err != nil {
+				return fmt.Errorf("could not apply migration %04d_%s: %w", mg.Version, mg.Name, err)
+			}
+			m.log.Infof("migration %04d_%s applied", mg.Version, mg.Name)
+			applied++
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down rolls back given number of the latest applied migrations
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	reverted := 0
+	err := m.locked(ctx, func(conn *sqlx.Conn, done map[int]time.Time) error {
+		for i := len(m.migrations) - 1; i >= 0 && reverted < steps; i-- {
+			mg := m.migrations[i]
+			if _, ok := done[mg.Version]; !ok {
+				continue
+			}
+			if err := apply(ctx, conn, mg.Down,
+				`DELETE FROM schema_migrations WHERE version=$1`, mg.Version); err != nil {
+				return fmt.Errorf("could not revert migration %04d_%s: %w", mg.Version, mg.Name, err)
+			}
+			m.log.Infof("migration %04d_%s reverted", mg.Version, mg.Name)
+			reverted++
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Status list of all known migrations with their state. It is read-only: neither the migrations lock is taken
+// nor the bookkeeping table is created, all migrations are pending until it exists
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var exists bool
+	if err := m.db.GetContext(ctx, &exists, `SELECT to_regclass('schema_migrations') IS NOT NULL`); err != nil {
+		return nil, fmt.Errorf("could not check schema_migrations table: %w", err)
+	}
+	done := make(map[int]time.Time)
+	if exists {
+		var err error
+		if done, err = applied(ctx, m.db); err != nil {
+			return nil, err
+		}
+	}
+
+	result := make([]Status, len(m.migrations))
+	for i, mg := range m.migrations {
+		result[i] = Status{Version: mg.Version, Name: mg.Name}
+		if at, ok := done[mg.Version]; ok {
+			result[i].AppliedAt = &at
+		}
+	}
+	return result, nil
+}
+
+// locked runs fn holding the advisory lock on a dedicated connection,
+// advisory locks are bound to the session, so all the statements must share it
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sqlx.Conn, done map[int]time.Time) error) error {
+	conn, err := m.db.Connx(ctx)
+	if err != nil {
+		return fmt.Errorf("could not acquire connection: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if _, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockID); err != nil {
+		return fmt.Errorf("could not acquire migrations lock: %w", err)
+	}
+	defer func() {
+		// context could be already canceled, lock must be released anyway
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockID); err != nil {
+			m.log.Errorf("could not release migrations lock: %v", err)
+		}
+	}()
+
+	if _, err = conn.ExecContext(ctx, bookkeeping); err != nil {
+		return fmt.Errorf("could not create schema_migrations table: %w", err)
+	}
+
+	done, err := applied(ctx, conn)
+	if err != nil {
+		return err
+	}
+	return fn(conn, done)
+}
+
+// applied reads versions of the applied migrations along with the time they have been applied
+func applied(ctx context.Context, q sqlx.QueryerContext) (map[int]time.Time, error) {
+	rows := make([]struct {
+		Version   int       `db:"version"`
+		AppliedAt time.Time `db:"applied_at"`
+	}, 0)
+	if err := sqlx.SelectContext(ctx, q, &rows, `SELECT version, applied_at FROM schema_migrations`); err != nil &&
+		!errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("could not read schema_migrations: %w", err)
+	}
+	done := make(map[int]time.Time, len(rows))
+	for _, r := range rows {
+		done[r.Version] = r.AppliedAt
+	}
+	return done, nil
+}
+
+// apply runs migration script and its bookkeeping statement in a single transaction
+func apply(ctx context.Context, conn *sqlx.Conn, script, bookkeeping string, args ...interface{}) error {
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err = tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, bookkeeping, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// load reads and validates migrations, every version must have both up and down scripts
+func load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, "sql")
+	if err != nil {
+		return nil, fmt.Errorf("could not read migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		match := fileName.FindStringSubmatch(e.Name())
+		if match == nil {
+			return nil, fmt.Errorf("malformed migration file name '%s'", e.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		body, err := fs.ReadFile(fsys, path.Join("sql", e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("could not read migration '%s': %w", e.Name(), err)
+		}
+
+		mg, ok := byVersion[version]
+		if !ok {
+			mg = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = mg
+		} else if mg.Name != match[2] {
+			return nil, fmt.Errorf("migration version %d used by '%s' and '%s'", version, mg.Name, match[2])
+		}
+		if match[3] == "up" {
+			mg.Up = string(body)
+		} else {
+			mg.Down = string(body)
+		}
+	}
+
+	result := make([]Migration, 0, len(byVersion))
+	for _, mg := range byVersion {
+		if mg.Up == "" || mg.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s must have both up and down scripts", mg.Version, mg.Name)
+		}
+		result = append(result, *mg)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Version < result[j].Version
+	})
+	return result, nil
+}
@@ -0,0 +1,68 @@
+package migrations
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+
+	t.Run("embedded migrations are valid", func(t *testing.T) {
+		migrations, err := load(files)
+		require.NoError(t, err)
+		require.NotEmpty(t, migrations)
+		for i, mg := range migrations {
+			assert.Equal(t, i+1, mg.Version, "versions must be sequential")
+		}
+	})
+
+	tests := map[string]struct {
+		fs  fstest.MapFS
+		err string
+	}{
+		"ordered by version": {
+			fs: fstest.MapFS{
+				"sql/0002_second.up.sql":   {Data: []byte("SELECT 2")},
+				"sql/0002_second.down.sql": {Data: []byte("SELECT 2")},
+				"sql/0001_first.up.sql":    {Data: []byte("SELECT 1")},
+				"sql/0001_first.down.sql":  {Data: []byte("SELECT 1")},
+			},
+		},
+		"malformed name": {
+			fs: fstest.MapFS{
+				"sql/first.up.sql": {Data: []byte("SELECT 1")},
+			},
+			err: "malformed migration file name 'first.up.sql'",
+		},
+		"missing down script": {
+			fs: fstest.MapFS{
+				"sql/0001_first.up.sql": {Data: []byte("SELECT 1")},
+			},
+			err: "migration 0001_first must have both up and down scripts",
+		},
+		"duplicated version": {
+			fs: fstest.MapFS{
+				"sql/0001_first.up.sql":  {Data: []byte("SELECT 1")},
+				"sql/0001_second.up.sql": {Data: []byte("SELECT 1")},
+			},
+			err: "migration version 1 used by 'first' and 'second'",
+		},
+	}
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			migrations, err := load(tt.fs)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, migrations, 2)
+			assert.Equal(t, "first", migrations[0].Name)
+			assert.Equal(t, "second", migrations[1].Name)
+		})
+	}
+}
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS users (
+    id TEXT NOT NULL,
+    first_name TEXT NOT NULL,
+    last_name TEXT NOT NULL,
+    nickname TEXT NOT NULL,
+    password TEXT NOT NULL,
+    email TEXT NOT NULL,
+    country TEXT NOT NULL,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT NOW(),
+    CONSTRAINT id_uq UNIQUE (id),
+    CONSTRAINT nickname_uq UNIQUE (nickname),
+    CONSTRAINT email_uq UNIQUE (email)
+);
+
+CREATE INDEX IF NOT EXISTS country_idx ON users USING btree (country);
@@ -0,0 +1,2 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'self';
+ALTER TABLE users ADD CONSTRAINT users_role_check CHECK (role IN ('admin', 'support', 'self'));
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS outbox (
+    id BIGSERIAL NOT NULL PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    channel TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    last_error TEXT,
+    delivered_at TIMESTAMP,
+    failed_at TIMESTAMP
+);
+
+-- pending events only, delivered and parked ones are kept for tracking
+CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox USING btree (user_id, id)
+    WHERE delivered_at IS NULL AND failed_at IS NULL;
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS users_created_at_id_idx;
+
+ALTER TABLE users ALTER COLUMN created_at DROP NOT NULL;
+ALTER TABLE users ALTER COLUMN created_at DROP DEFAULT;
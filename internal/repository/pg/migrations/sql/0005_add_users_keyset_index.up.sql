@@ -0,0 +1,6 @@
+-- keyset pagination relies on created_at being set, users created before are ordered by their update time
+UPDATE users SET created_at = COALESCE(updated_at, NOW()) WHERE created_at IS NULL;
+ALTER TABLE users ALTER COLUMN created_at SET DEFAULT NOW();
+ALTER TABLE users ALTER COLUMN created_at SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS users_created_at_id_idx ON users USING btree (created_at DESC, id DESC);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS users_country_id_idx;
+DROP INDEX IF EXISTS users_last_name_id_idx;
+DROP INDEX IF EXISTS users_updated_at_id_idx;
+
+ALTER TABLE users ALTER COLUMN updated_at DROP NOT NULL;
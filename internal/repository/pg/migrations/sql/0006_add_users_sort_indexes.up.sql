@@ -0,0 +1,9 @@
+-- keyset pagination over updated_at relies on it being set
+UPDATE users SET updated_at = created_at WHERE updated_at IS NULL;
+ALTER TABLE users ALTER COLUMN updated_at SET NOT NULL;
+
+-- indexes of the sort fields along with the id tie-breaker, scanned backwards for descending sorts.
+-- nickname and email are unique already
+CREATE INDEX IF NOT EXISTS users_updated_at_id_idx ON users USING btree (updated_at, id);
+CREATE INDEX IF NOT EXISTS users_last_name_id_idx ON users USING btree (last_name, id);
+CREATE INDEX IF NOT EXISTS users_country_id_idx ON users USING btree (country, id);
@@ -0,0 +1,2 @@
+-- version is incremented by every update, so that concurrent updates of the same version could be detected
+ALTER TABLE users ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
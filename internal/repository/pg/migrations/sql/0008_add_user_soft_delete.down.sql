@@ -0,0 +1,10 @@
+-- deleted users could not be kept without taking their email and nickname back
+DELETE FROM users WHERE deleted_at IS NOT NULL;
+
+DROP INDEX IF EXISTS users_deleted_at_idx;
+DROP INDEX IF EXISTS users_email_uq;
+DROP INDEX IF EXISTS users_nickname_uq;
+ALTER TABLE users ADD CONSTRAINT nickname_uq UNIQUE (nickname);
+ALTER TABLE users ADD CONSTRAINT email_uq UNIQUE (email);
+
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,11 @@
+-- deleted users are kept until they are purged, so that they could be restored meanwhile
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+
+-- email and nickname of deleted users are free to be taken by others
+ALTER TABLE users DROP CONSTRAINT IF EXISTS nickname_uq;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS email_uq;
+CREATE UNIQUE INDEX IF NOT EXISTS users_nickname_uq ON users USING btree (nickname) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_uq ON users USING btree (email) WHERE deleted_at IS NULL;
+
+-- deleted users due for purge
+CREATE INDEX IF NOT EXISTS users_deleted_at_idx ON users USING btree (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,16 @@
+-- append-only trail of user changes, entries are kept after the user is purged
+CREATE TABLE IF NOT EXISTS user_audit (
+    id BIGSERIAL NOT NULL PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    action TEXT NOT NULL,
+    actor_subject TEXT,
+    actor_method TEXT,
+    actor_role TEXT,
+    transport TEXT,
+    request_id TEXT,
+    -- field values before and after the change, password values are redacted
+    changes JSONB NOT NULL DEFAULT '[]',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS user_audit_user_id_idx ON user_audit USING btree (user_id, id DESC);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS outbox_user_id_idx;
+
+ALTER TABLE users DROP COLUMN IF EXISTS erased_at;
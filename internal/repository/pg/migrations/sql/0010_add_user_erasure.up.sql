@@ -0,0 +1,6 @@
+-- erased users keep their row with personal data cleared, they are neither restored nor purged.
+-- Their audit changes and outbox payloads are redacted in place
+ALTER TABLE users ADD COLUMN IF NOT EXISTS erased_at TIMESTAMP;
+
+-- every event of the user is looked up for data subject requests, delivered ones included
+CREATE INDEX IF NOT EXISTS outbox_user_id_idx ON outbox USING btree (user_id, id);
@@ -0,0 +1,11 @@
+-- personal data of encrypted users could not be decrypted without their data keys anymore
+
+DROP INDEX IF EXISTS users_country_bidx_idx;
+DROP INDEX IF EXISTS users_nickname_bidx_uq;
+DROP INDEX IF EXISTS users_email_bidx_uq;
+
+ALTER TABLE users DROP COLUMN IF EXISTS country_bidx;
+ALTER TABLE users DROP COLUMN IF EXISTS nickname_bidx;
+ALTER TABLE users DROP COLUMN IF EXISTS email_bidx;
+ALTER TABLE users DROP COLUMN IF EXISTS data_key;
+ALTER TABLE users DROP COLUMN IF EXISTS data_key_id;
@@ -0,0 +1,13 @@
+-- personal data of the users is encrypted with a data key of their own once encryption is configured,
+-- the data key is wrapped with the master key data_key_id. Both are NULL for plaintext users
+ALTER TABLE users ADD COLUMN IF NOT EXISTS data_key_id TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS data_key TEXT;
+
+-- blind indexes, keyed hashes encrypted values are compared for equality with.
+-- Uniqueness of email and nickname of encrypted users relies on them, erased users are not indexed
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_bidx TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS nickname_bidx TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS country_bidx TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_bidx_uq ON users USING btree (email_bidx) WHERE deleted_at IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS users_nickname_bidx_uq ON users USING btree (nickname_bidx) WHERE deleted_at IS NULL;
+CREATE INDEX IF NOT EXISTS users_country_bidx_idx ON users USING btree (country_bidx);
@@ -0,0 +1,6 @@
+-- sealed changes and payloads could not be decrypted without their data keys anymore
+
+ALTER TABLE outbox DROP COLUMN IF EXISTS data_key;
+ALTER TABLE outbox DROP COLUMN IF EXISTS data_key_id;
+ALTER TABLE user_audit DROP COLUMN IF EXISTS data_key;
+ALTER TABLE user_audit DROP COLUMN IF EXISTS data_key_id;
@@ -0,0 +1,7 @@
+-- changes of the audit entries and payloads of the outbox events hold personal data of the users, they are sealed
+-- as a whole with a data key of their own once encryption is configured, just like users. Both are NULL for
+-- plaintext records, sealed changes are stored as JSON strings
+ALTER TABLE user_audit ADD COLUMN IF NOT EXISTS data_key_id TEXT;
+ALTER TABLE user_audit ADD COLUMN IF NOT EXISTS data_key TEXT;
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS data_key_id TEXT;
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS data_key TEXT;
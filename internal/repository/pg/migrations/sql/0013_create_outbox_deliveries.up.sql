@@ -0,0 +1,9 @@
+-- subscribers done with the outbox event, either delivered or rejected for good. Retries of the event skip them,
+-- so that a single failing subscriber gets the event again while the others do not
+CREATE TABLE IF NOT EXISTS outbox_deliveries (
+    event_id BIGINT NOT NULL REFERENCES outbox (id) ON DELETE CASCADE,
+    subscriber TEXT NOT NULL,
+    rejected TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (event_id, subscriber)
+);
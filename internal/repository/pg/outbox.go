@@ -0,0 +1,203 @@
+package pg
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/BorisRostovskiy/ESL/internal/service"
+)
+
+// relayLockID random but stable key of the advisory lock, a single relay delivers events at a time,
+// so that events of the same user are never delivered by several instances concurrently
+const relayLockID = 7205124912
+
+// outboxTable table of the outbox events, sealed payloads are bound to it
+const outboxTable = "outbox"
+
+// OutboxEvent storage outbox event representation
+type OutboxEvent struct {
+	ID        int64          `db:"id"`
+	UserID    string         `db:"user_id"`
+	Channel   string         `db:"channel"`
+	Payload   string         `db:"payload"`
+	CreatedAt time.Time      `db:"created_at"`
+	Attempts  int            `db:"attempts"`
+	DataKeyID sql.NullString `db:"data_key_id"`
+	DataKey   sql.NullString `db:"data_key"`
+}
+
+// AddEvent stores event, must be called within the transaction of the change
+func (r *Repo) AddEvent(ctx context.Context, e *service.OutboxEvent) error {
+	payload, keyID, wrapped, err := r.crypto.sealRecord(outboxTable, e.UserID, e.Payload)
+	if err != nil {
+		return err
+	}
+	e.CreatedAt = time.Now().UTC()
+	err = r.q(ctx).GetContext(ctx, &e.ID,
+		`INSERT INTO outbox (user_id, channel, payload, created_at, data_key_id, data_key)
+			VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		e.UserID, e.Channel, payload, e.CreatedAt, keyID, wrapped)
+	if err != nil {
+		return fmt.Errorf("could not add outbox event: %w", err)
+	}
+	return nil
+}
+
+// ClaimEvents returns events due for delivery ordered by id, skipping users whose earlier events are
+// waiting for retry or claimed. Returned events are not due till `until`. Must be called within a transaction,
+// it holds the relay lock till its end
+func (r *Repo) ClaimEvents(ctx context.Context, limit int, until time.Time) ([]service.OutboxEvent, error) {
+	var locked bool
+	if err := r.q(ctx).GetContext(ctx, &locked, `SELECT pg_try_advisory_xact_lock($1)`, relayLockID); err != nil {
+		return nil, fmt.Errorf("could not acquire relay lock: %w", err)
+	}
+	if !locked {
+		return nil, nil
+	}
+
+	events := make([]OutboxEvent, 0)
+	err := r.q(ctx).SelectContext(ctx, &events,
+		`UPDATE outbox SET next_attempt_at=$1
+		WHERE id IN (
+			SELECT id FROM outbox o
+			WHERE delivered_at IS NULL AND failed_at IS NULL AND next_attempt_at <= NOW()
+				AND NOT EXISTS (
+					SELECT 1 FROM outbox p
+					WHERE p.user_id = o.user_id AND p.id < o.id
+						AND p.delivered_at IS NULL AND p.failed_at IS NULL AND p.next_attempt_at > NOW()
+				)
+			ORDER BY id
+			LIMIT $2
+		)
+		RETURNING id, user_id, channel, payload, created_at, attempts, data_key_id, data_key`, claimTime(until), limit)
+	if err != nil {
+		return nil, fmt.Errorf("could not claim pending outbox events: %w", err)
+	}
+	// RETURNING keeps no order
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+
+	result := make([]service.OutboxEvent, len(events))
+	for i := range events {
+		e, err := r.toServiceEvent(&events[i])
+		if err != nil {
+			return nil, err
+		}
+		result[i] = *e
+	}
+	return result, nil
+}
+
+// toServiceEvent converts stored event to the service one, its payload is decrypted
+func (r *Repo) toServiceEvent(e *OutboxEvent) (*service.OutboxEvent, error) {
+	payload, err := r.crypto.openRecord(outboxTable, e.UserID, e.Payload, e.DataKeyID, e.DataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &service.OutboxEvent{
+		ID:        e.ID,
+		UserID:    e.UserID,
+		Channel:   e.Channel,
+		Payload:   payload,
+		CreatedAt: e.CreatedAt,
+		Attempts:  e.Attempts,
+	}, nil
+}
+
+// MarkDelivered records successful delivery
+func (r *Repo) MarkDelivered(ctx context.Context, id int64) error {
+	if _, err := r.q(ctx).ExecContext(ctx,
+		`UPDATE outbox SET delivered_at=$1 WHERE id=$2`, time.Now().UTC(), id); err != nil {
+		return fmt.Errorf("could not mark outbox event delivered: %w", err)
+	}
+	return nil
+}
+
+// MarkAttemptFailed records failed attempt, event is parked for good if retryAt is nil
+func (r *Repo) MarkAttemptFailed(ctx context.Context, id int64, reason string, retryAt *time.Time) error {
+	query := `UPDATE outbox SET attempts=attempts+1, last_error=$1, next_attempt_at=$2 WHERE id=$3`
+	args := []interface{}{reason, retryAt, id}
+	if retryAt == nil {
+		query = `UPDATE outbox SET attempts=attempts+1, last_error=$1, failed_at=$2 WHERE id=$3`
+		args[1] = time.Now().UTC()
+	}
+	if _, err := r.q(ctx).ExecContext(ctx, query, args...); err != nil {
+		return fmt.Errorf("could not mark outbox event attempt failed: %w", err)
+	}
+	return nil
+}
+
+// RenewClaim extends claim of the event till `until` if it is still claimed till `claimed`,
+// returns false if the claim has been taken over or the event is not pending anymore
+func (r *Repo) RenewClaim(ctx context.Context, id int64, claimed, until time.Time) (bool, error) {
+	res, err := r.q(ctx).ExecContext(ctx,
+		`UPDATE outbox SET next_attempt_at=$1
+		WHERE id=$2 AND next_attempt_at=$3 AND delivered_at IS NULL AND failed_at IS NULL`,
+		claimTime(until), id, claimTime(claimed))
+	if err != nil {
+		return false, fmt.Errorf("could not renew outbox event claim: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("could not renew outbox event claim: %w", err)
+	}
+	return n == 1, nil
+}
+
+// ReleaseEvents makes events still claimed till `claimed` due right away
+func (r *Repo) ReleaseEvents(ctx context.Context, ids []int64, claimed time.Time) error {
+	if _, err := r.q(ctx).ExecContext(ctx,
+		`UPDATE outbox SET next_attempt_at=NOW()
+		WHERE id = ANY($1) AND next_attempt_at=$2 AND delivered_at IS NULL AND failed_at IS NULL`,
+		ids, claimTime(claimed)); err != nil {
+		return fmt.Errorf("could not release outbox events: %w", err)
+	}
+	return nil
+}
+
+// claimTime end of the claim as it is stored, timestamps keep microseconds only, so that claims are matched exactly
+func claimTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Microsecond)
+}
+
+// SubscriberDelivery storage representation of the outbox event delivery to a single subscriber
+type SubscriberDelivery struct {
+	EventID    int64          `db:"event_id"`
+	Subscriber string         `db:"subscriber"`
+	Rejected   sql.NullString `db:"rejected"`
+	CreatedAt  time.Time      `db:"created_at"`
+}
+
+// AddSubscriberDelivery records that subscriber is done with the event, recording it once again changes nothing
+func (r *Repo) AddSubscriberDelivery(ctx context.Context, d *service.SubscriberDelivery) error {
+	d.CreatedAt = time.Now().UTC()
+	if _, err := r.q(ctx).ExecContext(ctx,
+		`INSERT INTO outbox_deliveries (event_id, subscriber, rejected, created_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (event_id, subscriber) DO NOTHING`,
+		d.EventID, d.Subscriber, sql.NullString{String: d.Rejected, Valid: d.Rejected != ""}, d.CreatedAt); err != nil {
+		return fmt.Errorf("could not add outbox event delivery: %w", err)
+	}
+	return nil
+}
+
+// SubscriberDeliveries returns subscribers done with the event
+func (r *Repo) SubscriberDeliveries(ctx context.Context, eventID int64) ([]service.SubscriberDelivery, error) {
+	deliveries := make([]SubscriberDelivery, 0)
+	if err := r.q(ctx).SelectContext(ctx, &deliveries,
+		`SELECT event_id, subscriber, rejected, created_at FROM outbox_deliveries WHERE event_id=$1 ORDER BY subscriber`,
+		eventID); err != nil {
+		return nil, fmt.Errorf("could not select outbox event deliveries: %w", err)
+	}
+	result := make([]service.SubscriberDelivery, len(deliveries))
+	for i, d := range deliveries {
+		result[i] = service.SubscriberDelivery{
+			EventID:    d.EventID,
+			Subscriber: d.Subscriber,
+			Rejected:   d.Rejected.String,
+			CreatedAt:  d.CreatedAt,
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,84 @@
+package pg
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/BorisRostovskiy/ESL/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepo_SubscriberDeliveries(t *testing.T) {
+	t.Parallel()
+	var added [][]driver.NamedValue
+	db := &fakeDB{
+		exec: func(_ string, args []driver.NamedValue) (driver.Result, error) {
+			added = append(added, args)
+			return driver.RowsAffected(1), nil
+		},
+		query: func(_ string, args []driver.NamedValue) (driver.Rows, error) {
+			assert.Equal(t, int64(7), args[0].Value)
+			return &fakeRows{
+				columns: []string{"event_id", "subscriber", "rejected", "created_at"},
+				values: [][]driver.Value{
+					{int64(7), "http://crm/hooks", nil, time.Now()},
+					{int64(7), "http://legacy/hooks", "subscriber rejected event with 410", time.Now()},
+				},
+			}, nil
+		},
+	}
+	repo := newFakeRepo(t, db)
+	ctx := context.Background()
+
+	require.NoError(t, repo.AddSubscriberDelivery(ctx, &service.SubscriberDelivery{EventID: 7, Subscriber: "http://crm/hooks"}))
+	require.NoError(t, repo.AddSubscriberDelivery(ctx, &service.SubscriberDelivery{EventID: 7,
+		Subscriber: "http://legacy/hooks", Rejected: "subscriber rejected event with 410"}))
+	require.Len(t, added, 2)
+	assert.Equal(t, sql.NullString{}, added[0][2].Value, "delivered events are not rejected")
+	assert.Equal(t, sql.NullString{String: "subscriber rejected event with 410", Valid: true}, added[1][2].Value)
+	assert.Contains(t, db.Queries()[0], "ON CONFLICT (event_id, subscriber) DO NOTHING")
+
+	deliveries, err := repo.SubscriberDeliveries(ctx, 7)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2)
+	assert.Equal(t, "http://crm/hooks", deliveries[0].Subscriber)
+	assert.Empty(t, deliveries[0].Rejected)
+	assert.Equal(t, "subscriber rejected event with 410", deliveries[1].Rejected)
+}
+
+func TestRepo_RenewClaim(t *testing.T) {
+	t.Parallel()
+	var args [][]driver.NamedValue
+	affected := int64(1)
+	db := &fakeDB{
+		exec: func(_ string, named []driver.NamedValue) (driver.Result, error) {
+			args = append(args, named)
+			return driver.RowsAffected(affected), nil
+		},
+	}
+	repo := newFakeRepo(t, db)
+	ctx := context.Background()
+	claimed := time.Date(2024, 8, 7, 13, 19, 6, 123456789, time.Local)
+	until := claimed.Add(time.Minute)
+
+	renewed, err := repo.RenewClaim(ctx, 7, claimed, until)
+	require.NoError(t, err)
+	assert.True(t, renewed)
+	assert.Contains(t, db.Queries()[0], "WHERE id=$2 AND next_attempt_at=$3")
+	// stored timestamps keep microseconds only, claim would never match otherwise
+	assert.Equal(t, until.UTC().Truncate(time.Microsecond), args[0][0].Value)
+	assert.Equal(t, time.Date(2024, 8, 7, 13, 19, 6, 123456000, time.Local).UTC(), args[0][2].Value)
+
+	affected = 0
+	renewed, err = repo.RenewClaim(ctx, 7, claimed, until)
+	require.NoError(t, err)
+	assert.False(t, renewed, "claim has been taken over")
+
+	require.NoError(t, repo.ReleaseEvents(ctx, []int64{7, 8}, claimed))
+	assert.Contains(t, db.Queries()[2], "WHERE id = ANY($1) AND next_attempt_at=$2")
+	assert.Equal(t, args[0][2].Value, args[2][1].Value, "released events must be claimed by the relay")
+}
@@ -0,0 +1,164 @@
+package pg
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/BorisRostovskiy/ESL/internal/events"
+	"github.com/BorisRostovskiy/ESL/internal/repository"
+	"github.com/BorisRostovskiy/ESL/internal/service"
+)
+
+// UserRecord storage user representation along with its deletion state
+type UserRecord struct {
+	User
+	DeletedAt sql.NullTime `db:"deleted_at"`
+	ErasedAt  sql.NullTime `db:"erased_at"`
+}
+
+// Notification storage outbox event representation along with its delivery state
+type Notification struct {
+	OutboxEvent
+	DeliveredAt sql.NullTime   `db:"delivered_at"`
+	FailedAt    sql.NullTime   `db:"failed_at"`
+	LastError   sql.NullString `db:"last_error"`
+}
+
+// UserRecord retrieve user by ID, deleted and erased users included
+func (r *Repo) UserRecord(ctx context.Context, userID string) (*service.UserRecord, error) {
+	var user UserRecord
+	query := `SELECT ` + userColumns + `, deleted_at, erased_at
+		FROM users WHERE id=$1`
+
+	if err := r.q(ctx).GetContext(ctx, &user, query, userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repository.NoUsersFoundError
+		}
+		return nil, fmt.Errorf("could not perform select user record: %w", err)
+	}
+	u, err := r.toService(&user.User)
+	if err != nil {
+		return nil, err
+	}
+	return &service.UserRecord{
+		User:      *u,
+		DeletedAt: nullTime(user.DeletedAt),
+		ErasedAt:  nullTime(user.ErasedAt),
+	}, nil
+}
+
+// UserNotifications returns every outbox event of the user ordered by id, delivered ones included
+func (r *Repo) UserNotifications(ctx context.Context, userID string) ([]service.Notification, error) {
+	query := `SELECT id, user_id, channel, payload, created_at, attempts, data_key_id, data_key,
+			delivered_at, failed_at, last_error
+		FROM outbox WHERE user_id=$1
+		ORDER BY id`
+
+	notifications := make([]Notification, 0)
+	if err := r.q(ctx).SelectContext(ctx, &notifications, query, userID); err != nil {
+		return nil, fmt.Errorf("could not select user outbox events: %w", err)
+	}
+	result := make([]service.Notification, len(notifications))
+	for i := range notifications {
+		n := &notifications[i]
+		e, err := r.toServiceEvent(&n.OutboxEvent)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = service.Notification{
+			OutboxEvent: *e,
+			DeliveredAt: nullTime(n.DeliveredAt),
+			FailedAt:    nullTime(n.FailedAt),
+			LastError:   n.LastError.String,
+		}
+	}
+	return result, nil
+}
+
+// EraseUser clears personal data, its blind indexes and password of the user and deletes its refresh tokens,
+// the user is soft-deleted unless it is already. Its version is incremented, NoUsersFoundError is returned if the user is erased already
+func (r *Repo) EraseUser(ctx context.Context, userID string) error {
+	now := time.Now()
+	query := `WITH erased AS (
+			UPDATE users SET first_name='', last_name='', nickname='', email='', password='',
+				email_bidx=NULL, nickname_bidx=NULL,
+				deleted_at=COALESCE(deleted_at, $1), erased_at=$1, updated_at=$1, version=version+1
+			WHERE id=$2 AND erased_at IS NULL
+			RETURNING id
+		), tokens AS (
+			DELETE FROM refresh_tokens WHERE user_id IN (SELECT id FROM erased)
+		)
+		SELECT count(*) FROM erased`
+
+	var erased int
+	if err := r.q(ctx).GetContext(ctx, &erased, query, now, userID); err != nil {
+		return fmt.Errorf("could not erase user: %w", err)
+	}
+	if erased == 0 {
+		return repository.NoUsersFoundError
+	}
+	return nil
+}
+
+// UpdateNotificationPayload replaces payload of the outbox event, it is sealed with a new data key
+func (r *Repo) UpdateNotificationPayload(ctx context.Context, id int64, payload string) error {
+	userID, err := r.recordOwner(ctx, outboxTable, id)
+	if err != nil {
+		return err
+	}
+	sealed, keyID, wrapped, err := r.crypto.sealRecord(outboxTable, userID, payload)
+	if err != nil {
+		return err
+	}
+	if _, err = r.q(ctx).ExecContext(ctx,
+		`UPDATE outbox SET payload=$1, data_key_id=$2, data_key=$3 WHERE id=$4`, sealed, keyID, wrapped, id); err != nil {
+		return fmt.Errorf("could not update outbox event payload: %w", err)
+	}
+	return nil
+}
+
+// UpdateAuditChanges replaces changes of the audit entry
+func (r *Repo) UpdateAuditChanges(ctx context.Context, id int64, changes []events.Change) error {
+	if changes == nil {
+		changes = []events.Change{}
+	}
+	data, err := json.Marshal(changes)
+	if err != nil {
+		return fmt.Errorf("could not marshal audit changes: %w", err)
+	}
+	userID, err := r.recordOwner(ctx, auditTable, id)
+	if err != nil {
+		return err
+	}
+	sealed, keyID, wrapped, err := r.crypto.sealChanges(userID, string(data))
+	if err != nil {
+		return err
+	}
+	if _, err = r.q(ctx).ExecContext(ctx,
+		`UPDATE user_audit SET changes=$1, data_key_id=$2, data_key=$3 WHERE id=$4`, sealed, keyID, wrapped, id); err != nil {
+		return fmt.Errorf("could not update audit entry changes: %w", err)
+	}
+	return nil
+}
+
+// recordOwner returns ID of the user the record of the table belongs to, sealed records are bound to it.
+// Missing records own nothing, updating them does nothing
+func (r *Repo) recordOwner(ctx context.Context, table string, id int64) (string, error) {
+	var userID string
+	err := r.q(ctx).GetContext(ctx, &userID, `SELECT user_id FROM `+table+` WHERE id=$1`, id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("could not select owner of %s record: %w", table, err)
+	}
+	return userID, nil
+}
+
+func nullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
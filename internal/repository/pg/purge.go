@@ -0,0 +1,31 @@
+package pg
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// PurgeUsers hard-deletes up to limit users soft-deleted before the given time along with their refresh tokens,
+// returns number of purged users. Erased users are kept for good. Users locked by concurrent purges are skipped,
+// so that instances never wait for each other
+func (r *Repo) PurgeUsers(ctx context.Context, deletedBefore time.Time, limit int) (int, error) {
+	var purged int
+	err := r.q(ctx).GetContext(ctx, &purged,
+		`WITH purged AS (
+			DELETE FROM users WHERE id IN (
+				SELECT id FROM users WHERE deleted_at < $1 AND erased_at IS NULL
+				ORDER BY deleted_at
+				LIMIT $2
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING id
+		), tokens AS (
+			DELETE FROM refresh_tokens WHERE user_id IN (SELECT id FROM purged)
+		)
+		SELECT count(*) FROM purged`, deletedBefore, limit)
+	if err != nil {
+		return 0, fmt.Errorf("could not purge users: %w", err)
+	}
+	return purged, nil
+}
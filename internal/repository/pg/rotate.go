@@ -0,0 +1,246 @@
+package pg
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultRotateBatchSize users or records re-encrypted within a single transaction unless the batch size is set
+const DefaultRotateBatchSize = 500
+
+type (
+	// KeyRotator re-encrypts users and their records sealed with previous master keys, as well as plaintext ones,
+	// with the current key
+	KeyRotator struct {
+		conn   *sqlx.DB
+		crypto *fieldCipher
+		log    logrus.FieldLogger
+	}
+
+	// sealedTable table of the user records sealed as a whole, the column holds the sealed value
+	sealedTable struct {
+		name   string
+		column string
+		// records what the records are, in messages
+		records string
+		seal    func(c *fieldCipher, userID, value string) (string, sql.NullString, sql.NullString, error)
+		open    func(c *fieldCipher, userID, value string, keyID, wrapped sql.NullString) (string, error)
+	}
+
+	// sealedRecord storage representation of the sealed record
+	sealedRecord struct {
+		ID        int64          `db:"id"`
+		UserID    string         `db:"user_id"`
+		Value     string         `db:"value"`
+		DataKeyID sql.NullString `db:"data_key_id"`
+		DataKey   sql.NullString `db:"data_key"`
+	}
+)
+
+// sealedTables records holding personal data of the users
+var sealedTables = []sealedTable{
+	{
+		name:    auditTable,
+		column:  "changes",
+		records: "audit entries",
+		seal:    (*fieldCipher).sealChanges,
+		open:    (*fieldCipher).openChanges,
+	},
+	{
+		name:    outboxTable,
+		column:  "payload",
+		records: "outbox events",
+		seal: func(c *fieldCipher, userID, value string) (string, sql.NullString, sql.NullString, error) {
+			return c.sealRecord(outboxTable, userID, value)
+		},
+		open: func(c *fieldCipher, userID, value string, keyID, wrapped sql.NullString) (string, error) {
+			return c.openRecord(outboxTable, userID, value, keyID, wrapped)
+		},
+	},
+}
+
+// NewKeyRotator sets up key rotation of the Postgres repository, encryption must be configured
+func NewKeyRotator(cfg *Config, log *logrus.Logger) (*KeyRotator, error) {
+	crypto, err := newFieldCipher(cfg.Encryption)
+	if err != nil {
+		return nil, fmt.Errorf("an error occurred during setup encryption: %w", err)
+	}
+	if crypto == nil {
+		return nil, fmt.Errorf("encryption is not configured")
+	}
+
+	conn, err := Connect(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("an error occurred during setup connection pool: %w", err)
+	}
+	if !cfg.SkipMigrations {
+		if err = applyMigrations(conn, log); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("an error occurred during applying migrations: %w", err)
+		}
+	}
+	return &KeyRotator{conn: conn, crypto: crypto, log: log.WithField("component", "key-rotation")}, nil
+}
+
+// Close closes the connection pool
+func (k *KeyRotator) Close() error {
+	return k.conn.Close()
+}
+
+// Rotate re-encrypts users, their audit entries and outbox events in batches of the given size, each one within
+// a transaction of its own, so that they are locked for a short while only. Every user and record gets a new data
+// key and blind indexes of the users are rebuilt, neither their versions nor update times change. Returns number of
+// re-encrypted users and records
+func (k *KeyRotator) Rotate(ctx context.Context, batchSize int) (int, error) {
+	if batchSize <= 0 {
+		batchSize = DefaultRotateBatchSize
+	}
+	rotated, err := k.rotateUsers(ctx, batchSize)
+	if err != nil {
+		return rotated, err
+	}
+	for _, table := range sealedTables {
+		n, err := k.rotateRecords(ctx, table, batchSize)
+		rotated += n
+		if err != nil {
+			return rotated, err
+		}
+	}
+	return rotated, nil
+}
+
+// rotateUsers re-encrypts users in batches, returns number of re-encrypted users
+func (k *KeyRotator) rotateUsers(ctx context.Context, batchSize int) (int, error) {
+	var (
+		rotated int
+		after   string
+	)
+	for {
+		n, last, err := k.rotateBatch(ctx, after, batchSize)
+		rotated += n
+		if err != nil {
+			return rotated, err
+		}
+		if n < batchSize {
+			return rotated, nil
+		}
+		k.log.Infof("%d user(s) re-encrypted so far", rotated)
+		after = last
+	}
+}
+
+// rotateBatch re-encrypts up to size users going after the given id in id order, returns id of the last one
+func (k *KeyRotator) rotateBatch(ctx context.Context, after string, size int) (int, string, error) {
+	tx, err := k.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, "", fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	users := make([]User, 0, size)
+	err = tx.SelectContext(ctx, &users,
+		`SELECT id, first_name, last_name, nickname, email, country, data_key_id, data_key
+		FROM users WHERE id > $1 AND data_key_id IS DISTINCT FROM $2
+		ORDER BY id
+		LIMIT $3
+		FOR UPDATE`, after, k.crypto.keyID, size)
+	if err != nil {
+		return 0, "", fmt.Errorf("could not select users to re-encrypt: %w", err)
+	}
+	if len(users) == 0 {
+		return 0, after, nil
+	}
+
+	for i := range users {
+		u := &users[i]
+		if err = k.crypto.open(u); err != nil {
+			return 0, "", err
+		}
+		if err = k.crypto.seal(u); err != nil {
+			return 0, "", err
+		}
+		_, err = tx.ExecContext(ctx,
+			`UPDATE users SET first_name=$1, last_name=$2, nickname=$3, email=$4, country=$5,
+				data_key_id=$6, data_key=$7, email_bidx=$8, nickname_bidx=$9, country_bidx=$10
+			WHERE id=$11`,
+			u.FirstName, u.LastName, u.NickName, u.Email, u.Country,
+			u.DataKeyID, u.DataKey, u.EmailIndex, u.NicknameIndex, u.CountryIndex, u.Id)
+		if err != nil {
+			return 0, "", fmt.Errorf("could not re-encrypt user %s: %w", u.Id, err)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, "", fmt.Errorf("could not commit transaction: %w", err)
+	}
+	return len(users), users[len(users)-1].Id, nil
+}
+
+// rotateRecords re-encrypts records of the table in batches, returns number of re-encrypted records
+func (k *KeyRotator) rotateRecords(ctx context.Context, table sealedTable, batchSize int) (int, error) {
+	var (
+		rotated int
+		after   int64
+	)
+	for {
+		n, last, err := k.rotateRecordBatch(ctx, table, after, batchSize)
+		rotated += n
+		if err != nil {
+			return rotated, err
+		}
+		if n < batchSize {
+			return rotated, nil
+		}
+		k.log.Infof("%d %s re-encrypted so far", rotated, table.records)
+		after = last
+	}
+}
+
+// rotateRecordBatch re-encrypts up to size records of the table going after the given id in id order,
+// returns id of the last one
+func (k *KeyRotator) rotateRecordBatch(ctx context.Context, table sealedTable, after int64, size int) (int, int64, error) {
+	tx, err := k.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	records := make([]sealedRecord, 0, size)
+	err = tx.SelectContext(ctx, &records,
+		`SELECT id, user_id, `+table.column+`::TEXT AS value, data_key_id, data_key
+		FROM `+table.name+` WHERE id > $1 AND data_key_id IS DISTINCT FROM $2
+		ORDER BY id
+		LIMIT $3
+		FOR UPDATE`, after, k.crypto.keyID, size)
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not select %s records to re-encrypt: %w", table.name, err)
+	}
+	if len(records) == 0 {
+		return 0, after, nil
+	}
+
+	for i := range records {
+		r := &records[i]
+		value, err := table.open(k.crypto, r.UserID, r.Value, r.DataKeyID, r.DataKey)
+		if err != nil {
+			return 0, 0, err
+		}
+		sealed, keyID, wrapped, err := table.seal(k.crypto, r.UserID, value)
+		if err != nil {
+			return 0, 0, err
+		}
+		_, err = tx.ExecContext(ctx,
+			`UPDATE `+table.name+` SET `+table.column+`=$1, data_key_id=$2, data_key=$3 WHERE id=$4`,
+			sealed, keyID, wrapped, r.ID)
+		if err != nil {
+			return 0, 0, fmt.Errorf("could not re-encrypt %s record %d: %w", table.name, r.ID, err)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("could not commit transaction: %w", err)
+	}
+	return len(records), records[len(records)-1].ID, nil
+}
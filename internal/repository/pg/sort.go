@@ -0,0 +1,74 @@
+package pg
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/BorisRostovskiy/ESL/internal/service"
+)
+
+// orderBy ORDER BY list of the sort, id breaks ties in the direction of the last key
+func orderBy(sort service.Sort) string {
+	parts := make([]string, 0, len(sort)+1)
+	for _, k := range sort {
+		parts = append(parts, filterColumns[k.Field]+direction(k.Desc))
+	}
+	return strings.Join(append(parts, "id"+direction(sort[len(sort)-1].Desc)), ", ")
+}
+
+// whereAfter keyset condition of the rows going after the cursor. Row comparison is used if all the keys
+// are in the same direction, so that the index on them is used, it is expanded to OR of the prefixes otherwise
+func whereAfter(sort service.Sort, c *service.Cursor, args *[]interface{}) (string, error) {
+	columns := make([]string, 0, len(sort)+1)
+	values := make([]string, 0, len(sort)+1)
+	for i, k := range sort {
+		v, err := service.ParseSortValue(k.Field, c.Values[i])
+		if err != nil {
+			return "", err
+		}
+		*args = append(*args, v)
+		columns = append(columns, filterColumns[k.Field])
+		values = append(values, fmt.Sprintf("$%d", len(*args)))
+	}
+	*args = append(*args, c.ID)
+	columns = append(columns, "id")
+	values = append(values, fmt.Sprintf("$%d", len(*args)))
+
+	desc := make([]bool, 0, len(columns))
+	sameDirection := true
+	for _, k := range sort {
+		desc = append(desc, k.Desc)
+		sameDirection = sameDirection && k.Desc == sort[0].Desc
+	}
+	desc = append(desc, sort[len(sort)-1].Desc)
+
+	if sameDirection {
+		return fmt.Sprintf("(%s) %s (%s)", strings.Join(columns, ", "), after(desc[0]),
+			strings.Join(values, ", ")), nil
+	}
+	alternatives := make([]string, len(columns))
+	for i := range columns {
+		terms := make([]string, 0, i+1)
+		for j := 0; j < i; j++ {
+			terms = append(terms, fmt.Sprintf("%s = %s", columns[j], values[j]))
+		}
+		terms = append(terms, fmt.Sprintf("%s %s %s", columns[i], after(desc[i]), values[i]))
+		alternatives[i] = "(" + strings.Join(terms, " AND ") + ")"
+	}
+	return "(" + strings.Join(alternatives, " OR ") + ")", nil
+}
+
+func direction(desc bool) string {
+	if desc {
+		return " DESC"
+	}
+	return " ASC"
+}
+
+// after comparison operator of the values going after the given one
+func after(desc bool) string {
+	if desc {
+		return "<"
+	}
+	return ">"
+}
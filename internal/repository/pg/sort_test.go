@@ -0,0 +1,60 @@
+package pg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/BorisRostovskiy/ESL/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWhereAfter(t *testing.T) {
+	t.Parallel()
+	updated := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := map[string]struct {
+		sort    string
+		values  []string
+		orderBy string
+		where   string
+		args    []interface{}
+	}{
+		"default": {
+			values:  []string{"2024-01-01T10:00:00.000000000Z"},
+			orderBy: "created_at DESC, id DESC",
+			where:   "(created_at, id) < ($1, $2)",
+			args:    []interface{}{updated, "id"},
+		},
+		"same direction": {
+			sort:    "last_name,nickname",
+			values:  []string{"Doe", "jd"},
+			orderBy: "last_name ASC, nickname ASC, id ASC",
+			where:   "(last_name, nickname, id) > ($1, $2, $3)",
+			args:    []interface{}{"Doe", "jd", "id"},
+		},
+		"mixed directions": {
+			sort:    "-updated_at,last_name",
+			values:  []string{"2024-01-01T10:00:00.000000000Z", "Doe"},
+			orderBy: "updated_at DESC, last_name ASC, id ASC",
+			where:   "((updated_at < $1) OR (updated_at = $1 AND last_name > $2) OR (updated_at = $1 AND last_name = $2 AND id > $3))",
+			args:    []interface{}{updated, "Doe", "id"},
+		},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			sort, err := service.ParseSort(tc.sort)
+			require.NoError(t, err)
+			sort = sort.OrDefault()
+
+			var args []interface{}
+			where, err := whereAfter(sort, &service.Cursor{Values: tc.values, ID: "id"}, &args)
+			require.NoError(t, err)
+			assert.Equal(t, tc.orderBy, orderBy(sort))
+			assert.Equal(t, tc.where, where)
+			assert.Equal(t, tc.args, args)
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package pg
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/BorisRostovskiy/ESL/internal/service"
+)
+
+// streamFetchSize rows fetched from the cursor at once
+const streamFetchSize = 500
+
+// StreamUsers reads users through a server-side cursor within a read only REPEATABLE READ transaction,
+// so that all of them come from the same snapshot while only a batch is held in memory.
+// Next batch is not fetched until fn returns for every user of the previous one
+func (r *Repo) StreamUsers(ctx context.Context, q service.ListQuery, fn func(u *service.User) error) error {
+	query, queryArgs, err := r.listQuery(service.ListQuery{Filter: q.Filter, Sort: q.Sort})
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.conn.BeginTxx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	// cursor is closed along with the transaction, there is nothing to commit
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err = tx.ExecContext(ctx, "DECLARE users_stream NO SCROLL CURSOR FOR "+query, queryArgs...); err != nil {
+		return fmt.Errorf("could not declare users cursor: %w", err)
+	}
+	fetch := fmt.Sprintf("FETCH FORWARD %d FROM users_stream", streamFetchSize)
+	for {
+		users := make([]User, 0, streamFetchSize)
+		if err = tx.SelectContext(ctx, &users, fetch); err != nil {
+			return fmt.Errorf("could not fetch users: %w", err)
+		}
+		for i := range users {
+			u, err := r.toService(&users[i])
+			if err != nil {
+				return err
+			}
+			if err = fn(u); err != nil {
+				return err
+			}
+		}
+		if len(users) < streamFetchSize {
+			return nil
+		}
+	}
+}
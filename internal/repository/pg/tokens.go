@@ -0,0 +1,96 @@
+package pg
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/BorisRostovskiy/ESL/internal/repository"
+	"github.com/BorisRostovskiy/ESL/internal/service"
+)
+
+// RefreshToken storage refresh token representation
+type RefreshToken struct {
+	Hash      string       `db:"token_hash"`
+	UserID    string       `db:"user_id"`
+	FamilyID  string       `db:"family_id"`
+	CreatedAt time.Time    `db:"created_at"`
+	ExpiresAt time.Time    `db:"expires_at"`
+	RevokedAt sql.NullTime `db:"revoked_at"`
+}
+
+// CreateRefreshToken stores refresh token starting a new family
+func (r *Repo) CreateRefreshToken(ctx context.Context, t *service.RefreshToken) error {
+	_, err := r.q(ctx).ExecContext(ctx,
+		`INSERT INTO refresh_tokens (token_hash, user_id, family_id, created_at, expires_at) 
+				VALUES ($1, $2, $3, $4, $5)`,
+		t.Hash, t.UserID, t.FamilyID, t.CreatedAt, t.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("could not create refresh token: %w", err)
+	}
+	return nil
+}
+
+// RotateRefreshToken revokes token and stores the next one within the same family.
+// Presenting already revoked token revokes the whole family as it could be stolen
+func (r *Repo) RotateRefreshToken(ctx context.Context, hash string, next *service.RefreshToken) (*service.RefreshToken, error) {
+	tx, err := r.conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var old RefreshToken
+	err = tx.GetContext(ctx, &old,
+		`SELECT token_hash, user_id, family_id, created_at, expires_at, revoked_at
+				FROM refresh_tokens WHERE token_hash=$1 FOR UPDATE`, hash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repository.TokenNotFoundError
+		}
+		return nil, fmt.Errorf("could not select refresh token: %w", err)
+	}
+
+	now := time.Now().UTC()
+	if old.RevokedAt.Valid {
+		if _, err = tx.ExecContext(ctx,
+			`UPDATE refresh_tokens SET revoked_at=$1 WHERE family_id=$2 AND revoked_at IS NULL`,
+			now, old.FamilyID); err != nil {
+			return nil, fmt.Errorf("could not revoke refresh token family: %w", err)
+		}
+		if err = tx.Commit(); err != nil {
+			return nil, err
+		}
+		return nil, repository.TokenReusedError
+	}
+	if !old.ExpiresAt.After(now) {
+		return nil, repository.TokenNotFoundError
+	}
+
+	next.UserID = old.UserID
+	next.FamilyID = old.FamilyID
+	if _, err = tx.ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked_at=$1, replaced_by=$2 WHERE token_hash=$3`,
+		now, next.Hash, old.Hash); err != nil {
+		return nil, fmt.Errorf("could not revoke refresh token: %w", err)
+	}
+	if _, err = tx.ExecContext(ctx,
+		`INSERT INTO refresh_tokens (token_hash, user_id, family_id, created_at, expires_at) 
+				VALUES ($1, $2, $3, $4, $5)`,
+		next.Hash, next.UserID, next.FamilyID, next.CreatedAt, next.ExpiresAt); err != nil {
+		return nil, fmt.Errorf("could not create refresh token: %w", err)
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &service.RefreshToken{
+		Hash:      old.Hash,
+		UserID:    old.UserID,
+		FamilyID:  old.FamilyID,
+		CreatedAt: old.CreatedAt,
+		ExpiresAt: old.ExpiresAt,
+	}, nil
+}
@@ -1,18 +1,84 @@
 package pg
 
 import (
+	"database/sql"
 	"time"
+
+	"github.com/BorisRostovskiy/ESL/internal/service"
 )
 
 // User storage user representation
 type User struct {
-	Id        string
-	FirstName string
-	LastName  string
-	NickName  string
-	Password  string
-	Email     string
-	Country   string
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	Id        string    `db:"id"`
+	FirstName string    `db:"first_name"`
+	LastName  string    `db:"last_name"`
+	NickName  string    `db:"nickname"`
+	Password  string    `db:"password"`
+	Email     string    `db:"email"`
+	Country   string    `db:"country"`
+	Role      string    `db:"role"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+	Version   int64     `db:"version"`
+	// DataKeyID master key DataKey is wrapped with, both are NULL unless personal data is encrypted
+	DataKeyID sql.NullString `db:"data_key_id"`
+	DataKey   sql.NullString `db:"data_key"`
+	// blind indexes of the encrypted fields, they are written only
+	EmailIndex    sql.NullString `db:"email_bidx"`
+	NicknameIndex sql.NullString `db:"nickname_bidx"`
+	CountryIndex  sql.NullString `db:"country_bidx"`
+}
+
+// userColumns columns users are read with
+const userColumns = `id, first_name, last_name, nickname, email, country, role, created_at, updated_at, version,
+	data_key_id, data_key`
+
+// insertColumns columns users are created with, in the order of insertArgs
+const insertColumns = `id, first_name, last_name, nickname, password, email, country, role, created_at,
+	data_key_id, data_key, email_bidx, nickname_bidx, country_bidx`
+
+func (u *User) insertArgs() []interface{} {
+	return []interface{}{u.Id, u.FirstName, u.LastName, u.NickName, u.Password, u.Email, u.Country, u.Role,
+		u.CreatedAt, u.DataKeyID, u.DataKey, u.EmailIndex, u.NicknameIndex, u.CountryIndex}
+}
+
+func fromService(u *service.User) *User {
+	return &User{
+		Id:        u.ID,
+		FirstName: u.FirstName,
+		LastName:  u.LastName,
+		NickName:  u.NickName,
+		Email:     u.Email,
+		Country:   u.Country,
+		Role:      u.Role,
+		CreatedAt: u.CreatedAt,
+		UpdatedAt: u.UpdatedAt,
+		Version:   u.Version,
+	}
+}
+
+// personal column -> field of the personal data, it is encrypted if encryption is enabled
+func (u *User) personal() map[string]*string {
+	return map[string]*string{
+		"first_name": &u.FirstName,
+		"last_name":  &u.LastName,
+		"nickname":   &u.NickName,
+		"email":      &u.Email,
+		"country":    &u.Country,
+	}
+}
+
+func (u *User) toService() *service.User {
+	return &service.User{
+		ID:        u.Id,
+		FirstName: u.FirstName,
+		LastName:  u.LastName,
+		NickName:  u.NickName,
+		Email:     u.Email,
+		Country:   u.Country,
+		Role:      u.Role,
+		CreatedAt: u.CreatedAt,
+		UpdatedAt: u.UpdatedAt,
+		Version:   u.Version,
+	}
 }
@@ -5,17 +5,18 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/BorisRostovskiy/ESL/internal/repository"
+	"github.com/BorisRostovskiy/ESL/internal/repository/pg/migrations"
 	"github.com/BorisRostovskiy/ESL/internal/service"
 	"github.com/google/uuid"
+	"github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4/stdlib"
 	"github.com/jmoiron/sqlx"
-	"github.com/lib/pq"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
-	//"github.com/pkg/errors"
 )
 
 const (
@@ -25,48 +26,57 @@ const (
 	errPgUniqueKeyViolation  = "23505"
 
 	couldNotRetrieveAffected = "could not retrieve affected rows: %w"
-	schema                   = `
-	CREATE TABLE IF NOT EXISTS users (
-		id TEXT NOT NULL,
-		first_name TEXT NOT NULL,
-		last_name TEXT NOT NULL,
-		nickname TEXT NOT NULL,
-		password TEXT NOT NULL,
-		email TEXT NOT NULL,
-		country TEXT NOT NULL,
-		created_at TIMESTAMP,
-		updated_at TIMESTAMP DEFAULT NOW(),
-		CONSTRAINT id_uq UNIQUE (id),
-	    CONSTRAINT nickname_uq UNIQUE(nickname),
-	    CONSTRAINT email_uq UNIQUE (email)
-	);
-	CREATE INDEX IF NOT EXISTS country_idx ON users USING btree(country);
-`
 )
 
 type (
-	// Repo implements service.UserRepo interface
+	// Repo implements service.UserRepo and service.TokenRepo interfaces
 	Repo struct {
 		conn *sqlx.DB
 		log  *logrus.Logger
+		// filters fields ListUsers is allowed to filter by
+		filters service.Filters
+		// crypto encrypts personal data of the users, nil if it is stored in plaintext
+		crypto *fieldCipher
 	}
 )
 
-// New sets up a new Postgres repository.
-func New(cfg *Config, log *logrus.Logger) (*Repo, error) {
+// New sets up a new Postgres repository, users could be filtered by enabled fields only.
+// Personal data of the users is encrypted if encryption is configured, encrypted fields could be
+// compared for equality only then and could not be enabled for sorting
+func New(cfg *Config, filters service.Filters, sorts service.SortFields, log *logrus.Logger) (*Repo, error) {
 	repo := new(Repo)
 
-	conn, err := setupConnectionPool(cfg)
+	crypto, err := newFieldCipher(cfg.Encryption)
+	if err != nil {
+		return nil, fmt.Errorf("an error occurred during setup encryption: %w", err)
+	}
+	if crypto != nil {
+		if err = crypto.validateFilters(filters); err != nil {
+			return nil, fmt.Errorf("filters are not supported along with encryption: %w", err)
+		}
+		if err = crypto.validateSortFields(sorts); err != nil {
+			return nil, fmt.Errorf("sort fields are not supported along with encryption: %w", err)
+		}
+	}
+
+	conn, err := Connect(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("an error occurred during setup connection pool: %w", err)
 	}
 
-	//if err = applySchema(conn); err != nil {
-	//	return nil, fmt.Errorf("an error occurred during applying schema: %w", err)
-	//}
+	if !cfg.SkipMigrations {
+		if err = applyMigrations(conn, log); err != nil {
+			return nil, fmt.Errorf("an error occurred during applying migrations: %w", err)
+		}
+	}
+	if err = checkEncryption(conn, crypto); err != nil {
+		return nil, err
+	}
 
 	repo.conn = conn
 	repo.log = log
+	repo.filters = filters
+	repo.crypto = crypto
 	return repo, nil
 }
 
@@ -74,20 +84,26 @@ func New(cfg *Config, log *logrus.Logger) (*Repo, error) {
 func (r *Repo) CreateUser(ctx context.Context, newUser *service.User) (*service.User, error) {
 	newUser.ID = uuid.New().String()
 	newUser.CreatedAt = time.Now()
-	hashedPwd, err := bcrypt.GenerateFromPassword([]byte(newUser.Password), 8)
+	newUser.Version = 1
+	hashedPwd, err := bcrypt.GenerateFromPassword([]byte(newUser.Password), repository.PasswordCost)
 	if err != nil {
 		r.log.Errorf("generate pwd error: %v", err)
 		return nil, repository.GeneratePwdError
 	}
 
-	_, err = r.conn.ExecContext(ctx,
-		`INSERT INTO users (id, first_name, last_name, nickname, password, email, country, created_at) 
-				VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
-		newUser.ID, newUser.FirstName, newUser.LastName, newUser.NickName,
-		hashedPwd, newUser.Email, newUser.Country, newUser.CreatedAt)
+	stored := fromService(newUser)
+	stored.Password = string(hashedPwd)
+	if err = r.crypto.seal(stored); err != nil {
+		return nil, err
+	}
+
+	_, err = r.q(ctx).ExecContext(ctx,
+		`INSERT INTO users (`+insertColumns+`) 
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
+		stored.insertArgs()...)
 
 	if err != nil {
-		if isPgViolation(err, errPgForeignKeyViolation) {
+		if isPgViolation(err, errPgUniqueKeyViolation) {
 			return nil, repository.DuplicateKeyError
 		}
 		return nil, fmt.Errorf("could not create new user: %w", err)
@@ -96,155 +112,345 @@ func (r *Repo) CreateUser(ctx context.Context, newUser *service.User) (*service.
 	return newUser, nil
 }
 
-const ()
-
-// ListUsers get (filtered) list of users, sorted DESC
-func (r *Repo) ListUsers(ctx context.Context, limit, offset int, filter *service.Filter) ([]service.User, error) {
+// ListUsers get (filtered) list of users in the query sort order, ties are broken by id,
+// page starts right after the cursor so that its cost does not depend on the page depth. Deleted users are skipped
+func (r *Repo) ListUsers(ctx context.Context, q service.ListQuery) ([]service.User, error) {
 	users := make([]User, 0)
 
-	query := `SELECT id, first_name, last_name, nickname, email, country, created_at, updated_at
+	query, queryArgs, err := r.listQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	err = r.q(ctx).SelectContext(ctx, &users, query, queryArgs...)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("could not perform select all from users: %w", err)
+	}
+
+	return r.toServiceAll(users)
+}
+
+// listQuery builds users listing query along with its arguments
+func (r *Repo) listQuery(q service.ListQuery) (string, []interface{}, error) {
+	query := `SELECT ` + userColumns + `
 					FROM users %s
-					ORDER BY created_at
-					DESC %s`
+					ORDER BY %s %s`
+
+	var (
+		queryArgs []interface{}
+		// deleted users are not listed until they are restored
+		conditions = []string{"deleted_at IS NULL"}
+		limit      string
+	)
+	sort := q.Sort.OrDefault()
+	if err := sort.Validate(q.After); err != nil {
+		return "", nil, err
+	}
+	if r.crypto != nil {
+		for _, k := range sort {
+			if encryptedFields[k.Field] {
+				return "", nil, fmt.Errorf("sorting by '%s' is not supported, it is encrypted", k.Field)
+			}
+		}
+	}
+	if f := q.Filter; f.IsValid() {
+		// filters are validated by the service already, SQL is never built for fields not enabled anyway
+		if err := r.filters.Validate(f); err != nil {
+			return "", nil, fmt.Errorf("filter refused: %w", err)
+		}
+		cond, err := whereFilter(f.Expr, &queryArgs, r.crypto)
+		if err != nil {
+			return "", nil, err
+		}
+		conditions = append(conditions, cond)
+	}
+	if c := q.After; c != nil {
+		cond, err := whereAfter(sort, c, &queryArgs)
+		if err != nil {
+			return "", nil, err
+		}
+		conditions = append(conditions, cond)
+	}
+	if q.Limit > 0 {
+		queryArgs = append(queryArgs, q.Limit)
+		limit = fmt.Sprintf("LIMIT $%d", len(queryArgs))
+	}
 
+	where := "WHERE " + strings.Join(conditions, " AND ")
+	return fmt.Sprintf(query, where, orderBy(sort), limit), queryArgs, nil
+}
+
+// CountUsers counts users matching the filter per country with a single aggregate query, total is their sum
+func (r *Repo) CountUsers(ctx context.Context, f *service.Filter) (*service.Totals, error) {
 	var queryArgs []interface{}
-	where := ""
-	limitOffset := ""
-
-	{
-		i := 1
-		if filter != nil && filter.IsValid() {
-			where = fmt.Sprintf("WHERE %s=$%d", filter.By.String(), i)
-			queryArgs = append(queryArgs, filter.Query)
-			i++
+	where := "WHERE deleted_at IS NULL"
+	if f.IsValid() {
+		if err := r.filters.Validate(f); err != nil {
+			return nil, fmt.Errorf("filter refused: %w", err)
 		}
-		if limit > 0 && offset >= 0 {
-			limitOffset = fmt.Sprintf("OFFSET $%d LIMIT $%d", i, i+1)
-			queryArgs = append(queryArgs, offset)
-			queryArgs = append(queryArgs, limit)
+		cond, err := whereFilter(f.Expr, &queryArgs, r.crypto)
+		if err != nil {
+			return nil, err
 		}
+		where += " AND " + cond
 	}
 
-	query = fmt.Sprintf(query, where, limitOffset)
-	err := r.conn.SelectContext(ctx, &users, query, queryArgs...)
-	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, nil
-		}
-		return nil, fmt.Errorf("could not perform select all from users: %w", err)
+	var counts []struct {
+		User
+		Count int `db:"count"`
+	}
+	query := fmt.Sprintf(`SELECT country, count(*) AS count FROM users %s GROUP BY country`, where)
+	if r.crypto != nil {
+		// every country is sealed with a data key of its own, users are grouped by the blind index
+		// and the country of a single user of the group is decrypted
+		query = fmt.Sprintf(`SELECT DISTINCT ON (country_bidx) id, country, data_key_id, data_key,
+				count(*) OVER (PARTITION BY country_bidx) AS count
+			FROM users %s
+			ORDER BY country_bidx`, where)
+	}
+	if err := r.q(ctx).SelectContext(ctx, &counts, query, queryArgs...); err != nil {
+		return nil, fmt.Errorf("could not count users: %w", err)
 	}
 
-	result := make([]service.User, len(users))
-	for i, u := range users {
-		result[i] = service.User{
-			ID:        u.Id,
-			FirstName: u.FirstName,
-			LastName:  u.LastName,
-			NickName:  u.NickName,
-			Email:     u.Email,
-			Country:   u.Country,
-			CreatedAt: u.CreatedAt,
-			UpdatedAt: u.UpdatedAt,
+	totals := &service.Totals{Countries: make(map[string]int, len(counts))}
+	for _, c := range counts {
+		if err := r.crypto.open(&c.User); err != nil {
+			return nil, err
 		}
+		totals.Count += c.Count
+		totals.Countries[c.Country] = c.Count
 	}
-	return result, nil
+	return totals, nil
 }
 
-// UpdateUser update all user fields except the password(need to be separate function)
+// UpdateUser update all user fields, the password only if it is set. The user is updated only if it is still
+// of the user version unless the version is 0, the version is incremented then. Deleted users are not found
 func (r *Repo) UpdateUser(ctx context.Context, user *service.User) error {
-	query := `UPDATE users SET first_name=$1, last_name=$2, nickname=$3, email=$4, country=$5, updated_at=$6`
+	// personal data is sealed with a new data key by every update
+	stored := fromService(user)
+	if err := r.crypto.seal(stored); err != nil {
+		return err
+	}
+	query := `UPDATE users SET first_name=$1, last_name=$2, nickname=$3, email=$4, country=$5, role=$6, updated_at=$7,
+				data_key_id=$8, data_key=$9, email_bidx=$10, nickname_bidx=$11, country_bidx=$12, version=version+1`
 	args := []interface{}{
-		user.FirstName,
-		user.LastName,
-		user.NickName,
-		user.Email,
-		user.Country,
+		stored.FirstName,
+		stored.LastName,
+		stored.NickName,
+		stored.Email,
+		stored.Country,
+		stored.Role,
 		time.Now(),
+		stored.DataKeyID,
+		stored.DataKey,
+		stored.EmailIndex,
+		stored.NicknameIndex,
+		stored.CountryIndex,
 	}
 	if user.Password != "" {
-		query += "password=$7 WHERE id=$8"
 		args = append(args, user.Password)
-	} else {
-		query += "WHERE id=$7"
+		query += fmt.Sprintf(", password=$%d", len(args))
 	}
-
 	args = append(args, user.ID)
-	tx, err := r.conn.BeginTx(ctx, nil)
-	if err != nil {
-		return err
+	query += fmt.Sprintf(" WHERE id=$%d AND deleted_at IS NULL", len(args))
+	if user.Version != 0 {
+		args = append(args, user.Version)
+		query += fmt.Sprintf(" AND version=$%d", len(args))
 	}
-	result, err := tx.ExecContext(ctx, query, args...)
+	query += " RETURNING version"
 
+	var version int64
+	err := r.q(ctx).GetContext(ctx, &version, query, args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		if user.Version == 0 {
+			return repository.NoUsersFoundError
+		}
+		// either the user is gone or it is of another version already
+		if _, err = r.GetUser(ctx, user.ID); err != nil {
+			return err
+		}
+		return repository.VersionMismatchError
+	}
 	if err != nil {
-		_ = tx.Rollback()
-		if isPgViolation(err, errPgForeignKeyViolation) {
+		if isPgViolation(err, errPgUniqueKeyViolation) {
 			return repository.DuplicateKeyError
 		}
 		return fmt.Errorf("could not update user: %w", err)
 	}
-	affected, err := result.RowsAffected()
+	user.Version = version
+	return nil
+}
+
+// GetUser retrieve user by ID
+func (r *Repo) GetUser(ctx context.Context, userID string) (*service.User, error) {
+	return r.getUserBy(ctx, "id", userID)
+}
+
+// GetUserByEmail retrieve user by email
+func (r *Repo) GetUserByEmail(ctx context.Context, email string) (*service.User, error) {
+	return r.getUserBy(ctx, "email", email)
+}
+
+// GetUserByNickname retrieve user by nickname
+func (r *Repo) GetUserByNickname(ctx context.Context, nickname string) (*service.User, error) {
+	return r.getUserBy(ctx, "nickname", nickname)
+}
+
+// GetCredentials retrieve user along with hashed password by email or nickname
+func (r *Repo) GetCredentials(ctx context.Context, login string) (*service.User, error) {
+	var user User
+	query := `SELECT password, ` + userColumns + `
+ 	FROM users WHERE (email=lower($1) OR nickname=$1) AND deleted_at IS NULL
+ 	LIMIT 1`
+	args := []interface{}{login}
+	if r.crypto != nil {
+		query = `SELECT password, ` + userColumns + `
+ 		FROM users WHERE (email_bidx=$1 OR nickname_bidx=$2) AND deleted_at IS NULL
+ 		LIMIT 1`
+		args = []interface{}{r.crypto.blindIndex("email", strings.ToLower(login)), r.crypto.blindIndex("nickname", login)}
+	}
+
+	err := r.q(ctx).GetContext(ctx, &user, query, args...)
 	if err != nil {
-		return fmt.Errorf(couldNotRetrieveAffected, err)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repository.NoUsersFoundError
+		}
+		return nil, fmt.Errorf("could not perform select user credentials: %v", err)
 	}
-	if affected == 0 {
-		return repository.NoUsersFoundError
+	u, err := r.toService(&user)
+	if err != nil {
+		return nil, err
 	}
-
-	return tx.Commit()
+	u.Password = user.Password
+	return u, nil
 }
 
-// GetUser retrieve user by ID
-func (r *Repo) GetUser(ctx context.Context, userID string) (*service.User, error) {
+// getUserBy retrieve single user by one of the unique columns, deleted users are not found.
+// Encrypted columns are looked up by their blind indexes
+func (r *Repo) getUserBy(ctx context.Context, column, value string) (*service.User, error) {
 	var user User
-	query := `SELECT
-    	id,
-		first_name, 
-		last_name, 
-		nickname, 
-		email, 
-		country, 
-		created_at, 
-		updated_at
- 	FROM users WHERE id=$1`
-
-	err := r.conn.GetContext(ctx, &user, query, userID)
+	where := column
+	if index, ok := blindIndexes[column]; ok && r.crypto != nil {
+		where, value = index, r.crypto.blindIndex(column, value)
+	}
+	query := fmt.Sprintf(`SELECT `+userColumns+`
+ 	FROM users WHERE %s=$1 AND deleted_at IS NULL`, where)
+
+	err := r.q(ctx).GetContext(ctx, &user, query, value)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, repository.NoUsersFoundError
 		}
-		return nil, fmt.Errorf("could not perform select all from users: %v", err)
-	}
-	return &service.User{
-		ID:        user.Id,
-		FirstName: user.FirstName,
-		LastName:  user.LastName,
-		NickName:  user.NickName,
-		Email:     user.Email,
-		Country:   user.Country,
-		CreatedAt: user.CreatedAt,
-		UpdatedAt: user.UpdatedAt,
-	}, nil
+		return nil, fmt.Errorf("could not perform select user by %s: %v", column, err)
+	}
+	return r.toService(&user)
+}
+
+// TakenLogins looks up which of the emails and nicknames are taken by the existing users.
+// Encrypted columns are looked up by their blind indexes
+func (r *Repo) TakenLogins(ctx context.Context, emails, nicknames []string) (*service.Logins, error) {
+	query := `SELECT ` + userColumns + `
+		FROM users WHERE (email = ANY($1) OR nickname = ANY($2)) AND deleted_at IS NULL`
+	if r.crypto != nil {
+		query = `SELECT ` + userColumns + `
+		FROM users WHERE (email_bidx = ANY($1) OR nickname_bidx = ANY($2)) AND deleted_at IS NULL`
+		emails, nicknames = r.blindIndexes("email", emails), r.blindIndexes("nickname", nicknames)
+	}
+
+	users := make([]User, 0)
+	if err := r.q(ctx).SelectContext(ctx, &users, query, emails, nicknames); err != nil {
+		return nil, fmt.Errorf("could not select users taking logins: %w", err)
+	}
+	taken := &service.Logins{Emails: make(map[string]string), Nicknames: make(map[string]string)}
+	for i := range users {
+		u, err := r.toService(&users[i])
+		if err != nil {
+			return nil, err
+		}
+		taken.Emails[u.Email] = u.ID
+		taken.Nicknames[u.NickName] = u.ID
+	}
+	return taken, nil
+}
 
+// blindIndexes blind indexes of the field values
+func (r *Repo) blindIndexes(field string, values []string) []string {
+	indexes := make([]string, len(values))
+	for i, v := range values {
+		indexes[i] = r.crypto.blindIndex(field, v)
+	}
+	return indexes
 }
 
-// DeleteUser delete user by ID
+// DeleteUser soft-deletes user by ID, the user is kept until it is purged
 func (r *Repo) DeleteUser(ctx context.Context, userID string) error {
-	query := `DELETE FROM users WHERE id=$1`
-	_, err := r.conn.ExecContext(ctx, query, userID)
+	query := `UPDATE users SET deleted_at=$1 WHERE id=$2 AND deleted_at IS NULL`
+	result, err := r.q(ctx).ExecContext(ctx, query, time.Now(), userID)
 
 	if err != nil {
 		return fmt.Errorf("could not delete user: %w", err)
 	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf(couldNotRetrieveAffected, err)
+	}
+	if affected == 0 {
+		return repository.NoUsersFoundError
+	}
 	return nil
 }
 
+// RestoreUser restores soft-deleted user by ID unless it is erased, its version is incremented.
+// DuplicateKeyError is returned if its email or nickname are taken meanwhile
+func (r *Repo) RestoreUser(ctx context.Context, userID string) (*service.User, error) {
+	var user User
+	query := `UPDATE users SET deleted_at=NULL, updated_at=$1, version=version+1
+		WHERE id=$2 AND deleted_at IS NOT NULL AND erased_at IS NULL
+		RETURNING ` + userColumns
+
+	err := r.q(ctx).GetContext(ctx, &user, query, time.Now(), userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, repository.NoUsersFoundError
+		}
+		if isPgViolation(err, errPgUniqueKeyViolation) {
+			return nil, repository.DuplicateKeyError
+		}
+		return nil, fmt.Errorf("could not restore user: %w", err)
+	}
+	return r.toService(&user)
+}
+
+// toService decrypts the user if it is encrypted
+func (r *Repo) toService(u *User) (*service.User, error) {
+	if err := r.crypto.open(u); err != nil {
+		return nil, err
+	}
+	return u.toService(), nil
+}
+
+func (r *Repo) toServiceAll(users []User) ([]service.User, error) {
+	result := make([]service.User, len(users))
+	for i := range users {
+		u, err := r.toService(&users[i])
+		if err != nil {
+			return nil, err
+		}
+		result[i] = *u
+	}
+	return result, nil
+}
+
 // TestConnection tests that the Store can properly connect to the Postgres Server.
 func (r *Repo) TestConnection(_ context.Context) error {
 	err := r.conn.Ping()
 	return err
 }
 
-func setupConnectionPool(cfg *Config) (*sqlx.DB, error) {
+// Connect sets up connection pool to the Postgres server
+func Connect(cfg *Config) (*sqlx.DB, error) {
 	dsn := fmt.Sprintf("postgres://%s:%s@%s/%s?sslmode=disable", cfg.User, cfg.Pwd, cfg.Server, cfg.DBName)
 	conn, err := sqlx.Connect("pgx", dsn)
 	if err != nil {
@@ -253,31 +459,55 @@ func setupConnectionPool(cfg *Config) (*sqlx.DB, error) {
 	return conn, nil
 }
 
-// applySchema applies the schema to a connection
-func applySchema(conn *sqlx.DB) error {
-	tx, err := conn.Begin()
-
+// applyMigrations applies all pending schema migrations to a connection
+func applyMigrations(conn *sqlx.DB, log *logrus.Logger) error {
+	m, err := migrations.New(conn, log)
 	if err != nil {
 		return err
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	_, err = m.Up(ctx)
+	return err
+}
 
-	defer func() { _ = tx.Rollback() }()
-
-	if _, err = tx.Exec(schema); err != nil {
-		return err
+// checkEncryption makes sure users and their sealed records are stored the way encryption is configured, so that
+// encrypted ones are never read as plaintext nor the other way around. Plaintext ones are encrypted by the
+// `rotate-keys` command
+func checkEncryption(conn *sqlx.DB, crypto *fieldCipher) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	tables := []sealedTable{{name: "users", records: "users"}}
+	for _, table := range append(tables, sealedTables...) {
+		query := `SELECT EXISTS (SELECT 1 FROM ` + table.name + ` WHERE data_key_id IS NOT NULL)`
+		if crypto != nil {
+			query = `SELECT EXISTS (SELECT 1 FROM ` + table.name + ` WHERE data_key_id IS NULL)`
+		}
+		var mismatched bool
+		if err := conn.GetContext(ctx, &mismatched, query); err != nil {
+			return fmt.Errorf("could not check %s encryption: %w", table.records, err)
+		}
+		switch {
+		case !mismatched:
+			continue
+		case crypto == nil:
+			return fmt.Errorf("%s are encrypted while encryption is not configured", table.records)
+		default:
+			return fmt.Errorf("%s are stored in plaintext, encrypt them with the rotate-keys command first", table.records)
+		}
 	}
-
-	return tx.Commit()
+	return nil
 }
 
-func isPgViolation(err error, filter ...pq.ErrorCode) bool {
-	var pgErr *pq.Error
+func isPgViolation(err error, filter ...string) bool {
+	var pgErr *pgconn.PgError
 	ok := errors.As(err, &pgErr)
 	if !ok {
 		return false
 	}
 	if filter == nil {
-		filter = []pq.ErrorCode{
+		filter = []string{
 			errPgCheckViolation,
 			errPgNotNullViolation,
 			errPgUniqueKeyViolation,
@@ -0,0 +1,201 @@
+package pg
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BorisRostovskiy/ESL/internal/repository"
+	"github.com/BorisRostovskiy/ESL/internal/service"
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsPgViolation(t *testing.T) {
+	t.Parallel()
+	unique := fmt.Errorf("wrapped: %w", &pgconn.PgError{Code: errPgUniqueKeyViolation})
+
+	assert.True(t, isPgViolation(unique, errPgUniqueKeyViolation))
+	assert.True(t, isPgViolation(unique))
+	assert.False(t, isPgViolation(unique, errPgForeignKeyViolation))
+	assert.False(t, isPgViolation(fmt.Errorf("not a pg error"), errPgUniqueKeyViolation))
+}
+
+func TestRepo_CreateUserDuplicate(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		code string
+		err  error
+	}{
+		"unique violation": {code: errPgUniqueKeyViolation, err: repository.DuplicateKeyError},
+		"foreign key":      {code: errPgForeignKeyViolation},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			repo := newFakeRepo(t, &fakeDB{
+				exec: func(string, []driver.NamedValue) (driver.Result, error) {
+					return nil, &pgconn.PgError{Code: tc.code}
+				},
+			})
+
+			_, err := repo.CreateUser(context.Background(), &service.User{Email: "john@example.com", Password: "pwd"})
+			require.Error(t, err)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+			} else {
+				assert.NotErrorIs(t, err, repository.DuplicateKeyError)
+			}
+		})
+	}
+}
+
+func TestRepo_UpdateUser(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		user  service.User
+		where string
+		err   error
+		code  string
+	}{
+		"without password": {
+			user:  service.User{ID: "id"},
+			where: "version=version+1 WHERE id=$13 AND deleted_at IS NULL RETURNING version",
+		},
+		"with password and version": {
+			user:  service.User{ID: "id", Password: "hash", Version: 3},
+			where: "version=version+1, password=$13 WHERE id=$14 AND deleted_at IS NULL AND version=$15 RETURNING version",
+		},
+		"duplicate": {
+			user: service.User{ID: "id"},
+			code: errPgUniqueKeyViolation,
+			err:  repository.DuplicateKeyError,
+		},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			db := &fakeDB{
+				query: func(string, []driver.NamedValue) (driver.Rows, error) {
+					if tc.code != "" {
+						return nil, &pgconn.PgError{Code: tc.code}
+					}
+					return &fakeRows{columns: []string{"version"}, values: [][]driver.Value{{int64(4)}}}, nil
+				},
+			}
+			repo := newFakeRepo(t, db)
+
+			user := tc.user
+			err := repo.UpdateUser(context.Background(), &user)
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.EqualValues(t, 4, user.Version)
+			require.Len(t, db.Queries(), 1)
+			assert.True(t, strings.HasSuffix(db.Queries()[0], tc.where), db.Queries()[0])
+		})
+	}
+}
+
+func TestRepo_DeleteUser(t *testing.T) {
+	t.Parallel()
+	tests := map[string]struct {
+		affected int64
+		err      error
+	}{
+		"deleted":   {affected: 1},
+		"not found": {affected: 0, err: repository.NoUsersFoundError},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			repo := newFakeRepo(t, &fakeDB{
+				exec: func(string, []driver.NamedValue) (driver.Result, error) {
+					return driver.RowsAffected(tc.affected), nil
+				},
+			})
+
+			err := repo.DeleteUser(context.Background(), "id")
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestRepo_TakenLogins(t *testing.T) {
+	t.Parallel()
+	c, err := newFieldCipher(EncryptionConfig{KeyID: "k1", KeyFile: writeKey(t), IndexKeyFile: writeKey(t)})
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		crypto *fieldCipher
+		where  string
+		args   [][]string
+	}{
+		"plaintext": {
+			where: "WHERE (email = ANY($1) OR nickname = ANY($2)) AND deleted_at IS NULL",
+			args:  [][]string{{"jd@example.com"}, {"jd", "free"}},
+		},
+		"encrypted": {
+			crypto: c,
+			where:  "WHERE (email_bidx = ANY($1) OR nickname_bidx = ANY($2)) AND deleted_at IS NULL",
+			args: [][]string{{c.blindIndex("email", "jd@example.com")},
+				{c.blindIndex("nickname", "jd"), c.blindIndex("nickname", "free")}},
+		},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			stored := User{Id: "id1", FirstName: "John", LastName: "Doe", NickName: "jd", Email: "jd@example.com",
+				Role: "self"}
+			require.NoError(t, tc.crypto.seal(&stored))
+			var args [][]string
+			db := &fakeDB{
+				query: func(_ string, named []driver.NamedValue) (driver.Rows, error) {
+					for _, arg := range named {
+						args = append(args, arg.Value.([]string))
+					}
+					return &fakeRows{
+						columns: strings.Fields(strings.ReplaceAll(userColumns, ",", " ")),
+						values: [][]driver.Value{{stored.Id, stored.FirstName, stored.LastName, stored.NickName,
+							stored.Email, stored.Country, stored.Role, time.Now(), time.Now(), int64(1),
+							nullValue(stored.DataKeyID), nullValue(stored.DataKey)}},
+					}, nil
+				},
+			}
+			repo := newFakeRepo(t, db)
+			repo.crypto = tc.crypto
+
+			// email filtering is not enabled, logins are looked up regardless of the filters
+			repo.filters = service.Filters{"country": {service.FilterEq}}
+			taken, err := repo.TakenLogins(context.Background(), []string{"jd@example.com"}, []string{"jd", "free"})
+			require.NoError(t, err)
+			assert.Equal(t, map[string]string{"jd@example.com": "id1"}, taken.Emails)
+			assert.Equal(t, map[string]string{"jd": "id1"}, taken.Nicknames)
+			require.Len(t, db.Queries(), 1)
+			assert.Contains(t, db.Queries()[0], tc.where)
+			assert.Equal(t, tc.args, args, "values must be looked up by blind indexes once encrypted")
+		})
+	}
+}
+
+func nullValue(v sql.NullString) driver.Value {
+	if !v.Valid {
+		return nil
+	}
+	return v.String
+}
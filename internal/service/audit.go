@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/BorisRostovskiy/ESL/internal/events"
+)
+
+const (
+	// DefaultHistoryLimit entries of a history page unless the limit is requested
+	DefaultHistoryLimit = 50
+	// MaxHistoryLimit entries a single history page could have
+	MaxHistoryLimit = 1000
+)
+
+// AuditAction user change recorded in the audit trail
+type AuditAction string
+
+const (
+	AuditCreated  AuditAction = "created"
+	AuditUpdated  AuditAction = "updated"
+	AuditDeleted  AuditAction = "deleted"
+	AuditRestored AuditAction = "restored"
+	AuditErased   AuditAction = "erased"
+)
+
+type (
+	// AuditRepo define append-only user audit trail repository interface
+	AuditRepo interface {
+		// AddAuditEntry appends the entry, must be called within the transaction of the change
+		AddAuditEntry(ctx context.Context, e *AuditEntry) error
+		// UserHistory returns up to limit entries of the user preceding the entry with id before, newest first.
+		// The latest entries are returned if before is 0
+		UserHistory(ctx context.Context, userID string, before int64, limit int) ([]AuditEntry, error)
+	}
+
+	// AuditEntry single change of the user
+	AuditEntry struct {
+		ID     int64
+		UserID string
+		Action AuditAction
+		// Actor caller made the change, nil if the request is anonymous
+		Actor *Caller
+		// Transport and RequestID of the request made the change, empty if they are unknown
+		Transport string
+		RequestID string
+		// Changes field values before and after the change, password values are redacted
+		Changes   []events.Change
+		CreatedAt time.Time
+	}
+
+	// HistoryQuery user history page request
+	HistoryQuery struct {
+		UserID string
+		// Limit DefaultHistoryLimit if not set, MaxHistoryLimit at most
+		Limit int
+		// Before id of the last entry seen, the latest entries are returned if 0
+		Before int64
+	}
+
+	// HistoryPage user history entries, newest first
+	HistoryPage struct {
+		Entries []AuditEntry
+		// Next id of the last entry of the page if there are older ones, 0 otherwise
+		Next int64
+	}
+)
+
+// WithAudit records every user change in the audit trail within the change transaction
+func WithAudit(repo AuditRepo) Option {
+	return func(s *Users) {
+		s.audit = repo
+	}
+}
+
+// UserHistory returns page of the user changes, newest first. History of deleted users is kept
+func (s Users) UserHistory(ctx context.Context, q HistoryQuery) (*HistoryPage, error) {
+	if err := s.authorize(ctx, access{op: OpGetHistory, target: q.UserID}); err != nil {
+		return nil, err
+	}
+	if s.audit == nil {
+		return nil, ErrAuditDisabled
+	}
+	limit := q.Limit
+	switch {
+	case limit <= 0:
+		limit = DefaultHistoryLimit
+	case limit > MaxHistoryLimit:
+		limit = MaxHistoryLimit
+	}
+
+	// one more entry is fetched to know whether the next page exists
+	entries, err := s.audit.UserHistory(ctx, q.UserID, q.Before, limit+1)
+	if err != nil {
+		s.log.WithField("component", "service").Debug(err)
+		return nil, ErrInternal
+	}
+	page := &HistoryPage{Entries: entries}
+	if len(entries) > limit {
+		page.Entries = entries[:limit]
+		page.Next = page.Entries[limit-1].ID
+	}
+	return page, nil
+}
+
+// record appends the change to the audit trail if it is enabled, before is nil for creations
+// and after is nil for deletions
+func (s Users) record(ctx context.Context, action AuditAction, userID string, before, after *User) error {
+	if s.audit == nil {
+		return nil
+	}
+	if before == nil {
+		before = &User{}
+	}
+	if after == nil {
+		after = &User{}
+	}
+	return s.recordChanges(ctx, action, userID, changes(before, after))
+}
+
+// recordChanges appends the change to the audit trail if it is enabled
+func (s Users) recordChanges(ctx context.Context, action AuditAction, userID string, diff []events.Change) error {
+	if s.audit == nil {
+		return nil
+	}
+	e := &AuditEntry{
+		UserID:  userID,
+		Action:  action,
+		Actor:   CallerFrom(ctx),
+		Changes: diff,
+	}
+	if r := RequestFrom(ctx); r != nil {
+		e.Transport, e.RequestID = r.Transport, r.ID
+	}
+	return s.audit.AddAuditEntry(ctx, e)
+}
@@ -0,0 +1,272 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/BorisRostovskiy/ESL/internal/repository"
+)
+
+// MaxBatchSize items a single batch could have
+const MaxBatchSize = 1000
+
+var errRepeated = &Error{Code: ErrCodeBadRequest, Message: "user is repeated in the batch"}
+
+// BatchResult outcome of a single batch item, Err is nil if the item is applied
+type BatchResult struct {
+	ID string
+	// User created user, set by BatchCreateUsers only
+	User *User
+	Err  *Error
+}
+
+// BatchCreateUsers validates users and creates the valid ones at once. Invalid and conflicting users fail on their
+// own, the others are created unless atomic. The whole batch is denied if caller could not create any of the users
+func (s Users) BatchCreateUsers(ctx context.Context, users []*User, atomic bool) ([]BatchResult, error) {
+	if err := checkBatch(len(users)); err != nil {
+		return nil, err
+	}
+	assignsRole := false
+	for _, u := range users {
+		assignsRole = assignsRole || u.Role != "" && u.Role != RoleSelf
+	}
+	if err := s.authorize(ctx, access{op: OpCreateUser, role: assignsRole}); err != nil {
+		return nil, err
+	}
+
+	results := make([]BatchResult, len(users))
+	valid := make([]*User, 0, len(users))
+	positions := make([]int, 0, len(users))
+	for i, u := range users {
+		if u.Role == "" {
+			u.Role = RoleSelf
+		}
+		if err := u.Validate(false); err != nil {
+			results[i].Err = &Error{Code: ErrCodeBadRequest, Message: err.Error()}
+			continue
+		}
+		valid = append(valid, u)
+		positions = append(positions, i)
+	}
+	if len(valid) == 0 || atomic && len(valid) < len(users) {
+		return aborted(results), nil
+	}
+
+	created, err := s.createUsers(ctx, valid, atomic)
+	if err != nil && !(atomic && errors.Is(err, repository.DuplicateKeyError)) {
+		s.log.WithField("component", "service").Debug(err)
+		return nil, ErrInternal
+	}
+
+	for j, u := range valid {
+		i := positions[j]
+		if !created[j] {
+			results[i].Err = ErrUserAlreadyExists
+			continue
+		}
+		if err == nil {
+			user := *u
+			user.Password = ""
+			results[i].ID = u.ID
+			results[i].User = &user
+		}
+	}
+	if err != nil {
+		return aborted(results), nil
+	}
+	return results, nil
+}
+
+// createUsers creates valid users and publishes creation of the created ones within a single transaction
+func (s Users) createUsers(ctx context.Context, valid []*User, atomic bool) ([]bool, error) {
+	var created []bool
+	err := s.inTx(ctx, func(ctx context.Context) error {
+		var err error
+		if created, err = s.repo.CreateUsers(ctx, valid, atomic); err != nil {
+			return err
+		}
+		for i, u := range valid {
+			if !created[i] {
+				continue
+			}
+			if err = s.publishCreate(ctx, u); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return created, err
+}
+
+// BatchUpdateUsers updates users the way UpdateUser does. Every user is updated on its own unless atomic,
+// all of them are updated within a single transaction then
+func (s Users) BatchUpdateUsers(ctx context.Context, users []*User, atomic bool) ([]BatchResult, error) {
+	if err := checkBatch(len(users)); err != nil {
+		return nil, err
+	}
+	results := make([]BatchResult, len(users))
+	seen := make(map[string]bool, len(users))
+	for i, u := range users {
+		results[i].ID = u.ID
+		if seen[u.ID] {
+			results[i].Err = errRepeated
+		}
+		seen[u.ID] = true
+	}
+
+	if !atomic {
+		for i, u := range users {
+			if results[i].Err == nil {
+				results[i].Err = s.itemError(s.UpdateUser(ctx, u))
+			}
+		}
+		return results, nil
+	}
+
+	before := make([]*User, len(users))
+	after := make([]*User, len(users))
+	for i, u := range users {
+		if results[i].Err != nil {
+			continue
+		}
+		var err error
+		if before[i], after[i], err = s.prepareUpdate(ctx, u); err != nil {
+			results[i].Err = s.itemError(err)
+		}
+	}
+	if failed(results) {
+		return aborted(results), nil
+	}
+
+	index := -1
+	err := s.inTx(ctx, func(ctx context.Context) error {
+		var err error
+		if index, err = s.repo.UpdateUsers(ctx, after); err != nil {
+			return err
+		}
+		for i := range after {
+			if err = s.publishUpdate(ctx, before[i], after[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if index < 0 {
+			s.log.WithField("component", "service").Debug(err)
+			return nil, ErrInternal
+		}
+		results[index].Err = s.itemError(err)
+		return aborted(results), nil
+	}
+	return results, nil
+}
+
+// BatchDeleteUsers deletes users at once. Users not found or not permitted to delete fail on their own,
+// the others are deleted unless atomic
+func (s Users) BatchDeleteUsers(ctx context.Context, ids []string, atomic bool) ([]BatchResult, error) {
+	if err := checkBatch(len(ids)); err != nil {
+		return nil, err
+	}
+	results := make([]BatchResult, len(ids))
+	pending := make([]string, 0, len(ids))
+	positions := make([]int, 0, len(ids))
+	seen := make(map[string]bool, len(ids))
+	for i, id := range ids {
+		results[i].ID = id
+		if seen[id] {
+			results[i].Err = errRepeated
+		} else {
+			results[i].Err = s.itemError(s.authorize(ctx, access{op: OpDeleteUser, target: id}))
+		}
+		seen[id] = true
+		if results[i].Err == nil {
+			pending = append(pending, id)
+			positions = append(positions, i)
+		}
+	}
+	if len(pending) == 0 || atomic && len(pending) < len(ids) {
+		return aborted(results), nil
+	}
+
+	var deleted []User
+	err := s.inTx(ctx, func(ctx context.Context) error {
+		var err error
+		if deleted, err = s.repo.DeleteUsers(ctx, pending, atomic); err != nil {
+			return err
+		}
+		for i := range deleted {
+			if err = s.publishDelete(ctx, &deleted[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil && !(atomic && errors.Is(err, repository.NoUsersFoundError)) {
+		s.log.WithField("component", "service").Debug(err)
+		return nil, ErrInternal
+	}
+
+	found := make(map[string]bool, len(deleted))
+	for _, u := range deleted {
+		found[u.ID] = true
+	}
+	for j, id := range pending {
+		if !found[id] {
+			results[positions[j]].Err = ErrUserNotFound
+		}
+	}
+	if err != nil {
+		return aborted(results), nil
+	}
+	return results, nil
+}
+
+// itemError error of a batch item as it is reported, unexpected errors are internal ones
+func (s Users) itemError(err error) *Error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, repository.DuplicateKeyError):
+		return ErrDuplicateKeyError
+	case errors.Is(err, repository.NoUsersFoundError):
+		return ErrUserNotFound
+	case errors.Is(err, repository.VersionMismatchError):
+		return ErrVersionMismatch
+	}
+	if e := ToError(err); e != nil {
+		return e
+	}
+	s.log.WithField("component", "service").Debug(err)
+	return ErrInternal
+}
+
+func checkBatch(size int) error {
+	if size == 0 {
+		return &Error{Code: ErrCodeBadRequest, Message: "empty batch"}
+	}
+	if size > MaxBatchSize {
+		return &Error{Code: ErrCodeBadRequest, Message: fmt.Sprintf("batch exceeds %d items", MaxBatchSize)}
+	}
+	return nil
+}
+
+func failed(results []BatchResult) bool {
+	for _, r := range results {
+		if r.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// aborted marks items not failed on their own as aborted, atomic batch is applied as a whole only
+func aborted(results []BatchResult) []BatchResult {
+	for i := range results {
+		if results[i].Err == nil {
+			results[i].Err = ErrBatchAborted
+		}
+	}
+	return results
+}
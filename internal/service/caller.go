@@ -0,0 +1,54 @@
+package service
+
+import "context"
+
+type callerKey struct{}
+
+// Caller authenticated identity the request is performed on behalf of
+type Caller struct {
+	// Subject user id for tokens issued on login, key name for API keys,
+	// certificate common name for mTLS
+	Subject string
+	// Method authentication method the caller has been identified by
+	Method string
+	// Role one of RoleAdmin, RoleSupport, RoleSelf, caller without role is denied everything
+	// once authorization is enabled
+	Role string
+}
+
+// WithCaller stores caller identity into the context
+func WithCaller(ctx context.Context, c *Caller) context.Context {
+	return context.WithValue(ctx, callerKey{}, c)
+}
+
+// CallerFrom extracts caller identity from the context, nil if request is anonymous
+func CallerFrom(ctx context.Context) *Caller {
+	c, _ := ctx.Value(callerKey{}).(*Caller)
+	return c
+}
+
+const (
+	TransportHTTP = "http"
+	TransportGRPC = "grpc"
+)
+
+type requestKey struct{}
+
+// Request transport details of the request, recorded along with the changes it makes
+type Request struct {
+	// Transport one of TransportHTTP, TransportGRPC
+	Transport string
+	// ID request id either passed by the client or generated
+	ID string
+}
+
+// WithRequest stores request details into the context
+func WithRequest(ctx context.Context, r *Request) context.Context {
+	return context.WithValue(ctx, requestKey{}, r)
+}
+
+// RequestFrom extracts request details from the context, nil if they are unknown
+func RequestFrom(ctx context.Context) *Request {
+	r, _ := ctx.Value(requestKey{}).(*Request)
+	return r
+}
@@ -5,14 +5,24 @@ import (
 )
 
 const (
-	ErrCodeInternalError = 100
-	ErrCodeBadRequest    = 101
-	ErrCodeConflict      = 102
-	ErrCodeEmptyUpdate   = 103
+	ErrCodeInternalError   = 100
+	ErrCodeBadRequest      = 101
+	ErrCodeConflict        = 102
+	ErrCodeEmptyUpdate     = 103
+	ErrCodeNotConfigured   = 104
+	ErrCodeWatchExpired    = 105
+	ErrCodeWatchLagging    = 106
+	ErrCodeBatchAborted    = 107
+	ErrCodeVersionMismatch = 108
 
 	ErrCodeUserNotFound = 200
 
 	ErrCodeUserAlreadyExists = 300
+
+	ErrCodeInvalidCredentials  = 400
+	ErrCodeInvalidRefreshToken = 401
+	ErrCodeUnauthenticated     = 402
+	ErrCodePermissionDenied    = 403
 )
 
 var (
@@ -21,6 +31,19 @@ var (
 	ErrUserNotFound       = &Error{Code: ErrCodeUserNotFound, Message: "user not found"}
 	ErrDuplicateKeyError  = &Error{Code: ErrCodeConflict, Message: "duplicate key error"}
 	ErrEmptyUpdateRequest = &Error{Code: ErrCodeEmptyUpdate, Message: "empty request"}
+	ErrInvalidCredentials = &Error{Code: ErrCodeInvalidCredentials, Message: "invalid login or password"}
+	ErrInvalidRefresh     = &Error{Code: ErrCodeInvalidRefreshToken, Message: "invalid or expired refresh token"}
+	ErrTokensDisabled     = &Error{Code: ErrCodeNotConfigured, Message: "tokens issuing is not configured"}
+	ErrUnauthenticated    = &Error{Code: ErrCodeUnauthenticated, Message: "missing or invalid credentials"}
+	ErrPermissionDenied   = &Error{Code: ErrCodePermissionDenied, Message: "permission denied"}
+	ErrWatchDisabled      = &Error{Code: ErrCodeNotConfigured, Message: "watch is not configured"}
+	ErrAuditDisabled      = &Error{Code: ErrCodeNotConfigured, Message: "audit trail is not configured"}
+	ErrPrivacyDisabled    = &Error{Code: ErrCodeNotConfigured, Message: "data subject requests are not configured"}
+	ErrWatchExpired       = &Error{Code: ErrCodeWatchExpired, Message: "events after the requested one are no longer available"}
+	ErrWatchLagging       = &Error{Code: ErrCodeWatchLagging, Message: "watcher could not keep up with the events, resume from the last one"}
+	ErrBatchAborted       = &Error{Code: ErrCodeBatchAborted, Message: "not applied, another item of the atomic batch failed"}
+	ErrVersionMismatch    = &Error{Code: ErrCodeVersionMismatch, Message: "user is modified since the expected version"}
+	ErrRestoreConflict    = &Error{Code: ErrCodeUserAlreadyExists, Message: "email or nickname of the user is taken by another user"}
 )
 
 type Error struct {
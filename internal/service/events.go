@@ -0,0 +1,59 @@
+package service
+
+import (
+	"context"
+
+	"github.com/BorisRostovskiy/ESL/internal/events"
+)
+
+// actor caller of the request as the event actor, nil if request is anonymous
+func actor(ctx context.Context) *events.Actor {
+	c := CallerFrom(ctx)
+	if c == nil {
+		return nil
+	}
+	return &events.Actor{Subject: c.Subject, Method: c.Method, Role: c.Role}
+}
+
+func eventUser(u *User) events.User {
+	return events.User{
+		ID:        u.ID,
+		FirstName: u.FirstName,
+		LastName:  u.LastName,
+		NickName:  u.NickName,
+		Email:     u.Email,
+		Country:   u.Country,
+		Role:      u.Role,
+		CreatedAt: u.CreatedAt,
+	}
+}
+
+// changes diff of the user fields, password values are redacted
+func changes(before, after *User) []events.Change {
+	fields := []struct {
+		name     string
+		old, new string
+		redacted bool
+	}{
+		{name: "first_name", old: before.FirstName, new: after.FirstName},
+		{name: "last_name", old: before.LastName, new: after.LastName},
+		{name: "nickname", old: before.NickName, new: after.NickName},
+		{name: "email", old: before.Email, new: after.Email},
+		{name: "country", old: before.Country, new: after.Country},
+		{name: "role", old: before.Role, new: after.Role},
+		{name: "password", old: before.Password, new: after.Password, redacted: true},
+	}
+
+	diff := make([]events.Change, 0, len(fields))
+	for _, f := range fields {
+		if f.old == f.new {
+			continue
+		}
+		if f.redacted {
+			diff = append(diff, events.Change{Field: f.name, Redacted: true})
+			continue
+		}
+		diff = append(diff, events.Change{Field: f.name, Old: f.old, New: f.new})
+	}
+	return diff
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/BorisRostovskiy/ESL/internal/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChanges(t *testing.T) {
+	t.Parallel()
+	before := User{ID: "1", FirstName: "User", NickName: "user1", Email: "user1@gmail.com", Password: "hash1", Role: RoleSelf}
+
+	tests := map[string]struct {
+		after User
+		want  []events.Change
+	}{
+		"nothing changed": {
+			after: before,
+			want:  []events.Change{},
+		},
+		"fields changed": {
+			after: User{ID: "1", FirstName: "User", NickName: "user2", Email: "user2@gmail.com", Password: "hash1", Role: RoleAdmin},
+			want: []events.Change{
+				{Field: "nickname", Old: "user1", New: "user2"},
+				{Field: "email", Old: "user1@gmail.com", New: "user2@gmail.com"},
+				{Field: "role", Old: RoleSelf, New: RoleAdmin},
+			},
+		},
+		"password is redacted": {
+			after: User{ID: "1", FirstName: "User", NickName: "user1", Email: "user1@gmail.com", Password: "hash2", Role: RoleSelf},
+			want:  []events.Change{{Field: "password", Redacted: true}},
+		},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tc.want, changes(&before, &tc.after))
+		})
+	}
+}
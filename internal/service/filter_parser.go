@@ -0,0 +1,301 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+const (
+	// maxFilterLength longest filter expression accepted
+	maxFilterLength = 1024
+	// maxFilterConditions most conditions (including in values) in a single expression
+	maxFilterConditions = 32
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenString
+	tokenOp
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+type filterToken struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// ParseFilter parses filter expression and validates it against enabled fields, e.g.
+//
+//	country in (NL, DE) and created_at >= 2024-01-01
+//	(first_name prefix Jo or nickname = "j doe") and email_domain = example.com
+//
+// Conditions are combined with and/or, and binds tighter than or, keywords are case-insensitive.
+// Values are either bare words or double quoted strings
+func (fs Filters) ParseFilter(expr string) (*Filter, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, fmt.Errorf("empty filter")
+	}
+	if len(expr) > maxFilterLength {
+		return nil, fmt.Errorf("filter is longer than %d characters", maxFilterLength)
+	}
+	tokens, err := lexFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens, fields: fs}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected '%s' at %d", t.text, t.pos)
+	}
+	return &Filter{Expr: e}, nil
+}
+
+type filterParser struct {
+	fields     Filters
+	tokens     []filterToken
+	pos        int
+	conditions int
+}
+
+func (p *filterParser) peek() filterToken {
+	return p.tokens[p.pos]
+}
+
+func (p *filterParser) next() filterToken {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+// keyword consumes the next token if it is the given keyword
+func (p *filterParser) keyword(kw string) bool {
+	if t := p.peek(); t.kind == tokenWord && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) expect(kind tokenKind, what string) (filterToken, error) {
+	t := p.next()
+	if t.kind != kind {
+		return t, unexpected(t, what)
+	}
+	return t, nil
+}
+
+func (p *filterParser) parseOr() (FilterExpr, error) {
+	return p.parseLogical(FilterOr, p.parseAnd)
+}
+
+func (p *filterParser) parseAnd() (FilterExpr, error) {
+	return p.parseLogical(FilterAnd, p.parsePrimary)
+}
+
+// parseLogical parses operands separated by op, single operand is returned as is
+func (p *filterParser) parseLogical(op FilterLogic, operand func() (FilterExpr, error)) (FilterExpr, error) {
+	e, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	operands := []FilterExpr{e}
+	for p.keyword(string(op)) {
+		if e, err = operand(); err != nil {
+			return nil, err
+		}
+		operands = append(operands, e)
+	}
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+	return &Logical{Op: op, Operands: operands}, nil
+}
+
+func (p *filterParser) parsePrimary() (FilterExpr, error) {
+	if p.peek().kind == tokenLParen {
+		p.next()
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, err = p.expect(tokenRParen, "')'"); err != nil {
+			return nil, err
+		}
+		return e, nil
+	}
+	return p.parseCondition()
+}
+
+func (p *filterParser) parseCondition() (FilterExpr, error) {
+	field, err := p.expect(tokenWord, "field name")
+	if err != nil {
+		return nil, err
+	}
+	cond := &Condition{Field: strings.ToLower(field.text)}
+
+	switch {
+	case p.keyword(string(FilterIn)):
+		cond.Op = FilterIn
+		if cond.Values, err = p.parseList(); err != nil {
+			return nil, err
+		}
+	case p.keyword(string(FilterPrefix)):
+		cond.Op = FilterPrefix
+	default:
+		op, err := p.expect(tokenOp, "operator")
+		if err != nil {
+			return nil, err
+		}
+		cond.Op = FilterOp(op.text)
+	}
+	if cond.Op != FilterIn {
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		cond.Values = []string{v}
+	}
+
+	if p.conditions += len(cond.Values); p.conditions > maxFilterConditions {
+		return nil, fmt.Errorf("filter has more than %d conditions", maxFilterConditions)
+	}
+	if err = cond.validate(p.fields); err != nil {
+		return nil, err
+	}
+	return cond, nil
+}
+
+// parseList parses parenthesized comma separated values
+func (p *filterParser) parseList() ([]string, error) {
+	if _, err := p.expect(tokenLParen, "'('"); err != nil {
+		return nil, err
+	}
+	var values []string
+	for {
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		if t := p.next(); t.kind == tokenRParen {
+			return values, nil
+		} else if t.kind != tokenComma {
+			return nil, unexpected(t, "',' or ')'")
+		}
+	}
+}
+
+func (p *filterParser) parseValue() (string, error) {
+	t := p.next()
+	if t.kind != tokenWord && t.kind != tokenString {
+		return "", unexpected(t, "value")
+	}
+	if t.kind == tokenString && t.text == "" {
+		return "", fmt.Errorf("empty value at %d", t.pos)
+	}
+	return t.text, nil
+}
+
+func unexpected(t filterToken, what string) error {
+	if t.kind == tokenEOF {
+		return fmt.Errorf("unexpected end of filter, %s expected", what)
+	}
+	return fmt.Errorf("unexpected '%s' at %d, %s expected", t.text, t.pos, what)
+}
+
+// lexFilter splits expression into tokens, the last one is always tokenEOF
+func lexFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			tokens = append(tokens, filterToken{kind: tokenLParen, text: "(", pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, filterToken{kind: tokenRParen, text: ")", pos: i})
+			i++
+		case c == ',':
+			tokens = append(tokens, filterToken{kind: tokenComma, text: ",", pos: i})
+			i++
+		case c == '=' || c == '!' || c == '<' || c == '>':
+			start := i
+			i++
+			if i < len(expr) && expr[i] == '=' {
+				i++
+			}
+			op := expr[start:i]
+			if op == "!" {
+				return nil, fmt.Errorf("unexpected '!' at %d", start)
+			}
+			tokens = append(tokens, filterToken{kind: tokenOp, text: op, pos: start})
+		case c == '"':
+			start := i
+			var sb strings.Builder
+			for i++; ; i++ {
+				if i >= len(expr) {
+					return nil, fmt.Errorf("unterminated string at %d", start)
+				}
+				if expr[i] == '\\' && i+1 < len(expr) {
+					i++
+				} else if expr[i] == '"' {
+					i++
+					break
+				}
+				sb.WriteByte(expr[i])
+			}
+			tokens = append(tokens, filterToken{kind: tokenString, text: sb.String(), pos: start})
+		default:
+			start := i
+			for i < len(expr) && isWordByte(expr[i]) {
+				i++
+			}
+			if start == i {
+				return nil, fmt.Errorf("unexpected '%c' at %d", c, start)
+			}
+			tokens = append(tokens, filterToken{kind: tokenWord, text: expr[start:i], pos: start})
+		}
+	}
+	return append(tokens, filterToken{kind: tokenEOF, pos: len(expr)}), nil
+}
+
+// isWordByte bare words are anything but whitespace, quotes, operators and punctuation of the grammar,
+// so that dates, timestamps, emails and domains need no quoting
+func isWordByte(c byte) bool {
+	if c >= 0x80 {
+		return true
+	}
+	return !unicode.IsSpace(rune(c)) && !strings.ContainsRune(`()",=!<>`, rune(c))
+}
+
+// quote returns bare word if it is parsed back to the same value, quoted string otherwise
+func quote(v string) string {
+	bare := v != ""
+	for i := 0; i < len(v) && bare; i++ {
+		bare = isWordByte(v[i])
+	}
+	if bare {
+		switch strings.ToLower(v) {
+		case string(FilterAnd), string(FilterOr), string(FilterIn), string(FilterPrefix):
+			bare = false
+		}
+	}
+	if bare {
+		return v
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+}
@@ -1,40 +1,361 @@
 package service
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+	"time"
 
-type FilterBy string
+	"gopkg.in/yaml.v3"
+)
+
+// FilterOp comparison operator of the filter condition
+type FilterOp string
+
+const (
+	FilterEq     FilterOp = "="
+	FilterNe     FilterOp = "!="
+	FilterLt     FilterOp = "<"
+	FilterLte    FilterOp = "<="
+	FilterGt     FilterOp = ">"
+	FilterGte    FilterOp = ">="
+	FilterIn     FilterOp = "in"
+	FilterPrefix FilterOp = "prefix"
+)
+
+// FilterLogic logical operator combining filter expressions
+type FilterLogic string
+
+const (
+	FilterAnd FilterLogic = "and"
+	FilterOr  FilterLogic = "or"
+)
+
+// FieldType type of the filterable field, defines operators it supports and how values are parsed
+type FieldType int
+
+const (
+	FieldString FieldType = iota
+	FieldTime
+)
 
-func (fb FilterBy) String() string {
-	return string(fb)
+// FilterField filterable user field
+type FilterField struct {
+	Type FieldType
+	// Ops operators the field supports
+	Ops []FilterOp
+	// value user field value compared
+	value func(u *User) string
+	// time user time field value compared
+	time func(u *User) time.Time
 }
 
 var (
-	filterByCountry FilterBy = "country"
+	stringOps = []FilterOp{FilterEq, FilterNe, FilterIn, FilterPrefix}
+	timeOps   = []FilterOp{FilterEq, FilterNe, FilterLt, FilterLte, FilterGt, FilterGte}
+
+	// filterFields all the fields users could be filtered by
+	filterFields = map[string]FilterField{
+		"first_name": {Type: FieldString, Ops: stringOps, value: func(u *User) string { return u.FirstName }},
+		"last_name":  {Type: FieldString, Ops: stringOps, value: func(u *User) string { return u.LastName }},
+		"nickname":   {Type: FieldString, Ops: stringOps, value: func(u *User) string { return u.NickName }},
+		"email":      {Type: FieldString, Ops: stringOps, value: func(u *User) string { return u.Email }},
+		// email_domain part of the email after @, compared case-insensitively
+		"email_domain": {Type: FieldString, Ops: []FilterOp{FilterEq, FilterNe, FilterIn},
+			value: func(u *User) string { return emailDomain(u.Email) }},
+		"country":    {Type: FieldString, Ops: []FilterOp{FilterEq, FilterNe, FilterIn}, value: func(u *User) string { return u.Country }},
+		"role":       {Type: FieldString, Ops: []FilterOp{FilterEq, FilterNe, FilterIn}, value: func(u *User) string { return u.Role }},
+		"created_at": {Type: FieldTime, Ops: timeOps, time: func(u *User) time.Time { return u.CreatedAt }},
+		"updated_at": {Type: FieldTime, Ops: timeOps, time: func(u *User) time.Time { return u.UpdatedAt }},
+	}
 )
 
-type Filter struct {
-	By    FilterBy
-	Query string
+func (f FilterField) supports(op FilterOp) bool {
+	for _, o := range f.Ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
 }
 
-func (f Filter) IsValid() bool {
-	if f.By != "" && f.Query != "" {
-		return true
+type (
+	// FilterConfig filterable field along with operators enabled for it, all the operators the field supports
+	// are enabled if none are listed. Plain field name is accepted as well
+	FilterConfig struct {
+		Field     string     `yaml:"field"`
+		Operators []FilterOp `yaml:"operators"`
 	}
-	return false
+
+	// Filters fields enabled for filtering -> operators allowed
+	Filters map[string][]FilterOp
+)
+
+// UnmarshalYAML accepts either field name or field with operators
+func (c *FilterConfig) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&c.Field)
+	}
+	type plain FilterConfig
+	return value.Decode((*plain)(c))
+}
+
+// NewFilters validates configured fields and operators
+func NewFilters(cfg []FilterConfig) (Filters, error) {
+	fs := make(Filters, len(cfg))
+	for _, c := range cfg {
+		field, ok := filterFields[c.Field]
+		if !ok {
+			return nil, fmt.Errorf("unknown filter field '%s'", c.Field)
+		}
+		if _, ok = fs[c.Field]; ok {
+			return nil, fmt.Errorf("filter field '%s' is configured twice", c.Field)
+		}
+		for _, op := range c.Operators {
+			if !field.supports(op) {
+				return nil, fmt.Errorf("operator '%s' is not supported by field '%s'", op, c.Field)
+			}
+		}
+		ops := c.Operators
+		if len(ops) == 0 {
+			ops = field.Ops
+		}
+		fs[c.Field] = ops
+	}
+	return fs, nil
 }
 
-func NewFilter(by, query string) (*Filter, error) {
-	fBy := FilterBy(by)
-	// more filters to add
+// WithFilters enables filtering users by the given fields, nothing is filterable otherwise
+func WithFilters(fs Filters) Option {
+	return func(s *Users) {
+		s.filters = fs
+	}
+}
+
+// Filters fields enabled for filtering, listing filters are parsed against them
+func (s Users) Filters() Filters {
+	return s.filters
+}
+
+// NewFilter legacy single field equality filter, e.g. filterBy=country&filter=NL
+func (fs Filters) NewFilter(by, query string) (*Filter, error) {
 	if query == "" {
 		return nil, fmt.Errorf("empty filter")
 	}
-	if fBy == filterByCountry {
-		return &Filter{
-			By:    fBy,
-			Query: query,
-		}, nil
+	if _, ok := fs[by]; !ok {
+		return nil, fmt.Errorf("filterBy parameter '%s' not supported", by)
+	}
+	cond := &Condition{Field: by, Op: FilterEq, Values: []string{query}}
+	if err := cond.validate(fs); err != nil {
+		return nil, err
+	}
+	return &Filter{Expr: cond}, nil
+}
+
+// Validate checks every condition of the filter uses enabled field and operator
+func (fs Filters) Validate(f *Filter) error {
+	if !f.IsValid() {
+		return nil
+	}
+	return fs.validate(f.Expr)
+}
+
+func (fs Filters) validate(e FilterExpr) error {
+	switch e := e.(type) {
+	case *Condition:
+		return fs.allow(e.Field, e.Op)
+	case *Logical:
+		for _, o := range e.Operands {
+			if err := fs.validate(o); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported filter expression %T", e)
+	}
+}
+
+func (fs Filters) allow(name string, op FilterOp) error {
+	field, ok := filterFields[name]
+	if !ok {
+		return fmt.Errorf("unknown filter field '%s'", name)
+	}
+	ops, ok := fs[name]
+	if !ok {
+		return fmt.Errorf("filtering by '%s' is not enabled", name)
+	}
+	if !field.supports(op) {
+		return fmt.Errorf("operator '%s' is not supported by field '%s'", op, name)
 	}
-	return nil, fmt.Errorf("filterBy parameter '%s' not supported", by)
+	for _, o := range ops {
+		if o == op {
+			return nil
+		}
+	}
+	return fmt.Errorf("operator '%s' is not enabled for field '%s'", op, name)
+}
+
+type (
+	// FilterExpr node of the filter expression tree, either *Condition or *Logical
+	FilterExpr interface {
+		String() string
+		Match(u *User) bool
+	}
+
+	// Condition compares user field with the values, Times holds parsed values of time fields
+	Condition struct {
+		Field  string
+		Op     FilterOp
+		Values []string
+		Times  []time.Time
+	}
+
+	// Logical combines operands with AND/OR
+	Logical struct {
+		Op       FilterLogic
+		Operands []FilterExpr
+	}
+
+	// Filter parsed and validated filter expression
+	Filter struct {
+		Expr FilterExpr
+	}
+)
+
+// IsValid reports whether filter has an expression
+func (f *Filter) IsValid() bool {
+	return f != nil && f.Expr != nil
+}
+
+// String canonical expression, parsed back to the same filter
+func (f *Filter) String() string {
+	if !f.IsValid() {
+		return ""
+	}
+	return f.Expr.String()
+}
+
+// Match reports whether user satisfies the filter, empty filter matches everyone
+func (f *Filter) Match(u *User) bool {
+	if !f.IsValid() {
+		return true
+	}
+	return f.Expr.Match(u)
+}
+
+// Match reports whether user field satisfies the condition
+func (c *Condition) Match(u *User) bool {
+	field := filterFields[c.Field]
+	if field.Type == FieldTime {
+		v := field.time(u)
+		switch c.Op {
+		case FilterEq:
+			return v.Equal(c.Times[0])
+		case FilterNe:
+			return !v.Equal(c.Times[0])
+		case FilterLt:
+			return v.Before(c.Times[0])
+		case FilterLte:
+			return !v.After(c.Times[0])
+		case FilterGt:
+			return v.After(c.Times[0])
+		case FilterGte:
+			return !v.Before(c.Times[0])
+		}
+		return false
+	}
+
+	v := c.value(field.value(u))
+	switch c.Op {
+	case FilterEq:
+		return v == c.value(c.Values[0])
+	case FilterNe:
+		return v != c.value(c.Values[0])
+	case FilterIn:
+		for _, val := range c.Values {
+			if v == c.value(val) {
+				return true
+			}
+		}
+		return false
+	case FilterPrefix:
+		return strings.HasPrefix(v, c.Values[0])
+	}
+	return false
+}
+
+// value normalizes values of case-insensitive fields
+func (c *Condition) value(v string) string {
+	if c.Field == "email_domain" {
+		return strings.ToLower(v)
+	}
+	return v
+}
+
+func (c *Condition) String() string {
+	values := make([]string, len(c.Values))
+	for i, v := range c.Values {
+		values[i] = quote(v)
+	}
+	if c.Op == FilterIn {
+		return fmt.Sprintf("%s in (%s)", c.Field, strings.Join(values, ", "))
+	}
+	return fmt.Sprintf("%s %s %s", c.Field, c.Op, values[0])
+}
+
+// validate checks the field and operator are enabled and parses time values
+func (c *Condition) validate(fs Filters) error {
+	if err := fs.allow(c.Field, c.Op); err != nil {
+		return err
+	}
+	if filterFields[c.Field].Type != FieldTime {
+		return nil
+	}
+	c.Times = make([]time.Time, len(c.Values))
+	for i, v := range c.Values {
+		t, err := parseFilterTime(v)
+		if err != nil {
+			return fmt.Errorf("field '%s': %w", c.Field, err)
+		}
+		c.Times[i] = t
+	}
+	return nil
+}
+
+// Match reports whether user satisfies all (AND) or any (OR) of the operands
+func (l *Logical) Match(u *User) bool {
+	for _, e := range l.Operands {
+		if e.Match(u) != (l.Op == FilterAnd) {
+			return l.Op != FilterAnd
+		}
+	}
+	return l.Op == FilterAnd
+}
+
+func (l *Logical) String() string {
+	parts := make([]string, len(l.Operands))
+	for i, e := range l.Operands {
+		parts[i] = e.String()
+		if _, ok := e.(*Logical); ok {
+			parts[i] = "(" + parts[i] + ")"
+		}
+	}
+	return strings.Join(parts, " "+string(l.Op)+" ")
+}
+
+// parseFilterTime accepts dates (UTC midnight) and RFC3339 timestamps
+func parseFilterTime(v string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("'%s' is neither date nor RFC3339 timestamp", v)
+	}
+	return t.UTC(), nil
+}
+
+func emailDomain(email string) string {
+	_, domain, _ := strings.Cut(email, "@")
+	return domain
 }
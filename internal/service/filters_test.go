@@ -0,0 +1,230 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+// allFilters every field with all its operators enabled
+func allFilters() Filters {
+	fs := make(Filters, len(filterFields))
+	for name, f := range filterFields {
+		fs[name] = f.Ops
+	}
+	return fs
+}
+
+func TestParseFilter(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		expr string
+		want string
+		err  string
+	}{
+		"single condition": {
+			expr: "country = NL",
+			want: "country = NL",
+		},
+		"in list": {
+			expr: "country in (NL,DE)",
+			want: "country in (NL, DE)",
+		},
+		"and binds tighter than or": {
+			expr: "country = NL or country = DE and created_at > 2024-01-01",
+			want: "country = NL or (country = DE and created_at > 2024-01-01)",
+		},
+		"parentheses and case-insensitive keywords": {
+			expr: "(first_name PREFIX Jo OR last_name prefix Do) AND updated_at <= 2024-01-01T10:00:00+02:00",
+			want: "(first_name prefix Jo or last_name prefix Do) and updated_at <= 2024-01-01T10:00:00+02:00",
+		},
+		"quoted values": {
+			expr: `nickname = "john doe" and first_name in ("and", "say \"hi\"")`,
+			want: `nickname = "john doe" and first_name in ("and", "say \"hi\"")`,
+		},
+		"email domain": {
+			expr: "email_domain in (Example.com, gmail.com)",
+			want: "email_domain in (Example.com, gmail.com)",
+		},
+		"empty": {
+			expr: "  ",
+			err:  "empty filter",
+		},
+		"unknown field": {
+			expr: "password = secret",
+			err:  "unknown filter field 'password'",
+		},
+		"operator not supported": {
+			expr: "created_at prefix 2024",
+			err:  "operator 'prefix' is not supported by field 'created_at'",
+		},
+		"invalid time": {
+			expr: "created_at > yesterday",
+			err:  "field 'created_at': 'yesterday' is neither date nor RFC3339 timestamp",
+		},
+		"missing value": {
+			expr: "country =",
+			err:  "unexpected end of filter, value expected",
+		},
+		"unbalanced parentheses": {
+			expr: "(country = NL",
+			err:  "unexpected end of filter, ')' expected",
+		},
+		"trailing tokens": {
+			expr: "country = NL DE",
+			err:  "unexpected 'DE' at 13",
+		},
+		"unterminated string": {
+			expr: `nickname = "john`,
+			err:  "unterminated string at 11",
+		},
+		"empty string": {
+			expr: `nickname = ""`,
+			err:  "empty value at 11",
+		},
+		"too many conditions": {
+			expr: "country in (" + strings.Repeat("NL,", maxFilterConditions) + "DE)",
+			err:  "filter has more than 32 conditions",
+		},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			f, err := allFilters().ParseFilter(tc.expr)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, f.String())
+
+			// canonical form is parsed back to the same filter
+			again, err := allFilters().ParseFilter(f.String())
+			require.NoError(t, err)
+			assert.Equal(t, f, again)
+		})
+	}
+}
+
+func TestFilter_Match(t *testing.T) {
+	t.Parallel()
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	u := &User{FirstName: "John", LastName: "Doe", NickName: "jd", Email: "john@Example.com", Country: "NL", CreatedAt: created}
+
+	tests := map[string]bool{
+		"country = NL":                                true,
+		"country != NL":                               false,
+		"country in (DE, NL)":                         true,
+		"first_name prefix Jo":                        true,
+		"first_name prefix jo":                        false,
+		"email_domain = example.COM":                  true,
+		"created_at > 2024-03-01":                     true,
+		"created_at >= 2024-03-01T12:00:00Z":          true,
+		"created_at < 2024-03-01T13:00:00+02:00":      false,
+		"country = DE or nickname = jd":               true,
+		"country = NL and (nickname = x or role = y)": false,
+	}
+	for expr, want := range tests {
+		expr, want := expr, want
+		t.Run(expr, func(t *testing.T) {
+			t.Parallel()
+			f, err := allFilters().ParseFilter(expr)
+			require.NoError(t, err)
+			assert.Equal(t, want, f.Match(u))
+		})
+	}
+}
+
+func TestNewFilter(t *testing.T) {
+	t.Parallel()
+	fs := Filters{"country": {FilterEq}}
+	f, err := fs.NewFilter("country", "NL")
+	require.NoError(t, err)
+	assert.Equal(t, "country = NL", f.String())
+
+	_, err = fs.NewFilter("FirstName", "John")
+	assert.EqualError(t, err, "filterBy parameter 'FirstName' not supported")
+	_, err = fs.NewFilter("nickname", "john")
+	assert.EqualError(t, err, "filterBy parameter 'nickname' not supported")
+	_, err = fs.NewFilter("country", "")
+	assert.EqualError(t, err, "empty filter")
+}
+
+func TestNewFilters(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		config string
+		want   Filters
+		err    string
+	}{
+		"field names": {
+			config: "[country, email_domain]",
+			want: Filters{
+				"country":      {FilterEq, FilterNe, FilterIn},
+				"email_domain": {FilterEq, FilterNe, FilterIn},
+			},
+		},
+		"field with operators": {
+			config: `[country, {field: created_at, operators: [">=", "<"]}]`,
+			want: Filters{
+				"country":    {FilterEq, FilterNe, FilterIn},
+				"created_at": {FilterGte, FilterLt},
+			},
+		},
+		"none": {
+			config: "[]",
+			want:   Filters{},
+		},
+		"unknown field": {
+			config: "[password]",
+			err:    "unknown filter field 'password'",
+		},
+		"unsupported operator": {
+			config: "[{field: country, operators: [prefix]}]",
+			err:    "operator 'prefix' is not supported by field 'country'",
+		},
+		"duplicate field": {
+			config: "[country, {field: country, operators: [=]}]",
+			err:    "filter field 'country' is configured twice",
+		},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var cfg []FilterConfig
+			require.NoError(t, yaml.Unmarshal([]byte(tc.config), &cfg))
+			fs, err := NewFilters(cfg)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, fs)
+		})
+	}
+}
+
+func TestFilters_ParseFilterNotEnabled(t *testing.T) {
+	t.Parallel()
+	fs := Filters{"country": {FilterEq, FilterIn}, "created_at": {FilterGte}}
+
+	_, err := fs.ParseFilter("country in (NL, DE) and created_at >= 2024-01-01")
+	require.NoError(t, err)
+
+	_, err = fs.ParseFilter("country = NL or nickname = jd")
+	assert.EqualError(t, err, "filtering by 'nickname' is not enabled")
+	_, err = fs.ParseFilter("created_at < 2024-01-01")
+	assert.EqualError(t, err, "operator '<' is not enabled for field 'created_at'")
+
+	f, err := allFilters().ParseFilter("country = NL or nickname = jd")
+	require.NoError(t, err)
+	assert.EqualError(t, fs.Validate(f), "filtering by 'nickname' is not enabled")
+}
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"context"
+	"fmt"
+)
+
+// MaxImportSize users a single import could have, they are created in batches of MaxBatchSize
+const MaxImportSize = 50 * MaxBatchSize
+
+type (
+	// ImportResult outcome of an imported user, ID and User are set for the created ones only
+	ImportResult struct {
+		BatchResult
+		// Conflicts email and nickname values already taken, the user is rejected if there are any
+		Conflicts []Conflict
+	}
+
+	// Conflict unique field value taken by an existing user or an earlier user of the import
+	Conflict struct {
+		Field string
+		Value string
+		// UserID existing user the value is taken by, empty if it is taken by the import itself
+		UserID string
+		// Item index of the earlier imported user the value is taken by, -1 if it is taken by an existing user
+		Item int
+	}
+
+	// Logins emails and nicknames taken by the existing users, value -> user ID
+	Logins struct {
+		Emails    map[string]string
+		Nicknames map[string]string
+	}
+)
+
+// ImportUsers validates users and reports their email and nickname conflicts, then creates the users passed both
+// unless dryRun. Users are created in batches of MaxBatchSize, failed users do not fail the others
+func (s Users) ImportUsers(ctx context.Context, users []*User, dryRun bool) ([]ImportResult, error) {
+	if len(users) == 0 {
+		return nil, &Error{Code: ErrCodeBadRequest, Message: "empty import"}
+	}
+	if len(users) > MaxImportSize {
+		return nil, &Error{Code: ErrCodeBadRequest, Message: fmt.Sprintf("import exceeds %d users", MaxImportSize)}
+	}
+	assignsRole := false
+	for _, u := range users {
+		assignsRole = assignsRole || u.Role != "" && u.Role != RoleSelf
+	}
+	if err := s.authorize(ctx, access{op: OpCreateUser, role: assignsRole}); err != nil {
+		return nil, err
+	}
+
+	results := make([]ImportResult, len(users))
+	// values taken by the import -> index of the user took them first
+	emails := make(map[string]int, len(users))
+	nicknames := make(map[string]int, len(users))
+	for i, u := range users {
+		if u.Role == "" {
+			u.Role = RoleSelf
+		}
+		if err := u.Validate(false); err != nil {
+			results[i].Err = &Error{Code: ErrCodeBadRequest, Message: err.Error()}
+			continue
+		}
+		if j, ok := emails[u.Email]; ok {
+			results[i].Conflicts = append(results[i].Conflicts, Conflict{Field: "email", Value: u.Email, Item: j})
+		} else {
+			emails[u.Email] = i
+		}
+		if j, ok := nicknames[u.NickName]; ok {
+			results[i].Conflicts = append(results[i].Conflicts, Conflict{Field: "nickname", Value: u.NickName, Item: j})
+		} else {
+			nicknames[u.NickName] = i
+		}
+	}
+
+	taken, err := s.repo.TakenLogins(ctx, keys(emails), keys(nicknames))
+	if err != nil {
+		s.log.WithField("component", "service").Debugf("could not look up taken logins: %v", err)
+		return nil, ErrInternal
+	}
+
+	pending := make([]int, 0, len(users))
+	for i, u := range users {
+		if results[i].Err != nil {
+			continue
+		}
+		if id, ok := taken.Emails[u.Email]; ok {
+			results[i].Conflicts = append(results[i].Conflicts, Conflict{Field: "email", Value: u.Email, UserID: id, Item: -1})
+		}
+		if id, ok := taken.Nicknames[u.NickName]; ok {
+			results[i].Conflicts = append(results[i].Conflicts,
+				Conflict{Field: "nickname", Value: u.NickName, UserID: id, Item: -1})
+		}
+		if len(results[i].Conflicts) > 0 {
+			results[i].Err = ErrUserAlreadyExists
+			continue
+		}
+		pending = append(pending, i)
+	}
+	if dryRun {
+		return results, nil
+	}
+
+	for start := 0; start < len(pending); start += MaxBatchSize {
+		positions := pending[start:min(start+MaxBatchSize, len(pending))]
+		batch := make([]*User, len(positions))
+		for j, i := range positions {
+			batch[j] = users[i]
+		}
+		created, err := s.createUsers(ctx, batch, false)
+		if err != nil {
+			s.log.WithField("component", "service").Debug(err)
+		}
+		for j, i := range positions {
+			switch {
+			case err != nil:
+				results[i].Err = ErrInternal
+			case !created[j]:
+				// taken concurrently after the conflicts were looked up
+				results[i].Err = ErrUserAlreadyExists
+			default:
+				user := *batch[j]
+				user.Password = ""
+				results[i].ID = user.ID
+				results[i].User = &user
+			}
+		}
+	}
+	return results, nil
+}
+
+// keys returns values taken by the import
+func keys(values map[string]int) []string {
+	all := make([]string, 0, len(values))
+	for v := range values {
+		all = append(all, v)
+	}
+	return all
+}
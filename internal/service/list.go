@@ -0,0 +1,229 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// sortTimeLayout fixed width UTC layout, so that cursor values of time fields are ordered as strings too
+const sortTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// sortFields fields users could be sorted by
+var sortFields = map[string]bool{
+	"created_at": true,
+	"updated_at": true,
+	"last_name":  true,
+	"nickname":   true,
+	"email":      true,
+	"country":    true,
+}
+
+// SortFields fields enabled for sorting, a subset of the supported ones
+type SortFields map[string]bool
+
+// DefaultSort newest users first
+var DefaultSort = Sort{{Field: "created_at", Desc: true}}
+
+type (
+	// SortKey field users are sorted by
+	SortKey struct {
+		Field string
+		Desc  bool
+	}
+
+	// Sort listing order, ties are broken by id in the direction of the last key
+	Sort []SortKey
+
+	// Cursor sort key values of the last user seen, in the sort order, along with its id
+	Cursor struct {
+		Values []string
+		ID     string
+	}
+
+	// ListQuery users page request, all the users are returned if limit is not positive.
+	// Users are sorted by DefaultSort unless Sort is set
+	ListQuery struct {
+		Limit  int
+		After  *Cursor
+		Filter *Filter
+		Sort   Sort
+		// IncludeTotal count users matching the filter along with the page
+		IncludeTotal bool
+	}
+
+	// Totals counts of the users matching the filter, regardless of the page
+	Totals struct {
+		Count int
+		// Countries country -> users count facet
+		Countries map[string]int
+	}
+
+	// UsersPage users page along with the cursor of the next one, nil if it is the last page.
+	// Totals are set only if they were requested
+	UsersPage struct {
+		Users  []User
+		Next   *Cursor
+		Totals *Totals
+	}
+)
+
+// ParseSort parses comma separated fields, descending ones are prefixed with '-', e.g. -updated_at,last_name.
+// Empty sort is nil, i.e. the default one
+func ParseSort(s string) (Sort, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	parts := strings.Split(s, ",")
+	sort := make(Sort, 0, len(parts))
+	seen := make(map[string]bool, len(parts))
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		key := SortKey{Field: strings.TrimPrefix(part, "+")}
+		if strings.HasPrefix(part, "-") {
+			key = SortKey{Field: part[1:], Desc: true}
+		}
+		if key.Field == "" {
+			return nil, fmt.Errorf("empty sort field")
+		}
+		if !sortFields[key.Field] {
+			return nil, fmt.Errorf("sort field '%s' not supported", key.Field)
+		}
+		if seen[key.Field] {
+			return nil, fmt.Errorf("sort field '%s' is repeated", key.Field)
+		}
+		seen[key.Field] = true
+		sort = append(sort, key)
+	}
+	return sort, nil
+}
+
+// NewSortFields validates configured fields, all the supported fields are enabled if none are configured
+func NewSortFields(fields []string) (SortFields, error) {
+	if len(fields) == 0 {
+		fields = make([]string, 0, len(sortFields))
+		for f := range sortFields {
+			fields = append(fields, f)
+		}
+	}
+	sf := make(SortFields, len(fields))
+	for _, f := range fields {
+		if !sortFields[f] {
+			return nil, fmt.Errorf("unknown sort field '%s'", f)
+		}
+		if sf[f] {
+			return nil, fmt.Errorf("sort field '%s' is configured twice", f)
+		}
+		sf[f] = true
+	}
+	return sf, nil
+}
+
+// Validate refuses sorting by the fields not enabled, nil sort fields enable all of them
+func (sf SortFields) Validate(s Sort) error {
+	if sf == nil {
+		return nil
+	}
+	for _, k := range s {
+		if !sf[k.Field] {
+			return fmt.Errorf("sort field '%s' is not enabled", k.Field)
+		}
+	}
+	return nil
+}
+
+// WithSortFields restricts sorting to the given fields, users could be sorted by any supported field otherwise
+func WithSortFields(sf SortFields) Option {
+	return func(s *Users) {
+		s.sorts = sf
+	}
+}
+
+// String sort in the form ParseSort accepts, empty for the default sort
+func (s Sort) String() string {
+	parts := make([]string, len(s))
+	for i, k := range s {
+		parts[i] = k.Field
+		if k.Desc {
+			parts[i] = "-" + k.Field
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// OrDefault sort itself, DefaultSort if it is empty
+func (s Sort) OrDefault() Sort {
+	if len(s) == 0 {
+		return DefaultSort
+	}
+	return s
+}
+
+// CursorOf cursor pointing right after the user
+func (s Sort) CursorOf(u *User) *Cursor {
+	s = s.OrDefault()
+	c := &Cursor{Values: make([]string, len(s)), ID: u.ID}
+	for i, k := range s {
+		c.Values[i] = SortValue(u, k.Field)
+	}
+	return c
+}
+
+// Compare orders users by the sort keys and then by id, negative if a goes before b
+func (s Sort) Compare(a, b *User) int {
+	s = s.OrDefault()
+	for _, k := range s {
+		if c := k.compare(SortValue(a, k.Field), SortValue(b, k.Field)); c != 0 {
+			return c
+		}
+	}
+	return s[len(s)-1].compare(a.ID, b.ID)
+}
+
+// Seen reports whether user goes before the cursor in the listing order, i.e. was already seen
+func (s Sort) Seen(c *Cursor, u *User) bool {
+	s = s.OrDefault()
+	for i, k := range s {
+		if cmp := k.compare(SortValue(u, k.Field), c.Values[i]); cmp != 0 {
+			return cmp < 0
+		}
+	}
+	return s[len(s)-1].compare(u.ID, c.ID) <= 0
+}
+
+// Validate checks cursor matches the sort
+func (s Sort) Validate(c *Cursor) error {
+	if c != nil && len(c.Values) != len(s.OrDefault()) {
+		return fmt.Errorf("cursor does not match sort '%s'", s.OrDefault())
+	}
+	return nil
+}
+
+func (k SortKey) compare(a, b string) int {
+	c := strings.Compare(a, b)
+	if k.Desc {
+		return -c
+	}
+	return c
+}
+
+// SortValue user field value as it is kept in the cursor, times are formatted with sortTimeLayout
+func SortValue(u *User, field string) string {
+	f := filterFields[field]
+	if f.Type == FieldTime {
+		return f.time(u).UTC().Format(sortTimeLayout)
+	}
+	return f.value(u)
+}
+
+// ParseSortValue cursor value in the type of the field, time.Time for time fields
+func ParseSortValue(field, v string) (interface{}, error) {
+	if filterFields[field].Type != FieldTime {
+		return v, nil
+	}
+	t, err := time.Parse(sortTimeLayout, v)
+	if err != nil {
+		return nil, fmt.Errorf("malformed cursor value of '%s': %w", field, err)
+	}
+	return t, nil
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSort(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		sort string
+		want Sort
+		err  string
+	}{
+		"default": {
+			sort: "",
+			want: nil,
+		},
+		"mixed directions": {
+			sort: "-updated_at, +last_name,nickname",
+			want: Sort{{Field: "updated_at", Desc: true}, {Field: "last_name"}, {Field: "nickname"}},
+		},
+		"not supported": {
+			sort: "password",
+			err:  "sort field 'password' not supported",
+		},
+		"repeated": {
+			sort: "email,-email",
+			err:  "sort field 'email' is repeated",
+		},
+		"empty field": {
+			sort: "email,,country",
+			err:  "empty sort field",
+		},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			sort, err := ParseSort(tc.sort)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, sort)
+
+			again, err := ParseSort(sort.String())
+			require.NoError(t, err)
+			assert.Equal(t, sort, again)
+		})
+	}
+}
+
+func TestSort_CompareSeen(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	a := &User{ID: "a", LastName: "Doe", UpdatedAt: now, CreatedAt: now}
+	b := &User{ID: "b", LastName: "Doe", UpdatedAt: now.Add(time.Nanosecond), CreatedAt: now}
+	c := &User{ID: "c", LastName: "Abe", UpdatedAt: now, CreatedAt: now.Add(time.Hour)}
+
+	tests := map[string]struct {
+		sort  Sort
+		order []*User
+	}{
+		"default": {
+			order: []*User{c, b, a},
+		},
+		"last name then id": {
+			sort:  Sort{{Field: "last_name"}},
+			order: []*User{c, a, b},
+		},
+		"updated_at descending then last name": {
+			sort:  Sort{{Field: "updated_at", Desc: true}, {Field: "last_name"}},
+			order: []*User{b, c, a},
+		},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			for i := 0; i < len(tc.order)-1; i++ {
+				assert.Negative(t, tc.sort.Compare(tc.order[i], tc.order[i+1]))
+				assert.Positive(t, tc.sort.Compare(tc.order[i+1], tc.order[i]))
+
+				cursor := tc.sort.CursorOf(tc.order[i])
+				assert.True(t, tc.sort.Seen(cursor, tc.order[i]), "user the cursor points at is seen")
+				assert.False(t, tc.sort.Seen(cursor, tc.order[i+1]))
+			}
+		})
+	}
+}
+
+func TestNewSortFields(t *testing.T) {
+	t.Parallel()
+	all, err := NewSortFields(nil)
+	require.NoError(t, err)
+	assert.Len(t, all, len(sortFields), "all the supported fields are enabled unless configured")
+
+	sf, err := NewSortFields([]string{"created_at", "updated_at"})
+	require.NoError(t, err)
+	sort, err := ParseSort("-updated_at,created_at")
+	require.NoError(t, err)
+	assert.NoError(t, sf.Validate(sort))
+	sort, err = ParseSort("created_at,last_name")
+	require.NoError(t, err)
+	assert.EqualError(t, sf.Validate(sort), "sort field 'last_name' is not enabled")
+	assert.NoError(t, SortFields(nil).Validate(sort))
+
+	_, err = NewSortFields([]string{"first_name"})
+	assert.EqualError(t, err, "unknown sort field 'first_name'")
+	_, err = NewSortFields([]string{"email", "email"})
+	assert.EqualError(t, err, "sort field 'email' is configured twice")
+}
+
+func TestUsers_ListUsersSortNotEnabled(t *testing.T) {
+	t.Parallel()
+	sf, err := NewSortFields([]string{"created_at"})
+	require.NoError(t, err)
+	// repository is never reached
+	s := New(nil, logrus.New(), nil, WithSortFields(sf))
+
+	_, err = s.ListUsers(context.Background(), ListQuery{Sort: Sort{{Field: "email"}}})
+	assert.Equal(t, &Error{Code: ErrCodeBadRequest, Message: "sort field 'email' is not enabled"}, err)
+	err = s.StreamUsers(context.Background(), ListQuery{Sort: Sort{{Field: "email"}}}, nil)
+	assert.Equal(t, &Error{Code: ErrCodeBadRequest, Message: "sort field 'email' is not enabled"}, err)
+}
@@ -0,0 +1,338 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/BorisRostovskiy/ESL/internal/clients"
+	"github.com/BorisRostovskiy/ESL/internal/events"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultRelayInterval    = time.Second
+	defaultRelayBatchSize   = 100
+	defaultRelayMaxAttempts = 10
+	defaultRelayBackoff     = time.Second
+	defaultRelayMaxBackoff  = 10 * time.Minute
+	defaultRelayTimeout     = 10 * time.Second
+	// relayClaimMargin extends claim of the event beyond its delivery timeout, so that results are recorded
+	// before another relay could claim it
+	relayClaimMargin = 5 * time.Second
+)
+
+type (
+	// Transactor runs fn in a single transaction carried by the context, repository calls
+	// made with that context are part of it. Nested calls join the outer transaction
+	Transactor interface {
+		RunInTx(ctx context.Context, fn func(ctx context.Context) error) error
+	}
+
+	// OutboxRepo define user change events repository interface
+	OutboxRepo interface {
+		AddEvent(ctx context.Context, e *OutboxEvent) error
+		// ClaimEvents returns events due for delivery ordered by id, skipping users whose earlier events are
+		// waiting for retry or claimed. Returned events are not due till `until`, so that neither another relay
+		// gets them nor later events of their users are relayed first. Returns nothing while another relay
+		// is claiming events, must be called within a transaction
+		ClaimEvents(ctx context.Context, limit int, until time.Time) ([]OutboxEvent, error)
+		// RenewClaim extends claim of the event till `until` if it is still claimed till `claimed`,
+		// returns false if the claim has been taken over or the event is not pending anymore
+		RenewClaim(ctx context.Context, id int64, claimed, until time.Time) (bool, error)
+		MarkDelivered(ctx context.Context, id int64) error
+		// MarkAttemptFailed records failed attempt, event is parked for good if retryAt is nil
+		MarkAttemptFailed(ctx context.Context, id int64, reason string, retryAt *time.Time) error
+		// ReleaseEvents makes events still claimed till `claimed` due right away
+		ReleaseEvents(ctx context.Context, ids []int64, claimed time.Time) error
+		// AddSubscriberDelivery records that subscriber is done with the event, it is not delivered there again
+		AddSubscriberDelivery(ctx context.Context, d *SubscriberDelivery) error
+		// SubscriberDeliveries returns subscribers done with the event
+		SubscriberDeliveries(ctx context.Context, eventID int64) ([]SubscriberDelivery, error)
+	}
+
+	// OutboxEvent user change event stored in the same transaction as the change itself
+	OutboxEvent struct {
+		ID      int64
+		UserID  string
+		Channel string
+		// Payload JSON encoded events.Event
+		Payload   string
+		CreatedAt time.Time
+		// Attempts number of failed delivery attempts so far
+		Attempts int
+	}
+
+	// SubscriberDelivery outcome of the outbox event delivery to a single subscriber
+	SubscriberDelivery struct {
+		EventID    int64
+		Subscriber string
+		// Rejected reason subscriber refused the event for good with, empty if it is delivered
+		Rejected  string
+		CreatedAt time.Time
+	}
+
+	// RelayConfig outbox relay settings
+	RelayConfig struct {
+		Interval    time.Duration `yaml:"interval"`
+		BatchSize   int           `yaml:"batch_size"`
+		MaxAttempts int           `yaml:"max_attempts"`
+		Backoff     time.Duration `yaml:"backoff"`
+		MaxBackoff  time.Duration `yaml:"max_backoff"`
+		// Timeout single event delivery timeout, the event is claimed for it along with a small margin
+		// right before its delivery
+		Timeout time.Duration `yaml:"timeout"`
+	}
+
+	// OutboxRelay polls outbox and hands events to the notificator and subscribers, at least once and in order per user
+	OutboxRelay struct {
+		cfg         RelayConfig
+		tx          Transactor
+		repo        OutboxRepo
+		notify      clients.ChannelNotificator
+		subscribers clients.SubscriberNotificator
+		log         logrus.FieldLogger
+	}
+
+	// RelayOption configures the relay
+	RelayOption func(*OutboxRelay)
+)
+
+// WithSubscribers delivers events to every subscriber on its own after the notificator has been notified,
+// deliveries are recorded per subscriber, so that retries skip subscribers done with the event and subscribers
+// rejecting it do not hold back the others
+func WithSubscribers(n clients.SubscriberNotificator) RelayOption {
+	return func(r *OutboxRelay) {
+		r.subscribers = n
+	}
+}
+
+// WithOutbox stores user change events in the outbox within the change transaction instead of
+// notifying directly, OutboxRelay delivers them afterward
+func WithOutbox(tx Transactor, repo OutboxRepo) Option {
+	return func(s *Users) {
+		s.tx = tx
+		s.outbox = repo
+	}
+}
+
+// NewOutboxRelay creates relay, Run starts it
+func NewOutboxRelay(cfg RelayConfig, tx Transactor, repo OutboxRepo, n clients.ChannelNotificator,
+	log *logrus.Logger, opts ...RelayOption) *OutboxRelay {
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultRelayInterval
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultRelayBatchSize
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultRelayMaxAttempts
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = defaultRelayBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = defaultRelayMaxBackoff
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultRelayTimeout
+	}
+	r := &OutboxRelay{
+		cfg:    cfg,
+		tx:     tx,
+		repo:   repo,
+		notify: n,
+		log:    log.WithField("component", "outbox_relay"),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Run relays events until ctx is done, full batches are followed by the next one immediately
+func (r *OutboxRelay) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		n, err := r.Relay(ctx)
+		if err != nil && ctx.Err() == nil {
+			r.log.Errorf("could not relay events: %v", err)
+		}
+		if n == r.cfg.BatchSize {
+			timer.Reset(0)
+		} else {
+			timer.Reset(r.cfg.Interval)
+		}
+	}
+}
+
+// Relay hands a single batch of pending events to the notificator, returns number of fetched events.
+// Events are claimed within a short transaction and delivered outside of it, so that slow subscribers never
+// keep the transaction open, every result is recorded on its own. The batch is claimed for a single delivery
+// timeout, every event renews its claim right before the delivery, so that events of a relay which has crashed
+// are not stuck for the whole batch. Events of the user following the failed one are held back until it is
+// delivered or parked
+func (r *OutboxRelay) Relay(ctx context.Context) (int, error) {
+	var pending []OutboxEvent
+	claimed := r.claimUntil()
+	err := r.tx.RunInTx(ctx, func(ctx context.Context) error {
+		var err error
+		pending, err = r.repo.ClaimEvents(ctx, r.cfg.BatchSize, claimed)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("relay outbox: %w", err)
+	}
+
+	// results of the delivered events are recorded even if the relay is stopping meanwhile,
+	// so that they are not delivered once again
+	rctx := context.WithoutCancel(ctx)
+	held := make(map[string]bool)
+	var released []int64
+	for i, e := range pending {
+		if held[e.UserID] || ctx.Err() != nil {
+			released = append(released, e.ID)
+			continue
+		}
+		renewed, err := r.repo.RenewClaim(ctx, e.ID, claimed, r.claimUntil())
+		if err == nil && !renewed {
+			// claim has ended before the event was attempted and another relay took it over along with
+			// the following events of the user
+			r.log.Warnf("claim of event %d of user %s has been taken over", e.ID, e.UserID)
+			held[e.UserID] = true
+			continue
+		}
+		if err == nil {
+			held[e.UserID], err = r.deliver(ctx, rctx, e)
+		}
+		if err != nil {
+			// the event is relayed once its claim ends, the following ones are not held back that long
+			for _, next := range pending[i+1:] {
+				released = append(released, next.ID)
+			}
+			r.release(rctx, released, claimed)
+			return len(pending), fmt.Errorf("relay outbox: %w", err)
+		}
+	}
+	r.release(rctx, released, claimed)
+	return len(pending), nil
+}
+
+// claimUntil end of the claim of the event delivered right away
+func (r *OutboxRelay) claimUntil() time.Time {
+	return time.Now().Add(r.cfg.Timeout + relayClaimMargin)
+}
+
+// deliver hands event to the notificator and records the result, returns whether the following events
+// of the user must be held back
+func (r *OutboxRelay) deliver(ctx, rctx context.Context, e OutboxEvent) (bool, error) {
+	var event events.Event
+	if err := json.Unmarshal([]byte(e.Payload), &event); err != nil {
+		// malformed event would never be delivered, it must not hold back the following ones
+		r.log.Errorf("event %d of user %s is parked, malformed payload: %v", e.ID, e.UserID, err)
+		return false, r.repo.MarkAttemptFailed(rctx, e.ID, err.Error(), nil)
+	}
+
+	nctx, cancel := context.WithTimeout(ctx, r.cfg.Timeout)
+	defer cancel()
+	notifyErr := r.notify.Notify(nctx, clients.ChannelName(e.Channel), &event)
+	if notifyErr == nil && r.subscribers != nil {
+		var err error
+		if notifyErr, err = r.deliverSubscribers(nctx, rctx, e, &event); err != nil {
+			return false, err
+		}
+	}
+	if notifyErr == nil {
+		return false, r.repo.MarkDelivered(rctx, e.ID)
+	}
+
+	var retryAt *time.Time
+	if e.Attempts+1 < r.cfg.MaxAttempts {
+		at := time.Now().Add(r.backoff(e.Attempts + 1))
+		retryAt = &at
+		r.log.Warnf("event %d of user %s is not delivered, retry at %s: %v",
+			e.ID, e.UserID, at.Format(time.RFC3339), notifyErr)
+	} else {
+		r.log.Errorf("event %d of user %s is parked after %d attempts: %v",
+			e.ID, e.UserID, e.Attempts+1, notifyErr)
+	}
+	return true, r.repo.MarkAttemptFailed(rctx, e.ID, notifyErr.Error(), retryAt)
+}
+
+// deliverSubscribers delivers event concurrently to the subscribers not done with it yet and records every outcome,
+// subscribers rejecting the event are done with it as well. Returns undelivered error joining failures worth
+// retrying, err if an outcome could not be recorded
+func (r *OutboxRelay) deliverSubscribers(ctx, rctx context.Context, e OutboxEvent,
+	event *events.Event) (undelivered error, err error) {
+	deliveries, err := r.repo.SubscriberDeliveries(rctx, e.ID)
+	if err != nil {
+		return nil, err
+	}
+	done := make(map[string]bool, len(deliveries))
+	for _, d := range deliveries {
+		done[d.Subscriber] = true
+	}
+
+	var pending []string
+	for _, subscriber := range r.subscribers.Subscribers(clients.ChannelName(e.Channel)) {
+		if !done[subscriber] {
+			pending = append(pending, subscriber)
+		}
+	}
+	results := make([]error, len(pending))
+	var wg sync.WaitGroup
+	for i, subscriber := range pending {
+		wg.Add(1)
+		go func(i int, subscriber string) {
+			defer wg.Done()
+			results[i] = r.subscribers.Deliver(ctx, subscriber, event)
+		}(i, subscriber)
+	}
+	wg.Wait()
+
+	var failed []error
+	for i, subscriber := range pending {
+		d := &SubscriberDelivery{EventID: e.ID, Subscriber: subscriber}
+		switch {
+		case results[i] == nil:
+		case errors.Is(results[i], clients.ErrRejected):
+			r.log.Warnf("event %d of user %s is dropped for %s: %v", e.ID, e.UserID, subscriber, results[i])
+			d.Rejected = results[i].Error()
+		default:
+			failed = append(failed, fmt.Errorf("%s: %w", subscriber, results[i]))
+			continue
+		}
+		if err = r.repo.AddSubscriberDelivery(rctx, d); err != nil {
+			return nil, err
+		}
+	}
+	return errors.Join(failed...), nil
+}
+
+// release ends claim of the events not attempted, they are relayed once the claim ends otherwise
+func (r *OutboxRelay) release(ctx context.Context, ids []int64, claimed time.Time) {
+	if len(ids) == 0 {
+		return
+	}
+	if err := r.repo.ReleaseEvents(ctx, ids, claimed); err != nil {
+		r.log.Errorf("could not release %d events: %v", len(ids), err)
+	}
+}
+
+func (r *OutboxRelay) backoff(attempt int) time.Duration {
+	d := r.cfg.Backoff << (attempt - 1)
+	if d <= 0 || d > r.cfg.MaxBackoff {
+		return r.cfg.MaxBackoff
+	}
+	return d
+}
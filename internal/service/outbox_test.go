@@ -0,0 +1,288 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/BorisRostovskiy/ESL/internal/clients"
+	"github.com/BorisRostovskiy/ESL/internal/events"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestOutboxRelay_Relay(t *testing.T) {
+	t.Parallel()
+
+	var (
+		first  = outboxEvent(t, 1, clients.ChannelCreate, events.NewUserCreated(nil, events.User{ID: "user1"}))
+		second = outboxEvent(t, 2, clients.ChannelUpdate, events.NewUserUpdated(nil, "user1", nil))
+		other  = outboxEvent(t, 3, clients.ChannelCreate, events.NewUserCreated(nil, events.User{ID: "user2"}))
+		errNA  = errors.New("unavailable")
+	)
+	parked := first
+	parked.Attempts = 2
+
+	tests := map[string]struct {
+		events  []OutboxEvent
+		prepare func(repo *MockOutboxRepo, n *clients.MockChannelNotificator)
+	}{
+		"all delivered": {
+			events: []OutboxEvent{first, second},
+			prepare: func(repo *MockOutboxRepo, n *clients.MockChannelNotificator) {
+				gomock.InOrder(
+					n.EXPECT().Notify(gomock.Any(), clients.ChannelCreate, eventID(first)).Return(nil),
+					repo.EXPECT().MarkDelivered(gomock.Any(), int64(1)).Return(nil),
+					n.EXPECT().Notify(gomock.Any(), clients.ChannelUpdate, eventID(second)).Return(nil),
+					repo.EXPECT().MarkDelivered(gomock.Any(), int64(2)).Return(nil),
+				)
+			},
+		},
+		"failed event holds back the user only": {
+			events: []OutboxEvent{first, second, other},
+			prepare: func(repo *MockOutboxRepo, n *clients.MockChannelNotificator) {
+				n.EXPECT().Notify(gomock.Any(), clients.ChannelCreate, eventID(first)).Return(errNA)
+				repo.EXPECT().MarkAttemptFailed(gomock.Any(), int64(1), "unavailable", gomock.Not(gomock.Nil())).
+					DoAndReturn(func(_ context.Context, _ int64, _ string, at *time.Time) error {
+						assert.WithinDuration(t, time.Now().Add(time.Second), *at, time.Second)
+						return nil
+					})
+				n.EXPECT().Notify(gomock.Any(), clients.ChannelCreate, eventID(other)).Return(nil)
+				repo.EXPECT().MarkDelivered(gomock.Any(), int64(3)).Return(nil)
+				// claim of the held back event ends, it waits for the failed one anyway
+				repo.EXPECT().ReleaseEvents(gomock.Any(), []int64{2}, gomock.Any()).Return(nil)
+			},
+		},
+		"parked after max attempts": {
+			events: []OutboxEvent{parked},
+			prepare: func(repo *MockOutboxRepo, n *clients.MockChannelNotificator) {
+				n.EXPECT().Notify(gomock.Any(), clients.ChannelCreate, eventID(first)).Return(errNA)
+				repo.EXPECT().MarkAttemptFailed(gomock.Any(), int64(1), "unavailable", gomock.Nil()).Return(nil)
+			},
+		},
+		"malformed payload is parked": {
+			events: []OutboxEvent{{ID: 1, UserID: "user1", Channel: "create", Payload: "user1 has been created"}, second},
+			prepare: func(repo *MockOutboxRepo, n *clients.MockChannelNotificator) {
+				repo.EXPECT().MarkAttemptFailed(gomock.Any(), int64(1), gomock.Any(), gomock.Nil()).Return(nil)
+				n.EXPECT().Notify(gomock.Any(), clients.ChannelUpdate, eventID(second)).Return(nil)
+				repo.EXPECT().MarkDelivered(gomock.Any(), int64(2)).Return(nil)
+			},
+		},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctrl := gomock.NewController(t)
+			tx := NewMockTransactor(ctrl)
+			repo := NewMockOutboxRepo(ctrl)
+			n := clients.NewMockChannelNotificator(ctrl)
+
+			var inTx atomic.Bool
+			tx.EXPECT().RunInTx(gomock.Any(), gomock.Any()).
+				DoAndReturn(func(ctx context.Context, fn func(ctx context.Context) error) error {
+					inTx.Store(true)
+					defer inTx.Store(false)
+					return fn(ctx)
+				})
+			repo.EXPECT().ClaimEvents(gomock.Any(), 10, gomock.Any()).Return(tc.events, nil)
+			repo.EXPECT().RenewClaim(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(true, nil).AnyTimes()
+			tc.prepare(repo, n)
+
+			// events are delivered outside of the claiming transaction
+			notify := notifyFunc(func(ctx context.Context, ch clients.ChannelName, e *events.Event) error {
+				assert.False(t, inTx.Load(), "event is delivered within the transaction")
+				return n.Notify(ctx, ch, e)
+			})
+			relay := NewOutboxRelay(RelayConfig{BatchSize: 10, MaxAttempts: 3, Timeout: time.Second}, tx, repo, notify,
+				logrus.New())
+			fetched, err := relay.Relay(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, len(tc.events), fetched)
+		})
+	}
+}
+
+func TestOutboxRelay_RelayRecordFailed(t *testing.T) {
+	t.Parallel()
+	var (
+		first  = outboxEvent(t, 1, clients.ChannelCreate, events.NewUserCreated(nil, events.User{ID: "user1"}))
+		second = outboxEvent(t, 2, clients.ChannelUpdate, events.NewUserUpdated(nil, "user1", nil))
+		other  = outboxEvent(t, 3, clients.ChannelCreate, events.NewUserCreated(nil, events.User{ID: "user2"}))
+	)
+	ctrl := gomock.NewController(t)
+	tx := NewMockTransactor(ctrl)
+	repo := NewMockOutboxRepo(ctrl)
+	n := clients.NewMockChannelNotificator(ctrl)
+
+	tx.EXPECT().RunInTx(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, fn func(ctx context.Context) error) error {
+			return fn(ctx)
+		})
+	repo.EXPECT().ClaimEvents(gomock.Any(), 10, gomock.Any()).Return([]OutboxEvent{first, second, other}, nil)
+	repo.EXPECT().RenewClaim(gomock.Any(), int64(1), gomock.Any(), gomock.Any()).Return(true, nil)
+	n.EXPECT().Notify(gomock.Any(), clients.ChannelCreate, eventID(first)).Return(nil)
+	repo.EXPECT().MarkDelivered(gomock.Any(), int64(1)).Return(errors.New("connection reset"))
+	// the delivered event stays claimed, the following ones are released
+	repo.EXPECT().ReleaseEvents(gomock.Any(), []int64{2, 3}, gomock.Any()).Return(nil)
+
+	relay := NewOutboxRelay(RelayConfig{BatchSize: 10}, tx, repo, n, logrus.New())
+	fetched, err := relay.Relay(context.Background())
+	assert.EqualError(t, err, "relay outbox: connection reset")
+	assert.Equal(t, 3, fetched)
+}
+
+func TestOutboxRelay_RelaySubscribers(t *testing.T) {
+	t.Parallel()
+	var (
+		first    = outboxEvent(t, 1, clients.ChannelCreate, events.NewUserCreated(nil, events.User{ID: "user1"}))
+		rejected = fmt.Errorf("%w with 410", clients.ErrRejected)
+	)
+	subscribers := []string{"http://done", "http://crm", "http://legacy", "http://down"}
+
+	tests := map[string]struct {
+		prepare func(repo *MockOutboxRepo, s *clients.MockSubscriberNotificator)
+	}{
+		"failed subscriber is retried alone": {
+			prepare: func(repo *MockOutboxRepo, s *clients.MockSubscriberNotificator) {
+				// delivered by the earlier attempt
+				repo.EXPECT().SubscriberDeliveries(gomock.Any(), int64(1)).
+					Return([]SubscriberDelivery{{EventID: 1, Subscriber: "http://done"}}, nil)
+				s.EXPECT().Deliver(gomock.Any(), "http://crm", eventID(first)).Return(nil)
+				s.EXPECT().Deliver(gomock.Any(), "http://legacy", eventID(first)).Return(rejected)
+				s.EXPECT().Deliver(gomock.Any(), "http://down", eventID(first)).Return(errors.New("unavailable"))
+				repo.EXPECT().AddSubscriberDelivery(gomock.Any(),
+					&SubscriberDelivery{EventID: 1, Subscriber: "http://crm"}).Return(nil)
+				// rejection is not retried
+				repo.EXPECT().AddSubscriberDelivery(gomock.Any(),
+					&SubscriberDelivery{EventID: 1, Subscriber: "http://legacy", Rejected: "subscriber rejected event with 410"}).
+					Return(nil)
+				repo.EXPECT().MarkAttemptFailed(gomock.Any(), int64(1), "http://down: unavailable", gomock.Not(gomock.Nil())).
+					Return(nil)
+			},
+		},
+		"delivered once all subscribers are done": {
+			prepare: func(repo *MockOutboxRepo, s *clients.MockSubscriberNotificator) {
+				repo.EXPECT().SubscriberDeliveries(gomock.Any(), int64(1)).Return([]SubscriberDelivery{
+					{EventID: 1, Subscriber: "http://done"},
+					{EventID: 1, Subscriber: "http://crm"},
+					{EventID: 1, Subscriber: "http://legacy", Rejected: "subscriber rejected event with 410"},
+				}, nil)
+				s.EXPECT().Deliver(gomock.Any(), "http://down", eventID(first)).Return(nil)
+				repo.EXPECT().AddSubscriberDelivery(gomock.Any(),
+					&SubscriberDelivery{EventID: 1, Subscriber: "http://down"}).Return(nil)
+				repo.EXPECT().MarkDelivered(gomock.Any(), int64(1)).Return(nil)
+			},
+		},
+	}
+	for name, tc := range tests {
+		tc := tc
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctrl := gomock.NewController(t)
+			tx := NewMockTransactor(ctrl)
+			repo := NewMockOutboxRepo(ctrl)
+			n := clients.NewMockChannelNotificator(ctrl)
+			s := clients.NewMockSubscriberNotificator(ctrl)
+
+			tx.EXPECT().RunInTx(gomock.Any(), gomock.Any()).
+				DoAndReturn(func(ctx context.Context, fn func(ctx context.Context) error) error {
+					return fn(ctx)
+				})
+			repo.EXPECT().ClaimEvents(gomock.Any(), 10, gomock.Any()).Return([]OutboxEvent{first}, nil)
+			repo.EXPECT().RenewClaim(gomock.Any(), int64(1), gomock.Any(), gomock.Any()).Return(true, nil)
+			// watchers are notified on every attempt, they skip events they have got already
+			n.EXPECT().Notify(gomock.Any(), clients.ChannelCreate, eventID(first)).Return(nil)
+			s.EXPECT().Subscribers(clients.ChannelCreate).Return(subscribers)
+			tc.prepare(repo, s)
+
+			relay := NewOutboxRelay(RelayConfig{BatchSize: 10}, tx, repo, n, logrus.New(), WithSubscribers(s))
+			fetched, err := relay.Relay(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, 1, fetched)
+		})
+	}
+}
+
+func TestOutboxRelay_RelayClaim(t *testing.T) {
+	t.Parallel()
+	var (
+		first  = outboxEvent(t, 1, clients.ChannelCreate, events.NewUserCreated(nil, events.User{ID: "user1"}))
+		second = outboxEvent(t, 2, clients.ChannelUpdate, events.NewUserUpdated(nil, "user1", nil))
+		other  = outboxEvent(t, 3, clients.ChannelCreate, events.NewUserCreated(nil, events.User{ID: "user2"}))
+	)
+	ctrl := gomock.NewController(t)
+	tx := NewMockTransactor(ctrl)
+	repo := NewMockOutboxRepo(ctrl)
+	n := clients.NewMockChannelNotificator(ctrl)
+
+	tx.EXPECT().RunInTx(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, fn func(ctx context.Context) error) error {
+			return fn(ctx)
+		})
+	var claimed time.Time
+	repo.EXPECT().ClaimEvents(gomock.Any(), 100, gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ int, until time.Time) ([]OutboxEvent, error) {
+			// the batch is claimed for a single delivery, not for the whole batch
+			assert.WithinDuration(t, time.Now().Add(time.Second+relayClaimMargin), until, time.Second)
+			claimed = until
+			return []OutboxEvent{first, second, other}, nil
+		})
+	// another relay has taken over the events of the user meanwhile
+	repo.EXPECT().RenewClaim(gomock.Any(), int64(1), gomock.Any(), gomock.Any()).Return(false, nil)
+	repo.EXPECT().RenewClaim(gomock.Any(), int64(3), gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ int64, from, until time.Time) (bool, error) {
+			assert.Equal(t, claimed, from)
+			assert.WithinDuration(t, time.Now().Add(time.Second+relayClaimMargin), until, time.Second)
+			return true, nil
+		})
+	n.EXPECT().Notify(gomock.Any(), clients.ChannelCreate, eventID(other)).Return(nil)
+	repo.EXPECT().MarkDelivered(gomock.Any(), int64(3)).Return(nil)
+	// released only if it is still claimed by the relay
+	repo.EXPECT().ReleaseEvents(gomock.Any(), []int64{2}, gomock.Any()).
+		DoAndReturn(func(_ context.Context, _ []int64, from time.Time) error {
+			assert.Equal(t, claimed, from)
+			return nil
+		})
+
+	relay := NewOutboxRelay(RelayConfig{BatchSize: 100, Timeout: time.Second}, tx, repo, n, logrus.New())
+	fetched, err := relay.Relay(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 3, fetched)
+}
+
+// notifyFunc adapts function to the clients.ChannelNotificator
+type notifyFunc func(ctx context.Context, channelName clients.ChannelName, event *events.Event) error
+
+func (f notifyFunc) Notify(ctx context.Context, channelName clients.ChannelName, event *events.Event) error {
+	return f(ctx, channelName, event)
+}
+
+func outboxEvent(t *testing.T, id int64, channel clients.ChannelName, e *events.Event) OutboxEvent {
+	t.Helper()
+	payload, err := json.Marshal(e)
+	require.NoError(t, err)
+	return OutboxEvent{ID: id, UserID: e.UserID, Channel: string(channel), Payload: string(payload)}
+}
+
+// eventID matches event stored in the outbox
+func eventID(e OutboxEvent) gomock.Matcher {
+	var stored events.Event
+	_ = json.Unmarshal([]byte(e.Payload), &stored)
+	return gomock.Cond(func(got *events.Event) bool { return got.ID == stored.ID })
+}
+
+func TestOutboxRelay_Backoff(t *testing.T) {
+	t.Parallel()
+	relay := NewOutboxRelay(RelayConfig{Backoff: time.Second, MaxBackoff: time.Minute}, nil, nil, nil, logrus.New())
+	assert.Equal(t, time.Second, relay.backoff(1))
+	assert.Equal(t, 4*time.Second, relay.backoff(3))
+	assert.Equal(t, time.Minute, relay.backoff(10))
+	assert.Equal(t, time.Minute, relay.backoff(100))
+}
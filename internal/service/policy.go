@@ -0,0 +1,89 @@
+package service
+
+import "context"
+
+const (
+	RoleAdmin   = "admin"
+	RoleSupport = "support"
+	RoleSelf    = "self"
+)
+
+// Operation user operation subject to authorization
+type Operation string
+
+const (
+	OpGetUser     Operation = "get_user"
+	OpListUsers   Operation = "list_users"
+	OpCreateUser  Operation = "create_user"
+	OpUpdateUser  Operation = "update_user"
+	OpDeleteUser  Operation = "delete_user"
+	OpRestoreUser Operation = "restore_user"
+	OpGetHistory  Operation = "get_history"
+	OpExportUser  Operation = "export_user"
+	OpEraseUser   Operation = "erase_user"
+)
+
+// access operation along with what it touches, input of the policy
+type access struct {
+	op Operation
+	// target user id, empty for create and list
+	target string
+	// email operation changes email
+	email bool
+	// role operation assigns role other than default
+	role bool
+}
+
+// ValidRole reports whether role is one of known roles
+func ValidRole(role string) bool {
+	switch role {
+	case RoleAdmin, RoleSupport, RoleSelf:
+		return true
+	}
+	return false
+}
+
+// permitted policy: admins could do anything, support could read everyone along with the history of changes,
+// create and update everyone except emails of other users, users could read, update and export only themselves.
+// Roles are assigned, users are deleted, restored and erased by admins only
+func (a access) permitted(c *Caller) bool {
+	if c == nil {
+		return false
+	}
+	switch c.Role {
+	case RoleAdmin:
+		return true
+	case RoleSupport:
+		switch a.op {
+		case OpGetUser, OpListUsers, OpGetHistory:
+			return true
+		case OpCreateUser:
+			return !a.role
+		case OpUpdateUser:
+			return !a.role && (!a.email || a.target == c.Subject)
+		}
+	case RoleSelf:
+		own := a.target != "" && a.target == c.Subject
+		switch a.op {
+		case OpGetUser:
+			return own
+		case OpUpdateUser:
+			return own && !a.role
+		case OpExportUser:
+			return own
+		}
+	}
+	return false
+}
+
+// authorize checks caller from the context against the policy, does nothing if authorization is disabled
+func (s Users) authorize(ctx context.Context, a access) error {
+	if !s.authorization {
+		return nil
+	}
+	if c := CallerFrom(ctx); !a.permitted(c) {
+		s.log.WithField("component", "service").Debugf("%s of '%s' denied for %+v", a.op, a.target, c)
+		return ErrPermissionDenied
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccess_Permitted(t *testing.T) {
+	t.Parallel()
+	admin := &Caller{Subject: "admin-id", Role: RoleAdmin}
+	support := &Caller{Subject: "support-id", Role: RoleSupport}
+	self := &Caller{Subject: "user-id", Role: RoleSelf}
+	noRole := &Caller{Subject: "ops"}
+
+	tests := map[string]struct {
+		caller *Caller
+		access access
+		want   bool
+	}{
+		"anonymous denied":                {nil, access{op: OpListUsers}, false},
+		"caller without role denied":      {noRole, access{op: OpGetUser, target: "user-id"}, false},
+		"admin deletes":                   {admin, access{op: OpDeleteUser, target: "user-id"}, true},
+		"admin changes email of others":   {admin, access{op: OpUpdateUser, target: "user-id", email: true}, true},
+		"admin assigns role":              {admin, access{op: OpUpdateUser, target: "user-id", role: true}, true},
+		"support lists":                   {support, access{op: OpListUsers}, true},
+		"support creates":                 {support, access{op: OpCreateUser}, true},
+		"support creates admin":           {support, access{op: OpCreateUser, role: true}, false},
+		"support updates others":          {support, access{op: OpUpdateUser, target: "user-id"}, true},
+		"support changes email of others": {support, access{op: OpUpdateUser, target: "user-id", email: true}, false},
+		"support changes own email":       {support, access{op: OpUpdateUser, target: "support-id", email: true}, true},
+		"support deletes":                 {support, access{op: OpDeleteUser, target: "user-id"}, false},
+		"self reads itself":               {self, access{op: OpGetUser, target: "user-id"}, true},
+		"self reads others":               {self, access{op: OpGetUser, target: "other-id"}, false},
+		"self reads unknown":              {self, access{op: OpGetUser}, false},
+		"self updates itself":             {self, access{op: OpUpdateUser, target: "user-id", email: true}, true},
+		"self updates others":             {self, access{op: OpUpdateUser, target: "other-id"}, false},
+		"self assigns role":               {self, access{op: OpUpdateUser, target: "user-id", role: true}, false},
+		"self lists":                      {self, access{op: OpListUsers}, false},
+		"self creates":                    {self, access{op: OpCreateUser}, false},
+		"self deletes itself":             {self, access{op: OpDeleteUser, target: "user-id"}, false},
+		"admin restores":                  {admin, access{op: OpRestoreUser, target: "user-id"}, true},
+		"support restores":                {support, access{op: OpRestoreUser, target: "user-id"}, false},
+		"self restores itself":            {self, access{op: OpRestoreUser, target: "user-id"}, false},
+		"support reads history":           {support, access{op: OpGetHistory, target: "user-id"}, true},
+		"self reads own history":          {self, access{op: OpGetHistory, target: "user-id"}, false},
+		"admin erases":                    {admin, access{op: OpEraseUser, target: "user-id"}, true},
+		"support erases":                  {support, access{op: OpEraseUser, target: "user-id"}, false},
+		"self erases itself":              {self, access{op: OpEraseUser, target: "user-id"}, false},
+		"support exports":                 {support, access{op: OpExportUser, target: "user-id"}, false},
+		"self exports itself":             {self, access{op: OpExportUser, target: "user-id"}, true},
+		"self exports others":             {self, access{op: OpExportUser, target: "other-id"}, false},
+	}
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.access.permitted(tt.caller))
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/BorisRostovskiy/ESL/internal/clients"
+	"github.com/BorisRostovskiy/ESL/internal/events"
+	"github.com/BorisRostovskiy/ESL/internal/repository"
+)
+
+type (
+	// PrivacyRepo define data subject requests repository interface
+	PrivacyRepo interface {
+		// UserRecord returns the user as it is stored, deleted and erased users included
+		UserRecord(ctx context.Context, userID string) (*UserRecord, error)
+		// UserNotifications returns every outbox event of the user ordered by id, delivered ones included
+		UserNotifications(ctx context.Context, userID string) ([]Notification, error)
+		// EraseUser clears personal data and password of the user and revokes its refresh tokens, the user is
+		// deleted unless it is already. Erased users are neither restored nor purged, so that their ID is kept.
+		// NoUsersFoundError is returned if the user is erased already
+		EraseUser(ctx context.Context, userID string) error
+		// UpdateNotificationPayload replaces payload of the outbox event
+		UpdateNotificationPayload(ctx context.Context, id int64, payload string) error
+		// UpdateAuditChanges replaces changes of the audit entry
+		UpdateAuditChanges(ctx context.Context, id int64, changes []events.Change) error
+	}
+
+	// UserRecord user as it is stored, password is never included
+	UserRecord struct {
+		User
+		// DeletedAt nil unless the user is deleted
+		DeletedAt *time.Time
+		// ErasedAt nil unless personal data of the user is erased
+		ErasedAt *time.Time
+	}
+
+	// Notification outbox event along with its delivery state
+	Notification struct {
+		OutboxEvent
+		DeliveredAt *time.Time
+		FailedAt    *time.Time
+		LastError   string
+	}
+
+	// UserExport everything stored about the user, answer to the data subject access request
+	UserExport struct {
+		User UserRecord
+		// History changes of the user newest first, empty if audit trail is disabled
+		History       []AuditEntry
+		Notifications []Notification
+		ExportedAt    time.Time
+	}
+)
+
+// WithPrivacy enables data subject export and erasure requests
+func WithPrivacy(repo PrivacyRepo) Option {
+	return func(s *Users) {
+		s.privacy = repo
+	}
+}
+
+// ExportUser returns everything stored about the user, deleted and erased users included
+func (s Users) ExportUser(ctx context.Context, id string) (*UserExport, error) {
+	if err := s.authorize(ctx, access{op: OpExportUser, target: id}); err != nil {
+		return nil, err
+	}
+	if s.privacy == nil {
+		return nil, ErrPrivacyDisabled
+	}
+
+	export := &UserExport{ExportedAt: time.Now().UTC()}
+	err := s.inTx(ctx, func(ctx context.Context) error {
+		record, err := s.privacy.UserRecord(ctx, id)
+		if err != nil {
+			return err
+		}
+		record.Password = ""
+		export.User = *record
+		if export.History, err = s.history(ctx, id); err != nil {
+			return err
+		}
+		export.Notifications, err = s.privacy.UserNotifications(ctx, id)
+		return err
+	})
+	if err != nil {
+		s.log.WithField("component", "service").Debug(err)
+		if errors.Is(err, repository.NoUsersFoundError) {
+			return nil, ErrUserNotFound
+		}
+		return nil, ErrInternal
+	}
+	return export, nil
+}
+
+// EraseUser irreversibly erases personal data of the user, deleted users included, while its ID is kept.
+// The user is deleted for good, its personal data is redacted from the history and the stored events,
+// subscribers are told to erase it as well. Erasing erased user does nothing
+func (s Users) EraseUser(ctx context.Context, id string) error {
+	if err := s.authorize(ctx, access{op: OpEraseUser, target: id}); err != nil {
+		return err
+	}
+	if s.privacy == nil {
+		return ErrPrivacyDisabled
+	}
+
+	err := s.inTx(ctx, func(ctx context.Context) error {
+		record, err := s.privacy.UserRecord(ctx, id)
+		if err != nil {
+			return err
+		}
+		if record.ErasedAt != nil {
+			return nil
+		}
+		if err = s.privacy.EraseUser(ctx, id); err != nil {
+			return err
+		}
+		if err = s.redactHistory(ctx, id); err != nil {
+			return err
+		}
+		if err = s.redactNotifications(ctx, id); err != nil {
+			return err
+		}
+		return s.publishErase(ctx, &record.User)
+	})
+	if err != nil {
+		s.log.WithField("component", "service").Debug(err)
+		if errors.Is(err, repository.NoUsersFoundError) {
+			return ErrUserNotFound
+		}
+		return ErrInternal
+	}
+	return nil
+}
+
+func (s Users) publishErase(ctx context.Context, erased *User) error {
+	values := map[string]string{
+		"first_name": erased.FirstName,
+		"last_name":  erased.LastName,
+		"nickname":   erased.NickName,
+		"email":      erased.Email,
+	}
+	fields := make([]string, 0, len(events.PersonalFields))
+	diff := make([]events.Change, 0, len(events.PersonalFields))
+	for _, f := range events.PersonalFields {
+		if values[f] == "" {
+			continue
+		}
+		fields = append(fields, f)
+		diff = append(diff, events.Change{Field: f, Redacted: true})
+	}
+	if err := s.recordChanges(ctx, AuditErased, erased.ID, diff); err != nil {
+		return err
+	}
+	e := events.NewUserErased(actor(ctx), erased.ID, fields)
+	e.Country = erased.Country
+	// subscribers dropping deleted users are the ones to erase them
+	return s.publish(ctx, clients.ChannelDelete, e)
+}
+
+// history returns every audit entry of the user newest first, nothing if audit trail is disabled
+func (s Users) history(ctx context.Context, userID string) ([]AuditEntry, error) {
+	entries := make([]AuditEntry, 0)
+	if s.audit == nil {
+		return entries, nil
+	}
+	var before int64
+	for {
+		page, err := s.audit.UserHistory(ctx, userID, before, MaxHistoryLimit)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, page...)
+		if len(page) < MaxHistoryLimit {
+			return entries, nil
+		}
+		before = page[len(page)-1].ID
+	}
+}
+
+// redactHistory removes personal data from the changes recorded in the audit trail
+func (s Users) redactHistory(ctx context.Context, userID string) error {
+	entries, err := s.history(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !events.RedactChanges(e.Changes, events.PersonalFields) {
+			continue
+		}
+		if err = s.privacy.UpdateAuditChanges(ctx, e.ID, e.Changes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redactNotifications removes personal data from the stored events, pending ones are delivered redacted
+func (s Users) redactNotifications(ctx context.Context, userID string) error {
+	notifications, err := s.privacy.UserNotifications(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, n := range notifications {
+		var e events.Event
+		if err = json.Unmarshal([]byte(n.Payload), &e); err != nil {
+			return fmt.Errorf("malformed payload of outbox event %d: %w", n.ID, err)
+		}
+		if !e.Redact(events.PersonalFields) {
+			continue
+		}
+		payload, err := json.Marshal(&e)
+		if err != nil {
+			return fmt.Errorf("could not marshal event: %w", err)
+		}
+		if err = s.privacy.UpdateNotificationPayload(ctx, n.ID, string(payload)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
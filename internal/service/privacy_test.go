@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/BorisRostovskiy/ESL/internal/clients"
+	"github.com/BorisRostovskiy/ESL/internal/events"
+	"github.com/BorisRostovskiy/ESL/internal/repository"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestUsers_EraseUser(t *testing.T) {
+	t.Parallel()
+	user := User{ID: "user1", FirstName: "User", LastName: "One", NickName: "user1", Email: "user1@gmail.com", Country: "NL"}
+	created := outboxEvent(t, 1, clients.ChannelCreate, events.NewUserCreated(nil, eventUser(&user)))
+	moved := outboxEvent(t, 2, clients.ChannelUpdate, events.NewUserUpdated(nil, "user1",
+		[]events.Change{{Field: "country", Old: "DE", New: "NL"}}))
+	erasedAt := time.Now()
+
+	tests := map[string]struct {
+		prepare func(p *MockPrivacyRepo, a *MockAuditRepo, n *clients.MockChannelNotificator)
+		err     error
+	}{
+		"personal data is erased everywhere": {
+			prepare: func(p *MockPrivacyRepo, a *MockAuditRepo, n *clients.MockChannelNotificator) {
+				p.EXPECT().UserRecord(gomock.Any(), "user1").Return(&UserRecord{User: user}, nil)
+				p.EXPECT().EraseUser(gomock.Any(), "user1").Return(nil)
+				a.EXPECT().UserHistory(gomock.Any(), "user1", int64(0), MaxHistoryLimit).Return([]AuditEntry{
+					{ID: 2, Changes: []events.Change{{Field: "email", Old: "user0@gmail.com", New: "user1@gmail.com"}}},
+					{ID: 1, Changes: []events.Change{{Field: "country", Old: "DE", New: "NL"}}},
+				}, nil)
+				p.EXPECT().UpdateAuditChanges(gomock.Any(), int64(2), []events.Change{{Field: "email", Redacted: true}})
+				p.EXPECT().UserNotifications(gomock.Any(), "user1").
+					Return([]Notification{{OutboxEvent: created}, {OutboxEvent: moved}}, nil)
+				p.EXPECT().UpdateNotificationPayload(gomock.Any(), int64(1), gomock.Any()).
+					DoAndReturn(func(_ context.Context, _ int64, payload string) error {
+						var e events.Event
+						require.NoError(t, json.Unmarshal([]byte(payload), &e))
+						assert.Equal(t, events.User{ID: "user1", Country: "NL", CreatedAt: e.Created.User.CreatedAt}, e.Created.User)
+						assert.NotContains(t, payload, "user1@gmail.com")
+						return nil
+					})
+				a.EXPECT().AddAuditEntry(gomock.Any(), gomock.Cond(func(e *AuditEntry) bool {
+					return e.Action == AuditErased && len(e.Changes) == 4 && e.Changes[3] == events.Change{Field: "email", Redacted: true}
+				}))
+				n.EXPECT().Notify(gomock.Any(), clients.ChannelDelete, gomock.Cond(func(e *events.Event) bool {
+					return e.Type == events.TypeUserErased && e.Country == "NL" &&
+						slices.Equal(events.PersonalFields, e.Erased.Fields)
+				}))
+			},
+		},
+		"erased user is not erased again": {
+			prepare: func(p *MockPrivacyRepo, a *MockAuditRepo, n *clients.MockChannelNotificator) {
+				p.EXPECT().UserRecord(gomock.Any(), "user1").Return(&UserRecord{User: User{ID: "user1"}, ErasedAt: &erasedAt}, nil)
+			},
+		},
+		"unknown user": {
+			prepare: func(p *MockPrivacyRepo, a *MockAuditRepo, n *clients.MockChannelNotificator) {
+				p.EXPECT().UserRecord(gomock.Any(), "user1").Return(nil, repository.NoUsersFoundError)
+			},
+			err: ErrUserNotFound,
+		},
+		"repo error": {
+			prepare: func(p *MockPrivacyRepo, a *MockAuditRepo, n *clients.MockChannelNotificator) {
+				p.EXPECT().UserRecord(gomock.Any(), "user1").Return(&UserRecord{User: user}, nil)
+				p.EXPECT().EraseUser(gomock.Any(), "user1").Return(assert.AnError)
+			},
+			err: ErrInternal,
+		},
+	}
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			ctrl := gomock.NewController(t)
+			privacy := NewMockPrivacyRepo(ctrl)
+			audit := NewMockAuditRepo(ctrl)
+			n := clients.NewMockChannelNotificator(ctrl)
+			tt.prepare(privacy, audit, n)
+
+			s := New(NewMockUserRepo(ctrl), logrus.New(), n, WithAudit(audit), WithPrivacy(privacy))
+			assert.ErrorIs(t, s.EraseUser(context.Background(), "user1"), tt.err)
+		})
+	}
+
+	t.Run("privacy is not configured", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		s := New(NewMockUserRepo(ctrl), logrus.New(), clients.NewMockChannelNotificator(ctrl))
+		assert.ErrorIs(t, s.EraseUser(context.Background(), "user1"), ErrPrivacyDisabled)
+		_, err := s.ExportUser(context.Background(), "user1")
+		assert.ErrorIs(t, err, ErrPrivacyDisabled)
+	})
+}
+
+func TestUsers_ExportUser(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	privacy := NewMockPrivacyRepo(ctrl)
+	audit := NewMockAuditRepo(ctrl)
+	s := New(NewMockUserRepo(ctrl), logrus.New(), clients.NewMockChannelNotificator(ctrl),
+		WithAudit(audit), WithPrivacy(privacy), WithAuthorization())
+
+	deletedAt := time.Now()
+	firstPage := make([]AuditEntry, MaxHistoryLimit)
+	for i := range firstPage {
+		firstPage[i] = AuditEntry{ID: int64(MaxHistoryLimit - i + 1)}
+	}
+	privacy.EXPECT().UserRecord(gomock.Any(), "user1").
+		Return(&UserRecord{User: User{ID: "user1", Password: "hash"}, DeletedAt: &deletedAt}, nil)
+	audit.EXPECT().UserHistory(gomock.Any(), "user1", int64(0), MaxHistoryLimit).Return(firstPage, nil)
+	audit.EXPECT().UserHistory(gomock.Any(), "user1", int64(2), MaxHistoryLimit).Return([]AuditEntry{{ID: 1}}, nil)
+	privacy.EXPECT().UserNotifications(gomock.Any(), "user1").Return([]Notification{{OutboxEvent: OutboxEvent{ID: 1}}}, nil)
+
+	ctx := WithCaller(context.Background(), &Caller{Subject: "user1", Role: RoleSelf})
+	export, err := s.ExportUser(ctx, "user1")
+	require.NoError(t, err)
+	assert.Empty(t, export.User.Password, "password hash is never exported")
+	assert.Equal(t, &deletedAt, export.User.DeletedAt)
+	assert.Len(t, export.History, MaxHistoryLimit+1, "the whole history is exported")
+	assert.Len(t, export.Notifications, 1)
+
+	ctx = WithCaller(context.Background(), &Caller{Subject: "user2", Role: RoleSelf})
+	_, err = s.ExportUser(ctx, "user1")
+	assert.ErrorIs(t, err, ErrPermissionDenied)
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultPurgeRetention = 30 * 24 * time.Hour
+	defaultPurgeInterval  = time.Hour
+	defaultPurgeBatchSize = 500
+)
+
+type (
+	// PurgeRepo define deleted users purge repository interface
+	PurgeRepo interface {
+		// PurgeUsers hard-deletes up to limit users soft-deleted before the given time, returns number of purged users
+		PurgeUsers(ctx context.Context, deletedBefore time.Time, limit int) (int, error)
+	}
+
+	// PurgeConfig deleted users purge settings
+	PurgeConfig struct {
+		// Retention time deleted users could be restored within, they are purged afterward
+		Retention time.Duration `yaml:"retention"`
+		Interval  time.Duration `yaml:"interval"`
+		BatchSize int           `yaml:"batch_size"`
+	}
+
+	// Purger hard-deletes users deleted longer than the retention period ago
+	Purger struct {
+		cfg  PurgeConfig
+		repo PurgeRepo
+		log  logrus.FieldLogger
+	}
+)
+
+// NewPurger creates purger, Run starts it
+func NewPurger(cfg PurgeConfig, repo PurgeRepo, log *logrus.Logger) *Purger {
+	if cfg.Retention <= 0 {
+		cfg.Retention = defaultPurgeRetention
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultPurgeInterval
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultPurgeBatchSize
+	}
+	return &Purger{
+		cfg:  cfg,
+		repo: repo,
+		log:  log.WithField("component", "purger"),
+	}
+}
+
+// Run purges users until ctx is done, full batches are followed by the next one immediately
+func (p *Purger) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		n, err := p.Purge(ctx)
+		if err != nil && ctx.Err() == nil {
+			p.log.Errorf("could not purge users: %v", err)
+		}
+		if n > 0 {
+			p.log.Infof("%d deleted user(s) purged", n)
+		}
+		if n == p.cfg.BatchSize {
+			timer.Reset(0)
+		} else {
+			timer.Reset(p.cfg.Interval)
+		}
+	}
+}
+
+// Purge hard-deletes a single batch of users deleted before the retention period, returns number of purged users
+func (p *Purger) Purge(ctx context.Context) (int, error) {
+	n, err := p.repo.PurgeUsers(ctx, time.Now().Add(-p.cfg.Retention), p.cfg.BatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("purge users: %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestPurger_Purge(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	repo := NewMockPurgeRepo(ctrl)
+	p := NewPurger(PurgeConfig{Retention: time.Hour, BatchSize: 10}, repo, logrus.New())
+
+	repo.EXPECT().PurgeUsers(gomock.Any(), gomock.Any(), 10).
+		DoAndReturn(func(_ context.Context, before time.Time, _ int) (int, error) {
+			assert.WithinDuration(t, time.Now().Add(-time.Hour), before, time.Second)
+			return 3, nil
+		})
+	n, err := p.Purge(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	repo.EXPECT().PurgeUsers(gomock.Any(), gomock.Any(), 10).Return(0, errors.New("unavailable"))
+	_, err = p.Purge(context.Background())
+	assert.EqualError(t, err, "purge users: unavailable")
+}
+
+func TestPurger_Run(t *testing.T) {
+	t.Parallel()
+	ctrl := gomock.NewController(t)
+	repo := NewMockPurgeRepo(ctrl)
+	p := NewPurger(PurgeConfig{Interval: time.Hour, BatchSize: 2}, repo, logrus.New())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	// full batch is followed by the next one right away, the partial one waits for the interval
+	gomock.InOrder(
+		repo.EXPECT().PurgeUsers(gomock.Any(), gomock.Any(), 2).Return(2, nil),
+		repo.EXPECT().PurgeUsers(gomock.Any(), gomock.Any(), 2).DoAndReturn(
+			func(context.Context, time.Time, int) (int, error) {
+				cancel()
+				return 1, nil
+			}),
+	)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.Run(ctx)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("purger is not stopped")
+	}
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/BorisRostovskiy/ESL/internal/repository"
+	"github.com/BorisRostovskiy/ESL/internal/tokens"
+	"github.com/google/uuid"
+)
+
+type (
+	// Session authenticated user along with issued tokens, tokens are nil if issuing is disabled
+	Session struct {
+		User   *User
+		Tokens *TokenPair
+	}
+
+	// TokenPair signed access token and opaque refresh token
+	TokenPair struct {
+		AccessToken      string
+		AccessExpiresAt  time.Time
+		RefreshToken     string
+		RefreshExpiresAt time.Time
+	}
+
+	// RefreshToken stored refresh token, plain token value is never stored
+	RefreshToken struct {
+		Hash      string
+		UserID    string
+		FamilyID  string
+		CreatedAt time.Time
+		ExpiresAt time.Time
+	}
+)
+
+// Refresh rotates refresh token issuing a new token pair, every refresh token could be used only once
+func (s Users) Refresh(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	if s.tokens == nil {
+		return nil, ErrTokensDisabled
+	}
+
+	now := time.Now().UTC()
+	plain, hash, err := tokens.NewRefreshToken()
+	if err != nil {
+		s.log.WithField("component", "service").Error(err)
+		return nil, ErrInternal
+	}
+	next := &RefreshToken{Hash: hash, CreatedAt: now, ExpiresAt: now.Add(s.tokens.RefreshTTL())}
+
+	old, err := s.tokenRepo.RotateRefreshToken(ctx, tokens.HashRefreshToken(refreshToken), next)
+	if err != nil {
+		s.log.WithField("component", "service").Debug(err)
+		if errors.Is(err, repository.TokenNotFoundError) || errors.Is(err, repository.TokenReusedError) {
+			return nil, ErrInvalidRefresh
+		}
+		return nil, ErrInternal
+	}
+
+	// user could be deleted in the meantime, role could be changed as well
+	user, err := s.repo.GetUser(ctx, old.UserID)
+	if err != nil {
+		s.log.WithField("component", "service").Debug(err)
+		if errors.Is(err, repository.NoUsersFoundError) {
+			return nil, ErrInvalidRefresh
+		}
+		return nil, ErrInternal
+	}
+
+	access, accessExp, err := s.tokens.Sign(user.ID, user.Role, now)
+	if err != nil {
+		s.log.WithField("component", "service").Error(err)
+		return nil, ErrInternal
+	}
+	return &TokenPair{
+		AccessToken:      access,
+		AccessExpiresAt:  accessExp,
+		RefreshToken:     plain,
+		RefreshExpiresAt: next.ExpiresAt,
+	}, nil
+}
+
+// issueTokens issues new token pair starting a new refresh token family
+func (s Users) issueTokens(ctx context.Context, user *User) (*TokenPair, error) {
+	now := time.Now().UTC()
+	access, accessExp, err := s.tokens.Sign(user.ID, user.Role, now)
+	if err != nil {
+		s.log.WithField("component", "service").Error(err)
+		return nil, ErrInternal
+	}
+
+	plain, hash, err := tokens.NewRefreshToken()
+	if err != nil {
+		s.log.WithField("component", "service").Error(err)
+		return nil, ErrInternal
+	}
+	rt := &RefreshToken{
+		Hash:      hash,
+		UserID:    user.ID,
+		FamilyID:  uuid.New().String(),
+		CreatedAt: now,
+		ExpiresAt: now.Add(s.tokens.RefreshTTL()),
+	}
+	if err = s.tokenRepo.CreateRefreshToken(ctx, rt); err != nil {
+		s.log.WithField("component", "service").Debug(err)
+		return nil, ErrInternal
+	}
+
+	return &TokenPair{
+		AccessToken:      access,
+		AccessExpiresAt:  accessExp,
+		RefreshToken:     plain,
+		RefreshExpiresAt: rt.ExpiresAt,
+	}, nil
+}
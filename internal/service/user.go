@@ -21,8 +21,11 @@ type User struct {
 	Password  string
 	Email     string
 	Country   string
+	Role      string
 	CreatedAt time.Time
 	UpdatedAt time.Time
+	// Version incremented by every update. Set on update, the update applies to that very version only
+	Version int64
 }
 
 // WithID add ID to user
@@ -66,6 +69,17 @@ func (u *User) WithCountry(c string) *User {
 	return u
 }
 
+func (u *User) WithRole(r string) *User {
+	u.Role = r
+	return u
+}
+
+// WithVersion set the version the update applies to
+func (u *User) WithVersion(v int64) *User {
+	u.Version = v
+	return u
+}
+
 func (u *User) WithCreateAt(c time.Time) *User {
 	u.CreatedAt = c
 	return u
@@ -87,6 +101,9 @@ func (u *User) Validate(passwordOkEmpty bool) error {
 	if u.Password == "" && !passwordOkEmpty {
 		return fmt.Errorf("empty password")
 	}
+	if u.Role != "" && !ValidRole(u.Role) {
+		return fmt.Errorf("unknown role")
+	}
 
 	if u.NickName != "" && !validateNickname(u.NickName, 1, 32) {
 		return fmt.Errorf("invalid nickname")
@@ -2,12 +2,16 @@ package service
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/BorisRostovskiy/ESL/internal/clients"
+	"github.com/BorisRostovskiy/ESL/internal/events"
 	"github.com/BorisRostovskiy/ESL/internal/repository"
+	"github.com/BorisRostovskiy/ESL/internal/tokens"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -16,24 +20,99 @@ import (
 type UserRepo interface {
 	TestConnection(ctx context.Context) error
 	GetUser(ctx context.Context, userId string) (*User, error)
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	GetUserByNickname(ctx context.Context, nickname string) (*User, error)
+	GetCredentials(ctx context.Context, login string) (*User, error)
 	CreateUser(ctx context.Context, in *User) (*User, error)
-	ListUsers(ctx context.Context, limit, offset int, filter *Filter) ([]User, error)
+	// CreateUsers creates users at once, users conflicting with the existing ones or earlier users of the batch
+	// are skipped. Returns which users are created, nothing is created if atomic and some are skipped
+	CreateUsers(ctx context.Context, in []*User, atomic bool) ([]bool, error)
+	// UpdateUsers updates all the users or none of them, returns index of the user failed the batch, -1 if none
+	UpdateUsers(ctx context.Context, in []*User) (int, error)
+	// DeleteUsers soft-deletes users at once, returns the deleted ones. Nothing is deleted if atomic and some
+	// are not found, the found ones are returned along with the error then
+	DeleteUsers(ctx context.Context, ids []string, atomic bool) ([]User, error)
+	// ListUsers returns up to q.Limit users following q.After in q.Sort order, ties broken by id
+	ListUsers(ctx context.Context, q ListQuery) ([]User, error)
+	// StreamUsers calls fn for every user matching q.Filter in q.Sort order, from a single consistent snapshot.
+	// Limit and After are not used, streaming stops at the first fn error which is returned
+	StreamUsers(ctx context.Context, q ListQuery, fn func(u *User) error) error
+	// CountUsers counts users matching the filter, in total and per country
+	CountUsers(ctx context.Context, f *Filter) (*Totals, error)
+	// UpdateUser updates the user if it is still of in.Version, regardless of the version if it is 0.
+	// in.Version is incremented along with the stored one, VersionMismatchError is returned if it is outdated
 	UpdateUser(ctx context.Context, in *User) error
+	// DeleteUser soft-deletes the user, it is not found by anything but RestoreUser until it is purged
 	DeleteUser(ctx context.Context, userId string) error
+	// RestoreUser restores soft-deleted user, DuplicateKeyError is returned if its email or nickname
+	// are taken meanwhile
+	RestoreUser(ctx context.Context, userId string) (*User, error)
+	// TakenLogins looks up which of the emails and nicknames are taken by the existing users, deleted users
+	// are not. Unlike ListUsers, it is not limited to the filterable fields
+	TakenLogins(ctx context.Context, emails, nicknames []string) (*Logins, error)
 }
 
+// TokenRepo define refresh tokens repository interface
+type TokenRepo interface {
+	CreateRefreshToken(ctx context.Context, token *RefreshToken) error
+	// RotateRefreshToken revokes token with the given hash and stores the next one within the same family,
+	// returns the revoked token
+	RotateRefreshToken(ctx context.Context, hash string, next *RefreshToken) (*RefreshToken, error)
+}
+
+// dummyHash is compared against when login is unknown, so that
+// failure paths take the same time regardless of the user existence
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), repository.PasswordCost)
+
 type Users struct {
 	repo   UserRepo
 	log    *logrus.Logger
 	notify clients.ChannelNotificator
+
+	tokens    *tokens.Issuer
+	tokenRepo TokenRepo
+
+	authorization bool
+	filters       Filters
+	sorts         SortFields
+
+	tx     Transactor
+	outbox OutboxRepo
+	audit  AuditRepo
+
+	privacy PrivacyRepo
+
+	watch *WatchHub
+}
+
+// Option optional Users dependency
+type Option func(s *Users)
+
+// WithTokens enables access/refresh tokens issuing on successful authentication
+func WithTokens(issuer *tokens.Issuer, repo TokenRepo) Option {
+	return func(s *Users) {
+		s.tokens = issuer
+		s.tokenRepo = repo
+	}
 }
 
-func New(repo UserRepo, log *logrus.Logger, n clients.ChannelNotificator) *Users {
-	return &Users{
+// WithAuthorization enforces roles policy on user operations, caller is taken from the context
+func WithAuthorization() Option {
+	return func(s *Users) {
+		s.authorization = true
+	}
+}
+
+func New(repo UserRepo, log *logrus.Logger, n clients.ChannelNotificator, opts ...Option) *Users {
+	s := &Users{
 		repo:   repo,
 		log:    log,
 		notify: n,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 // HealthCheck provide simple check of db status
@@ -44,7 +123,20 @@ func (s Users) HealthCheck(ctx context.Context) error {
 }
 
 func (s Users) CreateUser(ctx context.Context, in *User) (*User, error) {
-	user, err := s.repo.CreateUser(ctx, in)
+	if err := s.authorize(ctx, access{op: OpCreateUser, role: in.Role != "" && in.Role != RoleSelf}); err != nil {
+		return nil, err
+	}
+	if in.Role == "" {
+		in.Role = RoleSelf
+	}
+	var user *User
+	err := s.inTx(ctx, func(ctx context.Context) error {
+		var err error
+		if user, err = s.repo.CreateUser(ctx, in); err != nil {
+			return err
+		}
+		return s.publishCreate(ctx, user)
+	})
 
 	if err != nil {
 		s.log.WithField("component", "service").Debug(err)
@@ -53,30 +145,182 @@ func (s Users) CreateUser(ctx context.Context, in *User) (*User, error) {
 		}
 		return nil, ErrInternal
 	}
-	ctx, cancel := context.WithTimeout(ctx, time.Second*1)
-	defer cancel()
-	_ = s.notify.Notify(ctx, clients.ChannelCreate, fmt.Sprintf("user with ID=%s has been created", user.ID))
 	in.ID = user.ID
 	in.CreatedAt = user.CreatedAt
+	in.Version = user.Version
 	return in, nil
 }
 
-func (s Users) ListUsers(ctx context.Context, limit, offset int, filter *Filter) ([]User, error) {
-	users, err := s.repo.ListUsers(ctx, limit, offset, filter)
+// GetUser returns single user by ID
+func (s Users) GetUser(ctx context.Context, id string) (*User, error) {
+	user, err := s.lookupResult(s.repo.GetUser(ctx, id))
+	return s.authorizeLookup(ctx, user, err)
+}
+
+// GetUserByEmail returns single user by email
+func (s Users) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	user, err := s.lookupResult(s.repo.GetUserByEmail(ctx, strings.ToLower(email)))
+	return s.authorizeLookup(ctx, user, err)
+}
+
+// GetUserByNickname returns single user by nickname
+func (s Users) GetUserByNickname(ctx context.Context, nickname string) (*User, error) {
+	user, err := s.lookupResult(s.repo.GetUserByNickname(ctx, nickname))
+	return s.authorizeLookup(ctx, user, err)
+}
+
+// Authenticate verifies user credentials, login is either email or nickname.
+// Access and refresh tokens are issued along if tokens are enabled
+func (s Users) Authenticate(ctx context.Context, login, password string) (*Session, error) {
+	user, err := s.repo.GetCredentials(ctx, login)
+	hash := dummyHash
+	switch {
+	case err == nil:
+		hash = []byte(user.Password)
+	case !errors.Is(err, repository.NoUsersFoundError):
+		s.log.WithField("component", "service").Debug(err)
+		return nil, ErrInternal
+	}
+
+	if cmpErr := bcrypt.CompareHashAndPassword(hash, []byte(password)); cmpErr != nil || err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	user.Password = ""
+
+	session := &Session{User: user}
+	if s.tokens != nil {
+		if session.Tokens, err = s.issueTokens(ctx, user); err != nil {
+			return nil, err
+		}
+	}
+	return session, nil
+}
+
+// authorizeLookup checks access to the found user, callers allowed to read only themselves
+// get permission denied for unknown users too, so that they could not probe for existence
+func (s Users) authorizeLookup(ctx context.Context, user *User, err error) (*User, error) {
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		return nil, err
+	}
+	target := ""
+	if err == nil {
+		target = user.ID
+	}
+	if authErr := s.authorize(ctx, access{op: OpGetUser, target: target}); authErr != nil {
+		return nil, authErr
+	}
+	return user, err
+}
+
+func (s Users) lookupResult(user *User, err error) (*User, error) {
 	if err != nil {
+		s.log.WithField("component", "service").Debug(err)
+		if errors.Is(err, repository.NoUsersFoundError) {
+			return nil, ErrUserNotFound
+		}
+		return nil, ErrInternal
+	}
+	// hashed password never leaves the service
+	user.Password = ""
+	return user, nil
+}
+
+// ListUsers returns page of users, one more user is fetched to know whether the next page exists
+func (s Users) ListUsers(ctx context.Context, q ListQuery) (*UsersPage, error) {
+	if err := s.authorize(ctx, access{op: OpListUsers}); err != nil {
 		return nil, err
 	}
-	return users, nil
+	if err := s.sorts.Validate(q.Sort); err != nil {
+		return nil, &Error{Code: ErrCodeBadRequest, Message: err.Error()}
+	}
+	limit := q.Limit
+	if limit > 0 {
+		q.Limit++
+	}
+	users, err := s.repo.ListUsers(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &UsersPage{Users: users}
+	if limit > 0 && len(users) > limit {
+		page.Users = users[:limit]
+		page.Next = q.Sort.CursorOf(&page.Users[limit-1])
+	}
+	if q.IncludeTotal {
+		if page.Totals, err = s.repo.CountUsers(ctx, q.Filter); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+// StreamUsers calls fn for every user matching the query, see UserRepo.StreamUsers
+func (s Users) StreamUsers(ctx context.Context, q ListQuery, fn func(u *User) error) error {
+	if err := s.authorize(ctx, access{op: OpListUsers}); err != nil {
+		return err
+	}
+	if err := s.sorts.Validate(q.Sort); err != nil {
+		return &Error{Code: ErrCodeBadRequest, Message: err.Error()}
+	}
+	return s.repo.StreamUsers(ctx, q, fn)
+}
+
+// WatchUsers calls fn for every user change matching the query as it happens, see WatchHub.Watch
+func (s Users) WatchUsers(ctx context.Context, q WatchQuery, fn func(e *events.Event) error) error {
+	if err := s.authorize(ctx, access{op: OpListUsers}); err != nil {
+		return err
+	}
+	if s.watch == nil {
+		return ErrWatchDisabled
+	}
+	return s.watch.Watch(ctx, q, fn)
 }
 
 func (s Users) UpdateUser(ctx context.Context, updatedUser *User) error {
+	before, after, err := s.prepareUpdate(ctx, updatedUser)
+	if err != nil {
+		return err
+	}
+
+	err = s.inTx(ctx, func(ctx context.Context) error {
+		if err := s.repo.UpdateUser(ctx, after); err != nil {
+			return err
+		}
+		return s.publishUpdate(ctx, before, after)
+	})
+	if err != nil {
+		if errors.Is(err, repository.DuplicateKeyError) {
+			return ErrDuplicateKeyError
+		}
+		if errors.Is(err, repository.VersionMismatchError) {
+			return ErrVersionMismatch
+		}
+		return err
+	}
+	updatedUser.Version = after.Version
+	return nil
+}
+
+// prepareUpdate applies the update to the existing user, returns the user before and after it
+func (s Users) prepareUpdate(ctx context.Context, updatedUser *User) (*User, *User, error) {
+	// ownership is checked before the lookup, what is changed once the user is known
+	if err := s.authorize(ctx, access{op: OpUpdateUser, target: updatedUser.ID}); err != nil {
+		return nil, nil, err
+	}
 	existedUser, err := s.repo.GetUser(ctx, updatedUser.ID)
 	if err != nil {
 		if errors.Is(err, repository.NoUsersFoundError) {
-			return ErrUserNotFound
+			return nil, nil, ErrUserNotFound
 		}
-		return err
+		return nil, nil, err
+	}
+	// update of the outdated version is refused right away, the repository refuses the ones racing with others.
+	// The version read is updated otherwise, so that the changes are never applied over the concurrent ones
+	if v := updatedUser.Version; v != 0 && v != existedUser.Version {
+		return nil, nil, ErrVersionMismatch
 	}
+	before := *existedUser
 
 	updated := false
 	if fn := updatedUser.FirstName; fn != "" && fn != existedUser.FirstName {
@@ -87,7 +331,7 @@ func (s Users) UpdateUser(ctx context.Context, updatedUser *User) error {
 		existedUser.LastName = ln
 		updated = true
 	}
-	if nn := updatedUser.LastName; nn != "" && nn != existedUser.NickName {
+	if nn := updatedUser.NickName; nn != "" && nn != existedUser.NickName {
 		existedUser.NickName = nn
 		updated = true
 	}
@@ -101,45 +345,148 @@ func (s Users) UpdateUser(ctx context.Context, updatedUser *User) error {
 		updated = true
 	}
 	if pwd := updatedUser.Password; pwd != "" {
-		hashedPwd, err := bcrypt.GenerateFromPassword([]byte(pwd), 8)
+		hashedPwd, err := bcrypt.GenerateFromPassword([]byte(pwd), repository.PasswordCost)
 		if err != nil {
-			return fmt.Errorf("could not generate new hashed password for user: %w", err)
+			return nil, nil, fmt.Errorf("could not generate new hashed password for user: %w", err)
 		}
 		existedUser.Password = string(hashedPwd)
 		updated = true
 	}
 
+	if role := updatedUser.Role; role != "" && role != existedUser.Role {
+		existedUser.Role = role
+		updated = true
+	}
+
 	if !updated {
-		return ErrEmptyUpdateRequest
+		return nil, nil, ErrEmptyUpdateRequest
+	}
+
+	if err = s.authorize(ctx, access{
+		op:     OpUpdateUser,
+		target: existedUser.ID,
+		email:  existedUser.Email != before.Email,
+		role:   existedUser.Role != before.Role,
+	}); err != nil {
+		return nil, nil, err
 	}
 
 	if err = existedUser.Validate(true); err != nil {
-		return fmt.Errorf("updated user not valid: %v", err)
+		return nil, nil, fmt.Errorf("updated user not valid: %w", &Error{Code: ErrCodeBadRequest, Message: err.Error()})
 	}
+	return &before, existedUser, nil
+}
 
-	if err = s.repo.UpdateUser(ctx, existedUser); err != nil {
-		if errors.Is(err, repository.DuplicateKeyError) {
-			return ErrDuplicateKeyError
-		}
+// publishCreate publishes creation of the user and records it in the audit trail,
+// the other publish helpers do the same for the other changes
+func (s Users) publishCreate(ctx context.Context, created *User) error {
+	if err := s.record(ctx, AuditCreated, created.ID, nil, created); err != nil {
 		return err
 	}
-	ctx, cancel := context.WithTimeout(ctx, time.Second*1)
-	defer cancel()
-	_ = s.notify.Notify(ctx, clients.ChannelUpdate, fmt.Sprintf("user with ID=%s has been updated", existedUser.ID))
-	return nil
+	return s.publish(ctx, clients.ChannelCreate, events.NewUserCreated(actor(ctx), eventUser(created)))
+}
+
+func (s Users) publishUpdate(ctx context.Context, before, after *User) error {
+	if err := s.record(ctx, AuditUpdated, after.ID, before, after); err != nil {
+		return err
+	}
+	e := events.NewUserUpdated(actor(ctx), after.ID, changes(before, after))
+	e.Country = after.Country
+	return s.publish(ctx, clients.ChannelUpdate, e)
 }
 
 func (s Users) DeleteUser(ctx context.Context, id string) error {
-	err := s.repo.DeleteUser(ctx, id)
+	if err := s.authorize(ctx, access{op: OpDeleteUser, target: id}); err != nil {
+		return err
+	}
+	err := s.inTx(ctx, func(ctx context.Context) error {
+		// deleted user is looked up for the country the event is watched by
+		user, err := s.repo.GetUser(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err = s.repo.DeleteUser(ctx, id); err != nil {
+			return err
+		}
+		return s.publishDelete(ctx, user)
+	})
 	if err != nil {
 		if errors.Is(err, repository.NoUsersFoundError) {
 			return ErrUserNotFound
 		}
 		return err
 	}
+
+	return nil
+}
+
+// RestoreUser restores deleted user unless it is purged already, the restored user is returned
+func (s Users) RestoreUser(ctx context.Context, id string) (*User, error) {
+	if err := s.authorize(ctx, access{op: OpRestoreUser, target: id}); err != nil {
+		return nil, err
+	}
+	var user *User
+	err := s.inTx(ctx, func(ctx context.Context) error {
+		var err error
+		if user, err = s.repo.RestoreUser(ctx, id); err != nil {
+			return err
+		}
+		return s.publishRestore(ctx, user)
+	})
+	if err != nil {
+		s.log.WithField("component", "service").Debug(err)
+		switch {
+		case errors.Is(err, repository.NoUsersFoundError):
+			return nil, ErrUserNotFound
+		case errors.Is(err, repository.DuplicateKeyError):
+			return nil, ErrRestoreConflict
+		}
+		return nil, ErrInternal
+	}
+	user.Password = ""
+	return user, nil
+}
+
+func (s Users) publishDelete(ctx context.Context, deleted *User) error {
+	if err := s.record(ctx, AuditDeleted, deleted.ID, deleted, nil); err != nil {
+		return err
+	}
+	e := events.NewUserDeleted(actor(ctx), deleted.ID)
+	e.Country = deleted.Country
+	return s.publish(ctx, clients.ChannelDelete, e)
+}
+
+func (s Users) publishRestore(ctx context.Context, restored *User) error {
+	if err := s.record(ctx, AuditRestored, restored.ID, restored, restored); err != nil {
+		return err
+	}
+	// restored user is announced along with the created ones, subscribers may have dropped it already
+	return s.publish(ctx, clients.ChannelCreate, events.NewUserRestored(actor(ctx), eventUser(restored)))
+}
+
+// inTx runs fn in a single transaction if outbox is enabled, so that events are stored along with the change
+func (s Users) inTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if s.tx == nil {
+		return fn(ctx)
+	}
+	return s.tx.RunInTx(ctx, fn)
+}
+
+// publish stores event in the outbox if it is enabled, notifies directly otherwise.
+// Direct notification problems never fail the operation
+func (s Users) publish(ctx context.Context, channel clients.ChannelName, e *events.Event) error {
+	if s.outbox != nil {
+		payload, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("could not marshal event: %w", err)
+		}
+		return s.outbox.AddEvent(ctx, &OutboxEvent{UserID: e.UserID, Channel: string(channel), Payload: string(payload)})
+	}
+
 	ctx, cancel := context.WithTimeout(ctx, time.Second*1)
 	defer cancel()
-	_ = s.notify.Notify(ctx, clients.ChannelDelete, fmt.Sprintf("user with ID=%s has been deleted", id))
-
+	if err := s.notify.Notify(ctx, channel, e); err != nil {
+		s.log.WithField("component", "service").Warnf("could not send notification: %v", err)
+	}
 	return nil
 }
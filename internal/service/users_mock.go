@@ -40,6 +40,21 @@ func (m *MockUserRepo) EXPECT() *MockUserRepoMockRecorder {
 	return m.recorder
 }
 
+// CountUsers mocks base method.
+func (m *MockUserRepo) CountUsers(ctx context.Context, f *Filter) (*Totals, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CountUsers", ctx, f)
+	ret0, _ := ret[0].(*Totals)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CountUsers indicates an expected call of CountUsers.
+func (mr *MockUserRepoMockRecorder) CountUsers(ctx, f any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountUsers", reflect.TypeOf((*MockUserRepo)(nil).CountUsers), ctx, f)
+}
+
 // CreateUser mocks base method.
 func (m *MockUserRepo) CreateUser(ctx context.Context, in *User) (*User, error) {
 	m.ctrl.T.Helper()
@@ -55,6 +70,21 @@ func (mr *MockUserRepoMockRecorder) CreateUser(ctx, in any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateUser", reflect.TypeOf((*MockUserRepo)(nil).CreateUser), ctx, in)
 }
 
+// CreateUsers mocks base method.
+func (m *MockUserRepo) CreateUsers(ctx context.Context, in []*User, atomic bool) ([]bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateUsers", ctx, in, atomic)
+	ret0, _ := ret[0].([]bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateUsers indicates an expected call of CreateUsers.
+func (mr *MockUserRepoMockRecorder) CreateUsers(ctx, in, atomic any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateUsers", reflect.TypeOf((*MockUserRepo)(nil).CreateUsers), ctx, in, atomic)
+}
+
 // DeleteUser mocks base method.
 func (m *MockUserRepo) DeleteUser(ctx context.Context, userId string) error {
 	m.ctrl.T.Helper()
@@ -69,6 +99,36 @@ func (mr *MockUserRepoMockRecorder) DeleteUser(ctx, userId any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteUser", reflect.TypeOf((*MockUserRepo)(nil).DeleteUser), ctx, userId)
 }
 
+// DeleteUsers mocks base method.
+func (m *MockUserRepo) DeleteUsers(ctx context.Context, ids []string, atomic bool) ([]User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteUsers", ctx, ids, atomic)
+	ret0, _ := ret[0].([]User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteUsers indicates an expected call of DeleteUsers.
+func (mr *MockUserRepoMockRecorder) DeleteUsers(ctx, ids, atomic any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteUsers", reflect.TypeOf((*MockUserRepo)(nil).DeleteUsers), ctx, ids, atomic)
+}
+
+// GetCredentials mocks base method.
+func (m *MockUserRepo) GetCredentials(ctx context.Context, login string) (*User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetCredentials", ctx, login)
+	ret0, _ := ret[0].(*User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCredentials indicates an expected call of GetCredentials.
+func (mr *MockUserRepoMockRecorder) GetCredentials(ctx, login any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCredentials", reflect.TypeOf((*MockUserRepo)(nil).GetCredentials), ctx, login)
+}
+
 // GetUser mocks base method.
 func (m *MockUserRepo) GetUser(ctx context.Context, userId string) (*User, error) {
 	m.ctrl.T.Helper()
@@ -84,19 +144,93 @@ func (mr *MockUserRepoMockRecorder) GetUser(ctx, userId any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUser", reflect.TypeOf((*MockUserRepo)(nil).GetUser), ctx, userId)
 }
 
+// GetUserByEmail mocks base method.
+func (m *MockUserRepo) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserByEmail", ctx, email)
+	ret0, _ := ret[0].(*User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserByEmail indicates an expected call of GetUserByEmail.
+func (mr *MockUserRepoMockRecorder) GetUserByEmail(ctx, email any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserByEmail", reflect.TypeOf((*MockUserRepo)(nil).GetUserByEmail), ctx, email)
+}
+
+// GetUserByNickname mocks base method.
+func (m *MockUserRepo) GetUserByNickname(ctx context.Context, nickname string) (*User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUserByNickname", ctx, nickname)
+	ret0, _ := ret[0].(*User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUserByNickname indicates an expected call of GetUserByNickname.
+func (mr *MockUserRepoMockRecorder) GetUserByNickname(ctx, nickname any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserByNickname", reflect.TypeOf((*MockUserRepo)(nil).GetUserByNickname), ctx, nickname)
+}
+
 // ListUsers mocks base method.
-func (m *MockUserRepo) ListUsers(ctx context.Context, limit, offset int, filter *Filter) ([]User, error) {
+func (m *MockUserRepo) ListUsers(ctx context.Context, q ListQuery) ([]User, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "ListUsers", ctx, limit, offset, filter)
+	ret := m.ctrl.Call(m, "ListUsers", ctx, q)
 	ret0, _ := ret[0].([]User)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // ListUsers indicates an expected call of ListUsers.
-func (mr *MockUserRepoMockRecorder) ListUsers(ctx, limit, offset, filter any) *gomock.Call {
+func (mr *MockUserRepoMockRecorder) ListUsers(ctx, q any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListUsers", reflect.TypeOf((*MockUserRepo)(nil).ListUsers), ctx, q)
+}
+
+// RestoreUser mocks base method.
+func (m *MockUserRepo) RestoreUser(ctx context.Context, userId string) (*User, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RestoreUser", ctx, userId)
+	ret0, _ := ret[0].(*User)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RestoreUser indicates an expected call of RestoreUser.
+func (mr *MockUserRepoMockRecorder) RestoreUser(ctx, userId any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListUsers", reflect.TypeOf((*MockUserRepo)(nil).ListUsers), ctx, limit, offset, filter)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RestoreUser", reflect.TypeOf((*MockUserRepo)(nil).RestoreUser), ctx, userId)
+}
+
+// StreamUsers mocks base method.
+func (m *MockUserRepo) StreamUsers(ctx context.Context, q ListQuery, fn func(*User) error) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StreamUsers", ctx, q, fn)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// StreamUsers indicates an expected call of StreamUsers.
+func (mr *MockUserRepoMockRecorder) StreamUsers(ctx, q, fn any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StreamUsers", reflect.TypeOf((*MockUserRepo)(nil).StreamUsers), ctx, q, fn)
+}
+
+// TakenLogins mocks base method.
+func (m *MockUserRepo) TakenLogins(ctx context.Context, emails, nicknames []string) (*Logins, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TakenLogins", ctx, emails, nicknames)
+	ret0, _ := ret[0].(*Logins)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// TakenLogins indicates an expected call of TakenLogins.
+func (mr *MockUserRepoMockRecorder) TakenLogins(ctx, emails, nicknames any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TakenLogins", reflect.TypeOf((*MockUserRepo)(nil).TakenLogins), ctx, emails, nicknames)
 }
 
 // TestConnection mocks base method.
@@ -126,3 +260,71 @@ func (mr *MockUserRepoMockRecorder) UpdateUser(ctx, in any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateUser", reflect.TypeOf((*MockUserRepo)(nil).UpdateUser), ctx, in)
 }
+
+// UpdateUsers mocks base method.
+func (m *MockUserRepo) UpdateUsers(ctx context.Context, in []*User) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateUsers", ctx, in)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateUsers indicates an expected call of UpdateUsers.
+func (mr *MockUserRepoMockRecorder) UpdateUsers(ctx, in any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateUsers", reflect.TypeOf((*MockUserRepo)(nil).UpdateUsers), ctx, in)
+}
+
+// MockTokenRepo is a mock of TokenRepo interface.
+type MockTokenRepo struct {
+	ctrl     *gomock.Controller
+	recorder *MockTokenRepoMockRecorder
+	isgomock struct{}
+}
+
+// MockTokenRepoMockRecorder is the mock recorder for MockTokenRepo.
+type MockTokenRepoMockRecorder struct {
+	mock *MockTokenRepo
+}
+
+// NewMockTokenRepo creates a new mock instance.
+func NewMockTokenRepo(ctrl *gomock.Controller) *MockTokenRepo {
+	mock := &MockTokenRepo{ctrl: ctrl}
+	mock.recorder = &MockTokenRepoMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockTokenRepo) EXPECT() *MockTokenRepoMockRecorder {
+	return m.recorder
+}
+
+// CreateRefreshToken mocks base method.
+func (m *MockTokenRepo) CreateRefreshToken(ctx context.Context, token *RefreshToken) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateRefreshToken", ctx, token)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateRefreshToken indicates an expected call of CreateRefreshToken.
+func (mr *MockTokenRepoMockRecorder) CreateRefreshToken(ctx, token any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateRefreshToken", reflect.TypeOf((*MockTokenRepo)(nil).CreateRefreshToken), ctx, token)
+}
+
+// RotateRefreshToken mocks base method.
+func (m *MockTokenRepo) RotateRefreshToken(ctx context.Context, hash string, next *RefreshToken) (*RefreshToken, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RotateRefreshToken", ctx, hash, next)
+	ret0, _ := ret[0].(*RefreshToken)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RotateRefreshToken indicates an expected call of RotateRefreshToken.
+func (mr *MockTokenRepoMockRecorder) RotateRefreshToken(ctx, hash, next any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RotateRefreshToken", reflect.TypeOf((*MockTokenRepo)(nil).RotateRefreshToken), ctx, hash, next)
+}
@@ -0,0 +1,226 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/BorisRostovskiy/ESL/internal/clients"
+	"github.com/BorisRostovskiy/ESL/internal/events"
+)
+
+const (
+	defaultWatchBufferSize = 1024
+	defaultWatchQueueSize  = 64
+	defaultWatchHeartbeat  = 15 * time.Second
+)
+
+type (
+	// WatchConfig watch settings
+	WatchConfig struct {
+		// BufferSize number of the latest events watchers could resume from
+		BufferSize int `yaml:"buffer_size"`
+		// QueueSize events queued per watcher, slower watchers are disconnected
+		QueueSize int           `yaml:"queue_size"`
+		Heartbeat time.Duration `yaml:"heartbeat"`
+	}
+
+	// WatchQuery watch request
+	WatchQuery struct {
+		// After resume right after the event with this id, only new events are watched if empty
+		After string
+		// Countries changes of users from these countries only, all if empty.
+		// Updates moving user out of the country are matched as well
+		Countries []string
+	}
+
+	// WatchHub keeps the latest user change events and fans them out to the watchers.
+	// It wraps the actual notificator, so that watchers get exactly the events subscribers are notified of
+	WatchHub struct {
+		cfg  WatchConfig
+		next clients.ChannelNotificator
+
+		mu sync.Mutex
+		// ring latest events, head is the oldest one
+		ring     []*events.Event
+		head     int
+		size     int
+		ids      map[string]bool
+		watchers map[*watcher]struct{}
+
+		done      chan struct{}
+		closeOnce sync.Once
+	}
+
+	watcher struct {
+		events chan *events.Event
+	}
+)
+
+// WithWatch enables watching user changes recorded by the hub
+func WithWatch(hub *WatchHub) Option {
+	return func(s *Users) {
+		s.watch = hub
+	}
+}
+
+// NewWatchHub creates hub notifying next notificator of every event
+func NewWatchHub(cfg WatchConfig, next clients.ChannelNotificator) *WatchHub {
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = defaultWatchBufferSize
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaultWatchQueueSize
+	}
+	if cfg.Heartbeat <= 0 {
+		cfg.Heartbeat = defaultWatchHeartbeat
+	}
+	return &WatchHub{
+		cfg:      cfg,
+		next:     next,
+		ring:     make([]*events.Event, cfg.BufferSize),
+		ids:      make(map[string]bool, cfg.BufferSize),
+		watchers: make(map[*watcher]struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Notify records event and hands it to the watchers before notifying the next notificator.
+// Events redelivered after failed notification are recorded once
+func (h *WatchHub) Notify(ctx context.Context, channelName clients.ChannelName, event *events.Event) error {
+	h.record(event)
+	return h.next.Notify(ctx, channelName, event)
+}
+
+// Watch calls fn for every event matching the query, starting right after q.After if it is set, until ctx is done
+// or fn fails. fn is called with nil event as a heartbeat once watching has started and then every heartbeat
+// interval without events, so that idle watchers are kept alive and gone ones are noticed
+func (h *WatchHub) Watch(ctx context.Context, q WatchQuery, fn func(e *events.Event) error) error {
+	w := &watcher{events: make(chan *events.Event, h.cfg.QueueSize)}
+	h.mu.Lock()
+	backlog, err := h.since(q.After)
+	if err != nil {
+		h.mu.Unlock()
+		return err
+	}
+	h.watchers[w] = struct{}{}
+	h.mu.Unlock()
+	defer h.unsubscribe(w)
+
+	if err = fn(nil); err != nil {
+		return err
+	}
+	for _, e := range backlog {
+		if q.Match(e) {
+			if err = fn(e); err != nil {
+				return err
+			}
+		}
+	}
+
+	heartbeat := time.NewTicker(h.cfg.Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-h.done:
+			return nil
+		case e, ok := <-w.events:
+			if !ok {
+				return ErrWatchLagging
+			}
+			if !q.Match(e) {
+				continue
+			}
+			if err = fn(e); err != nil {
+				return err
+			}
+			heartbeat.Reset(h.cfg.Heartbeat)
+		case <-heartbeat.C:
+			if err = fn(nil); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Match reports whether event is of the watched countries
+func (q WatchQuery) Match(e *events.Event) bool {
+	if len(q.Countries) == 0 {
+		return true
+	}
+	for _, c := range q.Countries {
+		if e.Country == c {
+			return true
+		}
+		if e.Updated == nil {
+			continue
+		}
+		for _, ch := range e.Updated.Changes {
+			if ch.Field == "country" && ch.Old == c {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (h *WatchHub) record(e *events.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.ids[e.ID] {
+		return
+	}
+	if h.size == len(h.ring) {
+		delete(h.ids, h.ring[h.head].ID)
+		h.ring[h.head] = e
+		h.head = (h.head + 1) % len(h.ring)
+	} else {
+		h.ring[(h.head+h.size)%len(h.ring)] = e
+		h.size++
+	}
+	h.ids[e.ID] = true
+
+	for w := range h.watchers {
+		select {
+		case w.events <- e:
+		default:
+			// slow watcher must not hold back the others, it resumes from the last event it got
+			delete(h.watchers, w)
+			close(w.events)
+		}
+	}
+}
+
+// Close ends all the watches, so that servers shutting down gracefully are not held by them
+func (h *WatchHub) Close() {
+	h.closeOnce.Do(func() { close(h.done) })
+}
+
+// since events following the one with the given id, must be called with the lock held
+func (h *WatchHub) since(id string) ([]*events.Event, error) {
+	if id == "" {
+		return nil, nil
+	}
+	if !h.ids[id] {
+		return nil, ErrWatchExpired
+	}
+	for i := 0; i < h.size; i++ {
+		if h.ring[(h.head+i)%len(h.ring)].ID != id {
+			continue
+		}
+		backlog := make([]*events.Event, 0, h.size-i-1)
+		for j := i + 1; j < h.size; j++ {
+			backlog = append(backlog, h.ring[(h.head+j)%len(h.ring)])
+		}
+		return backlog, nil
+	}
+	return nil, ErrWatchExpired
+}
+
+func (h *WatchHub) unsubscribe(w *watcher) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.watchers, w)
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/BorisRostovskiy/ESL/internal/clients"
+	"github.com/BorisRostovskiy/ESL/internal/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+var errStopWatch = errors.New("stop")
+
+func TestWatchHub_Resume(t *testing.T) {
+	t.Parallel()
+	n := clients.NewMockChannelNotificator(gomock.NewController(t))
+	n.EXPECT().Notify(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	hub := NewWatchHub(WatchConfig{BufferSize: 3, Heartbeat: time.Millisecond}, n)
+
+	created := make([]*events.Event, 4)
+	for i := range created {
+		created[i] = events.NewUserCreated(nil, events.User{ID: "user", Country: "NL"})
+		require.NoError(t, hub.Notify(context.Background(), clients.ChannelCreate, created[i]))
+	}
+	// redelivered event is not recorded twice
+	require.NoError(t, hub.Notify(context.Background(), clients.ChannelCreate, created[3]))
+
+	tests := map[string]struct {
+		after string
+		ids   []string
+		err   error
+	}{
+		"resume within the buffer": {
+			after: created[1].ID,
+			ids:   []string{created[2].ID, created[3].ID},
+		},
+		"resume from the latest": {
+			after: created[3].ID,
+		},
+		"evicted event": {
+			after: created[0].ID,
+			err:   ErrWatchExpired,
+		},
+		"unknown event": {
+			after: "unknown",
+			err:   ErrWatchExpired,
+		},
+	}
+	for scenario, tt := range tests {
+		tt := tt
+		t.Run(scenario, func(t *testing.T) {
+			t.Parallel()
+			var ids []string
+			err := hub.Watch(context.Background(), WatchQuery{After: tt.after}, func(e *events.Event) error {
+				if e == nil {
+					if len(ids) == len(tt.ids) {
+						// the first heartbeat is sent before the backlog, the second one after it
+						return errStopWatch
+					}
+					return nil
+				}
+				ids = append(ids, e.ID)
+				return nil
+			})
+			if tt.err != nil {
+				assert.ErrorIs(t, err, tt.err)
+				return
+			}
+			assert.ErrorIs(t, err, errStopWatch)
+			assert.Equal(t, tt.ids, ids)
+		})
+	}
+}
+
+func TestWatchHub_Watch(t *testing.T) {
+	t.Parallel()
+	n := clients.NewMockChannelNotificator(gomock.NewController(t))
+	n.EXPECT().Notify(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	hub := NewWatchHub(WatchConfig{Heartbeat: time.Hour}, n)
+
+	var (
+		nl      = events.NewUserCreated(nil, events.User{ID: "user1", Country: "NL"})
+		de      = events.NewUserCreated(nil, events.User{ID: "user2", Country: "DE"})
+		movedDE = events.NewUserUpdated(nil, "user1", []events.Change{{Field: "country", Old: "NL", New: "DE"}})
+		deleted = events.NewUserDeleted(nil, "user2")
+	)
+	movedDE.Country = "DE"
+	deleted.Country = "DE"
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	got := make(chan *events.Event)
+	done := make(chan error, 1)
+	go func() {
+		done <- hub.Watch(ctx, WatchQuery{Countries: []string{"NL"}}, func(e *events.Event) error {
+			got <- e
+			return nil
+		})
+	}()
+	require.Nil(t, <-got, "heartbeat once watching has started")
+
+	for _, e := range []*events.Event{nl, de, movedDE, deleted} {
+		require.NoError(t, hub.Notify(ctx, clients.ChannelCreate, e))
+	}
+	assert.Equal(t, nl, <-got)
+	assert.Equal(t, movedDE, <-got, "user moved out of the country")
+
+	hub.Close()
+	assert.NoError(t, <-done)
+}
+
+func TestWatchHub_Lagging(t *testing.T) {
+	t.Parallel()
+	n := clients.NewMockChannelNotificator(gomock.NewController(t))
+	n.EXPECT().Notify(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	hub := NewWatchHub(WatchConfig{QueueSize: 1}, n)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- hub.Watch(context.Background(), WatchQuery{}, func(e *events.Event) error {
+			if e == nil {
+				close(started)
+				<-release
+			}
+			return nil
+		})
+	}()
+	<-started
+	// watcher is busy, the second event overflows its queue
+	for i := 0; i < 2; i++ {
+		require.NoError(t, hub.Notify(context.Background(), clients.ChannelCreate,
+			events.NewUserCreated(nil, events.User{ID: "user"})))
+	}
+	close(release)
+	assert.ErrorIs(t, <-done, ErrWatchLagging)
+}
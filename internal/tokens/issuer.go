@@ -0,0 +1,242 @@
+package tokens
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+
+	defaultAccessTTL  = 15 * time.Minute
+	defaultRefreshTTL = 30 * 24 * time.Hour
+)
+
+var (
+	// ErrInvalidToken causes when token could not be verified
+	ErrInvalidToken = errors.New("invalid token")
+)
+
+type (
+	// Config token issuing configuration
+	Config struct {
+		Enabled  bool     `yaml:"enabled"`
+		Issuer   string   `yaml:"issuer"`
+		Audience []string `yaml:"audience"`
+		// Algorithm one of RS256 or EdDSA
+		Algorithm string `yaml:"algorithm"`
+		// KeyID published as `kid` of the signing key
+		KeyID          string `yaml:"key_id"`
+		PrivateKeyFile string `yaml:"private_key_file"`
+		// PublicKeyFiles previous keys (key id -> PEM file) which are still published
+		// in JWKS and accepted by Verify during key rotation
+		PublicKeyFiles map[string]string `yaml:"public_key_files"`
+		AccessTTL      time.Duration     `yaml:"access_ttl"`
+		RefreshTTL     time.Duration     `yaml:"refresh_ttl"`
+	}
+
+	// Claims access token claims
+	Claims struct {
+		jwt.RegisteredClaims
+		Role string `json:"role,omitempty"`
+	}
+
+	// Issuer signs and verifies access tokens
+	Issuer struct {
+		cfg        Config
+		method     jwt.SigningMethod
+		signingKey crypto.Signer
+		publicKeys map[string]crypto.PublicKey
+	}
+)
+
+// New sets up token issuer with keys loaded from files named in the configuration
+func New(cfg Config) (*Issuer, error) {
+	i := &Issuer{cfg: cfg, publicKeys: make(map[string]crypto.PublicKey)}
+	if i.cfg.AccessTTL <= 0 {
+		i.cfg.AccessTTL = defaultAccessTTL
+	}
+	if i.cfg.RefreshTTL <= 0 {
+		i.cfg.RefreshTTL = defaultRefreshTTL
+	}
+	if cfg.KeyID == "" {
+		return nil, fmt.Errorf("key_id is mandatory")
+	}
+
+	switch cfg.Algorithm {
+	case AlgorithmRS256:
+		i.method = jwt.SigningMethodRS256
+	case AlgorithmEdDSA:
+		i.method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("algorithm '%s' not supported", cfg.Algorithm)
+	}
+
+	key, err := loadPrivateKey(cfg.PrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if err = i.checkKeyType(key.Public()); err != nil {
+		return nil, fmt.Errorf("private key '%s': %w", cfg.PrivateKeyFile, err)
+	}
+	i.signingKey = key
+	i.publicKeys[cfg.KeyID] = key.Public()
+
+	for kid, file := range cfg.PublicKeyFiles {
+		pub, err := loadPublicKey(file)
+		if err != nil {
+			return nil, err
+		}
+		if err = i.checkKeyType(pub); err != nil {
+			return nil, fmt.Errorf("public key '%s': %w", file, err)
+		}
+		i.publicKeys[kid] = pub
+	}
+	return i, nil
+}
+
+// AccessTTL lifetime of issued access tokens
+func (i *Issuer) AccessTTL() time.Duration {
+	return i.cfg.AccessTTL
+}
+
+// RefreshTTL lifetime of issued refresh tokens
+func (i *Issuer) RefreshTTL() time.Duration {
+	return i.cfg.RefreshTTL
+}
+
+// Sign issues signed access token for the subject granted with the role
+func (i *Issuer) Sign(subject, role string, now time.Time) (string, time.Time, error) {
+	expiresAt := now.Add(i.cfg.AccessTTL)
+	t := jwt.NewWithClaims(i.method, Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    i.cfg.Issuer,
+			Subject:   subject,
+			Audience:  i.cfg.Audience,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+		Role: role,
+	})
+	t.Header["kid"] = i.cfg.KeyID
+
+	signed, err := t.SignedString(i.signingKey)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("could not sign access token: %w", err)
+	}
+	return signed, expiresAt, nil
+}
+
+// Verify checks access token signature and registered claims
+func (i *Issuer) Verify(token string) (*Claims, error) {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{i.method.Alg()}),
+		jwt.WithExpirationRequired(),
+	}
+	if i.cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(i.cfg.Issuer))
+	}
+
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, ok := i.publicKeys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key id '%s'", kid)
+		}
+		return key, nil
+	}, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	// jwt.WithAudience keeps the last audience only, so that the token audience is checked against
+	// all configured ones here, any of them is enough
+	if len(i.cfg.Audience) > 0 && !i.acceptsAudience(claims.Audience) {
+		return nil, fmt.Errorf("%w: token has invalid audience", ErrInvalidToken)
+	}
+	return claims, nil
+}
+
+// acceptsAudience whether one of the token audiences is configured
+func (i *Issuer) acceptsAudience(audience jwt.ClaimStrings) bool {
+	for _, aud := range audience {
+		for _, expected := range i.cfg.Audience {
+			if aud == expected {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (i *Issuer) checkKeyType(pub crypto.PublicKey) error {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		if i.method == jwt.SigningMethodRS256 {
+			return nil
+		}
+	case ed25519.PublicKey:
+		if i.method == jwt.SigningMethodEdDSA {
+			return nil
+		}
+	}
+	return fmt.Errorf("key type %T does not match algorithm %s", pub, i.method.Alg())
+}
+
+func loadPrivateKey(file string) (crypto.Signer, error) {
+	block, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse private key '%s': %w", file, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("private key '%s' could not be used for signing", file)
+	}
+	return signer, nil
+}
+
+func loadPublicKey(file string) (crypto.PublicKey, error) {
+	block, err := readPEM(file)
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse public key '%s': %w", file, err)
+	}
+	return key, nil
+}
+
+func readPEM(file string) (*pem.Block, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read key file: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key file '%s' is not PEM encoded", file)
+	}
+	return block, nil
+}
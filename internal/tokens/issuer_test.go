@@ -0,0 +1,144 @@
+package tokens
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writePEM(t *testing.T, name, typ string, der []byte) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600))
+	return file
+}
+
+func TestIssuer(t *testing.T) {
+	t.Parallel()
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+	require.NoError(t, err)
+	oldPub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	oldDER, err := x509.MarshalPKIXPublicKey(oldPub)
+	require.NoError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	tests := map[string]struct {
+		cfg      Config
+		jwksKeys int
+		kty      string
+	}{
+		"EdDSA with previous key": {
+			cfg: Config{
+				Issuer:         "um",
+				Audience:       []string{"esl"},
+				Algorithm:      AlgorithmEdDSA,
+				KeyID:          "k2",
+				PrivateKeyFile: writePEM(t, "ed.pem", "PRIVATE KEY", edDER),
+				PublicKeyFiles: map[string]string{"k1": writePEM(t, "old.pem", "PUBLIC KEY", oldDER)},
+			},
+			jwksKeys: 2,
+			kty:      "OKP",
+		},
+		"RS256": {
+			cfg: Config{
+				Issuer:         "um",
+				Algorithm:      AlgorithmRS256,
+				KeyID:          "k1",
+				PrivateKeyFile: writePEM(t, "rsa.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)),
+			},
+			jwksKeys: 1,
+			kty:      "RSA",
+		},
+	}
+	for scenario, tt := range tests {
+		t.Run(scenario, func(t *testing.T) {
+			issuer, err := New(tt.cfg)
+			require.NoError(t, err)
+
+			token, expiresAt, err := issuer.Sign("user-1", "self", time.Now())
+			require.NoError(t, err)
+			assert.WithinDuration(t, time.Now().Add(defaultAccessTTL), expiresAt, time.Second)
+
+			claims, err := issuer.Verify(token)
+			require.NoError(t, err)
+			assert.Equal(t, "user-1", claims.Subject)
+
+			_, err = issuer.Verify(token + "x")
+			assert.ErrorIs(t, err, ErrInvalidToken)
+
+			expired, _, err := issuer.Sign("user-1", "self", time.Now().Add(-time.Hour))
+			require.NoError(t, err)
+			_, err = issuer.Verify(expired)
+			assert.ErrorIs(t, err, ErrInvalidToken)
+
+			jwks := issuer.JWKS()
+			require.Len(t, jwks.Keys, tt.jwksKeys)
+			for _, k := range jwks.Keys {
+				assert.Equal(t, tt.kty, k.Kty)
+				assert.Equal(t, tt.cfg.Algorithm, k.Alg)
+			}
+		})
+	}
+
+	t.Run("any of configured audiences", func(t *testing.T) {
+		issuer, err := New(Config{
+			Audience:       []string{"esl", "admin"},
+			Algorithm:      AlgorithmEdDSA,
+			KeyID:          "k1",
+			PrivateKeyFile: writePEM(t, "ed.pem", "PRIVATE KEY", edDER),
+		})
+		require.NoError(t, err)
+
+		sign := func(audience ...string) string {
+			token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, Claims{RegisteredClaims: jwt.RegisteredClaims{
+				Subject:   "user-1",
+				Audience:  audience,
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+			}})
+			token.Header["kid"] = "k1"
+			signed, err := token.SignedString(edKey)
+			require.NoError(t, err)
+			return signed
+		}
+		for _, aud := range []string{"esl", "admin"} {
+			_, err = issuer.Verify(sign(aud))
+			assert.NoError(t, err, aud)
+		}
+		_, err = issuer.Verify(sign("other"))
+		assert.ErrorIs(t, err, ErrInvalidToken)
+		_, err = issuer.Verify(sign())
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("key does not match algorithm", func(t *testing.T) {
+		_, err := New(Config{
+			Algorithm:      AlgorithmRS256,
+			KeyID:          "k1",
+			PrivateKeyFile: writePEM(t, "ed.pem", "PRIVATE KEY", edDER),
+		})
+		assert.ErrorContains(t, err, "does not match algorithm RS256")
+	})
+}
+
+func TestNewRefreshToken(t *testing.T) {
+	t.Parallel()
+	token, hash, err := NewRefreshToken()
+	require.NoError(t, err)
+	assert.Equal(t, hash, HashRefreshToken(token))
+	assert.NotEqual(t, token, hash)
+}
@@ -0,0 +1,55 @@
+package tokens
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"sort"
+)
+
+type (
+	// JWK public key representation as defined by RFC 7517
+	JWK struct {
+		Kty string `json:"kty"`
+		Use string `json:"use"`
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+		// RSA keys
+		N string `json:"n,omitempty"`
+		E string `json:"e,omitempty"`
+		// OKP keys
+		Crv string `json:"crv,omitempty"`
+		X   string `json:"x,omitempty"`
+	}
+
+	// JWKSet published at /.well-known/jwks.json
+	JWKSet struct {
+		Keys []JWK `json:"keys"`
+	}
+)
+
+// JWKS returns all the public keys tokens could be verified with
+func (i *Issuer) JWKS() JWKSet {
+	set := JWKSet{Keys: make([]JWK, 0, len(i.publicKeys))}
+	for kid, pub := range i.publicKeys {
+		key := JWK{Use: "sig", Alg: i.method.Alg(), Kid: kid}
+		switch k := pub.(type) {
+		case *rsa.PublicKey:
+			key.Kty = "RSA"
+			key.N = base64.RawURLEncoding.EncodeToString(k.N.Bytes())
+			key.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+		case ed25519.PublicKey:
+			key.Kty = "OKP"
+			key.Crv = "Ed25519"
+			key.X = base64.RawURLEncoding.EncodeToString(k)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, key)
+	}
+	sort.Slice(set.Keys, func(a, b int) bool {
+		return set.Keys[a].Kid < set.Keys[b].Kid
+	})
+	return set
+}
@@ -0,0 +1,28 @@
+package tokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+// refreshTokenSize number of random bytes in refresh token
+const refreshTokenSize = 32
+
+// NewRefreshToken generates opaque refresh token, only its hash is supposed to be stored
+func NewRefreshToken() (token string, hash string, err error) {
+	b := make([]byte, refreshTokenSize)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("could not generate refresh token: %w", err)
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, HashRefreshToken(token), nil
+}
+
+// HashRefreshToken hash refresh token is stored and looked up by
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}